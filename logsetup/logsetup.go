@@ -0,0 +1,60 @@
+/*****************************************************************************
+
+'logsetup' is the logging init shared by the programs in learninggo (the
+theatre model and tickets/sample_server):  each run logs to its own file,
+named for the time it started.
+
+If the log file can't be created, then the program logs to stderr instead,
+with a warning, rather than dying before it has even started.
+
+*****************************************************************************/
+
+package logsetup
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stderr is where the log goes if the log file can't be created.  It is only
+// changed by tests.
+var stderr io.Writer = os.Stderr
+
+// Open sets up the log for one run of a program.
+//
+// Parameters:
+//
+// fileBase
+//    The start of the log file's name, e.g. "log/theatre.";  the time is
+//    added to make the name.  The directory is created if it doesn't exist.
+// prefix
+//    The prefix for each log line, e.g. "theatre model:  ".
+//
+// Returns the Logger, the name of the log file, and a function for the
+// program to call (e.g. with defer) to close the file when it is done.  If
+// the log file or its directory can't be created, then a warning is written
+// to stderr, and the Logger writes to stderr instead, with a fileName of "".
+func Open(fileBase string, prefix string) (l *log.Logger, fileName string, closeLog func() error) {
+	fileName = fileBase + time.Now().Format("2006-01-02t15-04-05z-0700")
+	logFile, err := create(fileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "%sWARNING:  cannot set up log file '%s', logging to stderr instead:  %v\n", prefix, fileName, err)
+		return log.New(stderr, prefix, log.Ldate|log.Ltime|log.Lshortfile), "", func() error { return nil }
+	}
+	//// For now, don't run this.  Depending on user's umask, this might
+	//// actually INCREASE access to the logfile, instead of protecting it.
+	//logFile.Chmod(0644)
+	return log.New(logFile, prefix, log.Ldate|log.Ltime|log.Lshortfile), fileName, logFile.Close
+} // Open
+
+// create creates the file fileName, and its directory first, if need be.
+func create(fileName string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(fileName)
+} // create
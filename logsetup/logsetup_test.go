@@ -0,0 +1,52 @@
+package logsetup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenCreatesDir(tst *testing.T) {
+	base := filepath.Join(tst.TempDir(), "no", "such", "log", "test.")
+	l, fileName, closeLog := Open(base, "test:  ")
+	if !strings.HasPrefix(fileName, base) {
+		tst.Fatalf("Open(%s) logs to '%s', expected a file starting with the base", base, fileName)
+	}
+	l.Printf("hello")
+	if err := closeLog(); err != nil {
+		tst.Errorf("Closing the log returned error %v", err)
+	}
+	if logged, err := os.ReadFile(fileName); err != nil || !strings.Contains(string(logged), "test:  ") || !strings.Contains(string(logged), "hello") {
+		tst.Errorf("Log file '%s' has %q (error %v), expected the line logged", fileName, logged, err)
+	}
+} // TestOpenCreatesDir
+
+func TestOpenFallsBack(tst *testing.T) {
+	var warned bytes.Buffer
+	defer func(saved io.Writer) { stderr = saved }(stderr)
+	stderr = &warned
+
+	// A directory can't be made under a file.
+	dir := tst.TempDir()
+	blocker := filepath.Join(dir, "log")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		tst.Fatalf("Cannot create '%s':  %v", blocker, err)
+	}
+	l, fileName, closeLog := Open(filepath.Join(blocker, "test."), "test:  ")
+	if fileName != "" {
+		tst.Errorf("Open under a file logs to '%s', expected stderr", fileName)
+	}
+	if !strings.Contains(warned.String(), "WARNING") {
+		tst.Errorf("Open under a file warned %q, expected a warning", warned.String())
+	}
+	l.Printf("hello")
+	if err := closeLog(); err != nil {
+		tst.Errorf("Closing the fallback log returned error %v", err)
+	}
+	if !strings.Contains(warned.String(), "hello") {
+		tst.Errorf("The fallback log has %q, expected the line logged", warned.String())
+	}
+} // TestOpenFallsBack
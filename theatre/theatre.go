@@ -10,21 +10,27 @@ In the initial implementation, customers are not explicitly modeled.
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/d-m-w/learninggo/logsetup"
 	"github.com/d-m-w/learninggo/tickets"
+	"github.com/d-m-w/learninggo/tickets/loadgen"
+	"github.com/d-m-w/learninggo/tickets/ticketsclient"
 )
 
 type msgHeader struct {
@@ -34,6 +40,21 @@ type msgHeader struct {
 
 type msgDone struct{ head msgHeader }
 
+// msgTrackerDone is the tracker's msgDone to main(), with its totals for the
+// shutdown summary.
+type msgTrackerDone struct {
+	head   msgHeader
+	totals runTotals
+}
+
+// runTotals are the tracker's totals for the whole run.
+type runTotals struct {
+	runTime     time.Duration
+	ticketsSold int // not counting the sold-out placeholders
+	soldOut     int // ticket requests refused because the showing was sold out
+	exchanges   int
+}
+
 type msgStop struct {
 	head msgHeader
 	what string
@@ -45,6 +66,11 @@ type msgExchange struct {
 	xchOld, xchNew string
 }
 
+// xchPair is the goodie given up, and the one received, in an exchange.
+type xchPair struct {
+	old, new string
+}
+
 type msgTicketSale struct {
 	head   msgHeader
 	window int
@@ -56,29 +82,137 @@ type xchData struct {
 	tickNum int
 }
 
+// msgXchQueue tells the tracker that the cafeteria's queue of exchange
+// requests has reached a new maximum depth.
+type msgXchQueue struct {
+	head     msgHeader
+	maxDepth int
+}
+
+// msgPause tells a ticket window to pause (stop making sales, as when the
+// cashier goes on a break), or to resume, or (from the supervisor) to close
+// for good.
+type msgPause struct {
+	head  msgHeader
+	pause bool // true to pause, false to resume
+	close bool // true to shut the window down, as if chStopWin were closed;  pause is then ignored
+}
+
+// msgScale asks the tracker whether the supervisor may open (start) or close
+// (shut down) a ticket window, so that the tracker can count the windows it
+// must wait for at shutdown.  The tracker replies on ok:  true if it has
+// counted the change, or false if the model is already shutting down.
+type msgScale struct {
+	head   msgHeader
+	window int
+	open   bool // true to open the window, false to close it
+	ok     chan<- bool
+}
+
+// msgWindowOpened tells main() that the supervisor has started another
+// ticket window, so that main() waits for its msgDone too.
+type msgWindowOpened struct {
+	head   msgHeader
+	window int
+}
+
+// msgPaused tells the tracker how long a ticket window was paused for.
+type msgPaused struct {
+	head   msgHeader
+	window int
+	paused time.Duration
+}
+
+// msgCommand passes a command typed on stdin (see readCommands) to the
+// tracker.
+type msgCommand struct {
+	head msgHeader
+	what string // cmdReport or cmdStop
+}
+
+// The commands which may be typed on stdin while the model runs.
+const (
+	cmdReport = "report" // write an interim summary report to the console
+	cmdStop   = "stop"   // shut the model down now, as if the run time were up
+)
+
+// dimensions is the size of the theatre:  how many movies and showings of
+// each (numbered from 0), and ticket windows (numbered from 1), as fetched
+// from the tickets server by fetchDimensions.
+type dimensions struct {
+	movies, showings, windows int
+}
+
+// scaling is the ticket window auto-scaling set by the -scale-* options (see
+// supervisor).  An interval of 0 means no auto-scaling:  every window is
+// started, and stays open.
+type scaling struct {
+	interval               time.Duration // how often the throughput is checked
+	minWindows, maxWindows int           // how many windows may be open
+	up, down               float64       // tickets sold per open window per interval, to open or close one
+}
+
+// winBreak is one scheduled break for a ticket window, from the -breaks
+// option:  the window pauses start after the model starts, for length.
+type winBreak struct {
+	window        int
+	start, length time.Duration
+}
+
 const (
 
 	// ATTENTION!  constants named Max* are shared with tickets/sample_server and must be kept in sync.
 	//             If overridden by a cmd.line option, then the SAME option must be given
 	//             to sample_server when it is started.  Unspeakable horrors may result,
-	//             elsewise.
-
-	logFileBase                     = "log/theatre."
-	name              string        = "theatre model"
-	nDelay            time.Duration = time.Second / 10 // can't say 0.1 * time.Second, bec. Duration is an integer
-	MaxExchanges                    = 200
-	nMax                            = 1
-	MaxMovies                       = 5
-	MaxSeats                        = 100
-	MaxShowings                     = 4
-	MaxWindows                      = 2
-	runTime           time.Duration = 10 * time.Minute
-	summaryReportBase               = "log/theatre.summaryReport."
-	ticketServer                    = "http://localhost:1811/tickets"
+	//             elsewise.  (MaxMovies, MaxShowings and MaxWindows are only fallbacks,
+	//             since those are fetched from the server;  see fetchDimensions.)
+
+	logFileBase                  = "log/theatre."
+	name           string        = "theatre model"
+	nDelay         time.Duration = time.Second / 10 // can't say 0.1 * time.Second, bec. Duration is an integer
+	httpTimeout    time.Duration = 5 * time.Second
+	cafeteriaQueue               = 100 // exchange requests which can wait for the cafeteria before window 1 has to wait, too
+	MaxExchanges                 = 200
+	nMax                         = 1
+	MaxMovies                    = 5
+	MaxSeats                     = 100
+	MaxShowings                  = 4
+	MaxWindows                   = 2
+	corpusSize                   = 1000 // requests in the -corpus-seed corpus, shared out among the windows
+	runTime        time.Duration = 10 * time.Minute
+	maxRunTime     time.Duration = 24 * time.Hour // default cap on runTime
 )
 
+// summaryReportBase is the start of the summary report file names;  the time
+// is added to make each name.  It is only changed by tests.
+var summaryReportBase = "log/theatre.summaryReport."
+
+// ticketServer is the base URL of the tickets server.  It is only changed by
+// tests, to point at a test server.
+var ticketServer = "http://localhost:1811/tickets"
+
 var L *log.Logger
 
+// httpClient is shared by all calls to the tickets server (see
+// serverClient).  Its Timeout is set from the -http-timeout option, and
+// ticketsclient also puts a deadline of the same length on each request, so
+// a stalled server fails the call (reading the reply included) and the
+// caller moves on, instead of hanging forever.
+var httpClient = &http.Client{Timeout: httpTimeout}
+
+// runTimeCap is the longest the model may run for.  It is set from the
+// -max-runtime option, and tracker never sets its shutdown timer beyond it,
+// so a typo in -t can't leave the model running for days.
+var runTimeCap = maxRunTime
+
+// console is where the interim summary reports asked for by the "report"
+// command go.  It is only changed by tests.
+var console io.Writer = os.Stdout
+
+// latencies records how long each call to the tickets server took, by the
+// kind of call ("sell" or "exchange"), for the summary report.
+var latencies = newLatencyRecorder()
+
 // main starts and runs the model.
 // The size and runtime defaults (see const section, above) can be overridden
 // by cmd.line options:
@@ -90,61 +224,92 @@ var L *log.Logger
 //   -t <runTime>
 //   -w <MaxWindows>
 //   -x <nMax>
+//   -http-timeout <httpTimeout>
+//   -max-runtime <maxRunTime>
+//   -target-sold <tickets to sell before stopping, 0 = no target>
+//   -breaks <window>@<start>+<length>,...  (see parseBreaks)
+//   -report-interval <how often to write an interim summary report, 0 = never>
+//   -exchange-time <how long the cafeteria takes to serve each exchange>
+//   -scale-interval <how often to open or close ticket windows, 0 = never>
+//   -scale-min <fewest windows open>  -scale-max <most windows open, 0 = all>
+//   -scale-up <sales per window per interval to open one>  -scale-down <... to close one>
+//   -corpus-seed <seed of the fixed corpus of sales to make, 0 = random sales>
+//   -corpus-size <corpusSize>
+// The movies, showings and windows are fetched from the tickets server, so
+// -m, -h and -w are only used if it can't be asked (see fetchDimensions).
+// While it runs, "report" or "stop" may be typed on stdin (see
+// readCommands).
 func main() {
 
-	// This is boilerplate generalized from that in tickets/sample_server.
-	// Be nice to find some way to package and import it or something...
-
-	logFileName := logFileBase + time.Now().Format("2006-01-02t15-04-05z-0700")
-	logFile, logErr := os.Create(logFileName)
-	defer logFile.Close()
-	if logErr == nil {
-		//// For now, don't run this.  Depending on user's umask, this might
-		//// actually INCREASE access to the logfile, instead of protecting it.
-		//logErr = logFile.Chmod(0644)
-	}
-	if logErr != nil {
-		// log setup failed, so write this one to the default log, instead
-		log.Fatalf("%s aborting:  Error setting up log file '%s':  %v", name, logFileName, logErr)
-	}
-	L = log.New(logFile, name+":  ", log.Ldate|log.Ltime|log.Lshortfile) //? need '*'
-
-	// End common logging init.
+	var closeLog func() error
+	L, _, closeLog = logsetup.Open(logFileBase, name+":  ")
+	defer closeLog()
 
 	rand.Seed(time.Now().UnixNano())
 
 	dpAvgDelay := flag.Duration("a", nDelay, "average delay between transactions at the same window (see Go doc for time.ParseDuration)")
 	ipExchanges := flag.Int("c", MaxExchanges, "number of exchanges the cafeteria can make before running out of soda (Must match sample_server)")
-	ipMovies := flag.Int("m", MaxMovies, "number of movies the theatre can show (Must match sample_server)")
+	ipMovies := flag.Int("m", MaxMovies, "number of movies the theatre can show (only used if sample_server can't be asked)")
 	ipSeats := flag.Int("e", MaxSeats, "number of seats available for each movie showing (Must match sample_server)")
-	ipShowings := flag.Int("h", MaxShowings, "number of times each movie is shown, per day (Must match sample_server)")
+	ipShowings := flag.Int("h", MaxShowings, "number of times each movie is shown, per day (only used if sample_server can't be asked)")
 	dpTime := flag.Duration("t", runTime, "how long to run the model for (see Go doc for time.ParseDuration)")
-	ipWindows := flag.Int("w", MaxWindows, "number of open ticket windows (Must match sample_server)")
+	ipWindows := flag.Int("w", MaxWindows, "number of open ticket windows (only used if sample_server can't be asked)")
 	ipMax := flag.Int("x", nMax, "maximum number of tickets which may be purchased in one transaction")
+	ipTargetSold := flag.Int("target-sold", 0, "stop the model once this many tickets have been sold, or when -t is up, whichever comes first (0 means no target)")
+	dpMaxRunTime := flag.Duration("max-runtime", maxRunTime, "longest that -t may be (see Go doc for time.ParseDuration)")
+	dpHTTPTimeout := flag.Duration("http-timeout", httpTimeout, "how long to wait for each call to the tickets server before giving up (see Go doc for time.ParseDuration)")
+	dpReportInterval := flag.Duration("report-interval", 0, "how often to write an interim summary report while the model runs (0 means only at the end; see Go doc for time.ParseDuration)")
+	dpExchangeTime := flag.Duration("exchange-time", 0, "how long the cafeteria takes to serve each exchange (see Go doc for time.ParseDuration)")
+	spBreaks := flag.String("breaks", "", "comma-separated ticket window breaks, each <window>@<start>+<length>, e.g. 2@1m+30s pauses window 2 for 30s starting 1m into the run")
+	dpScaleInterval := flag.Duration("scale-interval", 0, "how often to check the ticket sales, to open or close ticket windows (0 means no auto-scaling:  all windows stay open;  see Go doc for time.ParseDuration)")
+	ipMinWindows := flag.Int("scale-min", 1, "fewest ticket windows to keep open, when auto-scaling")
+	ipMaxWindows := flag.Int("scale-max", 0, "most ticket windows to open, when auto-scaling (0 means all of them)")
+	fpScaleUp := flag.Float64("scale-up", 5, "tickets sold per open window per -scale-interval at which another window is opened")
+	fpScaleDown := flag.Float64("scale-down", 1, "tickets sold per open window per -scale-interval at or below which a window is closed")
+	ipCorpusSeed := flag.Int64("corpus-seed", 0, "make the sales of a fixed corpus generated from this seed (see tickets/loadgen), so that runs can be compared (0 means random sales;  -x is ignored otherwise)")
+	ipCorpusSize := flag.Int("corpus-size", corpusSize, "number of sales in the -corpus-seed corpus, which the windows make over and over")
 
 	flag.Parse()
 
-	if *dpAvgDelay < 0 {
-		L.Fatalf("Startup failed:  -a (average inter-txn delay) must not be negative")
+	if err := checkFlags(*dpAvgDelay, *dpTime, *dpMaxRunTime, *ipTargetSold, *ipMax, *dpHTTPTimeout, *dpReportInterval, *dpExchangeTime); err != nil {
+		L.Fatalf("Startup failed:\n%v", err)
 	}
+	runTimeCap = *dpMaxRunTime
+	httpClient.Timeout = *dpHTTPTimeout
 
-	if *dpTime < 1 {
-		L.Fatalf("Startup failed:  -t (time to run the model) must be at least 1ns")
+	dims := fetchDimensions(dimensions{movies: *ipMovies, showings: *ipShowings, windows: *ipWindows})
+	breaks, err := parseBreaks(*spBreaks, dims.windows)
+	if err != nil {
+		L.Fatalf("Startup failed:\n%v", err)
 	}
-
-	if *ipMax < 1 {
-		L.Fatalf("Startup failed:  -x (max tickets/txn) must be at least 1")
+	scale := scaling{interval: *dpScaleInterval, minWindows: *ipMinWindows, maxWindows: *ipMaxWindows, up: *fpScaleUp, down: *fpScaleDown}
+	if scale.maxWindows == 0 {
+		scale.maxWindows = dims.windows
+	}
+	if err := checkScaling(scale, dims.windows); err != nil {
+		L.Fatalf("Startup failed:\n%v", err)
+	}
+	corpora, err := windowCorpora(*ipCorpusSeed, *ipCorpusSize, dims)
+	if err != nil {
+		L.Fatalf("Startup failed:\n%v", err)
 	}
 
-	L.Printf("\n!!!TODO!!!  Need to have the server wait to init the tickets system until we call it.  Or, we need a way to query the configuration from the running server, when WE start up.  For now, you must be sure that the startup parameters of the server and the theatre match.\n\n")
+	L.Printf("\n!!!TODO!!!  The movies, showings and windows are fetched from the server, but the exchanges and seats are not.  For now, you must be sure that those startup parameters of the server and the theatre match.\n\n")
 	// prevent unused variable complaints, until the init problem is straightened out:
 	runtime.KeepAlive(ipExchanges)
 	runtime.KeepAlive(ipSeats)
 
-	chTracker := make(chan interface{}, 5) // All message TO tracker go over this channel (msgTicketSale, msgExchange, and some msgDone)
-	chStopWin := make(chan msgStop)        // Used to broadcast shutdown order to ticket windows, by closing the channel, as advised by Donovan & Kernighan, pg 251
-	chDone := make(chan interface{})       // Passes msgDone back to main()
-	chCafeteria := make(chan xchData, 2)   // Not sure whether buffering is good or bad, here.  Passes xchData to the Cafeteria.  When closed, the Cafeteria knows to close.
+	chTracker := make(chan interface{}, 5)              // All message TO tracker go over this channel (msgTicketSale, msgExchange, and some msgDone)
+	chStopWin := make(chan msgStop)                     // Used to broadcast shutdown order to ticket windows, by closing the channel, as advised by Donovan & Kernighan, pg 251
+	chDone := make(chan interface{})                    // Passes msgDone (and the tracker's msgTrackerDone) back to main()
+	chCafeteria := make(chan xchData, cafeteriaQueue)   // Passes xchData to the Cafeteria, which queue up here while it is busy (see -exchange-time).  When closed, the Cafeteria knows to close.
+	chControls := make([]chan msgPause, dims.windows+1) // chControls[i] passes msgPause to window i, to pause, resume or close it.  chControls[0] is not used.
+	chCommands := make(chan msgCommand)                 // Passes the commands typed on stdin to the tracker.  Never closed, since the tracker stops listening when it shuts down.
+	chThroughput := make(chan int, 1)                   // Passes the tickets sold in each -scale-interval from the tracker to the supervisor.  Never closed;  the supervisor stops when chStopWin is closed.
+	chScaling := make(chan msgScale)                    // Passes the supervisor's requests to open or close a window to the tracker.  Never closed, for the same reason.
+	for i := 1; i <= dims.windows; i++ {
+		chControls[i] = make(chan msgPause)
+	}
 	// Since we're not doing customers or actually watching the movies, we
 	// don't need a channel for sending tickets or customers from the
 	// ticket windows into the theatre spaces.
@@ -157,88 +322,444 @@ func main() {
 	//        There is also no way to query the status of other goroutines,
 	//        or to forcibly terminate them.
 	//
-	//   *  shutdowns are initiated from tracker()
+	//   *  shutdowns are initiated from tracker(), when the run time is up,
+	//      the -target-sold count is reached, or "stop" is typed on stdin
 	//   *  first, it closes chStopWin, which connects tracker to the ticket
 	//      windows.
 	//   *  all of the ticket windows see that, and they terminate.
 	//      They send msgDone on chTracker to notify tracker, and
 	//      on chDone to notify main().
+	//   *  The break schedule and the supervisor see it too, and give up.
+	//      Nobody waits for them.  Before the supervisor starts or shuts
+	//      down a window, it asks the tracker (on chScaling), which says no
+	//      once chStopWin is closed, so that the tracker and main() always
+	//      know how many windows to wait for.  It tells main() about each
+	//      window it starts with a msgWindowOpened on chDone.  A window it
+	//      shuts down sends msgDone, as above.
 	//   *  While shutting down, window 1 closes chCafeteria
 	//   *  When the Cafeteria notices chCafeteria is closed, then in
 	//      it closes, and sends msgDone on chTracker and chDone.
 	//   *  When tracker has msgDone from the Cafeteria and all ticket
 	//      windows, then tracker prints the summary report, sends
-	//      msgDone on chDone to main(), and shuts down.
+	//      msgTrackerDone, with its totals, on chDone to main(), and shuts
+	//      down.
 	//   *  When main has msgDone (on chDone) from all goroutines,
-	//      then it shuts down, also.
+	//      then it logs the shutdown summary, and shuts down, also.
 
-	go tracker(chTracker, chStopWin, chDone, *dpTime, *ipWindows, *ipMovies, *ipShowings)
+	opened := dims.windows // windows started now;  with auto-scaling, the supervisor starts the rest when they're needed
+	if scale.interval > 0 {
+		opened = scale.minWindows
+	}
+	go tracker(chTracker, chStopWin, chDone, chCommands, chThroughput, chScaling, *dpTime, *ipTargetSold, *dpReportInterval, scale.interval, opened, dims.windows, dims.movies, dims.showings)
 	runtime.Gosched() // give the tracker a chance to get started
-	go cafeteria(chTracker, chDone, chCafeteria)
+	go readCommands(os.Stdin, console, chCommands)
+	go cafeteria(chTracker, chDone, chCafeteria, *dpExchangeTime)
 	runtime.Gosched() // and give the Cafeteria a chance to get started, also
-	for i := 1; i <= *ipWindows; i++ {
-		go window(chTracker, chStopWin, chDone, chCafeteria, i, *ipMovies, *ipShowings, *ipMax, *dpAvgDelay)
+	for i := 1; i <= opened; i++ {
+		go window(chTracker, chStopWin, chDone, chCafeteria, chControls[i], i, dims, *ipMax, corpora[i], *dpAvgDelay)
 		// we don't have a customer-provider, so we don't need to wait for the windows to open up
 	}
+	scheduleBreaks(breaks, chControls, chStopWin)
+	if scale.interval > 0 {
+		openWindow := func(i int) {
+			chDone <- msgWindowOpened{head: msgHeader{at: time.Now(), from: "supervisor"}, window: i}
+			go window(chTracker, chStopWin, chDone, chCafeteria, chControls[i], i, dims, *ipMax, corpora[i], *dpAvgDelay)
+		}
+		go supervisor(chThroughput, chScaling, chControls, chStopWin, scale, openWindow)
+	}
 
-	var iGortns = 1 + 1 + *ipWindows // number of Goroutines we started with = number we're still waiting for
+	var iGortns = 1 + 1 + opened // number of Goroutines we started with (plus those the supervisor has started since) = number we're still waiting for
+	var totals runTotals
 shutdnloop:
 	for {
 		msg := <-chDone
 		L.Printf("SHUTDOWN - Received %T %+v over chDone\n", msg, msg)
 		switch msg.(type) {
+		case msgTrackerDone:
+			totals = msg.(msgTrackerDone).totals
+			if iGortns--; iGortns <= 0 {
+				break shutdnloop
+			}
 		case msgDone:
 			if iGortns--; iGortns <= 0 {
 				break shutdnloop
 			}
+		case msgWindowOpened:
+			iGortns++
 		// handle other msg types here, if needed
 		default: // ignore it
 		}
 	}
 	L.Printf("SHUTDOWN - All Goroutines have exited.  Shutting down.\n")
+	L.Printf("%s\n", shutdownSummary(totals))
 	return
 } // main
 
+// shutdownSummary puts the tracker's totals on one line, as key=value pairs,
+// for main() to log at the very end, so that they are easy to find (and to
+// pick out with a script).
+func shutdownSummary(totals runTotals) string {
+	return fmt.Sprintf("SHUTDOWN SUMMARY runTime=%v ticketsSold=%d soldOut=%d exchanges=%d",
+		totals.runTime.Round(time.Millisecond), totals.ticketsSold, totals.soldOut, totals.exchanges)
+} // shutdownSummary
+
+// tallyExchange counts one exchange into exchangesByPair, by the goodie given
+// up and the goodie received.
+func tallyExchange(exchangesByPair map[xchPair]int, x msgExchange) {
+	exchangesByPair[xchPair{old: x.xchOld, new: x.xchNew}]++
+} // tallyExchange
+
+// targetReached tells whether the grand total of the ticket sales in sold has
+// reached targetSold.  A targetSold of 0 means there is no target.
+func targetReached(sold tickets.SalesReport, targetSold int) bool {
+	if targetSold <= 0 {
+		return false
+	}
+	return sold.Total() >= targetSold
+} // targetReached
+
+// checkFlags checks the command line options which have limits.
+//
+// Returns nil if they are all valid.  Otherwise, an error (made by
+// errors.Join) which reports every invalid option, one per line, so that
+// they can all be fixed at once.
+func checkFlags(avgDelay time.Duration, runningtime time.Duration, maxRunningTime time.Duration, targetSold int, maxTix int, httpTimeout time.Duration, reportInterval time.Duration, exchangeTime time.Duration) error {
+	var problems []error
+	if avgDelay < 0 {
+		problems = append(problems, errors.New("-a (average inter-txn delay) must not be negative"))
+	}
+	if err := checkRunTime(runningtime, maxRunningTime); err != nil {
+		problems = append(problems, err)
+	}
+	if targetSold < 0 {
+		problems = append(problems, errors.New("-target-sold must not be negative"))
+	}
+	if maxTix < 1 {
+		problems = append(problems, errors.New("-x (max tickets/txn) must be at least 1"))
+	}
+	if httpTimeout < 1 {
+		problems = append(problems, errors.New("-http-timeout must be at least 1ns"))
+	}
+	if reportInterval < 0 {
+		problems = append(problems, errors.New("-report-interval must not be negative"))
+	}
+	if exchangeTime < 0 {
+		problems = append(problems, errors.New("-exchange-time must not be negative"))
+	}
+	return errors.Join(problems...)
+} // checkFlags
+
+// parseBreaks parses the -breaks option:  a comma-separated list of ticket
+// window breaks, each written <window>@<start>+<length>, where start and
+// length are time.Durations.  For example, "2@1m+30s" pauses window 2 for
+// 30s, starting 1m after the model starts.
+//
+// Returns the breaks, or an error (made by errors.Join) reporting every
+// break which is malformed or names a window which is not between 1 and
+// windows.  An empty spec means no breaks.
+func parseBreaks(spec string, windows int) ([]winBreak, error) {
+	var breaks []winBreak
+	var problems []error
+	if spec == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		var b winBreak
+		wStr, rest, okAt := strings.Cut(strings.TrimSpace(item), "@")
+		startStr, lengthStr, okPlus := strings.Cut(rest, "+")
+		if !okAt || !okPlus {
+			problems = append(problems, fmt.Errorf("-breaks '%s' is not <window>@<start>+<length>", item))
+			continue
+		}
+		var err error
+		if b.window, err = strconv.Atoi(wStr); err != nil || b.window < 1 || b.window > windows {
+			problems = append(problems, fmt.Errorf("-breaks '%s' window must be 1 to %d", item, windows))
+			continue
+		}
+		if b.start, err = time.ParseDuration(startStr); err != nil || b.start < 0 {
+			problems = append(problems, fmt.Errorf("-breaks '%s' start must be a duration of 0 or more", item))
+			continue
+		}
+		if b.length, err = time.ParseDuration(lengthStr); err != nil || b.length < 1 {
+			problems = append(problems, fmt.Errorf("-breaks '%s' length must be a duration of at least 1ns", item))
+			continue
+		}
+		breaks = append(breaks, b)
+	}
+	if err := errors.Join(problems...); err != nil {
+		return nil, err
+	}
+	return breaks, nil
+} // parseBreaks
+
+// scheduleBreaks starts a goroutine for each break, which tells the window
+// to pause (on its chControls channel) when the break starts, and to resume
+// when it ends.  Once chStopWin is closed, the goroutines give up instead.
+// If the supervisor has the window closed when its break starts, then the
+// break waits for it to be opened again, and lasts as long from then.
+func scheduleBreaks(breaks []winBreak, chControls []chan msgPause, chStopWin chan msgStop) {
+	for _, b := range breaks {
+		go func(b winBreak) {
+			for _, step := range []struct {
+				wait  time.Duration
+				pause bool
+			}{{b.start, true}, {b.length, false}} {
+				select {
+				case <-time.After(step.wait):
+				case <-chStopWin:
+					return
+				}
+				select {
+				case chControls[b.window] <- msgPause{head: msgHeader{at: time.Now(), from: "break schedule"}, pause: step.pause}:
+				case <-chStopWin:
+					return
+				}
+			}
+		}(b)
+	}
+} // scheduleBreaks
+
+// readCommands is run as a goroutine.  It reads commands from in, one per
+// line, and passes cmdReport and cmdStop to the tracker on chCommands.
+// Case and surrounding blanks don't matter, and blank lines are skipped.
+// Anything else gets a reminder of the commands on out.  It returns at the
+// end of in (e.g. if stdin isn't a terminal), without closing chCommands.
+func readCommands(in io.Reader, out io.Writer, chCommands chan<- msgCommand) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		cmd := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		switch cmd {
+		case "":
+		case cmdReport, cmdStop:
+			L.Printf("readCommands:  command %q typed\n", cmd)
+			chCommands <- msgCommand{head: msgHeader{at: time.Now(), from: "stdin"}, what: cmd}
+		default:
+			fmt.Fprintf(out, "Unknown command %q;  type %q for an interim summary report, or %q to shut down now\n", cmd, cmdReport, cmdStop)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		L.Printf("readCommands:  Error reading commands:  %v\n", err)
+	}
+} // readCommands
+
+// checkScaling checks the auto-scaling options (see scaling), for a theatre
+// with windows ticket windows.  The minimum and maximum must be in range, and
+// scale-down must be below scale-up (or windows would be opened and closed
+// over and over).
+//
+// Returns nil if auto-scaling is off, or the options are valid.  Otherwise,
+// an error (made by errors.Join) which reports every invalid option.
+func checkScaling(scale scaling, windows int) error {
+	if scale.interval < 0 {
+		return errors.New("-scale-interval must not be negative")
+	}
+	if scale.interval == 0 {
+		return nil
+	}
+	var problems []error
+	if scale.minWindows < 1 || scale.minWindows > windows {
+		problems = append(problems, fmt.Errorf("-scale-min %d must be 1 to %d", scale.minWindows, windows))
+	}
+	if scale.maxWindows < scale.minWindows || scale.maxWindows > windows {
+		problems = append(problems, fmt.Errorf("-scale-max %d must be -scale-min to %d", scale.maxWindows, windows))
+	}
+	if scale.down < 0 || scale.down >= scale.up {
+		problems = append(problems, fmt.Errorf("-scale-down %v must be at least 0, and below -scale-up %v", scale.down, scale.up))
+	}
+	return errors.Join(problems...)
+} // checkScaling
+
+// scaleDecision decides how many ticket windows should be open, when open
+// of them sold sold tickets in the last interval, by scale (see supervisor).
+// It opens or closes one window at a time, within scale's minimum and
+// maximum.
+func scaleDecision(open int, sold int, scale scaling) int {
+	perWindow := float64(sold) / float64(open)
+	switch {
+	case perWindow >= scale.up && open < scale.maxWindows:
+		return open + 1
+	case perWindow <= scale.down && open > scale.minWindows:
+		return open - 1
+	}
+	return open
+} // scaleDecision
+
+// supervisor is run as a goroutine, if auto-scaling is on (see
+// -scale-interval).  It opens and closes ticket windows as the sales go up
+// and down, by starting window goroutines and shutting them down.  The
+// windows are opened and closed from the highest number down, so window 1
+// (which directs customers to the Cafeteria, and closes chCafeteria when it
+// shuts down) is always open.
+//
+// main() starts windows 1 to scale.minWindows.  Then, for each count on
+// chThroughput (the tickets sold in one scale.interval, from the tracker),
+// the supervisor opens or closes a window as scaleDecision says.  To open
+// one, it asks the tracker (on chScaling) to count it, and then calls
+// openWindow, which tells main() and starts the goroutine.  To close one, it
+// asks the tracker, and then sends it a msgPause with close set, on its
+// chControls channel;  the window then shuts down as it does at the end of
+// the run.  If the tracker says no (because the model is shutting down), or
+// once chStopWin is closed, it gives up, as the break schedule does.
+//
+// Returns the number of windows which were open when it stopped.
+func supervisor(chThroughput <-chan int, chScaling chan<- msgScale, chControls []chan msgPause, chStopWin chan msgStop, scale scaling, openWindow func(window int)) int {
+	ask := func(window int, open bool) bool {
+		ok := make(chan bool, 1)
+		select {
+		case chScaling <- msgScale{head: msgHeader{at: time.Now(), from: "supervisor"}, window: window, open: open, ok: ok}:
+			return <-ok
+		case <-chStopWin:
+			return false
+		}
+	}
+
+	open := scale.minWindows
+	L.Printf("supervisor started with %d of %d ticket windows open.\n", open, len(chControls)-1)
+	for {
+		select {
+		case sold := <-chThroughput:
+			want := scaleDecision(open, sold, scale)
+			switch {
+			case want > open:
+				if !ask(want, true) {
+					return open
+				}
+				openWindow(want)
+			case want < open:
+				if !ask(open, false) {
+					return open
+				}
+				select {
+				case chControls[open] <- msgPause{head: msgHeader{at: time.Now(), from: "supervisor"}, close: true}:
+				case <-chStopWin: // it is shutting down anyway
+					return want
+				}
+			default:
+				continue
+			}
+			L.Printf("supervisor:  %d tickets sold at %d windows, %d windows open now.\n", sold, open, want)
+			open = want
+		case <-chStopWin:
+			return open
+		}
+	}
+} // supervisor
+
+// checkRunTime checks the -t running time against the -max-runtime cap.
+//
+// Returns an error if runningtime is less than 1ns, or more than maxRunningTime
+// (or if maxRunningTime itself is less than 1ns), or nil.
+func checkRunTime(runningtime time.Duration, maxRunningTime time.Duration) error {
+	if maxRunningTime < 1 {
+		return errors.New("-max-runtime must be at least 1ns")
+	}
+	if runningtime < 1 {
+		return errors.New("-t (time to run the model) must be at least 1ns")
+	}
+	if runningtime > maxRunningTime {
+		return fmt.Errorf("-t (time to run the model) %v is more than -max-runtime %v", runningtime, maxRunningTime)
+	}
+	return nil
+} // checkRunTime
+
 // tracker is run as a goroutine.
 // It tracks the activity of the cafeteria and ticket windows.
-// When the user-specified time elapses, it instructs the ticket windows and
-// the cafeteria to close.  When everybody has closed, then it generates a
-// summary report and closes down.
+// When the user-specified time elapses (or the target number of tickets has
+// been sold), it instructs the ticket windows and the cafeteria to close.
+// When everybody has closed, then it generates a summary report and closes
+// down.
 //
 // Parameters:
 //
 // chTracker
 //    The channel which tracker should listen on to receive sales and exchange
-//    notifications from the cafeteria and ticket windows.
+//    notifications from the cafeteria and ticket windows, and notifications
+//    of how long ticket windows were paused for.
 // chStopWin
 //    This channel never carries any actual traffic.  Instead, it is used as a
 //    broadcast one-shot (by closing it), to sidgnal ticket windows to close.
 // chDone
 //    The common channel which all goroutines use to communicate run status.
+//    The tracker's last message on it is a msgTrackerDone, with its totals.
+// chCommands
+//    The commands typed on stdin (see readCommands):  cmdReport writes an
+//    interim summary report to the console, and cmdStop shuts down as if
+//    the run time were up.  May be nil, for no commands.
+// chThroughput
+//    Where the tracker sends the number of tickets sold (not counting the
+//    sold-out placeholders) in each scaleInterval, for the supervisor.  The
+//    tracker never waits for it:  a count is dropped if the last one hasn't
+//    been taken yet.
+// chScaling
+//    The supervisor's requests to open or close a ticket window.  Until
+//    chStopWin is closed, the tracker says yes, and counts an opened window
+//    in winctr, and the time a window is closed for as paused time (for the
+//    Utilization report);  after, it says no.  May be nil, if there is no
+//    auto-scaling.
 // runningtime
 //    How long the tracker should allow the theatre to be open.
 //    It is a time.Duration, and comes from the runTime const or the -t option.
+//    It is cut down to runTimeCap, if it is longer.
+// targetSold
+//    If not 0, then the tracker also closes the theatre as soon as this many
+//    tickets have been sold, if that comes before runningtime is up.
+//    It comes from the -target-sold option.
+// reportInterval
+//    If not 0, then the tracker also writes an interim summary report (see
+//    writeSnapshot) this often, until it shuts down.  It comes from the
+//    -report-interval option.
+// scaleInterval
+//    If not 0, then how often the tracker sends a count on chThroughput.  It
+//    comes from the -scale-interval option.
 // winctr
-//    How many ticket windows were opened.
+//    How many ticket windows were opened at the start (windows 1 to winctr).
+// windows
+//    How many ticket windows there are.  Windows winctr+1 to windows are
+//    closed until the supervisor opens them.
 // movies
 //    How many movies there are.
 // showings
 //    How many showings per day of each movie.
 //
 // Returns nothing
-func tracker(chTracker chan interface{}, chStopWin chan msgStop, chDone chan interface{}, runningtime time.Duration, winctr int, movies int, showings int) {
-	if chTracker == nil || chStopWin == nil || chDone == nil || runningtime < 1 || winctr < 1 || movies < 1 || showings < 1 {
-		L.Fatalf("tracker() called with invalid parameters:\nchTracker=%v\nchStopWin=%v\nchDone=%v\nrunningtime=%v, winctr=%d, movies=%d, showings=%d\n",
-			chTracker, chStopWin, chDone, runningtime, winctr, movies, showings)
+func tracker(chTracker chan interface{}, chStopWin chan msgStop, chDone chan interface{}, chCommands <-chan msgCommand, chThroughput chan<- int, chScaling <-chan msgScale, runningtime time.Duration, targetSold int, reportInterval time.Duration, scaleInterval time.Duration, winctr int, windows int, movies int, showings int) {
+	if chTracker == nil || chStopWin == nil || chDone == nil || runningtime < 1 || targetSold < 0 || reportInterval < 0 || scaleInterval < 0 || (scaleInterval > 0 && chThroughput == nil) || winctr < 1 || windows < winctr || movies < 1 || showings < 1 {
+		L.Fatalf("tracker() called with invalid parameters:\nchTracker=%v\nchStopWin=%v\nchDone=%v\nchThroughput=%v\nrunningtime=%v, targetSold=%d, reportInterval=%v, scaleInterval=%v, winctr=%d, windows=%d, movies=%d, showings=%d\n",
+			chTracker, chStopWin, chDone, chThroughput, runningtime, targetSold, reportInterval, scaleInterval, winctr, windows, movies, showings)
 	}
 
+	if runningtime > runTimeCap {
+		L.Printf("tracker:  running time %v is beyond the %v cap; using the cap\n", runningtime, runTimeCap)
+		runningtime = runTimeCap
+	}
 	shutdownTimer := time.NewTimer(runningtime)
 	var chTrackerOpen = true
+	var stopping = false // has chStopWin been closed yet?
 	var cafeteriaClosed = false
 	var exchangeCtr = 0
-	var ticketsSold = make([][]int, movies+1, movies+1) // the last one will be used for totals for each showing, and a grand total
-	for i, _ := range ticketsSold {
-		ticketsSold[i] = make([]int, showings+1, showings+1) // the last one will be used for totals for the movie
+	var exchangesByPair = make(map[xchPair]int)
+	var maxXchQueue = 0                               // deepest the cafeteria's queue has been
+	var pausedTime = make([]time.Duration, windows+1) // how long each window was paused (or closed) for;  pausedTime[0] is not used
+	var openedAt = time.Now()
+	var closedAt = make(map[int]time.Time) // when each window which is closed now was closed, to add to its pausedTime when it opens
+	for i := winctr + 1; i <= windows; i++ {
+		closedAt[i] = openedAt
+	}
+	var sales = tickets.NewAggregator(movies, showings) // the ticket sales, by movie and showing, with their totals
+
+	var chReport <-chan time.Time // stays nil (never ready) if there are no interim reports
+	var snapshots sync.WaitGroup  // interim reports still being written
+	if reportInterval > 0 {
+		reportTicker := time.NewTicker(reportInterval)
+		defer reportTicker.Stop()
+		chReport = reportTicker.C
+	}
+	var chScale <-chan time.Time // stays nil if there is no auto-scaling
+	var lastScaleSold = 0        // tickets sold (not counting placeholders) as of the last count on chThroughput
+	if scaleInterval > 0 {
+		scaleTicker := time.NewTicker(scaleInterval)
+		defer scaleTicker.Stop()
+		chScale = scaleTicker.C
 	}
 
 	L.Printf("tracker started ... entering main event/wait loop ...\n")
@@ -248,9 +769,64 @@ mainloop:
 
 		select {
 		case s := <-shutdownTimer.C:
-			L.Printf("SHUTDOWN - time signal received:  %v  --  notifying ticket windows.\n", s)
-			close(chStopWin) // propagate shutdown to all ticket windows.
+			if !stopping {
+				L.Printf("SHUTDOWN - time signal received:  %v  --  notifying ticket windows.\n", s)
+				close(chStopWin) // propagate shutdown to all ticket windows.
+				stopping = true
+			}
 			shutdownTimer.Stop()
+		case <-chReport:
+			// Write the report from copies of the counts, in the background,
+			// so that the windows aren't held up waiting on chTracker while
+			// it is written.  Only tracker changes the counts, so they can be
+			// copied without a lock.  (sales makes its own copy.)
+			byPair, paused := copyCounts(exchangesByPair, pausedTime, closedAt)
+			sold := sales.Report().Sold
+			snapshots.Add(1)
+			go func(exchangeCtr int, maxXchQueue int, openFor time.Duration) {
+				defer snapshots.Done()
+				writeSnapshot(exchangeCtr, byPair, maxXchQueue, sold, openFor, paused)
+			}(exchangeCtr, maxXchQueue, time.Since(openedAt))
+		case <-chScale:
+			sold := sales.Report()
+			select {
+			case chThroughput <- sold.Total() - sold.SoldOut - lastScaleSold:
+				lastScaleSold = sold.Total() - sold.SoldOut
+			default: // the supervisor hasn't taken the last count yet
+			}
+		case rqst := <-chScaling:
+			L.Printf("Processing window scaling request:  %+v\n", rqst)
+			switch {
+			case stopping:
+			case rqst.open:
+				winctr++
+				pausedTime[rqst.window] += time.Since(closedAt[rqst.window])
+				delete(closedAt, rqst.window)
+			default:
+				closedAt[rqst.window] = time.Now()
+			}
+			rqst.ok <- !stopping
+		case c := <-chCommands:
+			L.Printf("Processing command:  %+v\n", c)
+			switch c.what {
+			case cmdReport:
+				// In the background, from copies, as for the interim report
+				// files.
+				byPair, paused := copyCounts(exchangesByPair, pausedTime, closedAt)
+				sold := sales.Report().Sold
+				snapshots.Add(1)
+				go func(exchangeCtr int, maxXchQueue int, openFor time.Duration) {
+					defer snapshots.Done()
+					summarize(console, time.Now().Format("2006-01-02 15:04:05")+" (interim)", exchangeCtr, byPair, maxXchQueue, sold, openFor, paused)
+				}(exchangeCtr, maxXchQueue, time.Since(openedAt))
+			case cmdStop:
+				if !stopping {
+					L.Printf("SHUTDOWN - stop command received  --  notifying ticket windows.\n")
+					close(chStopWin) // propagate shutdown to all ticket windows.
+					stopping = true
+					shutdownTimer.Stop()
+				}
+			}
 		case x, ok := <-chTracker:
 			if !ok {
 				break mainloop
@@ -259,14 +835,24 @@ mainloop:
 			case msgExchange:
 				L.Printf("Processing Exchange notification:  %+v\n", x)
 				exchangeCtr++
+				tallyExchange(exchangesByPair, x.(msgExchange))
 			case msgTicketSale:
 				L.Printf("Processing ticket sales notification:  %+v\n", x)
-				for _, t := range x.(msgTicketSale).ticks {
-					ticketsSold[t.Movie][t.Showing]++ // the particular movie and showing
-					ticketsSold[t.Movie][showings]++  // the movie subtotal
-					ticketsSold[movies][t.Showing]++  // the showing subtotal
-					ticketsSold[movies][showings]++   // the grand total
+				sales.Add(x.(msgTicketSale).ticks)
+				if sold := sales.Report(); !stopping && targetReached(sold, targetSold) {
+					L.Printf("SHUTDOWN - %d tickets sold, target of %d reached  --  notifying ticket windows.\n", sold.Total(), targetSold)
+					close(chStopWin) // propagate shutdown to all ticket windows.
+					stopping = true
+					shutdownTimer.Stop()
+				}
+			case msgXchQueue:
+				L.Printf("Processing cafeteria queue notification:  %+v\n", x)
+				if d := x.(msgXchQueue).maxDepth; d > maxXchQueue {
+					maxXchQueue = d
 				}
+			case msgPaused:
+				L.Printf("Processing window pause notification:  %+v\n", x)
+				pausedTime[x.(msgPaused).window] += x.(msgPaused).paused
 			case msgDone:
 				if strings.Contains(strings.ToLower(x.(msgDone).head.from), "cafeteria") {
 					cafeteriaClosed = true
@@ -287,6 +873,7 @@ mainloop:
 	} // main loop
 
 	L.Printf("SHUTDOWN - tracker exited main loop.  Printing summary reports and exiting.\n")
+	snapshots.Wait() // so that no interim report is left half written
 	// We are shutting down.  The cafeteria and all of the ticket windows have
 	// already shut down.  Time to print the reports and shut down, ourselves.
 
@@ -305,32 +892,137 @@ mainloop:
 		log.Fatalf("%s aborting:  Error setting up summry report file '%s':  %v", name, summaryReportName, srErr)
 	}
 
-	fmt.Fprintf(summaryReport, `Ticket and Exchange Report                             %s
+	sold := sales.Report()
+	for i, at := range closedAt { // windows which were still closed at the end
+		pausedTime[i] += time.Since(at)
+	}
+	summarize(summaryReport, summaryReportHead, exchangeCtr, exchangesByPair, maxXchQueue, sold.Sold, time.Since(openedAt), pausedTime)
+
+	totals := runTotals{runTime: time.Since(openedAt), ticketsSold: sold.Total() - sold.SoldOut, soldOut: sold.SoldOut, exchanges: exchangeCtr}
+	chDone <- msgTrackerDone{head: msgHeader{at: time.Now(), from: "tracker"}, totals: totals}
+	//runtime.Goexit   ---   getting strange error "runtime.Goexit evaluated but not used"
+
+} // tracker
+
+// copyCounts makes copies of the tracker's counts, for writeSnapshot to work
+// from while tracker carries on changing the originals.  (The ticket sales
+// are copied by their Aggregator's Report.)  The copy of pausedTime includes
+// the time so far of the windows which are closed now (see closedAt in
+// tracker).
+func copyCounts(exchangesByPair map[xchPair]int, pausedTime []time.Duration, closedAt map[int]time.Time) (map[xchPair]int, []time.Duration) {
+	byPair := make(map[xchPair]int, len(exchangesByPair))
+	for pair, n := range exchangesByPair {
+		byPair[pair] = n
+	}
+	paused := append([]time.Duration(nil), pausedTime...)
+	for i, at := range closedAt {
+		paused[i] += time.Since(at)
+	}
+	return byPair, paused
+} // copyCounts
+
+// createSnapshot creates an interim summary report file.  It is only
+// changed by tests.
+var createSnapshot = os.Create
+
+// writeSnapshot writes an interim summary report of the counts so far (see
+// summarize) to a new file, named for the current time.  A failure is only
+// logged, so that it can't stop the model.
+func writeSnapshot(exchangeCtr int, exchangesByPair map[xchPair]int, maxXchQueue int, ticketsSold [][]int, openFor time.Duration, pausedTime []time.Duration) {
+	now := time.Now()
+	snapshotName := summaryReportBase + "interim." + now.Format("2006-01-02t15-04-05.000z-0700")
+	snapshot, err := createSnapshot(snapshotName)
+	if err != nil {
+		L.Printf("tracker:  Error setting up interim summary report file '%s':  %v\n", snapshotName, err)
+		return
+	}
+	defer snapshot.Close()
+	summarize(snapshot, now.Format("2006-01-02 15:04:05")+" (interim)", exchangeCtr, exchangesByPair, maxXchQueue, ticketsSold, openFor, pausedTime)
+	L.Printf("tracker wrote interim summary report '%s'\n", snapshotName)
+} // writeSnapshot
+
+// summarize writes the summary report for the run to w:  the exchange count
+// and per-goodie breakdown, the cafeteria's deepest queue, the ticket sales
+// per movie and showing, the ticket window utilization, and the tickets
+// server call latency.
+//
+// Parameters:
+//
+// head
+//    The date and time to put in the report's heading.
+// exchangeCtr
+//    How many exchanges were performed.
+// exchangesByPair
+//    How many exchanges were performed of each goodie for each other goodie
+//    (see tallyExchange).
+// maxXchQueue
+//    The most exchange requests which were waiting for the cafeteria at
+//    once (including the one being served).
+// ticketsSold
+//    The ticket sales, laid out as in tickets.SalesReport.Sold.
+// openFor
+//    How long the theatre was open.
+// pausedTime
+//    How long each ticket window was paused (or closed, by the supervisor)
+//    for during that time, indexed by window number (pausedTime[0] is not
+//    used).
+func summarize(w io.Writer, head string, exchangeCtr int, exchangesByPair map[xchPair]int, maxXchQueue int, ticketsSold [][]int, openFor time.Duration, pausedTime []time.Duration) {
+	movies := len(ticketsSold) - 1
+	showings := len(ticketsSold[movies]) - 1
+
+	fmt.Fprintf(w, `Ticket and Exchange Report                             %s
 
 %d Exchanges performed
+%d Exchange requests waiting for the cafeteria, at most
 
 Ticket Sales per Movie and Showing
-             `, summaryReportHead, exchangeCtr)
+             `, head, exchangeCtr, maxXchQueue)
 	for i := 0; i < movies; i++ {
-		fmt.Fprintf(summaryReport, "Movie %2d  ", i) // Do NOT use a newline here!
+		fmt.Fprintf(w, "Movie %2d  ", i) // Do NOT use a newline here!
 	}
-	fmt.Fprintln(summaryReport, "All movies")
+	fmt.Fprintln(w, "All movies")
 	for j := 0; j <= showings; j++ {
 		if j == showings {
-			fmt.Fprintf(summaryReport, "All showings ") // NO NL
+			fmt.Fprintf(w, "All showings ") // NO NL
 		} else {
-			fmt.Fprintf(summaryReport, "Showing %2d   ", j) // NO NL
+			fmt.Fprintf(w, "Showing %2d   ", j) // NO NL
 		}
 		for i := 0; i <= movies; i++ {
-			fmt.Fprintf(summaryReport, "%8d  ", ticketsSold[i][j])
+			fmt.Fprintf(w, "%8d  ", ticketsSold[i][j])
 		}
-		fmt.Fprintln(summaryReport, "")
+		fmt.Fprintln(w, "")
 	}
 
-	chDone <- msgDone{head: msgHeader{at: time.Now(), from: "tracker"}}
-	//runtime.Goexit   ---   getting strange error "runtime.Goexit evaluated but not used"
+	fmt.Fprintf(w, "\nExchanges per Goodie\n%-20s %-20s %8s\n", "Given up", "Received", "Count")
+	pairs := make([]xchPair, 0, len(exchangesByPair))
+	for pair := range exchangesByPair {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].old != pairs[j].old {
+			return pairs[i].old < pairs[j].old
+		}
+		return pairs[i].new < pairs[j].new
+	})
+	for _, pair := range pairs {
+		fmt.Fprintf(w, "%-20s %-20s %8d\n", pair.old, pair.new, exchangesByPair[pair])
+	}
 
-} // tracker
+	fmt.Fprintf(w, "\nTicket Window Utilization (open %v)\n%-10s %12s %12s\n", openFor, "Window", "Paused", "Utilization")
+	for i := 1; i < len(pausedTime); i++ {
+		utilization := 100.0
+		if openFor > 0 {
+			utilization = 100 * float64(openFor-pausedTime[i]) / float64(openFor)
+		}
+		fmt.Fprintf(w, "%-10d %12v %11.1f%%\n", i, pausedTime[i], utilization)
+	}
+
+	fmt.Fprintf(w, "\nTickets Server Call Latency\n%-10s %8s %12s %12s %12s\n", "Call", "Count", "p50", "p90", "p99")
+	for _, kind := range latencies.kinds() {
+		p := latencies.percentiles(kind, 50, 90, 99)
+		fmt.Fprintf(w, "%-10s %8d %12v %12v %12v\n", kind, latencies.count(kind), p[0], p[1], p[2])
+	}
+} // summarize
 
 // cafeteria models the theatre's cafeteria.  It is run as a Goroutine.
 // In the initial implementation, all it does is perform exchanges of free
@@ -355,13 +1047,20 @@ Ticket Sales per Movie and Showing
 //    whether the request is valid or not.
 //    When this channel is closed by a ticket window, it inidates that the
 //    Cafeteria should close down.
+//    Whenever the number of requests waiting on it (including the one being
+//    served) is the most yet, the Cafeteria sends a msgXchQueue on chTracker.
+// exchangeTime
+//    How long the Cafeteria takes to serve each exchange request, before
+//    asking the tickets system for the exchange.  It comes from the
+//    -exchange-time option.
 //
 // Returns nothing
-func cafeteria(chTracker chan interface{}, chDone chan interface{}, chCafeteria chan xchData) {
+func cafeteria(chTracker chan interface{}, chDone chan interface{}, chCafeteria chan xchData, exchangeTime time.Duration) {
 
 	// The only possible exchange right now is water for soda:
 	var exchangeold = "water"
 	var exchangenew = "soda"
+	var maxDepth = 0
 
 	L.Printf("cafeteria started ... entering main event/wait loop ...\n")
 
@@ -376,20 +1075,27 @@ func cafeteria(chTracker chan interface{}, chDone chan interface{}, chCafeteria
 				runtime.Goexit()
 			}
 			L.Printf("Cafeteria received exchange request:  %+v\n", x)
-			// make HTTP request to tickets/exchange/<tickNum>/water/soda
-			// if successful (HTTP 204), send a msgExchange to tracker
+			if depth := len(chCafeteria) + 1; depth > maxDepth {
+				maxDepth = depth
+				chTracker <- msgXchQueue{head: msgHeader{at: time.Now(), from: "cafeteria"}, maxDepth: maxDepth}
+			}
+			time.Sleep(exchangeTime) // serving the customer
+			// ask the tickets server to exchange <tickNum>'s water for soda
+			// if successful, send a msgExchange to tracker
 			// if unsuccessful, log it and continue
-			url := fmt.Sprintf("%s/exchange/%d/%s/%s/", ticketServer, x.tickNum, exchangeold, exchangenew)
-			L.Printf("cafeteria GETing exchange from %s\n", url)
-			response, err := http.Get(url)
-			if err != nil {
-				L.Printf("Cafeteria exchange failed:\n\turl=%s\nerr=%v\n", url, err)
-			} else if response.StatusCode == http.StatusNoContent {
+			L.Printf("cafeteria asking %s to exchange ticket %d's %s for %s\n", ticketServer, x.tickNum, exchangeold, exchangenew)
+			start := time.Now()
+			_, err := serverClient().Exchange(x.tickNum, exchangeold, exchangenew)
+			latencies.record("exchange", time.Since(start))
+			var denied *ticketsclient.Error
+			if errors.As(err, &denied) {
+				L.Printf("Cafeteria exchange denied by tickets server:  %v\n", err)
+			} else if err != nil {
+				L.Printf("Cafeteria exchange failed:\n\tserver=%s\nerr=%v\n", ticketServer, err)
+			} else {
 				L.Printf("Cafeteria exchange succeeded.  Notifying tracker ...\n")
 				chTracker <- msgExchange{head: msgHeader{at: time.Now(), from: "cafeteria"}, tickNum: x.tickNum, xchOld: exchangeold, xchNew: exchangenew}
 				L.Printf("Cafeteria exchange notification sent.\n")
-			} else {
-				L.Printf("Cafeteria exchange denied by tickets server with status %s\n", response.Status)
 			}
 		} // select per input event
 	} // main event/wait loop
@@ -400,7 +1106,8 @@ func cafeteria(chTracker chan interface{}, chDone chan interface{}, chCafeteria
 
 // window models a ticket window.  It is run as a Goroutine.
 // In the initial implementation,it sells a random number of tickets for random
-// movies and showings, using the tickets system, and notifies the tracker when
+// movies and showings (or the sales of its corpus, with -corpus-seed), using
+// the tickets system, and notifies the tracker when
 // it has performed the salse.  If this instance of window happens to be window
 // 1, then it also selects a random subset of the sold tickets to go to the
 // Cafeteria and exchange their water for soda.  The Cafeteria is responsible
@@ -426,20 +1133,28 @@ func cafeteria(chTracker chan interface{}, chDone chan interface{}, chCafeteria
 //    to the Cafeteria.
 //    When the window shuts down, it also sends a msgDone to notify the
 //    Cafeteria that it should also shut down.
+// chControl
+//    The channel on which the window is told to pause (it makes no sales
+//    until told to resume) or resume, by a msgPause.  When it resumes (or
+//    shuts down while paused), it sends a msgPaused on chTracker, with how
+//    long it was paused for.  A msgPause with close set (from the
+//    supervisor) shuts the window down, as closing chStopWin does.  May be
+//    nil, if the window is never paused or closed.
 // iWindow
 //    This window's Window number.  Window 1 is special, because only it is
 //    authorized to give out promotional goodies, and to direct interested
 //    customers to the Cafeteria to exchange them.  Assumed to be between 1
-//    and *ipWindows.
-// iMovies
-//    The number of movies available at the theatre, numbered 0 to iMovies-1.
-//    Assumed to be at least 1.
-// iShowings
-//    The number of showings of each movie available at the theatre, numbered
-//    0 to iShowings-1.  Assumed to be at least 1.
+//    and dims.windows.
+// dims
+//    The size of the theatre, from fetchDimensions.  Tickets are requested
+//    for movies 0 to dims.movies-1, and showings 0 to dims.showings-1.
+//    Both are assumed to be at least 1.
 // iMax
 //    The maximum number of tickets the customer is allowed to buy.
 //    Assumed to be at least 1.
+// corpus
+//    The sales to make, over and over, in order (see windowCorpora), or nil
+//    to make random sales (see randomRequest) instead.
 // dAvgDelay
 //    A time.Duration suggesting the average delay between transactions at
 //    the window.  The actual delay between transactions is random, between
@@ -447,7 +1162,7 @@ func cafeteria(chTracker chan interface{}, chDone chan interface{}, chCafeteria
 //    artificial delays are introduced.  Set to 0, if negative.
 //
 // Returns nothing
-func window(chTracker chan interface{}, chStopWin chan msgStop, chDone chan interface{}, chCafeteria chan xchData, iWindow int, iMovies int, iShowings int, iMax int, dAvgDelay time.Duration) {
+func window(chTracker chan interface{}, chStopWin chan msgStop, chDone chan interface{}, chCafeteria chan xchData, chControl chan msgPause, iWindow int, dims dimensions, iMax int, corpus []loadgen.Request, dAvgDelay time.Duration) {
 
 	// Configure random delays averaging dAvgDelay.
 	// Not sure that this is the best way to do this, because it assumes
@@ -457,9 +1172,20 @@ func window(chTracker chan interface{}, chStopWin chan msgStop, chDone chan inte
 	}
 	var randlimit int64
 
+	shutdown := func(why string) {
+		L.Printf("SHUTDOWN - %s.  Shutting down window %d.\n", why, iWindow)
+		chTracker <- msgDone{head: msgHeader{at: time.Now(), from: "window"}} // tell tracker()
+		chDone <- msgDone{head: msgHeader{at: time.Now(), from: "window"}}    // tell main()
+		if iWindow == 1 {
+			close(chCafeteria)
+			L.Printf("SHUTDOWN - window %d closed chCafeteria.  The Cafeteria should beging shutting down now.", iWindow)
+		}
+		runtime.Goexit()
+	}
+
 	L.Printf("window %d started ... entering main event/wait loop ...\n", iWindow)
 
-	for { // process timers, sales, and interrupts until told to stop
+	for sales := 0; ; sales++ { // process timers, sales, and interrupts until told to stop
 
 		switch {
 		case dAvgDelay == 0: // do nothing - no delays
@@ -469,21 +1195,25 @@ func window(chTracker chan interface{}, chStopWin chan msgStop, chDone chan inte
 			time.Sleep(time.Duration(rand.Int63n(randlimit)))
 		}
 
-		makeSale(chTracker, chCafeteria, iWindow, iMovies, iShowings, iMax) // makeSale responsible for error handling/logging
+		rqst := randomRequest(iWindow, dims, iMax)
+		if corpus != nil {
+			rqst = corpus[sales%len(corpus)]
+		}
+		makeSale(chTracker, chCafeteria, rqst) // makeSale responsible for error handling/logging
 
 		select {
 		case m, ok := <-chStopWin:
 			if !ok {
-				L.Printf("SHUTDOWN - chStopWin has been closed and drained.  Shutting down window %d.\n", iWindow)
-				chTracker <- msgDone{head: msgHeader{at: time.Now(), from: "window"}} // tell tracker()
-				chDone <- msgDone{head: msgHeader{at: time.Now(), from: "window"}}    // tell main()
-				if iWindow == 1 {
-					close(chCafeteria)
-					L.Printf("SHUTDOWN - window %d closed chCafeteria.  The Cafeteria should beging shutting down now.", iWindow)
-				}
-				runtime.Goexit()
+				shutdown("chStopWin has been closed and drained")
 			}
 			L.Printf("SHUTDOWN - Unexpected message type %T ignored by window %d on chStopWin:  %+v\n", m, iWindow, m)
+		case c := <-chControl:
+			switch {
+			case c.close:
+				shutdown("closed by the " + c.head.from)
+			case c.pause && pauseWindow(chTracker, chStopWin, chControl, iWindow):
+				shutdown("told to shut down while paused")
+			}
 		default:
 		} // select per input event
 	} // main loop
@@ -492,114 +1222,255 @@ func window(chTracker chan interface{}, chStopWin chan msgStop, chDone chan inte
 
 } // window
 
-// makeSale performs the actual sale at a ticket window.
+// pauseWindow is called by window when it is told to pause.  It waits until
+// the window is told to resume (further pauses are ignored), or to shut down
+// (by chStopWin, or a msgPause with close set), and then sends a msgPaused on
+// chTracker with how long it was paused for.
+//
+// Returns true if the window should shut down, or false if it should resume.
+func pauseWindow(chTracker chan interface{}, chStopWin chan msgStop, chControl chan msgPause, iWindow int) bool {
+	start := time.Now()
+	L.Printf("window %d paused.\n", iWindow)
+	defer func() {
+		chTracker <- msgPaused{head: msgHeader{at: time.Now(), from: "window"}, window: iWindow, paused: time.Since(start)}
+	}()
+	for {
+		select {
+		case _, ok := <-chStopWin:
+			if !ok {
+				return true
+			}
+		case c := <-chControl:
+			if c.close {
+				return true
+			}
+			if !c.pause {
+				L.Printf("window %d resumed after %v.\n", iWindow, time.Since(start))
+				return false
+			}
+		}
+	}
+} // pauseWindow
+
+// randomRequest generates a random sale for a ticket window.
 // It generates random numbers to:
 //   *  determine how many different tickets to buy
 //      (each of the following is done separately for each ticket)
-//   *  choose among 5 movies (or whatever iMovies is)
-//   *  choose among 4 showings (or whatever iShowings is)
+//   *  choose among 5 movies (or whatever dims.movies is)
+//   *  choose among 4 showings (or whatever dims.showings is)
 //   *  decide whether to exchange the promo goodies (Window 1 only)
 //
 // Parameters
 //
-// chTracker
-//    The channel which the window should use to notify the tracker
-//    that an exchange has been performed.
-// chCafeteria
-//    The channel which the window should use to send exchange requests
-//    to the Cafeteria.
 // iWindow
-//    This window's Window number.  Window 1 is special, because only it is
-//    authorized to give out promotional goodies, and to direct interested
-//    customers to the Cafeteria to exchange them.  Assumed to be between 1
-//    and *ipWindows.
-// iMovies
-//    The number of movies available at the theatre, numbered 0 to iMovies-1.
-//    Assumed to be at least 1.
-// iShowings
-//    The number of showings of each movie available at the theatre, numbered
-//    0 to iShowings-1.  Assumed to be at least 1.
+//    The Window number to make the sale at.  Assumed to be between 1 and
+//    dims.windows.
+// dims
+//    The size of the theatre, from fetchDimensions.  Tickets are requested
+//    for movies 0 to dims.movies-1, and showings 0 to dims.showings-1.
+//    Both are assumed to be at least 1.
 // iMax
 //    The maximum number of tickets the customer is allowed to buy.
 //    Assumed to be at least 1.
-func makeSale(chTracker chan interface{}, chCafeteria chan xchData, iWindow int, iMovies int, iShowings int, iMax int) {
-	L.Printf("makeSale(chTracker,chCafeteria,iWindow=%d,iMovies=%d,iShowings=%d,iMax=%d) called.\n",
-		iWindow, iMovies, iShowings, iMax)
-	url := fmt.Sprintf("%s/sell/%d/", ticketServer, iWindow)
+func randomRequest(iWindow int, dims dimensions, iMax int) loadgen.Request {
 	items := 1 + rand.Intn(iMax) // number of items which will be purchased, if they're not sold out already
-	rqst := make(map[string]interface{})
-	rqst["LocalTime"] = time.Now()
-	rqst["PaymentInfo"] = map[string]interface{}{"Reserved": "PaymentInfo is reserved for future use."}
-	rqst["TicketRequests"] = make([][2]int, items, items)
-
+	rqst := loadgen.Request{Window: iWindow, TicketRequests: make([][2]int, items, items), Exchange: make([]bool, items, items)}
 	for i := 0; i < items; i++ {
-		thisMovie := rand.Intn(iMovies)     // movie# indexing is 0-based, rather than 1-based
-		thisShowing := rand.Intn(iShowings) // showing# indexing is 0-based, rather than 1-based
-		rqst["TicketRequests"].([][2]int)[i] = [2]int{thisMovie, thisShowing}
+		thisMovie := rand.Intn(dims.movies)     // movie# indexing is 0-based, rather than 1-based
+		thisShowing := rand.Intn(dims.showings) // showing# indexing is 0-based, rather than 1-based
+		rqst.TicketRequests[i] = [2]int{thisMovie, thisShowing}
+		rqst.Exchange[i] = rand.Intn(10)%2 == 0 // even -> true = try to exchange the water, odd -> false = keep it
 	}
+	return rqst
+} // randomRequest
 
-	L.Printf("makeSale for window %d has generated request:\n%+v\n", iWindow, rqst)
-	// convert rqst to JSON format
-	// make HTTP POST request to tickets/sell/<windowNumber>
-	// if successful (HTTP 200),
+// makeSale performs the actual sale at a ticket window.
+//
+// Parameters
+//
+// chTracker
+//    The channel which the window should use to notify the tracker
+//    that an exchange has been performed.
+// chCafeteria
+//    The channel which the window should use to send exchange requests
+//    to the Cafeteria.
+// rqst
+//    The sale to make (from randomRequest, or a -corpus-seed corpus), at
+//    window rqst.Window.  Window 1 is special, because only it is
+//    authorized to give out promotional goodies, and to direct interested
+//    customers to the Cafeteria to exchange them, for the tickets which
+//    rqst.Exchange says to.
+func makeSale(chTracker chan interface{}, chCafeteria chan xchData, rqst loadgen.Request) {
+	iWindow, ticketRequests := rqst.Window, rqst.TicketRequests
+	L.Printf("makeSale(chTracker,chCafeteria,rqst=%+v) called.\n", rqst)
+	localTime := time.Now()
+	paymentInfo := map[string]interface{}{"Reserved": "PaymentInfo is reserved for future use."}
+
+	L.Printf("makeSale for window %d is making request:\n%+v\n", iWindow, ticketRequests)
+	// ask the tickets server to sell them
+	// if successful,
 	//     send a msgTicketSale to tracker
 	//     determine which to send to the Cafeteria for exchange, and do so
 	// if unsuccessful, log it and continue
-	rqstJSON, err := json.Marshal(rqst)
-	if err != nil {
-		L.Printf("makeSale for window %d failed:  unable to convert rqst to JSON format:  %v\n", iWindow, err)
+	// if partly successful, log it and carry on with the tickets sold
+	L.Printf("makeSale for window %d sending ticket requests to %s\n", iWindow, ticketServer)
+	start := time.Now()
+	ticks, rcpt, err := serverClient().Sell(iWindow, ticketRequests, paymentInfo, localTime)
+	latencies.record("sell", time.Since(start))
+	var denied *ticketsclient.Error
+	if errors.As(err, &denied) && len(ticks) == 0 {
+		L.Printf("makeSale for window %d sell service call failed:  %v\nSale abandoned.\n", iWindow, err)
 		return
-	}
-	L.Printf("makeSale for window %d POSTing ticket requests to %s\n", iWindow, url)
-	response, err := http.Post(url, "application/json", bytes.NewReader(rqstJSON))
-	L.Printf("makeSale for window %d received response:\n%+v\n\n%#v\n", iWindow, response, response)
-	if err != nil {
-		L.Printf("makeSale for window %d failed:  sell service failed:  \n\turl=%s\nerr=%v\n", iWindow, url, err)
+	} else if denied != nil {
+		L.Printf("makeSale for window %d sell service call partly failed:  %v\nKeeping the %d tickets returned.\n", iWindow, err, len(ticks))
+	} else if err != nil {
+		L.Printf("makeSale for window %d failed:  sell service failed:  \n\tserver=%s\nerr=%v\n", iWindow, ticketServer, err)
 		return
-	} else if response.StatusCode == http.StatusOK {
-		var responseData struct {
-			// All fields must be exported (capitalized), to be visible to json.
-			Ticks []tickets.Ticket
-			Rcpt  tickets.Receipt
-		}
-		jbytes, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			L.Printf("makeSale for window %d failed:  cannot read sell service call's response.Body:  %v\n", iWindow, err)
-			return
-		}
+	}
 
-		jbuffer := bytes.NewBuffer(jbytes)
-		L.Printf("makeSale for window %d received %d bytes of raw response.Body:\n%s\n", iWindow, len(jbytes), jbuffer.String())
-		jparser := json.NewDecoder(jbuffer)
-		//jparser := json.NewDecoder(response.Body)
-		defer response.Body.Close()
-		if err := jparser.Decode(&responseData); err != nil {
-			L.Printf("makeSale for window %d failed:  sell service call reported status OK but response data not in JSON format:  %v\n", iWindow, url, err)
-			return
-		}
-		L.Printf("makeSale for window %d sell service call succeeded.  Notifying tracker ...\n", iWindow)
-		chTracker <- msgTicketSale{head: msgHeader{at: time.Now(), from: "window"}, window: iWindow, ticks: responseData.Ticks}
-		L.Printf("makeSale for window %d tracker notification sent.\n", iWindow)
-		L.Printf("makeSale for window %d sell service call succeeded.  Receipt:\n%+v\nTickets:\n", iWindow, responseData.Rcpt)
-		for _, t := range responseData.Ticks {
-			L.Printf("\tticket:  %+v\n", t)
-			if t.Goodies {
-				exchangeIt := rand.Intn(10)%2 == 0 // even -> true = try to exchange the water, odd -> false = keep it
-				if exchangeIt {
-					x := xchData{head: msgHeader{at: time.Now(), from: "window " + strconv.Itoa(iWindow)}, tickNum: t.TicketNum}
-					chCafeteria <- x
-					L.Printf("\t\t(exchange sent:  %+v)\n", x)
-				} else {
-					L.Printf("\t\t(not exchanged)\n")
-				}
+	L.Printf("makeSale for window %d sell service call succeeded.  Notifying tracker ...\n", iWindow)
+	chTracker <- msgTicketSale{head: msgHeader{at: time.Now(), from: "window"}, window: iWindow, ticks: ticks}
+	L.Printf("makeSale for window %d tracker notification sent.\n", iWindow)
+	L.Printf("makeSale for window %d sell service call succeeded.  Receipt:\n%+v\nTickets:\n", iWindow, rcpt)
+	for i, t := range ticks {
+		L.Printf("\tticket:  %+v\n", t)
+		if t.Goodies {
+			if i < len(rqst.Exchange) && rqst.Exchange[i] {
+				x := xchData{head: msgHeader{at: time.Now(), from: "window " + strconv.Itoa(iWindow)}, tickNum: t.TicketNum}
+				chCafeteria <- x
+				L.Printf("\t\t(exchange sent:  %+v)\n", x)
 			} else {
-				L.Printf("\t\t(no goodies to consider exchanging)\n")
+				L.Printf("\t\t(not exchanged)\n")
 			}
+		} else {
+			L.Printf("\t\t(no goodies to consider exchanging)\n")
 		}
-	} else {
-		L.Printf("makeSale for window %d sell service call failed with status %s.  Sale abandoned.\n", iWindow, response.Status)
-		return
 	}
 
 	return
 } // makeSale
+
+// fetchDimensions asks the tickets server how big the theatre is, so that
+// the ticket windows only ask for movies, showings and windows which the
+// server has.  It is called once, by main(), before any of the goroutines
+// are started, and they are each given a copy.
+//
+// Returns the server's dimensions, or (after logging why) fallback, which
+// comes from the -m, -h and -w options, if the server can't be asked or
+// replies with a size of less than 1.
+func fetchDimensions(fallback dimensions) dimensions {
+	cfg, err := serverClient().Config()
+	if err == nil && (cfg.Movies < 1 || cfg.Showings < 1 || cfg.Windows < 1) {
+		err = fmt.Errorf("the server reported %+v", cfg)
+	}
+	if err != nil {
+		L.Printf("Cannot get the theatre's size from %s:  %v\nUsing -m %d -h %d -w %d instead.  They must match the server's.\n", ticketServer, err, fallback.movies, fallback.showings, fallback.windows)
+		return fallback
+	}
+	dims := dimensions{movies: cfg.Movies, showings: cfg.Showings, windows: cfg.Windows}
+	if dims != fallback {
+		L.Printf("Using the server's %d movies, %d showings and %d windows (the -m, -h and -w options are ignored).\n", dims.movies, dims.showings, dims.windows)
+	}
+	return dims
+} // fetchDimensions
+
+// windowCorpora generates the -corpus-seed corpus of size sales for a
+// theatre of dims' size (see loadgen.GenerateRequests), and shares it out
+// among the windows, by each sale's Window, keeping the corpus order.
+//
+// Returns the sales for each window, indexed by Window number (0 is not
+// used);  or nil, so that every window makes random sales, if seed is 0.
+// Returns an error if size is less than 1, or too small to give every window
+// at least one sale.
+func windowCorpora(seed int64, size int, dims dimensions) ([][]loadgen.Request, error) {
+	corpora := make([][]loadgen.Request, dims.windows+1)
+	if seed == 0 {
+		return corpora, nil
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("-corpus-size %d must be at least 1", size)
+	}
+	for _, r := range loadgen.GenerateRequests(seed, size, tickets.Config{MaxMovies: dims.movies, MaxShowings: dims.showings, MaxWindows: dims.windows}) {
+		corpora[r.Window] = append(corpora[r.Window], r)
+	}
+	for i := 1; i <= dims.windows; i++ {
+		if len(corpora[i]) == 0 {
+			return nil, fmt.Errorf("-corpus-size %d is too small:  window %d has no sales in the corpus", size, i)
+		}
+	}
+	L.Printf("Using the %d sales generated from -corpus-seed %d.\n", size, seed)
+	return corpora, nil
+} // windowCorpora
+
+// serverClient returns a client for the tickets server at ticketServer,
+// which makes its calls with httpClient.
+func serverClient() *ticketsclient.Client {
+	return ticketsclient.New(ticketServer, httpClient)
+} // serverClient
+
+// latencyRecorder accumulates call durations by kind of call, and can be
+// safely used from any number of goroutines at once.  The model doesn't make
+// enough calls in a run for keeping every sample to be a problem, and then
+// the percentiles are exact.
+type latencyRecorder struct {
+	mutex   sync.Mutex
+	samples map[string][]time.Duration
+} // latencyRecorder
+
+// newLatencyRecorder creates an empty latencyRecorder.
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{samples: make(map[string][]time.Duration)}
+} // newLatencyRecorder
+
+// record adds one sample for kind.
+func (lr *latencyRecorder) record(kind string, d time.Duration) {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	lr.samples[kind] = append(lr.samples[kind], d)
+} // record
+
+// kinds returns the kinds of call which have samples, in alphabetical order.
+func (lr *latencyRecorder) kinds() []string {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	kinds := make([]string, 0, len(lr.samples))
+	for kind := range lr.samples {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+} // kinds
+
+// count returns the number of samples for kind.
+func (lr *latencyRecorder) count(kind string) int {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	return len(lr.samples[kind])
+} // count
+
+// percentiles returns the requested percentiles (each from 0 to 100) of the
+// samples for kind, in the same order as ps.  The nearest-rank method is
+// used, so each result is one of the actual samples.  If there are no
+// samples, then all of the results are 0.
+func (lr *latencyRecorder) percentiles(kind string, ps ...float64) []time.Duration {
+	lr.mutex.Lock()
+	sorted := append([]time.Duration(nil), lr.samples[kind]...)
+	lr.mutex.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	results := make([]time.Duration, len(ps), len(ps))
+	if len(sorted) == 0 {
+		return results
+	}
+	for i, p := range ps {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		if rank > len(sorted) {
+			rank = len(sorted)
+		}
+		results[i] = sorted[rank-1]
+	}
+	return results
+} // percentiles
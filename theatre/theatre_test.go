@@ -0,0 +1,868 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/d-m-w/learninggo/tickets"
+	"github.com/d-m-w/learninggo/tickets/loadgen"
+)
+
+func init() {
+	L = log.New(os.Stderr, "theatreTest:  ", log.Ldate|log.Ltime|log.Lshortfile)
+}
+
+func TestServerClientTimeout(tst *testing.T) {
+	release := make(chan bool)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		select {
+		case <-release:
+		case <-rqst.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	defer func(saved time.Duration) { httpClient.Timeout = saved }(httpClient.Timeout)
+	httpClient.Timeout = 50 * time.Millisecond
+	defer func(saved string) { ticketServer = saved }(ticketServer)
+
+	ticketServer = slow.URL + "/tickets"
+	start := time.Now()
+	_, err := serverClient().Exchange(1, "water", "soda")
+	elapsed := time.Since(start)
+	if err == nil {
+		tst.Error("Exchange with a stalled server returned no error")
+	}
+	if elapsed > 2*time.Second {
+		tst.Errorf("Exchange with a stalled server took %v, expected it to give up after about %v", elapsed, httpClient.Timeout)
+	}
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer fast.Close()
+	ticketServer = fast.URL + "/tickets"
+	if rcpt, err := serverClient().Exchange(1, "water", "soda"); err != nil || rcpt != nil {
+		tst.Errorf("Exchange with a prompt server returned %+v, %v, expected nil, nil", rcpt, err)
+	}
+} // TestServerClientTimeout
+
+func TestLatencyPercentiles(tst *testing.T) {
+	lr := newLatencyRecorder()
+	if p := lr.percentiles("sell", 50); p[0] != 0 {
+		tst.Errorf("p50 of no samples is %v, expected 0", p[0])
+	}
+
+	// 1ms to 100ms, recorded out of order, from several goroutines.
+	done := make(chan bool)
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			for i := 100 - g; i > 0; i -= 4 {
+				lr.record("sell", time.Duration(i)*time.Millisecond)
+			}
+			done <- true
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+	lr.record("exchange", 7*time.Millisecond)
+
+	if n := lr.count("sell"); n != 100 {
+		tst.Fatalf("Recorded 100 sell samples, but count is %d", n)
+	}
+	want := []time.Duration{1 * time.Millisecond, 50 * time.Millisecond, 90 * time.Millisecond, 99 * time.Millisecond, 100 * time.Millisecond}
+	got := lr.percentiles("sell", 0, 50, 90, 99, 100)
+	for i := range want {
+		if got[i] != want[i] {
+			tst.Errorf("Percentiles 0, 50, 90, 99, 100 of 1ms..100ms are %v, expected %v", got, want)
+			break
+		}
+	}
+	if p := lr.percentiles("exchange", 50, 99); p[0] != 7*time.Millisecond || p[1] != 7*time.Millisecond {
+		tst.Errorf("Percentiles of a single 7ms exchange sample are %v, expected 7ms", p)
+	}
+	if k := lr.kinds(); len(k) != 2 || k[0] != "exchange" || k[1] != "sell" {
+		tst.Errorf("kinds() returned %v, expected [exchange sell]", k)
+	}
+} // TestLatencyPercentiles
+
+func TestCheckRunTime(tst *testing.T) {
+	for _, c := range []struct {
+		runningtime, cap time.Duration
+		ok               bool
+	}{
+		{10 * time.Minute, maxRunTime, true},
+		{maxRunTime, maxRunTime, true},
+		{1000 * time.Hour, maxRunTime, false},
+		{2 * time.Hour, time.Hour, false},
+		{0, maxRunTime, false},
+		{time.Minute, 0, false},
+	} {
+		if err := checkRunTime(c.runningtime, c.cap); (err == nil) != c.ok {
+			tst.Errorf("checkRunTime(%v, %v) returned %v, expected ok=%v", c.runningtime, c.cap, err, c.ok)
+		}
+	}
+} // TestCheckRunTime
+
+func TestCheckFlags(tst *testing.T) {
+	if err := checkFlags(time.Second, time.Minute, maxRunTime, 0, 5, time.Second, time.Minute, time.Second); err != nil {
+		tst.Errorf("checkFlags with valid options returned %v", err)
+	}
+
+	err := checkFlags(-time.Second, 0, maxRunTime, -1, 0, 0, -time.Second, -time.Second)
+	if err == nil {
+		tst.Fatalf("checkFlags with seven invalid options returned nil")
+	}
+	for _, opt := range []string{"-a ", "-t ", "-target-sold ", "-x ", "-http-timeout ", "-report-interval ", "-exchange-time "} {
+		if !strings.Contains(err.Error(), opt) {
+			tst.Errorf("checkFlags error %q does not report %s", err, opt)
+		}
+	}
+	if lines := strings.Count(err.Error(), "\n") + 1; lines != 7 {
+		tst.Errorf("checkFlags error %q has %d lines, expected one per problem (7)", err, lines)
+	}
+} // TestCheckFlags
+
+func TestTargetSold(tst *testing.T) {
+	const movies, showings = 2, 3
+	sales := tickets.NewAggregator(movies, showings)
+
+	sales.Add([]tickets.Ticket{{Movie: 0, Showing: 1}, {Movie: 1, Showing: 2}})
+	if targetReached(sales.Report(), 3) {
+		tst.Errorf("targetReached(3) is true after 2 tickets")
+	}
+	if targetReached(sales.Report(), 0) {
+		tst.Errorf("targetReached(0) is true, expected 0 to mean no target")
+	}
+	sales.Add([]tickets.Ticket{{Movie: 1, Showing: 2}})
+	if !targetReached(sales.Report(), 3) {
+		tst.Errorf("targetReached(3) is false after 3 tickets")
+	}
+	if !targetReached(sales.Report(), 2) {
+		tst.Errorf("targetReached(2) is false after overshooting to 3 tickets")
+	}
+	if ticketsSold := sales.Report().Sold; ticketsSold[1][2] != 2 || ticketsSold[1][showings] != 2 || ticketsSold[movies][2] != 2 || ticketsSold[movies][showings] != 3 {
+		tst.Errorf("Aggregator totals are wrong:  %v", ticketsSold)
+	}
+} // TestTargetSold
+
+func TestExchangesByPair(tst *testing.T) {
+	byPair := make(map[xchPair]int)
+	for _, x := range []msgExchange{
+		{tickNum: 1, xchOld: "water", xchNew: "soda"},
+		{tickNum: 2, xchOld: "water", xchNew: "large soda"},
+		{tickNum: 3, xchOld: "water", xchNew: "soda"},
+		{tickNum: 4, xchOld: "popcorn", xchNew: "nachos"},
+	} {
+		tallyExchange(byPair, x)
+	}
+	for pair, want := range map[xchPair]int{{"water", "soda"}: 2, {"water", "large soda"}: 1, {"popcorn", "nachos"}: 1} {
+		if byPair[pair] != want {
+			tst.Errorf("tallyExchange counted %d for %s -> %s, expected %d", byPair[pair], pair.old, pair.new, want)
+		}
+	}
+
+	ticketsSold := [][]int{{0, 0}, {0, 0}} // 1 movie, 1 showing, and their totals
+	var report bytes.Buffer
+	summarize(&report, "today", 4, byPair, 0, ticketsSold, time.Hour, []time.Duration{0, 0})
+	lines := strings.Split(report.String(), "\n")
+	want := []string{
+		fmt.Sprintf("%-20s %-20s %8d", "popcorn", "nachos", 1),
+		fmt.Sprintf("%-20s %-20s %8d", "water", "large soda", 1),
+		fmt.Sprintf("%-20s %-20s %8d", "water", "soda", 2),
+	}
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Exchanges per Goodie") {
+			if len(lines) < i+2+len(want) {
+				tst.Fatalf("Summary report is cut short after the exchange table heading:\n%s", report.String())
+			}
+			for j, w := range want {
+				if lines[i+2+j] != w {
+					tst.Errorf("Exchange table line %d is '%s', expected '%s'", j+1, lines[i+2+j], w)
+				}
+			}
+			return
+		}
+	}
+	tst.Errorf("Summary report has no per-goodie exchange table:\n%s", report.String())
+} // TestExchangesByPair
+
+func TestWindowPause(tst *testing.T) {
+	var sells int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		atomic.AddInt32(&sells, 1)
+		http.Error(w, `{"error":"sold out","code":"ERR_TEST"}`, http.StatusConflict)
+	}))
+	defer server.Close()
+	defer func(saved string) { ticketServer = saved }(ticketServer)
+	ticketServer = server.URL + "/tickets"
+
+	chTracker := make(chan interface{}, 1000)
+	chStopWin := make(chan msgStop)
+	chDone := make(chan interface{}, 1)
+	chControl := make(chan msgPause)
+	go window(chTracker, chStopWin, chDone, make(chan xchData, 1), chControl, 2, dimensions{movies: 1, showings: 1, windows: 2}, 1, nil, time.Millisecond)
+
+	waitForSells := func(moreThan int32) {
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&sells) <= moreThan {
+			if time.Now().After(deadline) {
+				tst.Fatalf("window made no sales after %d, expected it to keep selling", moreThan)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForSells(0)
+
+	chControl <- msgPause{pause: true} // taken by the window between sales
+	paused := atomic.LoadInt32(&sells)
+	time.Sleep(100 * time.Millisecond)
+	if now := atomic.LoadInt32(&sells); now != paused {
+		tst.Errorf("paused window made %d sales, expected none until resumed", now-paused)
+	}
+
+	chControl <- msgPause{pause: false}
+	waitForSells(paused)
+
+	close(chStopWin)
+	<-chDone
+	var total time.Duration
+	for len(chTracker) > 0 {
+		if m, ok := (<-chTracker).(msgPaused); ok {
+			if m.window != 2 {
+				tst.Errorf("msgPaused is for window %d, expected 2", m.window)
+			}
+			total += m.paused
+		}
+	}
+	if total < 100*time.Millisecond {
+		tst.Errorf("window reported pausing for %v, expected at least 100ms", total)
+	}
+
+	var report bytes.Buffer
+	summarize(&report, "today", 0, nil, 0, [][]int{{0, 0}, {0, 0}}, time.Hour, []time.Duration{0, 0, 15 * time.Minute})
+	for _, want := range []string{
+		fmt.Sprintf("%-10d %12v %11.1f%%", 1, time.Duration(0), 100.0),
+		fmt.Sprintf("%-10d %12v %11.1f%%", 2, 15*time.Minute, 75.0),
+	} {
+		if !strings.Contains(report.String(), want+"\n") {
+			tst.Errorf("Summary report has no utilization line '%s':\n%s", want, report.String())
+		}
+	}
+} // TestWindowPause
+
+func TestWindowClose(tst *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		http.Error(w, `{"error":"sold out","code":"ERR_TEST"}`, http.StatusConflict)
+	}))
+	defer server.Close()
+	defer func(saved string) { ticketServer = saved }(ticketServer)
+	ticketServer = server.URL + "/tickets"
+
+	// Closed while selling, and while paused.
+	for _, pauseFirst := range []bool{false, true} {
+		chTracker := make(chan interface{}, 1000)
+		chStopWin := make(chan msgStop) // never closed
+		chDone := make(chan interface{}, 1)
+		chControl := make(chan msgPause)
+		go window(chTracker, chStopWin, chDone, make(chan xchData, 1), chControl, 2, dimensions{movies: 1, showings: 1, windows: 2}, 1, nil, time.Millisecond)
+		if pauseFirst {
+			chControl <- msgPause{pause: true}
+		}
+		chControl <- msgPause{head: msgHeader{from: "supervisor"}, close: true}
+		select {
+		case msg := <-chDone:
+			if _, ok := msg.(msgDone); !ok {
+				tst.Errorf("closed window sent %T %+v on chDone, expected a msgDone", msg, msg)
+			}
+		case <-time.After(5 * time.Second):
+			tst.Fatalf("window (paused %v) did not shut down when closed", pauseFirst)
+		}
+	}
+} // TestWindowClose
+
+func TestMakeSaleDimensions(tst *testing.T) {
+	var mutex sync.Mutex
+	var requested [][2]int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		switch {
+		case rqst.URL.Path == "/tickets/config":
+			w.Write([]byte(`{"movies":3,"showings":2,"windows":1}`))
+		case strings.HasPrefix(rqst.URL.Path, "/tickets/sell/"):
+			var body struct{ TicketRequests [][2]int }
+			json.NewDecoder(rqst.Body).Decode(&body)
+			mutex.Lock()
+			requested = append(requested, body.TicketRequests...)
+			mutex.Unlock()
+			http.Error(w, `{"error":"sold out","code":"ERR_TEST"}`, http.StatusConflict)
+		default:
+			http.NotFound(w, rqst)
+		}
+	}))
+	defer server.Close()
+	defer func(saved string) { ticketServer = saved }(ticketServer)
+	ticketServer = server.URL + "/tickets"
+
+	fallback := dimensions{movies: MaxMovies, showings: MaxShowings, windows: MaxWindows}
+	dims := fetchDimensions(fallback)
+	if want := (dimensions{movies: 3, showings: 2, windows: 1}); dims != want {
+		tst.Fatalf("fetchDimensions returned %+v, expected the server's %+v", dims, want)
+	}
+
+	for i := 0; i < 100; i++ {
+		makeSale(make(chan interface{}, 1), make(chan xchData, 1), randomRequest(1, dims, 4))
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(requested) < 100 {
+		tst.Fatalf("makeSale sent %d ticket requests in 100 sales, expected at least 100", len(requested))
+	}
+	seen := make(map[[2]int]bool)
+	for _, r := range requested {
+		if r[0] < 0 || r[0] >= dims.movies || r[1] < 0 || r[1] >= dims.showings {
+			tst.Errorf("makeSale requested movie %d, showing %d, outside the server's %d movies and %d showings", r[0], r[1], dims.movies, dims.showings)
+		}
+		seen[r] = true
+	}
+	if len(seen) != dims.movies*dims.showings {
+		tst.Errorf("makeSale requested %d of the %d showings in %d requests, expected all of them", len(seen), dims.movies*dims.showings, len(requested))
+	}
+
+	ticketServer = server.URL + "/nothing"
+	if got := fetchDimensions(fallback); got != fallback {
+		tst.Errorf("fetchDimensions with no config URL returned %+v, expected the fallback %+v", got, fallback)
+	}
+} // TestMakeSaleDimensions
+
+func TestMakeSalePartlyFailed(tst *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		switch rqst.URL.Path {
+		case "/tickets/sell/1":
+			w.Write([]byte(`{"tickets":[{"ticketNum":7,"movie":0,"showing":0,"price":1000,"goodies":true,"window":1}],"receipt":{"receiptNum":4,"total":1000},"error":"no more tickets","code":"ERR_NO_MORE_TICKETS"}`))
+		default:
+			http.Error(w, `{"error":"no more tickets","code":"ERR_NO_MORE_TICKETS"}`, http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+	defer func(saved string) { ticketServer = saved }(ticketServer)
+	ticketServer = server.URL + "/tickets"
+
+	// The ticket sold before the DB filled up is reported, and exchanged.
+	chTracker, chCafeteria := make(chan interface{}, 1), make(chan xchData, 1)
+	makeSale(chTracker, chCafeteria, loadgen.Request{Window: 1, TicketRequests: [][2]int{{0, 0}, {0, 0}}, Exchange: []bool{true, true}})
+	select {
+	case msg := <-chTracker:
+		if sale, ok := msg.(msgTicketSale); !ok || len(sale.ticks) != 1 || sale.ticks[0].TicketNum != 7 {
+			tst.Errorf("makeSale of a sale which partly failed sent the tracker %+v, expected ticket 7", msg)
+		}
+	default:
+		tst.Errorf("makeSale of a sale which partly failed did not notify the tracker")
+	}
+	select {
+	case x := <-chCafeteria:
+		if x.tickNum != 7 {
+			tst.Errorf("makeSale of a sale which partly failed sent exchange %+v, expected ticket 7", x)
+		}
+	default:
+		tst.Errorf("makeSale of a sale which partly failed did not send ticket 7 for exchange")
+	}
+
+	// With nothing sold, the sale is abandoned.
+	makeSale(chTracker, chCafeteria, loadgen.Request{Window: 2, TicketRequests: [][2]int{{0, 0}}, Exchange: []bool{true}})
+	if len(chTracker) != 0 || len(chCafeteria) != 0 {
+		tst.Errorf("makeSale of a refused sale notified the tracker or the Cafeteria")
+	}
+} // TestMakeSalePartlyFailed
+
+func TestWindowCorpora(tst *testing.T) {
+	dims := dimensions{movies: 3, showings: 2, windows: 3}
+	corpora, err := windowCorpora(7, 200, dims)
+	if err != nil {
+		tst.Fatalf("windowCorpora(7, 200) returned error %v", err)
+	}
+	again, _ := windowCorpora(7, 200, dims)
+	total := 0
+	for i := 1; i <= dims.windows; i++ {
+		for j, r := range corpora[i] {
+			if r.Window != i {
+				tst.Errorf("Window %d's corpus has a sale for window %d", i, r.Window)
+			}
+			if !reflect.DeepEqual(r, again[i][j]) {
+				tst.Errorf("Window %d's sale %d is %+v the first time and %+v the second, expected the same", i, j, r, again[i][j])
+			}
+		}
+		total += len(corpora[i])
+	}
+	if total != 200 {
+		tst.Errorf("windowCorpora(7, 200) shared out %d sales, expected 200", total)
+	}
+
+	if corpora, err := windowCorpora(0, 200, dims); err != nil || len(corpora) != dims.windows+1 || corpora[1] != nil {
+		tst.Errorf("windowCorpora with no seed returned %d corpora, error %v, expected none for any window", len(corpora), err)
+	}
+	for _, size := range []int{0, 1} {
+		if _, err := windowCorpora(7, size, dims); err == nil {
+			tst.Errorf("windowCorpora(7, %d) for %d windows should have failed", size, dims.windows)
+		}
+	}
+} // TestWindowCorpora
+
+func TestParseBreaks(tst *testing.T) {
+	breaks, err := parseBreaks("2@1m+30s, 1@0s+1h", 2)
+	if err != nil {
+		tst.Fatalf("parseBreaks returned error %v", err)
+	}
+	want := []winBreak{{2, time.Minute, 30 * time.Second}, {1, 0, time.Hour}}
+	if len(breaks) != len(want) {
+		tst.Fatalf("parseBreaks returned %+v, expected %+v", breaks, want)
+	}
+	for i := range want {
+		if breaks[i] != want[i] {
+			tst.Errorf("parseBreaks break %d is %+v, expected %+v", i, breaks[i], want[i])
+		}
+	}
+
+	if breaks, err := parseBreaks("", 2); err != nil || breaks != nil {
+		tst.Errorf("parseBreaks of no breaks returned %+v, %v, expected nil, nil", breaks, err)
+	}
+
+	_, err = parseBreaks("3@1m+30s,2@1m,x@1m+1s,1@1m+0s", 2)
+	if err == nil {
+		tst.Fatalf("parseBreaks with four bad breaks returned nil error")
+	}
+	if lines := strings.Count(err.Error(), "\n") + 1; lines != 4 {
+		tst.Errorf("parseBreaks error %q has %d lines, expected one per bad break (4)", err, lines)
+	}
+} // TestParseBreaks
+
+func TestInterimReports(tst *testing.T) {
+	dir := tst.TempDir()
+	defer func(saved string) { summaryReportBase = saved }(summaryReportBase)
+	summaryReportBase = filepath.Join(dir, "summaryReport.")
+
+	chTracker := make(chan interface{}, 5)
+	chStopWin := make(chan msgStop)
+	chDone := make(chan interface{})
+	go tracker(chTracker, chStopWin, chDone, nil, nil, nil, 500*time.Millisecond, 0, 100*time.Millisecond, 0, 1, 1, 1, 1)
+	chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}}}
+
+	<-chStopWin // the run time is up
+	chTracker <- msgDone{head: msgHeader{from: "window"}}
+	chTracker <- msgDone{head: msgHeader{from: "cafeteria"}}
+	<-chDone
+
+	interim, _ := filepath.Glob(summaryReportBase + "interim.*")
+	final, _ := filepath.Glob(summaryReportBase + "[0-9]*")
+	// 500ms at one per 100ms is 4 or 5, depending on whether the last
+	// one beats the shutdown;  allow some slack for a slow machine.
+	if len(interim) < 3 || len(interim) > 5 {
+		tst.Errorf("tracker wrote %d interim reports in 500ms at one per 100ms, expected about 5:  %v", len(interim), interim)
+	}
+	if len(final) != 1 {
+		tst.Errorf("tracker wrote %d final reports, expected 1:  %v", len(final), final)
+	}
+	for _, name := range interim {
+		report, err := os.ReadFile(name)
+		if err != nil {
+			tst.Fatalf("Cannot read interim report:  %v", err)
+		}
+		if !strings.Contains(string(report), "(interim)") || !strings.Contains(string(report), "All showings ") {
+			tst.Errorf("Interim report %s is not a summary snapshot:\n%s", name, report)
+		}
+	}
+} // TestInterimReports
+
+func TestCafeteriaExchangeTime(tst *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	defer func(saved string) { ticketServer = saved }(ticketServer)
+	ticketServer = server.URL + "/tickets"
+
+	const queued, exchangeTime = 5, 20 * time.Millisecond
+	chTracker := make(chan interface{}, 100)
+	chDone := make(chan interface{}, 1)
+	chCafeteria := make(chan xchData, cafeteriaQueue)
+	for i := 1; i <= queued; i++ {
+		chCafeteria <- xchData{tickNum: i}
+	}
+	close(chCafeteria)
+
+	start := time.Now()
+	go cafeteria(chTracker, chDone, chCafeteria, exchangeTime)
+	<-chDone
+	if elapsed := time.Since(start); elapsed < queued*exchangeTime {
+		tst.Errorf("cafeteria served %d exchanges in %v, expected at least %v at %v each", queued, elapsed, queued*exchangeTime, exchangeTime)
+	}
+
+	exchanges, maxDepth := 0, 0
+	for len(chTracker) > 0 {
+		switch m := (<-chTracker).(type) {
+		case msgExchange:
+			exchanges++
+		case msgXchQueue:
+			maxDepth = m.maxDepth
+		}
+	}
+	if exchanges != queued {
+		tst.Errorf("cafeteria reported %d exchanges, expected %d", exchanges, queued)
+	}
+	if maxDepth != queued {
+		tst.Errorf("cafeteria reported a queue %d deep, expected %d", maxDepth, queued)
+	}
+
+	var report bytes.Buffer
+	summarize(&report, "today", exchanges, nil, maxDepth, [][]int{{0, 0}, {0, 0}}, time.Hour, nil)
+	if want := fmt.Sprintf("%d Exchange requests waiting for the cafeteria, at most\n", queued); !strings.Contains(report.String(), want) {
+		tst.Errorf("Summary report does not include '%s':\n%s", want, report.String())
+	}
+} // TestCafeteriaExchangeTime
+
+func TestTrackerKeepsGoingDuringReports(tst *testing.T) {
+	dir := tst.TempDir()
+	defer func(saved string) { summaryReportBase = saved }(summaryReportBase)
+	summaryReportBase = filepath.Join(dir, "summaryReport.")
+
+	// Hold up every interim report until released.
+	started := make(chan bool, 100)
+	release := make(chan bool)
+	defer func(saved func(string) (*os.File, error)) { createSnapshot = saved }(createSnapshot)
+	createSnapshot = func(name string) (*os.File, error) {
+		started <- true
+		<-release
+		return os.Create(name)
+	}
+
+	const target = 20
+	chTracker := make(chan interface{}, 5)
+	chStopWin := make(chan msgStop)
+	chDone := make(chan interface{})
+	go tracker(chTracker, chStopWin, chDone, nil, nil, nil, time.Minute, target, 10*time.Millisecond, 0, 1, 1, 1, 1)
+
+	<-started // an interim report is being written
+	for i := 0; i < target; i++ {
+		select {
+		case chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}}}:
+		case <-time.After(2 * time.Second):
+			tst.Fatalf("tracker stopped taking sales after %d, while an interim report was being written", i)
+		}
+	}
+	select {
+	case <-chStopWin: // the target was reached, so all the sales were counted
+	case <-time.After(2 * time.Second):
+		tst.Errorf("tracker did not reach the target of %d sales while an interim report was being written", target)
+	}
+
+	close(release)
+	chTracker <- msgDone{head: msgHeader{from: "window"}}
+	chTracker <- msgDone{head: msgHeader{from: "cafeteria"}}
+	<-chDone
+
+	interim, _ := filepath.Glob(summaryReportBase + "interim.*")
+	if len(interim) == 0 {
+		tst.Errorf("tracker wrote no interim reports")
+	}
+	for _, name := range interim {
+		if report, err := os.ReadFile(name); err != nil || !strings.Contains(string(report), "Tickets Server Call Latency") {
+			tst.Errorf("Interim report %s was not finished (%v):\n%s", name, err, report)
+		}
+	}
+} // TestTrackerKeepsGoingDuringReports
+
+func TestShutdownSummary(tst *testing.T) {
+	dir := tst.TempDir()
+	defer func(saved string) { summaryReportBase = saved }(summaryReportBase)
+	summaryReportBase = filepath.Join(dir, "summaryReport.")
+
+	chTracker := make(chan interface{}, 5)
+	chStopWin := make(chan msgStop)
+	chDone := make(chan interface{})
+	go tracker(chTracker, chStopWin, chDone, nil, nil, nil, 50*time.Millisecond, 0, 0, 0, 1, 1, 2, 1)
+	chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}, {Movie: 1, Showing: 0, SoldOut: true}, {Movie: 1, Showing: 0}}}
+	chTracker <- msgExchange{tickNum: 1, xchOld: "soda", xchNew: "popcorn"}
+
+	<-chStopWin // the run time is up
+	chTracker <- msgDone{head: msgHeader{from: "window"}}
+	chTracker <- msgDone{head: msgHeader{from: "cafeteria"}}
+	msg := <-chDone
+
+	done, ok := msg.(msgTrackerDone)
+	if !ok {
+		tst.Fatalf("tracker sent %T %+v on chDone, expected a msgTrackerDone", msg, msg)
+	}
+	got := done.totals
+	if got.ticketsSold != 2 || got.soldOut != 1 || got.exchanges != 1 || got.runTime < 50*time.Millisecond {
+		tst.Errorf("tracker sent totals %+v, expected 2 sold, 1 sold out, 1 exchange, over at least 50ms", got)
+	}
+
+	summary := shutdownSummary(runTotals{runTime: 90*time.Second + 1234567*time.Nanosecond, ticketsSold: 120, soldOut: 5, exchanges: 30})
+	expected := "SHUTDOWN SUMMARY runTime=1m30.001s ticketsSold=120 soldOut=5 exchanges=30"
+	if summary != expected {
+		tst.Errorf("shutdownSummary returned '%s', expected '%s'", summary, expected)
+	}
+} // TestShutdownSummary
+
+func TestReadCommands(tst *testing.T) {
+	chCommands := make(chan msgCommand, 10)
+	var out bytes.Buffer
+	readCommands(strings.NewReader("report\n\n  STOP \nplease stop\nReport\n"), &out, chCommands)
+	close(chCommands)
+
+	var got []string
+	for c := range chCommands {
+		got = append(got, c.what)
+	}
+	if want := []string{cmdReport, cmdStop, cmdReport}; strings.Join(got, ",") != strings.Join(want, ",") {
+		tst.Errorf("readCommands passed on %v, expected %v", got, want)
+	}
+	if !strings.Contains(out.String(), `Unknown command "please stop"`) || strings.Count(out.String(), "\n") != 1 {
+		tst.Errorf("readCommands wrote %q, expected one reminder, for the unknown command", out.String())
+	}
+} // TestReadCommands
+
+func TestTrackerCommands(tst *testing.T) {
+	dir := tst.TempDir()
+	defer func(saved string) { summaryReportBase = saved }(summaryReportBase)
+	summaryReportBase = filepath.Join(dir, "summaryReport.")
+	var out bytes.Buffer
+	defer func(saved io.Writer) { console = saved }(console)
+	console = &out
+
+	chTracker := make(chan interface{}, 5)
+	chStopWin := make(chan msgStop)
+	chDone := make(chan interface{})
+	chCommands := make(chan msgCommand)
+	go tracker(chTracker, chStopWin, chDone, chCommands, nil, nil, time.Minute, 0, 0, 0, 1, 1, 1, 1)
+	chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}}}
+	chCommands <- msgCommand{what: cmdReport}
+	chCommands <- msgCommand{what: cmdStop}
+	select {
+	case <-chStopWin:
+	case <-time.After(2 * time.Second):
+		tst.Fatalf("tracker did not shut down on the stop command")
+	}
+	chTracker <- msgDone{head: msgHeader{from: "window"}}
+	chTracker <- msgDone{head: msgHeader{from: "cafeteria"}}
+	<-chDone
+
+	// The final report waits for the interim one, so it is finished.
+	if !strings.Contains(out.String(), "(interim)") || !strings.Contains(out.String(), "Tickets Server Call Latency") {
+		tst.Errorf("The report command wrote %q to the console, expected an interim summary report", out.String())
+	}
+	if final, _ := filepath.Glob(summaryReportBase + "[0-9]*"); len(final) != 1 {
+		tst.Errorf("tracker wrote %d final reports after the stop command, expected 1:  %v", len(final), final)
+	}
+} // TestTrackerCommands
+
+func TestScaleDecision(tst *testing.T) {
+	scale := scaling{interval: time.Second, minWindows: 1, maxWindows: 3, up: 5, down: 1}
+	for _, c := range []struct {
+		open, sold, want int
+	}{
+		{1, 5, 2},  // 5 per window:  busy enough to open another
+		{1, 4, 1},  // 4 per window:  in between
+		{2, 9, 2},  // 4.5 per window
+		{2, 10, 3}, // 5 per window
+		{3, 30, 3}, // busy, but all open
+		{3, 3, 2},  // 1 per window:  quiet enough to close one
+		{2, 3, 2},  // 1.5 per window
+		{1, 0, 1},  // quiet, but the minimum is open
+	} {
+		if got := scaleDecision(c.open, c.sold, scale); got != c.want {
+			tst.Errorf("scaleDecision with %d tickets sold at %d windows returned %d, expected %d", c.sold, c.open, got, c.want)
+		}
+	}
+
+	if err := checkScaling(scale, 3); err != nil {
+		tst.Errorf("checkScaling(%+v) returned error %v", scale, err)
+	}
+	if err := checkScaling(scaling{}, 3); err != nil {
+		tst.Errorf("checkScaling with auto-scaling off returned error %v", err)
+	}
+	bad := scaling{interval: time.Second, minWindows: 0, maxWindows: 4, up: 1, down: 1}
+	err := checkScaling(bad, 3)
+	if err == nil || strings.Count(err.Error(), "\n") != 2 {
+		tst.Errorf("checkScaling(%+v) returned error %v, expected 3 problems", bad, err)
+	}
+} // TestScaleDecision
+
+func TestSupervisor(tst *testing.T) {
+	const windows = 4
+	scale := scaling{interval: time.Second, minWindows: 1, maxWindows: 3, up: 5, down: 1}
+	chThroughput := make(chan int)
+	chScaling := make(chan msgScale)
+	chStopWin := make(chan msgStop)
+	chControls := make([]chan msgPause, windows+1)
+	for i := 1; i <= windows; i++ {
+		chControls[i] = make(chan msgPause, 10)
+	}
+	var opened []int
+	chOpen := make(chan int)
+	go func() {
+		chOpen <- supervisor(chThroughput, chScaling, chControls, chStopWin, scale, func(window int) { opened = append(opened, window) })
+	}()
+
+	// A stand-in for the tracker, which says yes until it is told to say no.
+	var asked []string
+	chRefuse := make(chan bool)
+	go func() {
+		refuse := false
+		for {
+			select {
+			case rqst := <-chScaling:
+				asked = append(asked, fmt.Sprintf("%d %v", rqst.window, rqst.open))
+				rqst.ok <- !refuse
+			case refuse = <-chRefuse:
+			}
+		}
+	}()
+
+	// Simulated throughput:  busy, busier, flat out (with all 3 open), quiet,
+	// and in between.  Before each count is taken, the last one has been
+	// acted on, so the last (which changes nothing) sees the others done.
+	for _, sold := range []int{10, 20, 40, 0, 0, 2} {
+		chThroughput <- sold
+	}
+	chRefuse <- true // as if the model were shutting down
+	chThroughput <- 10
+	if open := <-chOpen; open != 1 {
+		tst.Errorf("supervisor stopped with %d windows open, expected 1", open)
+	}
+
+	if fmt.Sprint(opened) != "[2 3]" {
+		tst.Errorf("supervisor opened windows %v, expected [2 3]", opened)
+	}
+	if want := "[2 true 3 true 3 false 2 false 2 true]"; fmt.Sprint(asked) != want {
+		tst.Errorf("supervisor asked the tracker for %v, expected %s", asked, want)
+	}
+	for i := 1; i <= windows; i++ {
+		var closes int
+		for len(chControls[i]) > 0 {
+			if c := <-chControls[i]; c.close {
+				closes++
+			} else {
+				tst.Errorf("supervisor sent window %d %+v, expected only closes", i, c)
+			}
+		}
+		if want := map[bool]int{true: 1}[i == 2 || i == 3]; closes != want {
+			tst.Errorf("supervisor closed window %d %d times, expected %d", i, closes, want)
+		}
+	}
+
+	// And it gives up once chStopWin is closed.
+	go func() { chOpen <- supervisor(chThroughput, chScaling, chControls, chStopWin, scale, func(int) {}) }()
+	close(chStopWin)
+	if open := <-chOpen; open != 1 {
+		tst.Errorf("supervisor stopped with %d windows open, expected 1", open)
+	}
+} // TestSupervisor
+
+func TestTrackerThroughput(tst *testing.T) {
+	dir := tst.TempDir()
+	defer func(saved string) { summaryReportBase = saved }(summaryReportBase)
+	summaryReportBase = filepath.Join(dir, "summaryReport.")
+
+	chTracker := make(chan interface{}, 5)
+	chStopWin := make(chan msgStop)
+	chDone := make(chan interface{})
+	chThroughput := make(chan int, 1)
+	go tracker(chTracker, chStopWin, chDone, nil, chThroughput, nil, time.Minute, 3, 0, 10*time.Millisecond, 1, 1, 1, 1)
+	chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}, {Movie: 0, Showing: 0, SoldOut: true}}}
+	chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}}}
+
+	// The counts may be split over several intervals, but add up to the
+	// tickets sold, without the placeholder.
+	total := 0
+	for deadline := time.After(2 * time.Second); total < 2; {
+		select {
+		case sold := <-chThroughput:
+			total += sold
+		case <-deadline:
+			tst.Fatalf("tracker counted %d tickets sold on chThroughput, expected 2", total)
+		}
+	}
+	chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}}} // the target of 3
+	<-chStopWin
+	chTracker <- msgDone{head: msgHeader{from: "window"}}
+	chTracker <- msgDone{head: msgHeader{from: "cafeteria"}}
+	<-chDone
+	if total != 2 {
+		tst.Errorf("tracker counted %d tickets sold on chThroughput, expected 2", total)
+	}
+} // TestTrackerThroughput
+
+func TestTrackerScaling(tst *testing.T) {
+	dir := tst.TempDir()
+	defer func(saved string) { summaryReportBase = saved }(summaryReportBase)
+	summaryReportBase = filepath.Join(dir, "summaryReport.")
+
+	chTracker := make(chan interface{}, 5)
+	chStopWin := make(chan msgStop)
+	chDone := make(chan interface{})
+	chScaling := make(chan msgScale)
+	go tracker(chTracker, chStopWin, chDone, nil, nil, chScaling, time.Minute, 1, 0, 0, 1, 3, 1, 1)
+	ask := func(window int, open bool) bool {
+		ok := make(chan bool, 1)
+		chScaling <- msgScale{window: window, open: open, ok: ok}
+		return <-ok
+	}
+
+	time.Sleep(50 * time.Millisecond) // with windows 2 and 3 closed
+	if !ask(2, true) {
+		tst.Error("tracker refused to open window 2 before shutting down")
+	}
+	chTracker <- msgTicketSale{window: 1, ticks: []tickets.Ticket{{Movie: 0, Showing: 0}}} // the target of 1
+	<-chStopWin
+	if ask(3, true) {
+		tst.Error("tracker let window 3 open after shutting down")
+	}
+
+	// It must wait for window 2, as well as window 1.
+	chTracker <- msgDone{head: msgHeader{from: "window"}}
+	chTracker <- msgDone{head: msgHeader{from: "cafeteria"}}
+	select {
+	case msg := <-chDone:
+		tst.Fatalf("tracker sent %T %+v before window 2 was done", msg, msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	chTracker <- msgDone{head: msgHeader{from: "window"}}
+	<-chDone
+
+	final, _ := filepath.Glob(summaryReportBase + "[0-9]*")
+	if len(final) != 1 {
+		tst.Fatalf("tracker wrote summary reports %v, expected 1", final)
+	}
+	report, err := os.ReadFile(final[0])
+	if err != nil {
+		tst.Fatal(err)
+	}
+	utilization := string(report)[strings.Index(string(report), "Ticket Window Utilization"):]
+	utilization = utilization[:strings.Index(utilization, "Tickets Server Call Latency")]
+	closed := map[int]time.Duration{}
+	for _, line := range strings.Split(utilization, "\n") {
+		var window int
+		var paused string
+		if n, _ := fmt.Sscanf(line, "%d %s", &window, &paused); n == 2 {
+			closed[window], _ = time.ParseDuration(paused)
+		}
+	}
+	if closed[1] != 0 || closed[2] < 50*time.Millisecond || closed[3] < closed[2] {
+		tst.Errorf("tracker reported windows paused for %v, expected none for 1, at least 50ms for 2 (while closed), and the whole run for 3:\n%s", closed, report)
+	}
+} // TestTrackerScaling
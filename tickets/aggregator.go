@@ -0,0 +1,85 @@
+/*****************************************************************************
+
+An Aggregator tallies the Tickets from a stream of sales into counts per
+movie and showing, with subtotals for each movie and each showing, and a
+grand total, e.g. for the theatre model's summary report.
+
+*****************************************************************************/
+
+package tickets
+
+import (
+	"sync"
+)
+
+// An Aggregator counts the Tickets from the sales it is given (see Add).  It
+// may be used by several goroutines at once.
+type Aggregator struct {
+	mutex   sync.Mutex
+	sold    [][]int // laid out as SalesReport.Sold
+	soldOut int
+} // Aggregator
+
+// A SalesReport is what an Aggregator has counted so far (see
+// Aggregator.Report).
+type SalesReport struct {
+	// Sold is indexed by movie, then showing.  Its last row holds the totals
+	// for each showing (over all movies), and its last column the totals for
+	// each movie, so the last element of the last row is the grand total.
+	// The sold-out placeholders are counted, as the ticket requests made.
+	Sold [][]int
+
+	// SoldOut is how many of the Tickets counted were sold-out placeholders.
+	SoldOut int
+} // SalesReport
+
+// NewAggregator creates an Aggregator for a theatre with movies movies, each
+// with showings showings, with nothing counted yet.
+func NewAggregator(movies int, showings int) *Aggregator {
+	a := &Aggregator{sold: make([][]int, movies+1, movies+1)}
+	for i := range a.sold {
+		a.sold[i] = make([]int, showings+1, showings+1)
+	}
+	return a
+} // NewAggregator
+
+// Add counts the Tickets from one sale (as returned by Sell), sold-out
+// placeholders included.  Tickets for a movie or showing outside the
+// Aggregator's range are left out.
+func (a *Aggregator) Add(sale []Ticket) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	movies := len(a.sold) - 1
+	showings := len(a.sold[movies]) - 1
+	for _, t := range sale {
+		if t.Movie < 0 || t.Movie >= movies || t.Showing < 0 || t.Showing >= showings {
+			continue
+		}
+		a.sold[t.Movie][t.Showing]++ // the particular movie and showing
+		a.sold[t.Movie][showings]++  // the movie subtotal
+		a.sold[movies][t.Showing]++  // the showing subtotal
+		a.sold[movies][showings]++   // the grand total
+		if t.SoldOut {
+			a.soldOut++
+		}
+	}
+} // Add
+
+// Report returns a copy of the counts so far, which the caller may keep
+// while the Aggregator carries on counting.
+func (a *Aggregator) Report() SalesReport {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	sold := make([][]int, len(a.sold))
+	for i := range a.sold {
+		sold[i] = append([]int(nil), a.sold[i]...)
+	}
+	return SalesReport{Sold: sold, SoldOut: a.soldOut}
+} // Report
+
+// Total returns the grand total of Tickets counted, sold-out placeholders
+// included.
+func (r SalesReport) Total() int {
+	movies := len(r.Sold) - 1
+	return r.Sold[movies][len(r.Sold[movies])-1]
+} // Total
@@ -0,0 +1,41 @@
+package tickets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregator(tst *testing.T) {
+	const movies, showings = 2, 3
+	a := NewAggregator(movies, showings)
+	if r := a.Report(); r.Total() != 0 || r.SoldOut != 0 {
+		tst.Errorf("New Aggregator reported %+v, expected nothing counted", r)
+	}
+
+	a.Add([]Ticket{{Movie: 0, Showing: 1}, {Movie: 1, Showing: 2}, {Movie: 1, Showing: 2, SoldOut: true}})
+	a.Add([]Ticket{{Movie: 1, Showing: 0}, {Movie: 0, Showing: 1}})
+	a.Add(nil)
+	a.Add([]Ticket{{Movie: 2, Showing: 0}, {Movie: 0, Showing: 3}}) // out of range
+
+	// Added up by hand:  movie 0 has 2 for showing 1;  movie 1 has 1 for
+	// showing 0 and 2 for showing 2.
+	want := [][]int{
+		{0, 2, 0, 2}, // movie 0, and its subtotal
+		{1, 0, 2, 3}, // movie 1, and its subtotal
+		{1, 2, 2, 5}, // the showing subtotals, and the grand total
+	}
+	r := a.Report()
+	if !reflect.DeepEqual(r.Sold, want) {
+		tst.Errorf("Report().Sold is %v, expected %v", r.Sold, want)
+	}
+	if r.Total() != 5 || r.SoldOut != 1 {
+		tst.Errorf("Report() has total %d with %d sold out, expected 5 with 1", r.Total(), r.SoldOut)
+	}
+
+	// The report is a copy.
+	r.Sold[0][1] = 99
+	a.Add([]Ticket{{Movie: 0, Showing: 0}})
+	if r2 := a.Report(); r2.Sold[0][1] != 2 || r2.Total() != 6 || r.Total() != 5 {
+		tst.Errorf("Reports %v then %v share their counts, expected copies", r.Sold, r2.Sold)
+	}
+} // TestAggregator
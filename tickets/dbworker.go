@@ -0,0 +1,100 @@
+/*****************************************************************************
+
+The DB worker is an alternative to each sale and exchange locking the ticket
+DB to record its tickets:  if Config.DBWorker is set, then one goroutine owns
+the DB updates, and updateTicketSale and updateTicketExchange send theirs to
+it and wait for them to be made.  The worker makes every update which is
+waiting at once, under one lock of ticketDBmutex, so that busy windows take
+turns at the lock once per batch instead of once per ticket.
+
+Reads of the DB (readTicket, reports, SelfCheck, ...) and the bulk changes
+(voids, resets, compaction, ...) still lock ticketDBmutex themselves, so the
+worker is only a different way of making the per-ticket updates, not a
+different owner of the whole DB.  See BenchmarkSell for how the two compare:
+the DB updates are only a small part of a sale, so the hand-off to the worker
+usually costs more than the lock contention it saves, and the mutex remains
+the default.
+
+*****************************************************************************/
+
+package tickets
+
+// dbWorkerBatch is the most updates the dbWorker makes under one lock, so
+// that a steady stream of them can't keep the readers out.
+const dbWorkerBatch = 64
+
+// A dbUpdate is one ticket DB update for the dbWorker:  the sales-related
+// fields of t, or its product exchange fields if exchange.  done is closed
+// once it has been made.
+type dbUpdate struct {
+	t        Ticket
+	exchange bool
+	done     chan struct{}
+} // dbUpdate
+
+// updateTicket makes u, by way of the dbWorker if there is one, otherwise
+// under ticketDBmutex.  It returns once the update is in the DB.
+//
+// Once Close has stopped the worker, the updates of any exchanges (or sales)
+// still in progress are made under the mutex, as if there were no worker.
+func (th *Theatre) updateTicket(u dbUpdate) {
+	if th.dbUpdates != nil {
+		u.done = make(chan struct{})
+		select {
+		case th.dbUpdates <- u:
+			<-u.done
+			return
+		case <-th.stopRoll:
+		}
+	}
+
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+	th.apply(u)
+} // updateTicket
+
+// apply makes u.  The caller must hold ticketDBmutex.
+func (th *Theatre) apply(u dbUpdate) {
+	if u.exchange {
+		th.applyExchange(u.t)
+	} else {
+		th.applySale(u.t)
+	}
+} // apply
+
+// dbWorker is the DB-owner goroutine started by open if Config.DBWorker is
+// set.  Each update received from updates is made together with any others
+// already waiting (up to dbWorkerBatch), under one lock, and then all of
+// their senders are told.  It returns when stop is closed.
+//
+// updates is unbuffered, so a sender whose send went through knows that the
+// worker has its update, and will make it even if stop is closed meanwhile.
+func (th *Theatre) dbWorker(updates <-chan dbUpdate, stop <-chan struct{}) {
+	batch := make([]dbUpdate, 0, dbWorkerBatch)
+	for {
+		select {
+		case u := <-updates:
+			batch = append(batch[:0], u)
+		case <-stop:
+			return
+		}
+	more:
+		for len(batch) < dbWorkerBatch {
+			select {
+			case u := <-updates:
+				batch = append(batch, u)
+			default:
+				break more
+			}
+		}
+
+		th.ticketDBmutex.Lock()
+		for _, u := range batch {
+			th.apply(u)
+		}
+		th.ticketDBmutex.Unlock()
+		for _, u := range batch {
+			close(u.done)
+		}
+	}
+} // dbWorker
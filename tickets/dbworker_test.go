@@ -0,0 +1,111 @@
+package tickets
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDBWorker(tst *testing.T) {
+	const windows, perWindow = 8, 24
+	Ltest := log.New(os.Stderr, tst.Name()+":  ", log.Ldate|log.Ltime|log.Llongfile)
+	th, err := NewTheatre(Config{Logger: Ltest, MaxExchanges: windows * perWindow, MaxMovies: 2, MaxShowings: 2, MaxSeats: windows * perWindow / 4, MaxWindows: windows, DBWorker: true})
+	if err != nil {
+		tst.Fatalf("NewTheatre with DBWorker returned error %v", err)
+	}
+	for m := 0; m < 2; m++ {
+		if err := th.SetGoodieShowings(m, []int{0, 1}); err != nil {
+			tst.Fatalf("SetGoodieShowings(%d, [0 1]) returned error %v", m, err)
+		}
+	}
+
+	// Every window sells and exchanges at once, so that the worker gets
+	// batches of both kinds of update.
+	var wg sync.WaitGroup
+	var exchanged int32
+	for w := 1; w <= windows; w++ {
+		wg.Add(1)
+		go func(window int) {
+			defer wg.Done()
+			for i := 0; i < perWindow; i++ {
+				ticks, _, err := th.Sell(window, [][2]int{{i % 2, (i / 2) % 2}}, nil, "a dummy time")
+				if err != nil {
+					tst.Errorf("Sell at window %d returned error %v", window, err)
+					return
+				}
+				if err := th.Exchange(ticks[0].TicketNum, "popcorn", "soda"); err != nil {
+					tst.Errorf("Exchange of ticket %d returned error %v", ticks[0].TicketNum, err)
+					return
+				}
+				atomic.AddInt32(&exchanged, 1)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	sold := 0
+	for m := 0; m < 2; m++ {
+		for s := 0; s < 2; s++ {
+			for _, t := range th.TicketsForShowing(m, s) {
+				sold++
+				if !t.Exchanged || t.XchNew != "soda" {
+					tst.Errorf("Ticket %d was exchanged, but the DB has Exchanged %v XchNew %q", t.TicketNum, t.Exchanged, t.XchNew)
+				}
+			}
+		}
+	}
+	if sold != windows*perWindow || int(exchanged) != sold {
+		tst.Errorf("The DB has %d tickets sold and %d exchanged, expected %d of each", sold, exchanged, windows*perWindow)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v with the DB worker", problems)
+	}
+
+	// Once Close has stopped the worker, updates fall back to the mutex.
+	if err := th.Close(); err != nil {
+		tst.Fatalf("Close returned error %v", err)
+	}
+	if err := th.updateTicketExchange(Ticket{TicketNum: 1, Exchanged: true, XchOld: "soda", XchNew: "candy"}); err != nil {
+		tst.Errorf("updateTicketExchange after Close returned error %v", err)
+	}
+	if t, _ := th.readTicket(1); t.XchNew != "candy" {
+		tst.Errorf("updateTicketExchange after Close left XchNew %q, expected candy", t.XchNew)
+	}
+} // TestDBWorker
+
+// BenchmarkSell compares the two ways of updating the ticket DB, with every
+// window selling one ticket at a time in parallel.
+func BenchmarkSell(b *testing.B) {
+	for _, bm := range []struct {
+		name     string
+		dbWorker bool
+	}{
+		{"Mutex", false},
+		{"DBWorker", true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			const windows = 8
+			Lbench := log.New(ioutil.Discard, "", 0)
+			th, err := NewTheatre(Config{Logger: Lbench, MaxMovies: 1, MaxShowings: 1, MaxSeats: b.N, MaxWindows: windows, DBWorker: bm.dbWorker})
+			if err != nil {
+				b.Fatalf("NewTheatre returned error %v", err)
+			}
+			defer th.Close()
+
+			var window int32
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				w := int(atomic.AddInt32(&window, 1)-1)%windows + 1
+				for pb.Next() {
+					if _, _, err := th.Sell(w, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+						b.Errorf("Sell at window %d returned error %v", w, err)
+						return
+					}
+				}
+			})
+		})
+	}
+} // BenchmarkSell
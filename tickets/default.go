@@ -0,0 +1,298 @@
+/*****************************************************************************
+
+The package-level functions of 'tickets', which work on the default Theatre
+(the one set up by Init).  They are kept so that programs written before
+there could be more than one Theatre keep working unchanged.  See the
+Theatre methods of the same names for their documentation.
+
+*****************************************************************************/
+
+package tickets
+
+import "time"
+
+// Close calls Close on the default Theatre.
+func Close() error {
+	return std.Close()
+} // Close
+
+// RegisterShutdownHook calls RegisterShutdownHook on the default Theatre.
+func RegisterShutdownHook(hook func() error) {
+	std.RegisterShutdownHook(hook)
+} // RegisterShutdownHook
+
+// Dimensions calls Dimensions on the default Theatre.
+func Dimensions() (movies int, showings int) {
+	return std.Dimensions()
+} // Dimensions
+
+// Windows calls Windows on the default Theatre.
+func Windows() int {
+	return std.Windows()
+} // Windows
+
+// SetSalesWindow calls SetSalesWindow on the default Theatre.
+func SetSalesWindow(open time.Time, close time.Time) error {
+	return std.SetSalesWindow(open, close)
+} // SetSalesWindow
+
+// SetPriceBounds calls SetPriceBounds on the default Theatre.
+func SetPriceBounds(minPenneys int, maxPenneys int) error {
+	return std.SetPriceBounds(minPenneys, maxPenneys)
+} // SetPriceBounds
+
+// UpdatePrices calls UpdatePrices on the default Theatre.
+func UpdatePrices(updates []PriceUpdate) error {
+	return std.UpdatePrices(updates)
+} // UpdatePrices
+
+// SetCustomerTicketLimit calls SetCustomerTicketLimit on the default Theatre.
+func SetCustomerTicketLimit(n int) error {
+	return std.SetCustomerTicketLimit(n)
+} // SetCustomerTicketLimit
+
+// SetSpendGoodieThreshold calls SetSpendGoodieThreshold on the default
+// Theatre.
+func SetSpendGoodieThreshold(penneys int) error {
+	return std.SetSpendGoodieThreshold(penneys)
+} // SetSpendGoodieThreshold
+
+// SetPaymentLimit calls SetPaymentLimit on the default Theatre.
+func SetPaymentLimit(perShowing int) error {
+	return std.SetPaymentLimit(perShowing)
+} // SetPaymentLimit
+
+// SetPaymentIDField calls SetPaymentIDField on the default Theatre.
+func SetPaymentIDField(field string) error {
+	return std.SetPaymentIDField(field)
+} // SetPaymentIDField
+
+// SetFlatPrice calls SetFlatPrice on the default Theatre.
+func SetFlatPrice(penneys int, enabled bool) error {
+	return std.SetFlatPrice(penneys, enabled)
+} // SetFlatPrice
+
+// SetWindowPaymentTypes calls SetWindowPaymentTypes on the default Theatre.
+func SetWindowPaymentTypes(window int, types []string) error {
+	return std.SetWindowPaymentTypes(window, types)
+} // SetWindowPaymentTypes
+
+// SetWindowCashRounding calls SetWindowCashRounding on the default Theatre.
+func SetWindowCashRounding(window int, on bool) error {
+	return std.SetWindowCashRounding(window, on)
+} // SetWindowCashRounding
+
+// SetGoodieShowings calls SetGoodieShowings on the default Theatre.
+func SetGoodieShowings(movie int, showings []int) error {
+	return std.SetGoodieShowings(movie, showings)
+} // SetGoodieShowings
+
+// SetSellTimeout calls SetSellTimeout on the default Theatre.
+func SetSellTimeout(timeout time.Duration) {
+	std.SetSellTimeout(timeout)
+} // SetSellTimeout
+
+// SetReadOnly calls SetReadOnly on the default Theatre.
+func SetReadOnly(on bool) {
+	std.SetReadOnly(on)
+} // SetReadOnly
+
+// Blackout calls Blackout on the default Theatre.
+func Blackout(movie int, showing int, on bool) error {
+	return std.Blackout(movie, showing, on)
+} // Blackout
+
+// Exchange calls Exchange on the default Theatre.
+func Exchange(tickNum int, oldGoodie string, newGoodie string) error {
+	return std.Exchange(tickNum, oldGoodie, newGoodie)
+} // Exchange
+
+// ExchangeWithReceipt calls ExchangeWithReceipt on the default Theatre.
+func ExchangeWithReceipt(tickNum int, oldGoodie string, newGoodie string) (receipt Receipt, err error) {
+	return std.ExchangeWithReceipt(tickNum, oldGoodie, newGoodie)
+} // ExchangeWithReceipt
+
+// SetGoodieRationing calls SetGoodieRationing on the default Theatre.
+func SetGoodieRationing(totalStock int, over time.Duration) {
+	std.SetGoodieRationing(totalStock, over)
+} // SetGoodieRationing
+
+// SetReceiptFooter calls SetReceiptFooter on the default Theatre.
+func SetReceiptFooter(lines []string) {
+	std.SetReceiptFooter(lines)
+} // SetReceiptFooter
+
+// SetUpgradeMenu calls SetUpgradeMenu on the default Theatre.
+func SetUpgradeMenu(menu map[string][]Upgrade) error {
+	return std.SetUpgradeMenu(menu)
+} // SetUpgradeMenu
+
+// UndoExchange calls UndoExchange on the default Theatre.
+func UndoExchange(tickNum int) error {
+	return std.UndoExchange(tickNum)
+} // UndoExchange
+
+// ReserveGoodies calls ReserveGoodies on the default Theatre.
+func ReserveGoodies(goodie string, n int) (string, error) {
+	return std.ReserveGoodies(goodie, n)
+} // ReserveGoodies
+
+// ReleaseGoodies calls ReleaseGoodies on the default Theatre.
+func ReleaseGoodies(resID string) error {
+	return std.ReleaseGoodies(resID)
+} // ReleaseGoodies
+
+// ConsumeGoodies calls ConsumeGoodies on the default Theatre.
+func ConsumeGoodies(resID string) error {
+	return std.ConsumeGoodies(resID)
+} // ConsumeGoodies
+
+// Sell calls Sell on the default Theatre.
+func Sell(window int, ticketRequests [][2]int, paymentInfo map[string]interface{}, localTime interface{}) (tickets []Ticket, receipt Receipt, err error) {
+	return std.Sell(window, ticketRequests, paymentInfo, localTime)
+} // Sell
+
+// ReceiptByNum calls ReceiptByNum on the default Theatre.
+func ReceiptByNum(receiptNum int) (Receipt, error) {
+	return std.ReceiptByNum(receiptNum)
+} // ReceiptByNum
+
+// ResetShowing calls ResetShowing on the default Theatre.
+func ResetShowing(movie int, showing int) error {
+	return std.ResetShowing(movie, showing)
+} // ResetShowing
+
+// CancelShowing calls CancelShowing on the default Theatre.
+func CancelShowing(movie int, showing int) ([]Receipt, error) {
+	return std.CancelShowing(movie, showing)
+} // CancelShowing
+
+// SelfCheck calls SelfCheck on the default Theatre.
+func SelfCheck() []error {
+	return std.SelfCheck()
+} // SelfCheck
+
+// SelfLoadTest calls SelfLoadTest on the default Theatre.
+func SelfLoadTest(concurrency int, duration time.Duration) LoadResult {
+	return std.SelfLoadTest(concurrency, duration)
+} // SelfLoadTest
+
+// TicketsForShowing calls TicketsForShowing on the default Theatre.
+func TicketsForShowing(movie int, showing int) []Ticket {
+	return std.TicketsForShowing(movie, showing)
+} // TicketsForShowing
+
+// Reissue calls Reissue on the default Theatre.
+func Reissue(tickNum int) (Ticket, error) {
+	return std.Reissue(tickNum)
+} // Reissue
+
+// SetShowingStart calls SetShowingStart on the default Theatre.
+func SetShowingStart(movie int, showing int, start time.Time) error {
+	return std.SetShowingStart(movie, showing, start)
+} // SetShowingStart
+
+// StartShowing calls StartShowing on the default Theatre.
+func StartShowing(movie int, showing int) error {
+	return std.StartShowing(movie, showing)
+} // StartShowing
+
+// Compact calls Compact on the default Theatre.
+func Compact() int {
+	return std.Compact()
+} // Compact
+
+// SetCompactInterval calls SetCompactInterval on the default Theatre.
+func SetCompactInterval(interval time.Duration) {
+	std.SetCompactInterval(interval)
+} // SetCompactInterval
+
+// SetReissueGrace calls SetReissueGrace on the default Theatre.
+func SetReissueGrace(grace time.Duration) {
+	std.SetReissueGrace(grace)
+} // SetReissueGrace
+
+// SetClockSkewTolerance calls SetClockSkewTolerance on the default Theatre.
+func SetClockSkewTolerance(tolerance time.Duration) {
+	std.SetClockSkewTolerance(tolerance)
+} // SetClockSkewTolerance
+
+// SetAuditSink calls SetAuditSink on the default Theatre.
+func SetAuditSink(sink AuditSink) {
+	std.SetAuditSink(sink)
+} // SetAuditSink
+
+// SetOnlineWindows calls SetOnlineWindows on the default Theatre.
+func SetOnlineWindows(windows []int) error {
+	return std.SetOnlineWindows(windows)
+} // SetOnlineWindows
+
+// SetChannelAllocation calls SetChannelAllocation on the default Theatre.
+func SetChannelAllocation(movie int, showing int, onlinePct int) error {
+	return std.SetChannelAllocation(movie, showing, onlinePct)
+} // SetChannelAllocation
+
+// ChannelSales calls ChannelSales on the default Theatre.
+func ChannelSales(movie int, showing int) (window int, online int) {
+	return std.ChannelSales(movie, showing)
+} // ChannelSales
+
+// SelloutTimes calls SelloutTimes on the default Theatre.
+func SelloutTimes() map[int]map[int]time.Time {
+	return std.SelloutTimes()
+} // SelloutTimes
+
+// NextAvailableShowing calls NextAvailableShowing on the default Theatre.
+func NextAvailableShowing(movie int) (showing int, found bool) {
+	return std.NextAvailableShowing(movie)
+} // NextAvailableShowing
+
+// TicketsByCustomer calls TicketsByCustomer on the default Theatre.
+func TicketsByCustomer(id string) []Ticket {
+	return std.TicketsByCustomer(id)
+} // TicketsByCustomer
+
+// Revenue calls Revenue on the default Theatre.
+func Revenue(filter RevenueFilter) int {
+	return std.Revenue(filter)
+} // Revenue
+
+// TopCustomers calls TopCustomers on the default Theatre.
+func TopCustomers(n int) []CustomerCount {
+	return std.TopCustomers(n)
+} // TopCustomers
+
+// VoidLastSale calls VoidLastSale on the default Theatre.
+func VoidLastSale(window int) (voidedTickets []Ticket, err error) {
+	return std.VoidLastSale(window)
+} // VoidLastSale
+
+// SetPrepareTimeout calls SetPrepareTimeout on the default Theatre.
+func SetPrepareTimeout(timeout time.Duration) {
+	std.SetPrepareTimeout(timeout)
+} // SetPrepareTimeout
+
+// SetSeatReleaseDelay calls SetSeatReleaseDelay on the default Theatre.
+func SetSeatReleaseDelay(d time.Duration) {
+	std.SetSeatReleaseDelay(d)
+} // SetSeatReleaseDelay
+
+// PrepareSale calls PrepareSale on the default Theatre.
+func PrepareSale(window int, ticketRequests [][2]int, paymentInfo map[string]interface{}, localTime interface{}) (PreparedSale, error) {
+	return std.PrepareSale(window, ticketRequests, paymentInfo, localTime)
+} // PrepareSale
+
+// CommitSale calls CommitSale on the default Theatre.
+func CommitSale(txID int) ([]Ticket, Receipt, error) {
+	return std.CommitSale(txID)
+} // CommitSale
+
+// AbortSale calls AbortSale on the default Theatre.
+func AbortSale(txID int) error {
+	return std.AbortSale(txID)
+} // AbortSale
+
+// BeginTransaction calls BeginTransaction on the default Theatre.
+func BeginTransaction(window int, paymentInfo map[string]interface{}) (*Txn, error) {
+	return std.BeginTransaction(window, paymentInfo)
+} // BeginTransaction
@@ -0,0 +1,123 @@
+/*****************************************************************************
+
+Goodie holds let a concession stand manage the goodie stock directly, e.g.
+to keep some back for walk-up sales, instead of only through ticket
+exchanges.  ReserveGoodies holds some of the stock, which exchanges can no
+longer take, until the hold is released (putting the goods back) or consumed
+(the goods are gone for good).
+
+*****************************************************************************/
+
+package tickets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoSuchReservation is returned by ReleaseGoodies and ConsumeGoodies if
+// the reservation ID is not one of a hold which is still outstanding.
+var ErrNoSuchReservation = errors.New("Goodie hold denied:  there is no such reservation (it may have been released or consumed)")
+
+// A goodieHold is the goods held by one ReserveGoodies call.
+type goodieHold struct {
+	goodie string
+	n      int
+} // goodieHold
+
+// ReserveGoodies holds n of the goodie stock, so that exchanges can't take
+// them.  The stock given to Init is shared by all goodies, so goodie is only
+// the name the hold is recorded (and logged) under.
+//
+// Returns the reservation ID for ReleaseGoodies or ConsumeGoodies, or an
+// error:  ErrXchOutOfGoods if fewer than n goods are on hand (in which case
+// nothing is held), ErrReadOnly, or an error if n is not positive or the
+// ticketing system is down.
+func (th *Theatre) ReserveGoodies(goodie string, n int) (resID string, err error) {
+	defer func() {
+		th.audit("ReserveGoodies", 0, fmt.Sprintf("%d %s", n, goodie), resID, err)
+	}()
+	if !th.salesOpen {
+		return "", errors.New("ReserveGoodies failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return "", ErrReadOnly
+	}
+	if n < 1 {
+		return "", fmt.Errorf("ReserveGoodies failed:  %d goodies requested, must be at least 1", n)
+	}
+
+	th.goodsMutex.Lock()
+	defer th.goodsMutex.Unlock()
+	if th.goodsOnHand() < n {
+		return "", ErrXchOutOfGoods
+	}
+	if th.goodieHolds == nil {
+		th.goodieHolds = make(map[string]goodieHold)
+	}
+	th.lastHoldID++
+	resID = fmt.Sprintf("G%d", th.lastHoldID)
+	th.goodieHolds[resID] = goodieHold{goodie: goodie, n: n}
+	th.heldGoods += n
+	th.L.Printf("ReserveGoodies held %d %s as %s.", n, goodie, resID)
+	return resID, nil
+} // ReserveGoodies
+
+// ReleaseGoodies ends the hold resID, putting its goods back in stock for
+// exchanges (or another hold).
+//
+// Returns ErrNoSuchReservation if resID is not an outstanding hold, otherwise
+// nil.
+func (th *Theatre) ReleaseGoodies(resID string) (err error) {
+	defer func() {
+		th.audit("ReleaseGoodies", 0, resID, "released", err)
+	}()
+	hold, err := th.endHold(resID, false)
+	if err != nil {
+		return err
+	}
+	th.L.Printf("ReleaseGoodies put %d %s from %s back in stock.", hold.n, hold.goodie, resID)
+	return nil
+} // ReleaseGoodies
+
+// ConsumeGoodies ends the hold resID, once its goods have been handed out, so
+// that they are gone from the stock for good.
+//
+// Returns ErrNoSuchReservation if resID is not an outstanding hold, otherwise
+// nil.
+func (th *Theatre) ConsumeGoodies(resID string) (err error) {
+	defer func() {
+		th.audit("ConsumeGoodies", 0, resID, "consumed", err)
+	}()
+	hold, err := th.endHold(resID, true)
+	if err != nil {
+		return err
+	}
+	th.L.Printf("ConsumeGoodies used up %d %s from %s.", hold.n, hold.goodie, resID)
+	return nil
+} // ConsumeGoodies
+
+// endHold removes the hold resID, and its goods from heldGoods, adding them
+// to usedGoods if consumed (in the same step, so that they are never back on
+// hand in between).  Returns the hold, or ErrNoSuchReservation.
+func (th *Theatre) endHold(resID string, consumed bool) (goodieHold, error) {
+	th.goodsMutex.Lock()
+	defer th.goodsMutex.Unlock()
+	hold, ok := th.goodieHolds[resID]
+	if !ok {
+		return goodieHold{}, ErrNoSuchReservation
+	}
+	delete(th.goodieHolds, resID)
+	th.heldGoods -= hold.n
+	if consumed {
+		th.usedGoods += hold.n
+	}
+	return hold, nil
+} // endHold
+
+// goodsOnHand returns how many goods are left for exchanges or holds:  the
+// stock given to Init, less those exchanged, held and consumed.  The caller
+// must hold goodsMutex.
+func (th *Theatre) goodsOnHand() int {
+	return th.maxExchanges - th.totExchanges - th.heldGoods - th.usedGoods
+} // goodsOnHand
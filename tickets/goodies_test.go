@@ -0,0 +1,87 @@
+package tickets
+
+import (
+	"testing"
+)
+
+func TestReserveGoodies(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 4, 1)
+	ticks, _, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+
+	// Hold 4 of the 5, so that only one exchange can be made.
+	popcorn, err := th.ReserveGoodies("popcorn", 3)
+	if err != nil {
+		tst.Fatalf("ReserveGoodies(popcorn, 3) returned error %v", err)
+	}
+	soda, err := th.ReserveGoodies("soda", 1)
+	if err != nil || soda == popcorn {
+		tst.Fatalf("ReserveGoodies(soda, 1) returned %q, error %v, expected a new reservation", soda, err)
+	}
+	if _, err := th.ReserveGoodies("soda", 2); err != ErrXchOutOfGoods {
+		tst.Errorf("ReserveGoodies for 2 of 1 on hand returned error %v, expected %v", err, ErrXchOutOfGoods)
+	}
+	if err := th.Exchange(ticks[0].TicketNum, "popcorn", "soda"); err != nil {
+		tst.Errorf("Exchange with 1 on hand returned error %v", err)
+	}
+	if err := th.Exchange(ticks[1].TicketNum, "popcorn", "soda"); err != ErrXchOutOfGoods {
+		tst.Errorf("Exchange with all of the rest held returned error %v, expected %v", err, ErrXchOutOfGoods)
+	}
+
+	// Releasing puts the goods back for exchanges;  consuming doesn't.
+	if err := th.ReleaseGoodies(soda); err != nil {
+		tst.Errorf("ReleaseGoodies(%s) returned error %v", soda, err)
+	}
+	if err := th.ConsumeGoodies(soda); err != ErrNoSuchReservation {
+		tst.Errorf("ConsumeGoodies of a released hold returned error %v, expected %v", err, ErrNoSuchReservation)
+	}
+	if err := th.Exchange(ticks[1].TicketNum, "popcorn", "soda"); err != nil {
+		tst.Errorf("Exchange after ReleaseGoodies returned error %v", err)
+	}
+	if err := th.ConsumeGoodies(popcorn); err != nil {
+		tst.Errorf("ConsumeGoodies(%s) returned error %v", popcorn, err)
+	}
+	if err := th.ReleaseGoodies(popcorn); err != ErrNoSuchReservation {
+		tst.Errorf("ReleaseGoodies of a consumed hold returned error %v, expected %v", err, ErrNoSuchReservation)
+	}
+	if _, err := th.ReserveGoodies("popcorn", 1); err != ErrXchOutOfGoods {
+		tst.Errorf("ReserveGoodies after the stock was exchanged and consumed returned error %v, expected %v", err, ErrXchOutOfGoods)
+	}
+
+	// Undoing an exchange makes room for another hold.
+	if err := th.UndoExchange(ticks[0].TicketNum); err != nil {
+		tst.Fatalf("UndoExchange returned error %v", err)
+	}
+	if _, err := th.ReserveGoodies("popcorn", 1); err != nil {
+		tst.Errorf("ReserveGoodies after UndoExchange returned error %v", err)
+	}
+	if _, err := th.ReserveGoodies("popcorn", 0); err == nil {
+		tst.Errorf("ReserveGoodies(popcorn, 0) should have failed")
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v with goodies held", problems)
+	}
+} // TestReserveGoodies
+
+func TestReserveGoodiesRace(tst *testing.T) {
+	const stock = 20
+	th := newTestTheatre(tst, stock, 1, 1, 1, 1)
+	held := make(chan string, 2*stock)
+	done := make(chan bool)
+	for i := 0; i < 2*stock; i++ {
+		go func() {
+			if resID, err := th.ReserveGoodies("popcorn", 1); err == nil {
+				held <- resID
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 2*stock; i++ {
+		<-done
+	}
+	if len(held) != stock {
+		tst.Errorf("%d goroutines each holding 1 of %d goodies held %d, expected %d", 2*stock, stock, len(held), stock)
+	}
+} // TestReserveGoodiesRace
@@ -0,0 +1,64 @@
+/*****************************************************************************
+
+'loadgen' generates a fixed corpus of sell and exchange requests from a seed,
+so that load tests and benchmarks (e.g. the theatre model's -corpus-seed
+option, and BenchmarkSellCorpus) send the same requests on every run, and so
+can be compared with each other.
+
+The same seed, count and dimensions always give the same corpus, on any
+machine, since it comes from its own math/rand source.
+
+*****************************************************************************/
+
+package loadgen
+
+import (
+	"math/rand"
+
+	"github.com/d-m-w/learninggo/tickets"
+)
+
+// MaxTickets is the most tickets one Request asks for.
+const MaxTickets = 4
+
+// A Request is one sale to make, and what to do with its Tickets afterwards.
+type Request struct {
+	// Window is the window to sell at, from 1 to the dimensions'
+	// MaxWindows.
+	Window int
+
+	// TicketRequests are the {movie, showing} pairs to pass to Sell:  1 to
+	// MaxTickets of them, each in range for the dimensions.
+	TicketRequests [][2]int
+
+	// Exchange tells, for each of TicketRequests, whether the customer
+	// exchanges the goodies which come with its Ticket.  It only matters for
+	// Tickets which do come with goodies (see tickets.SetGoodieShowings).
+	Exchange []bool
+} // Request
+
+// GenerateRequests generates n Requests for a theatre of dims' size (only
+// MaxMovies, MaxShowings and MaxWindows are used).  The windows, movies and
+// showings are spread evenly over their ranges, and about half of the
+// Tickets are exchanged.
+//
+// Returns the Requests, in the order they are to be made, or nil if n is
+// less than 1, or if dims has no movies, showings or windows.
+func GenerateRequests(seed int64, n int, dims tickets.Config) []Request {
+	if n < 1 || dims.MaxMovies < 1 || dims.MaxShowings < 1 || dims.MaxWindows < 1 {
+		return nil
+	}
+
+	rnd := rand.New(rand.NewSource(seed))
+	rqsts := make([]Request, n)
+	for i := range rqsts {
+		items := 1 + rnd.Intn(MaxTickets)
+		r := Request{Window: 1 + rnd.Intn(dims.MaxWindows), TicketRequests: make([][2]int, items), Exchange: make([]bool, items)}
+		for j := 0; j < items; j++ {
+			r.TicketRequests[j] = [2]int{rnd.Intn(dims.MaxMovies), rnd.Intn(dims.MaxShowings)}
+			r.Exchange[j] = rnd.Intn(2) == 0
+		}
+		rqsts[i] = r
+	}
+	return rqsts
+} // GenerateRequests
@@ -0,0 +1,91 @@
+package loadgen
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/d-m-w/learninggo/tickets"
+)
+
+func TestGenerateRequests(tst *testing.T) {
+	dims := tickets.Config{MaxMovies: 3, MaxShowings: 2, MaxWindows: 4}
+	corpus := GenerateRequests(42, 500, dims)
+	if len(corpus) != 500 {
+		tst.Fatalf("GenerateRequests(42, 500) returned %d requests, expected 500", len(corpus))
+	}
+	if again := GenerateRequests(42, 500, dims); !reflect.DeepEqual(corpus, again) {
+		tst.Errorf("GenerateRequests(42, 500) returned a different corpus the second time")
+	}
+	if other := GenerateRequests(43, 500, dims); reflect.DeepEqual(corpus, other) {
+		tst.Errorf("GenerateRequests(43, 500) returned the same corpus as seed 42")
+	}
+
+	windows := make(map[int]bool)
+	showings := make(map[[2]int]bool)
+	for i, r := range corpus {
+		if r.Window < 1 || r.Window > dims.MaxWindows {
+			tst.Errorf("Request %d is for window %d, expected 1 to %d", i, r.Window, dims.MaxWindows)
+		}
+		windows[r.Window] = true
+		if len(r.TicketRequests) < 1 || len(r.TicketRequests) > MaxTickets || len(r.Exchange) != len(r.TicketRequests) {
+			tst.Errorf("Request %d has %d ticket requests and %d exchange flags, expected the same number, from 1 to %d", i, len(r.TicketRequests), len(r.Exchange), MaxTickets)
+		}
+		for _, trqst := range r.TicketRequests {
+			if trqst[0] < 0 || trqst[0] >= dims.MaxMovies || trqst[1] < 0 || trqst[1] >= dims.MaxShowings {
+				tst.Errorf("Request %d asks for movie %d, showing %d, outside the %d movies and %d showings", i, trqst[0], trqst[1], dims.MaxMovies, dims.MaxShowings)
+			}
+			showings[trqst] = true
+		}
+	}
+	if len(windows) != dims.MaxWindows || len(showings) != dims.MaxMovies*dims.MaxShowings {
+		tst.Errorf("The corpus used %d windows and %d showings, expected all %d and %d", len(windows), len(showings), dims.MaxWindows, dims.MaxMovies*dims.MaxShowings)
+	}
+
+	for _, bad := range []struct {
+		n    int
+		dims tickets.Config
+	}{
+		{0, dims},
+		{10, tickets.Config{MaxMovies: 0, MaxShowings: 2, MaxWindows: 4}},
+		{10, tickets.Config{MaxMovies: 3, MaxShowings: 2, MaxWindows: 0}},
+	} {
+		if got := GenerateRequests(42, bad.n, bad.dims); got != nil {
+			tst.Errorf("GenerateRequests(42, %d, %+v) returned %d requests, expected nil", bad.n, bad.dims, len(got))
+		}
+	}
+} // TestGenerateRequests
+
+// BenchmarkSellCorpus makes the sales (and exchanges) of a fixed corpus, one
+// after another, so that runs can be compared with each other.
+func BenchmarkSellCorpus(b *testing.B) {
+	dims := tickets.Config{MaxMovies: 5, MaxShowings: 4, MaxWindows: 4}
+	corpus := GenerateRequests(1, b.N, dims)
+
+	// Room in the ticket DB, and goodies, for every ticket the corpus asks
+	// for, so that it never runs out part way.
+	dims.Logger = log.New(ioutil.Discard, "", 0)
+	dims.MaxSeats = b.N*MaxTickets/(dims.MaxMovies*dims.MaxShowings) + 1
+	dims.MaxExchanges = b.N * MaxTickets
+	th, err := tickets.NewTheatre(dims)
+	if err != nil {
+		b.Fatalf("NewTheatre returned error %v", err)
+	}
+	defer th.Close()
+
+	b.ResetTimer()
+	for _, r := range corpus {
+		ticks, _, err := th.Sell(r.Window, r.TicketRequests, nil, "a dummy time")
+		if err != nil {
+			b.Fatalf("Sell of %+v returned error %v", r, err)
+		}
+		for i, t := range ticks {
+			if t.Goodies && r.Exchange[i] {
+				if err := th.Exchange(t.TicketNum, "popcorn", "soda"); err != nil {
+					b.Fatalf("Exchange of ticket %d returned error %v", t.TicketNum, err)
+				}
+			}
+		}
+	}
+} // BenchmarkSellCorpus
@@ -0,0 +1,147 @@
+/*****************************************************************************
+
+SelfLoadTest drives concurrent sales and exchanges against a scratch Theatre,
+to check the ticketing system's concurrency safety in place.
+
+*****************************************************************************/
+
+package tickets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadResult reports what a SelfLoadTest did, and any invariant violations
+// it found.
+type LoadResult struct {
+	Concurrency    int           `json:"concurrency"`
+	Duration       time.Duration `json:"duration"` // how long the load actually ran, in nanoseconds
+	Sells          int           `json:"sells"`    // Sell calls made
+	TicketsSold    int           `json:"ticketsSold"`
+	SoldOut        int           `json:"soldOut"` // requests refused because the showing was sold out
+	Exchanges      int           `json:"exchanges"`
+	Errors         int           `json:"errors"` // Sell or Exchange calls which failed unexpectedly
+	SellsPerSecond float64       `json:"sellsPerSecond"`
+	DBFull         bool          `json:"dbFull"` // the load stopped early, because the scratch DB filled up
+	Violations     []string      `json:"violations"`
+} // LoadResult
+
+// SelfLoadTest runs concurrency goroutines for up to duration, each one
+// repeatedly selling a ticket for a random showing at a random window (and
+// exchanging its goodies, if it has any), directly through Sell and Exchange.
+// It then checks the invariants:  SelfCheck finds nothing, no ticket number
+// was handed out twice, and no showing sold more than its seats.
+//
+// The load runs against a scratch Theatre with the same dimensions as th,
+// not th itself, so that it doesn't use up th's seats, goodies and ticket
+// numbers.  The scratch Theatre's ticket DB only has room for one ticket per
+// seat, so the load stops early (and DBFull is set) once that many requests
+// have been made.
+//
+// Returns a LoadResult.  Bad arguments, or a failure to open the scratch
+// Theatre, are reported as Violations.
+func (th *Theatre) SelfLoadTest(concurrency int, duration time.Duration) LoadResult {
+	result := LoadResult{Concurrency: concurrency, Violations: make([]string, 0)}
+	if concurrency < 1 || duration < 1 {
+		result.Violations = append(result.Violations, fmt.Sprintf("SelfLoadTest failed:  need concurrency (%d) >= 1 and duration (%v) >= 1ns", concurrency, duration))
+		return result
+	}
+	scratch, err := NewTheatre(Config{
+		Logger:       log.New(ioutil.Discard, "", 0), // per-sale logging would swamp the real log
+		MaxExchanges: th.maxExchanges,
+		MaxMovies:    th.maxMovies,
+		MaxShowings:  th.maxShowings,
+		MaxSeats:     th.maxSeats,
+		MaxWindows:   th.maxWindows,
+	})
+	if err != nil {
+		result.Violations = append(result.Violations, fmt.Sprintf("SelfLoadTest failed:  cannot open scratch theatre:  %v", err))
+		return result
+	}
+	defer scratch.Close()
+	th.L.Printf("SelfLoadTest starting:  %d goroutines for %v.", concurrency, duration)
+
+	capacity := int64(len(scratch.ticketRqstDB) - 1)
+	var requested int64 // ticket numbers claimed so far; must not pass capacity
+	var sells, sold, soldOut, exchanges, errs int64
+	var seenMutex sync.Mutex
+	seen := make(map[int]bool)
+	duplicates := make([]int, 0)
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	var wg sync.WaitGroup
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if atomic.AddInt64(&requested, 1) > capacity {
+					return
+				}
+				window := 1 + rnd.Intn(scratch.maxWindows)
+				rqst := [2]int{rnd.Intn(scratch.maxMovies), rnd.Intn(scratch.maxShowings)}
+				ticks, _, err := scratch.Sell(window, [][2]int{rqst}, nil, nil)
+				atomic.AddInt64(&sells, 1)
+				if err != nil || len(ticks) != 1 {
+					atomic.AddInt64(&errs, 1)
+					continue
+				}
+				t := ticks[0]
+				seenMutex.Lock()
+				if seen[t.TicketNum] {
+					duplicates = append(duplicates, t.TicketNum)
+				}
+				seen[t.TicketNum] = true
+				seenMutex.Unlock()
+				if t.SoldOut {
+					atomic.AddInt64(&soldOut, 1)
+					continue
+				}
+				atomic.AddInt64(&sold, 1)
+				if t.Goodies {
+					switch err := scratch.Exchange(t.TicketNum, "water", "soda"); err {
+					case nil:
+						atomic.AddInt64(&exchanges, 1)
+					case ErrXchOutOfGoods:
+					default:
+						atomic.AddInt64(&errs, 1)
+					}
+				}
+			}
+		}(rand.New(rand.NewSource(time.Now().UnixNano() + int64(g))))
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(start)
+	result.Sells = int(sells)
+	result.TicketsSold = int(sold)
+	result.SoldOut = int(soldOut)
+	result.Exchanges = int(exchanges)
+	result.Errors = int(errs)
+	result.SellsPerSecond = float64(sells) / result.Duration.Seconds()
+	result.DBFull = requested > capacity
+
+	for _, p := range scratch.SelfCheck() {
+		result.Violations = append(result.Violations, p.Error())
+	}
+	for _, n := range duplicates {
+		result.Violations = append(result.Violations, fmt.Sprintf("Ticket number %d was handed out more than once", n))
+	}
+	for m := 0; m < scratch.maxMovies; m++ {
+		for s := 0; s < scratch.maxShowings; s++ {
+			if n := len(scratch.TicketsForShowing(m, s)); n > scratch.maxSeats {
+				result.Violations = append(result.Violations, fmt.Sprintf("Movie %d, showing %d:  %d tickets sold for %d seats", m, s, n, scratch.maxSeats))
+			}
+		}
+	}
+
+	th.L.Printf("SelfLoadTest finished:  %+v", result)
+	return result
+} // SelfLoadTest
@@ -0,0 +1,32 @@
+package tickets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelfLoadTest(tst *testing.T) {
+	th := newTestTheatre(tst, 20, 2, 3, 10, 2)
+	result := th.SelfLoadTest(8, 50*time.Millisecond)
+	if len(result.Violations) != 0 {
+		tst.Errorf("SelfLoadTest found violations:  %v", result.Violations)
+	}
+	if result.Sells == 0 || result.Errors != 0 {
+		tst.Errorf("SelfLoadTest made %d sells with %d errors, expected some sells and no errors", result.Sells, result.Errors)
+	}
+	if result.TicketsSold+result.SoldOut != result.Sells {
+		tst.Errorf("SelfLoadTest sold %d and refused %d, but made %d sells", result.TicketsSold, result.SoldOut, result.Sells)
+	}
+	if result.TicketsSold > 2*3*10 || result.Exchanges > 20 {
+		tst.Errorf("SelfLoadTest sold %d tickets and made %d exchanges, more than the theatre has", result.TicketsSold, result.Exchanges)
+	}
+
+	// The load runs on a scratch theatre, so th itself is untouched.
+	if got := th.TicketsForShowing(0, 0); len(got) != 0 {
+		tst.Errorf("SelfLoadTest sold %d tickets in the theatre itself, expected none", len(got))
+	}
+
+	if result := th.SelfLoadTest(0, time.Second); len(result.Violations) != 1 || result.Sells != 0 {
+		tst.Errorf("SelfLoadTest(0, 1s) returned %+v, expected it to be refused", result)
+	}
+} // TestSelfLoadTest
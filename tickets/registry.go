@@ -0,0 +1,96 @@
+/*****************************************************************************
+
+Registry lets one process run several independent Theatres, addressed by
+name (e.g. one per site served by a single tickets server).
+
+*****************************************************************************/
+
+package tickets
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrTheatreExists is returned by Register when the name is already taken.
+var ErrTheatreExists = errors.New("Register denied:  a theatre with this name is already registered")
+
+// A Registry maps names to Theatres.  A zero Registry is empty and ready to
+// use.
+type Registry struct {
+	mutex    sync.RWMutex
+	theatres map[string]*Theatre
+} // Registry
+
+// defaultRegistry is the Registry used by the package-level Register, Lookup
+// and Names.
+var defaultRegistry Registry
+
+// Register creates a new Theatre from cfg (see NewTheatre), and adds it to
+// the Registry under name.
+//
+// Parameters:
+//
+// name
+//   The name to find the Theatre by.  Must not be empty or already in use.
+// cfg
+//   The settings for the new Theatre.
+//
+// Returns the new Theatre, or nil and an error if the name is unusable or a
+// setting is invalid.
+func (r *Registry) Register(name string, cfg Config) (*Theatre, error) {
+	if name == "" {
+		return nil, errors.New("Register failed:  missing theatre name")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, found := r.theatres[name]; found {
+		return nil, ErrTheatreExists
+	}
+	th, err := NewTheatre(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if r.theatres == nil {
+		r.theatres = make(map[string]*Theatre)
+	}
+	r.theatres[name] = th
+	return th, nil
+} // Register
+
+// Lookup returns the Theatre registered under name, or nil and false if
+// there isn't one.
+func (r *Registry) Lookup(name string) (*Theatre, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	th, found := r.theatres[name]
+	return th, found
+} // Lookup
+
+// Names returns the names of all the registered Theatres, sorted.
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.theatres))
+	for name := range r.theatres {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+} // Names
+
+// Register calls Register on the package's default Registry.
+func Register(name string, cfg Config) (*Theatre, error) {
+	return defaultRegistry.Register(name, cfg)
+} // Register
+
+// Lookup calls Lookup on the package's default Registry.
+func Lookup(name string) (*Theatre, bool) {
+	return defaultRegistry.Lookup(name)
+} // Lookup
+
+// Names calls Names on the package's default Registry.
+func Names() []string {
+	return defaultRegistry.Names()
+} // Names
@@ -0,0 +1,74 @@
+package tickets
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+func TestRegistryTheatresAreIndependent(tst *testing.T) {
+	Ltest := log.New(os.Stderr, "TestRegistry:  ", log.Ldate|log.Ltime|log.Llongfile)
+	var r Registry
+	north, err := r.Register("north", Config{Logger: Ltest, MaxExchanges: 1, MaxMovies: 1, MaxShowings: 1, MaxSeats: 2, MaxWindows: 1})
+	if err != nil {
+		tst.Fatalf("Register(north) returned error %v", err)
+	}
+	south, err := r.Register("south", Config{Logger: Ltest, MaxExchanges: 0, MaxMovies: 2, MaxShowings: 3, MaxSeats: 2, MaxWindows: 1})
+	if err != nil {
+		tst.Fatalf("Register(south) returned error %v", err)
+	}
+	if _, err := r.Register("north", Config{Logger: Ltest, MaxMovies: 1, MaxShowings: 1, MaxSeats: 1, MaxWindows: 1}); err != ErrTheatreExists {
+		tst.Errorf("Register(north) again returned %v, expected ErrTheatreExists", err)
+	}
+	if _, err := r.Register("bad", Config{Logger: Ltest}); err == nil {
+		tst.Errorf("Register with MaxMovies 0 returned no error")
+	}
+	if names := r.Names(); len(names) != 2 || names[0] != "north" || names[1] != "south" {
+		tst.Errorf("Names() returned %v, expected [north south]", names)
+	}
+	if th, found := r.Lookup("south"); !found || th != south {
+		tst.Errorf("Lookup(south) returned %p %v, expected %p true", th, found, south)
+	}
+	if _, found := r.Lookup("east"); found {
+		tst.Errorf("Lookup(east) found a theatre, expected none")
+	}
+	if m, s := south.Dimensions(); m != 2 || s != 3 {
+		tst.Errorf("south Dimensions() returned %d, %d, expected 2, 3", m, s)
+	}
+
+	// Filling north's room must leave south's seats alone.
+	tks, _, err := north.Sell(1, [][2]int{{0, 0}, {0, 0}}, nil, nil)
+	if err != nil {
+		tst.Fatalf("north Sell returned error %v", err)
+	}
+	if tks[0].SoldOut || tks[1].SoldOut {
+		tst.Errorf("north Sell returned SoldOut %v, %v, expected false, false", tks[0].SoldOut, tks[1].SoldOut)
+	}
+	tks, _, err = south.Sell(1, [][2]int{{0, 0}, {0, 0}}, nil, nil)
+	if err != nil {
+		tst.Fatalf("south Sell returned error %v", err)
+	}
+	if tks[0].SoldOut || tks[1].SoldOut {
+		tst.Errorf("south Sell returned SoldOut %v, %v, expected false, false", tks[0].SoldOut, tks[1].SoldOut)
+	}
+	if tks[0].TicketNum != 1 || tks[1].TicketNum != 2 {
+		tst.Errorf("south Sell returned ticket numbers %d, %d, expected its own roll's 1, 2", tks[0].TicketNum, tks[1].TicketNum)
+	}
+
+	// Each theatre has its own goodies:  north has one, south has none.
+	if err := north.Exchange(1, "popcorn", "mug"); err != nil {
+		tst.Errorf("north Exchange returned error %v", err)
+	}
+	if err := south.Exchange(1, "popcorn", "mug"); err != ErrXchOutOfGoods {
+		tst.Errorf("south Exchange returned %v, expected ErrXchOutOfGoods", err)
+	}
+	if err := north.Exchange(2, "popcorn", "mug"); err != ErrXchOutOfGoods {
+		tst.Errorf("north second Exchange returned %v, expected ErrXchOutOfGoods", err)
+	}
+	if got := len(north.TicketsForShowing(0, 0)); got != 2 {
+		tst.Errorf("north TicketsForShowing(0, 0) returned %d tickets, expected 2", got)
+	}
+	if got := len(south.TicketsForShowing(0, 0)); got != 2 {
+		tst.Errorf("south TicketsForShowing(0, 0) returned %d tickets, expected 2", got)
+	}
+} // TestRegistryTheatresAreIndependent
@@ -7,22 +7,153 @@ You can use it if you want to make 'tickets' available as a stand-alone Web
 service.  It will then have a JSON interface.
 
 The following URLs are supported:
-    /tickets/sell/<window_number>
+    /tickets/sell/<window_number>[?omit_soldout=true]
         This URL is accessed with POST.  The request is sent in JSON format:
             {
                 "TicketRequests" : [ [ <movie#>, <showning#> ], ... ],
-                "PaymentInfo"    : <can be anything -- validation not implemented>,
-                "LocalTime"      : <can be anything -- not currently used>
+                "PaymentInfo"    : { <payment fields, see below> },
+                "LocalTime"      : <the client's time, e.g. "2020-06-01T18:55:00Z">
             }
+        PaymentInfo may hold any fields, but only these are used:
+            "paymentType"     : checked against the types the window
+                                accepts (ERR_PAYMENT_TYPE_NOT_ACCEPTED)
+            "cardFingerprint" : identifies the payer, for the payment
+                                limit (see tickets.SetPaymentIDField)
+            "customerID"      : the customer's loyalty ID, copied into the
+                                tickets, and counted against the customer
+                                ticket limit
+        LocalTime is copied as-is into the receipt's "time".  If the clock
+        skew tolerance is set (see tickets.SetClockSkewTolerance), then an
+        RFC 3339 time within it is also used for the showing start checks,
+        so that a client whose clock is a little behind isn't refused a
+        showing which has only just started by the server's clock.
         The reply is also sent back in JSON format:
             {
                 "tickets"        :   [ { <struct Ticket expressed as a JSON map> }, ... ],
                 "receipt"        :   { <struct Receipt expressed as a JSON map> }
             }
-	and you get HTTP 200 on success.
+	and you get HTTP 200 on success.  If the sale fails part way, after some
+	of the tickets were sold (with ERR_NO_MORE_TICKETS, when the ticket DB
+	fills up, or ERR_CHANNEL_SOLD_OUT, when the window's sales channel has
+	sold its share of a showing), you still get HTTP 200 and the tickets
+	(the refused requests as sold-out placeholders) and receipt of what was
+	sold, with "error" and "code" added, as in an error reply (see below).
+	With omit_soldout=true, the sold-out placeholders are left out of the
+	tickets, and reported separately:
+            {
+                "sold"           :   [ { <struct Ticket expressed as a JSON map> }, ... ],
+                "unavailable"    :   [ { "movie" : <movie#>, "showing" : <showing#>, "reason" : <why> }, ... ],
+                "receipt"        :   { <struct Receipt expressed as a JSON map> }
+            }
     /tickets/exchange/<ticket_number>/<old_goodie>/<new_goodie>
         There is no additional payload with this URL.  Use GET or POST.
-        There is no reply data (get HTTP 204 on success).
+        There is no reply data for a free exchange (get HTTP 204 on success).
+        If the upgrade menu charges (or refunds) for the exchange, then the
+        reply is HTTP 200 and
+            {
+                "receipt"        :   { <struct Receipt expressed as a JSON map> }
+            }
+    /tickets/receipt-by-num/<receipt#>
+        Use GET.  The reply is the receipt with that receiptNum (as sent back
+        by the sell which made it), with HTTP 200, or HTTP 404 and the error
+        code ERR_NO_SUCH_RECEIPT:
+            { <struct Receipt expressed as a JSON map> }
+    /tickets/config
+        Use GET.  The reply is the size of the theatre the server was
+        started with, so that clients can make valid requests without
+        having to be started with the same options:
+            {
+                "movies"         :   <movies, numbered from 0>,
+                "showings"       :   <showings of each movie, numbered from 0>,
+                "windows"        :   <ticket windows, numbered from 1>
+            }
+    /tickets/showing/<movie#>/<showing#>
+        Use GET.  The reply is all of the tickets sold for that showing, and
+        how many of its seats were sold at the walk-up windows and online:
+            {
+                "tickets"        :   [ { <struct Ticket expressed as a JSON map> }, ... ],
+                "windowSold"     :   <seats sold at walk-up windows>,
+                "onlineSold"     :   <seats sold online>
+            }
+    /tickets/next/<movie#>
+        Use GET.  The reply is the earliest showing of that movie which can
+        still be sold (not started, not blacked out, and with a seat left),
+        with HTTP 200.  found is false, and showing 0, if there isn't one:
+            {
+                "showing"        :   <showing#>,
+                "found"          :   <true|false>
+            }
+
+The following admin URLs are also supported.  They are disabled unless the
+server is started with -admin-token, and then the same token must be sent in
+the X-Admin-Token header of each request:
+    /tickets/admin/selfcheck
+        Use GET.  Runs tickets.SelfCheck, and replies with HTTP 200 and
+            { "problems" : [ <description of each inconsistency found>, ... ] }
+    /tickets/admin/blackout/<movie#>/<showing#>?on=<true|false>
+        Use POST.  Runs tickets.Blackout, to take the showing off public sale
+        (on=true) or put it back on sale (on=false).  Replies with HTTP 204.
+        While a showing is blacked out, sells which include it fail with the
+        error code ERR_BLACKOUT (a sold-out showing still just gets a
+        sold-out placeholder ticket).
+    /tickets/showing/<movie#>/<showing#>/start
+        Use POST.  Runs tickets.StartShowing, to mark the showing as started
+        now (e.g. to try out what happens once a showing starts, without
+        waiting for it).  Replies with HTTP 204.  After that, sells which
+        include the showing fail with the error code ERR_SHOWING_STARTED.
+    /tickets/admin/readonly?on=<true|false>
+        Use POST.  Runs tickets.SetReadOnly, to turn read-only maintenance
+        mode on or off.  Replies with HTTP 204.  While it is on, sells and
+        exchanges fail with the error code ERR_READ_ONLY, but the showing
+        and receipt lookups and the self-check still work.
+    /tickets/admin/compact
+        Use POST.  Runs tickets.Compact, to release the data of the void
+        tickets and sold-out placeholders, and replies with HTTP 200 and
+            { "compacted" : <number of tickets which had data released> }
+        (The server also does this every -compact-interval, if it is set.)
+    /tickets/admin/loadtest?concurrency=<n>&duration=<time.Duration>
+        Use POST.  Runs tickets.SelfLoadTest (on a scratch theatre, so the
+        real one is not touched), and replies with HTTP 200 and
+            { <struct LoadResult expressed as a JSON map> }
+        concurrency must be 1 to 1000, and duration (e.g. "2s") at most 1m.
+    /tickets/revenue?movie=<movie#>&showing=<showing#>&window=<window#>&from=<time>&to=<time>
+        Use GET.  Runs tickets.Revenue, and replies with HTTP 200 and the
+        takings, in penneys, of the tickets sold for that movie, showing and
+        window, at or after from and before to (RFC 3339 times, e.g.
+        "2026-10-14T00:00:00Z").  Each parameter is optional;  leaving it
+        out counts everything for it:
+            { "revenue" : <penneys> }
+    /tickets/logs?tail=<n>
+        Use GET.  Replies with HTTP 200 and the last n lines (default 100,
+        at most 5000) of the log file the server is writing, oldest first:
+            {
+                "lines"          :   [ <log line>, ... ]
+            }
+
+If a request fails, then the reply is sent with an HTTP 4xx or 5xx status,
+and this JSON body:
+            { "error" : <message>, "code" : <stable error code> }
+The codes include ERR_BAD_REQUEST, ERR_FORBIDDEN, ERR_NOT_FOUND (for any
+URL not listed above), ERR_METHOD_NOT_ALLOWED, ERR_TOO_MANY_REQUESTS,
+ERR_INTERNAL, and one per tickets package error (see errorCodes), such as
+ERR_XCH_OUT_OF_GOODS or ERR_SALES_CLOSED.  A sell refused with
+ERR_NO_MORE_TICKETS, with nothing sold, gets HTTP 429, and one refused with
+ERR_BUSY gets HTTP 503.  Every 429 and 503 reply has a Retry-After header,
+with the number of seconds to wait before trying again (-retry-after, plus a
+random part of up to -retry-after-jitter, so that clients don't all come back
+at once).
+
+Tickets and receipts are sent as JSON maps with these keys:
+    Ticket   ticketNum, movie, showing, price, soldOut, goodies, exchanged,
+             xchOld, xchNew, window, void, customerID (from the sell body's
+             paymentInfo "customerID" field, or ""), soldAt (an RFC 3339 time)
+    Receipt  receiptNum, time, window, itemsSold (a list of { desc, penneys }),
+             total, footer (a list of lines; left out when there is no footer)
+Earlier versions sent the capitalized Go field names (TicketNum, ItemsSold,
+...) instead, and the sell reply's "tickets" and "receipt" were sent as
+"Ticks" and "Rcpt".  Clients which match JSON keys case-insensitively (as
+Go's encoding/json does) can still read the Ticket and Receipt keys, but
+must be changed to use "tickets" and "receipt".
 
 See the doc. in tickets.go for application details.
 
@@ -32,16 +163,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/d-m-w/learninggo/logsetup"
 	"github.com/d-m-w/learninggo/tickets"
 	//"tickets"
 )
@@ -66,6 +206,25 @@ const (
 
 var L *log.Logger
 
+// logFileName is the path of the log file which main creates for L, for
+// /tickets/logs.  It is "" if L fell back to stderr (see logsetup.Open).
+var logFileName string
+
+// adminToken must be sent in the X-Admin-Token header to use the admin URLs.
+// If it is empty, then the admin URLs are disabled.
+var adminToken string
+
+// retryAfterBase and retryAfterJitter set the Retry-After header sent with
+// the HTTP 429 and 503 replies (see retryAfterSeconds).
+var (
+	retryAfterBase   = time.Second
+	retryAfterJitter = 2 * time.Second
+)
+
+// sell is the Sell which sellTickets calls.  It is only changed by tests, to
+// sell from a Theatre of their own.
+var sell = tickets.Sell
+
 // main starts and runs the sample tickets server.
 // The size and runtime defaults (see const section, above) can be overridden
 // by cmd.line options:
@@ -74,51 +233,914 @@ var L *log.Logger
 //   -s <MaxSeats>
 //   -h <MaxShowings>
 //   -w <MaxWindows>
+// and the request-handling options:
+//   -per-ip-concurrency <max in-flight requests per client IP, 0 = unlimited>
+//   -trust-xff          (take the client IP from X-Forwarded-For, if present)
+//   -admin-token <token required to use the admin URLs>
+//   -idle-timeout <shut down after this long with no requests, 0 = never>
+//   -compact-interval <how often to compact void and sold-out tickets, 0 = never>
+//   -retry-after <how long 429 and 503 replies ask clients to wait>
+//   -retry-after-jitter <most extra time added at random to -retry-after>
+//
+// SIGINT (e.g. Ctrl-C) or SIGTERM shuts the server down gracefully, as
+// -idle-timeout does, and then closes the ticket system, so that its
+// shutdown hooks (see tickets.RegisterShutdownHook) get to run.
 func main() {
-	logFileName := LogFileBase + time.Now().Format("2006-01-02t15-04-05z-0700")
-	logFile, logErr := os.Create(logFileName)
-	defer logFile.Close()
-	if logErr == nil {
-		//// For now, don't run this.  Depending on user's umask, this might
-		//// actually INCREASE access to the logfile, instead of protecting it.
-		//logErr = logFile.Chmod(0644)
-	}
-	if logErr != nil {
-		// log setup failed, so write this one to the default log, instead
-		log.Fatalf("ticketServer aborting:  Error setting up log file '%s':  %v", logFileName, logErr)
-	}
-	L = log.New(logFile, "ticketServer:  ", log.Ldate|log.Ltime|log.Lshortfile) //? need '*'
-
-	// End common logging init.
+	var closeLog func() error
+	L, logFileName, closeLog = logsetup.Open(LogFileBase, "ticketServer:  ")
+	defer closeLog()
 
 	ipExchanges := flag.Int("c", MaxExchanges, "number of exchanges the cafeteria can make before running out of soda (Must match theatre model)")
 	ipMovies := flag.Int("m", MaxMovies, "number of movies the theatre can show (Must match theatre model)")
 	ipSeats := flag.Int("e", MaxSeats, "number of seats available for each movie showing (Must match theatre model)")
 	ipShowings := flag.Int("h", MaxShowings, "number of times each movie is shown, per day (Must match theatre model)")
 	ipWindows := flag.Int("w", MaxWindows, "number of open ticket windows (Must match theatre model)")
+	ipPerIP := flag.Int("per-ip-concurrency", 0, "maximum number of in-flight requests allowed from one client IP (0 means no limit)")
+	bpTrustXFF := flag.Bool("trust-xff", false, "trust the X-Forwarded-For header to identify the client IP (only if behind a trusted proxy)")
+	spAdminToken := flag.String("admin-token", "", "token which must be sent in the X-Admin-Token header to use the admin URLs (admin URLs are disabled if empty)")
+	dpIdleTimeout := flag.Duration("idle-timeout", 0, "shut the server down gracefully after this long with no requests (0 means never)")
+	dpCompactInterval := flag.Duration("compact-interval", 0, "how often to release the data of void and sold-out tickets (0 means only when /tickets/admin/compact is POSTed)")
+	dpRetryAfter := flag.Duration("retry-after", retryAfterBase, "how long the Retry-After header of HTTP 429 and 503 replies asks clients to wait (rounded up to whole seconds, at least 1)")
+	dpRetryAfterJitter := flag.Duration("retry-after-jitter", retryAfterJitter, "most extra time to add at random to -retry-after, so that clients don't all retry at once")
 
 	flag.Parse()
+	adminToken = *spAdminToken
 
+	// Report every startup problem together, rather than one per run.
+	var problems []error
 	if err := tickets.Init(L, *ipExchanges, *ipMovies, *ipShowings, *ipSeats, *ipWindows); err != nil {
-		L.Fatalf("Startup failed:  ticket system initialization failed:  %v\n", err)
+		problems = append(problems, fmt.Errorf("ticket system initialization failed:\n%w", err))
+	}
+	if *ipPerIP < 0 {
+		problems = append(problems, errors.New("-per-ip-concurrency must not be negative"))
+	}
+	if *dpIdleTimeout < 0 {
+		problems = append(problems, errors.New("-idle-timeout must not be negative"))
+	}
+	if *dpCompactInterval < 0 {
+		problems = append(problems, errors.New("-compact-interval must not be negative"))
 	}
+	if *dpRetryAfter < 0 {
+		problems = append(problems, errors.New("-retry-after must not be negative"))
+	}
+	if *dpRetryAfterJitter < 0 {
+		problems = append(problems, errors.New("-retry-after-jitter must not be negative"))
+	}
+	if err := errors.Join(problems...); err != nil {
+		L.Fatalf("Startup failed:\n%v\n", err)
+	}
+	tickets.SetCompactInterval(*dpCompactInterval)
+	retryAfterBase, retryAfterJitter = *dpRetryAfter, *dpRetryAfterJitter
 
-	http.HandleFunc("/tickets/sell/", sellTickets)
-	http.HandleFunc("/tickets/exchange/", handleExchange)
-	L.Fatal(http.ListenAndServe("localhost:"+ServerPort, nil))
+	registerHandlers(http.DefaultServeMux)
+
+	var handler http.Handler = http.DefaultServeMux
+	if *ipPerIP > 0 {
+		handler = newIPLimiter(*ipPerIP, *bpTrustXFF).limit(handler)
+	}
+	ln, err := net.Listen("tcp", "localhost:"+ServerPort)
+	if err != nil {
+		L.Fatalf("Startup failed:  %v\n", err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	if err := run(&http.Server{Handler: handler}, ln, *dpIdleTimeout, stop, tickets.Close); err != nil {
+		L.Fatal(err)
+	}
+	L.Printf("ticketServer shut down.\n")
 } // main
 
+// run serves srv on ln (see serve) until it is shut down, and then closes
+// the ticket system with closeTheatre, which runs its shutdown hooks.  If srv
+// fails instead, the ticket system is left as it is.
+//
+// Returns the error which stopped srv, or nil after a graceful shutdown.
+func run(srv *http.Server, ln net.Listener, idleTimeout time.Duration, stop <-chan os.Signal, closeTheatre func() error) error {
+	if err := serve(srv, ln, idleTimeout, stop); err != nil {
+		return err
+	}
+	if err := closeTheatre(); err != nil {
+		L.Printf("Shutdown hooks failed:\n%v\n", err)
+	}
+	return nil
+} // run
+
+// registerHandlers sets up all of the server's URLs on mux.
+func registerHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/tickets/sell/", sellTickets)
+	mux.HandleFunc("/tickets/exchange/", handleExchange)
+	mux.HandleFunc("/tickets/config", handleConfig)
+	mux.HandleFunc("/tickets/showing/", handleShowing)
+	mux.HandleFunc("/tickets/receipt-by-num/", handleReceiptByNum)
+	mux.HandleFunc("/tickets/next/", handleNext)
+	mux.HandleFunc("/tickets/admin/selfcheck", adminOnly(handleSelfCheck))
+	mux.HandleFunc("/tickets/admin/blackout/", adminOnly(handleBlackout))
+	mux.HandleFunc("/tickets/admin/readonly", adminOnly(handleReadOnly))
+	mux.HandleFunc("/tickets/admin/compact", adminOnly(handleCompact))
+	mux.HandleFunc("/tickets/admin/loadtest", adminOnly(handleLoadTest))
+	mux.HandleFunc("/tickets/revenue", adminOnly(handleRevenue))
+	mux.HandleFunc("/tickets/logs", adminOnly(handleLogs))
+	// Longer patterns win in a ServeMux, so this only gets what nothing
+	// above matches.
+	mux.HandleFunc("/", handleUnknown)
+} // registerHandlers
+
+// serve runs srv on ln until it fails, or until a signal arrives on stop, or
+// it has gone idleTimeout with no requests, at which point it is shut down
+// gracefully (requests already in flight are allowed to finish).  An
+// idleTimeout of 0 means never shut down for being idle.
+//
+// Returns nil after a graceful shutdown, or the error which stopped srv.
+func serve(srv *http.Server, ln net.Listener, idleTimeout time.Duration, stop <-chan os.Signal) error {
+	shutDown := make(chan error, 1)
+	var once sync.Once
+	shutdown := func(why string) {
+		once.Do(func() {
+			L.Printf("%s:  shutting down\n", why)
+			shutDown <- srv.Shutdown(context.Background())
+		})
+	}
+	if idleTimeout > 0 {
+		idle := newIdleTimer(idleTimeout, func() { shutdown(fmt.Sprintf("No requests for %v", idleTimeout)) })
+		srv.Handler = idle.track(srv.Handler)
+	}
+	served := make(chan struct{})
+	defer close(served)
+	go func() {
+		select {
+		case sig := <-stop:
+			shutdown(fmt.Sprintf("Got %v", sig))
+		case <-served:
+		}
+	}()
+
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return <-shutDown
+} // serve
+
+// idleTimer calls fire once no request has been handled for timeout.  The
+// timer is stopped while any request is in flight, and restarted when the
+// last one finishes, so a long request never counts as idle time.
+type idleTimer struct {
+	timeout  time.Duration
+	mutex    sync.Mutex  // protects inFlight and timer
+	inFlight int         // requests currently being handled
+	timer    *time.Timer // runs fire when it expires
+} // idleTimer
+
+// newIdleTimer creates an idleTimer, and starts it running, so that fire is
+// called if no request at all arrives within timeout.
+func newIdleTimer(timeout time.Duration, fire func()) *idleTimer {
+	return &idleTimer{timeout: timeout, timer: time.AfterFunc(timeout, fire)}
+} // newIdleTimer
+
+// track wraps next so that each request it handles holds off the idle
+// timer.
+func (it *idleTimer) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		it.begin()
+		defer it.end()
+		next.ServeHTTP(w, rqst)
+	})
+} // track
+
+// begin stops the timer for a request which is starting.
+func (it *idleTimer) begin() {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	it.inFlight++
+	it.timer.Stop()
+} // begin
+
+// end restarts the timer when the last request in flight finishes.
+func (it *idleTimer) end() {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	if it.inFlight--; it.inFlight == 0 {
+		it.timer.Reset(it.timeout)
+	}
+} // end
+
+// ipLimiter limits the number of requests which may be in flight at the same
+// time from any one client IP.  It is a counting semaphore per IP, and the
+// count for an IP is removed from the map once its last request completes,
+// so the map only ever holds the clients which are currently active.
+type ipLimiter struct {
+	max      int            // in-flight requests allowed per IP
+	trustXFF bool           // take the client IP from X-Forwarded-For?
+	mutex    sync.Mutex     // protects inFlight
+	inFlight map[string]int // client IP -> requests currently being handled
+} // ipLimiter
+
+// newIPLimiter creates an ipLimiter allowing max concurrent requests per
+// client IP.  If trustXFF is set, then the X-Forwarded-For header (when
+// present) is used to identify the client, instead of the connection's
+// remote address.  Only set it if the server is behind a trusted proxy,
+// because otherwise any client can claim to be anybody.
+func newIPLimiter(max int, trustXFF bool) *ipLimiter {
+	return &ipLimiter{max: max, trustXFF: trustXFF, inFlight: make(map[string]int)}
+} // newIPLimiter
+
+// limit wraps next with the per-IP semaphore.  A request which would exceed
+// the client's limit is rejected with HTTP 429, without calling next.  The
+// client's slot is released when next returns.
+func (lim *ipLimiter) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		ip := clientIP(rqst, lim.trustXFF)
+		if !lim.acquire(ip) {
+			L.Printf("Request '%s' from %s rejected:  more than %d requests in flight\n", rqst.URL.Path, ip, lim.max)
+			writeJSONError(w, http.StatusTooManyRequests, "ERR_TOO_MANY_REQUESTS", "too many concurrent requests from this client")
+			return
+		}
+		defer lim.release(ip)
+		next.ServeHTTP(w, rqst)
+	})
+} // limit
+
+// acquire takes one of ip's slots, if one is free.  Returns false if ip is
+// already at its limit.
+func (lim *ipLimiter) acquire(ip string) bool {
+	lim.mutex.Lock()
+	defer lim.mutex.Unlock()
+	if lim.inFlight[ip] >= lim.max {
+		return false
+	}
+	lim.inFlight[ip]++
+	return true
+} // acquire
+
+// release gives back one of ip's slots.
+func (lim *ipLimiter) release(ip string) {
+	lim.mutex.Lock()
+	defer lim.mutex.Unlock()
+	if lim.inFlight[ip]--; lim.inFlight[ip] <= 0 {
+		delete(lim.inFlight, ip)
+	}
+} // release
+
+// clientIP works out which client IP a request came from.  If trustXFF is
+// set and the request has an X-Forwarded-For header, then the first (i.e.
+// the originating client) address in it is used.  Otherwise, the host part
+// of the connection's RemoteAddr is used (or all of it, if it has no port).
+func clientIP(rqst *http.Request, trustXFF bool) string {
+	if trustXFF {
+		if xff := rqst.Header.Get("X-Forwarded-For"); xff != "" {
+			if ip := strings.TrimSpace(strings.Split(xff, ",")[0]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(rqst.RemoteAddr)
+	if err != nil {
+		return rqst.RemoteAddr
+	}
+	return host
+} // clientIP
+
+// adminOnly wraps an admin URL's handler so that it can only be used if the
+// request's X-Admin-Token header matches adminToken.  If adminToken is empty,
+// the admin URLs are disabled.  Returns HTTP 403 if the request is refused.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, rqst *http.Request) {
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(rqst.Header.Get("X-Admin-Token")), []byte(adminToken)) != 1 {
+			L.Printf("Request '%s' from %s refused:  missing or wrong admin token\n", rqst.URL.Path, rqst.RemoteAddr)
+			writeJSONError(w, http.StatusForbidden, "ERR_FORBIDDEN", "admin access denied")
+			return
+		}
+		next(w, rqst)
+	}
+} // adminOnly
+
+// handleUnknown answers requests for URLs the server doesn't have, with HTTP
+// 404 and the code ERR_NOT_FOUND.  It logs each one, since they usually mean
+// a client bug.
+func handleUnknown(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("Request '%s' from %s failed:  unknown URL path\n", rqst.URL.Path, rqst.RemoteAddr)
+	writeJSONError(w, http.StatusNotFound, "ERR_NOT_FOUND", "no such URL:  "+rqst.URL.Path)
+} // handleUnknown
+
+// handleSelfCheck runs the ticketing system's SelfCheck, and sends back the
+// problems it found (if any) as JSON, with HTTP 200.  Access the URL with
+// HTTP GET.
+func handleSelfCheck(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("handleSelfCheck called for %v\n", rqst.URL)
+
+	var responseData struct {
+		Problems []string `json:"problems"`
+	}
+	responseData.Problems = make([]string, 0)
+	for _, p := range tickets.SelfCheck() {
+		responseData.Problems = append(responseData.Problems, p.Error())
+	}
+	if len(responseData.Problems) > 0 {
+		L.Printf("handleSelfCheck found %d problems:\n%s\n", len(responseData.Problems), strings.Join(responseData.Problems, "\n"))
+	}
+
+	writeJSON(w, rqst, responseData)
+	return
+} // handleSelfCheck
+
+// handleBlackout blacks a showing out, or clears its blackout (see
+// tickets.Blackout).  The URL format is:
+//     /tickets/admin/blackout/<movie#>/<showing#>?on=<true|false>
+// Access the URL with HTTP POST.
+//
+// Returns HTTP 204 on success, HTTP 405 if not POSTed, or HTTP 400 if the
+// movie, showing or on flag is invalid.
+func handleBlackout(w http.ResponseWriter, rqst *http.Request) {
+	const (
+		PPMovie   = 4 // where's the movie# in the URL.Path?
+		PPShowing = 5 // where's the showing# in the URL.Path?
+	)
+
+	L.Printf("handleBlackout called for %v\n", rqst.URL)
+
+	if rqst.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "ERR_METHOD_NOT_ALLOWED", "use POST")
+		return
+	}
+	pathParts := strings.Split(rqst.URL.Path, "/")
+	if len(pathParts) <= PPShowing {
+		L.Printf("Request '%s' failed:  expected /tickets/admin/blackout/<movie#>/<showing#>\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "expected /tickets/admin/blackout/<movie#>/<showing#>")
+		return
+	}
+	movie, err := strconv.Atoi(pathParts[PPMovie])
+	if err != nil {
+		L.Printf("Request '%s' failed:  movie number invalid\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "movie number invalid")
+		return
+	}
+	showing, err := strconv.Atoi(pathParts[PPShowing])
+	if err != nil {
+		L.Printf("Request '%s' failed:  showing number invalid\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "showing number invalid")
+		return
+	}
+	on, err := strconv.ParseBool(rqst.URL.Query().Get("on"))
+	if err != nil {
+		L.Printf("Request '%s' failed:  on invalid:  %v\n", rqst.URL, err)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "on must be true or false")
+		return
+	}
+
+	if err := tickets.Blackout(movie, showing, on); err != nil {
+		L.Printf("Request '%s' failed:  error from tickets.Blackout:  %v\n", rqst.URL.Path, err)
+		writeTicketsError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+} // handleBlackout
+
+// handleReadOnly turns the ticketing system's read-only mode on or off (see
+// tickets.SetReadOnly).  The URL format is:
+//     /tickets/admin/readonly?on=<true|false>
+// Access the URL with HTTP POST.
+//
+// Returns HTTP 204 on success, HTTP 405 if not POSTed, or HTTP 400 if the on
+// flag is invalid.
+func handleReadOnly(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("handleReadOnly called for %v\n", rqst.URL)
+
+	if rqst.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "ERR_METHOD_NOT_ALLOWED", "use POST")
+		return
+	}
+	on, err := strconv.ParseBool(rqst.URL.Query().Get("on"))
+	if err != nil {
+		L.Printf("Request '%s' failed:  on invalid:  %v\n", rqst.URL, err)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "on must be true or false")
+		return
+	}
+
+	tickets.SetReadOnly(on)
+	w.WriteHeader(http.StatusNoContent)
+	return
+} // handleReadOnly
+
+// handleCompact runs tickets.Compact, and sends back how many tickets it
+// released, as JSON, with HTTP 200.  Access the URL with HTTP POST.
+//
+// Returns HTTP 405 if not POSTed.
+func handleCompact(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("handleCompact called for %v\n", rqst.URL)
+
+	if rqst.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "ERR_METHOD_NOT_ALLOWED", "use POST")
+		return
+	}
+
+	var responseData struct {
+		Compacted int `json:"compacted"`
+	}
+	responseData.Compacted = tickets.Compact()
+	writeJSON(w, rqst, responseData)
+	return
+} // handleCompact
+
+// handleRevenue runs tickets.Revenue, with a filter made from the URL's
+// movie, showing, window, from and to parameters, and sends back the revenue
+// as JSON, with HTTP 200.  Access the URL with HTTP GET.
+//
+// Returns HTTP 405 if not a GET, or HTTP 400 if a parameter is invalid.
+func handleRevenue(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("handleRevenue called for %v\n", rqst.URL)
+
+	if rqst.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "ERR_METHOD_NOT_ALLOWED", "use GET")
+		return
+	}
+
+	query := rqst.URL.Query()
+	var filter tickets.RevenueFilter
+	for _, p := range []struct {
+		name  string
+		field **int
+	}{{"movie", &filter.Movie}, {"showing", &filter.Showing}, {"window", &filter.Window}} {
+		if v := query.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				L.Printf("Request '%s' failed:  %s invalid\n", rqst.URL, p.name)
+				writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", fmt.Sprintf("%s must be a number", p.name))
+				return
+			}
+			*p.field = &n
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		field *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := query.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				L.Printf("Request '%s' failed:  %s invalid\n", rqst.URL, p.name)
+				writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", fmt.Sprintf("%s must be an RFC 3339 time", p.name))
+				return
+			}
+			*p.field = t
+		}
+	}
+
+	var responseData struct {
+		Revenue int `json:"revenue"`
+	}
+	responseData.Revenue = tickets.Revenue(filter)
+	writeJSON(w, rqst, responseData)
+	return
+} // handleRevenue
+
+// Limits on /tickets/admin/loadtest, so that a typo can't tie the server up.
+const (
+	maxLoadConcurrency = 1000
+	maxLoadDuration    = time.Minute
+)
+
+// handleLoadTest runs tickets.SelfLoadTest, and sends back its LoadResult as
+// JSON.  The URL format is:
+//     /tickets/admin/loadtest?concurrency=<n>&duration=<time.Duration>
+// Access the URL with HTTP POST.  The reply is not sent until the load test
+// has finished.
+//
+// Returns HTTP 200 and the LoadResult, HTTP 405 if not POSTed, or HTTP 400 if
+// concurrency or duration is invalid or beyond its limit.
+func handleLoadTest(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("handleLoadTest called for %v\n", rqst.URL)
+
+	if rqst.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "ERR_METHOD_NOT_ALLOWED", "use POST")
+		return
+	}
+	concurrency, err := strconv.Atoi(rqst.URL.Query().Get("concurrency"))
+	if err != nil || concurrency < 1 || concurrency > maxLoadConcurrency {
+		L.Printf("Request '%s' failed:  concurrency invalid\n", rqst.URL)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", fmt.Sprintf("concurrency must be 1 to %d", maxLoadConcurrency))
+		return
+	}
+	duration, err := time.ParseDuration(rqst.URL.Query().Get("duration"))
+	if err != nil || duration < 1 || duration > maxLoadDuration {
+		L.Printf("Request '%s' failed:  duration invalid\n", rqst.URL)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", fmt.Sprintf("duration must be a time.Duration, more than 0 and at most %v", maxLoadDuration))
+		return
+	}
+
+	result := tickets.SelfLoadTest(concurrency, duration)
+	if len(result.Violations) > 0 {
+		L.Printf("handleLoadTest found %d violations:\n%s\n", len(result.Violations), strings.Join(result.Violations, "\n"))
+	}
+	writeJSON(w, rqst, result)
+	return
+} // handleLoadTest
+
+// Limits on /tickets/logs.
+const (
+	defaultLogTail = 100
+	maxLogTail     = 5000
+)
+
+// handleLogs sends back the last lines of the server's log file, as JSON.
+// The URL format is:
+//     /tickets/logs?tail=<n>
+// Access the URL with HTTP GET.  tail defaults to defaultLogTail.
+//
+// Returns HTTP 200 and the lines (oldest first), HTTP 405 if not a GET, HTTP
+// 400 if tail is not 1 to maxLogTail, or HTTP 500 if the log file can't be
+// read.
+func handleLogs(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("handleLogs called for %v\n", rqst.URL)
+
+	if rqst.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "ERR_METHOD_NOT_ALLOWED", "use GET")
+		return
+	}
+	tail := defaultLogTail
+	if t := rqst.URL.Query().Get("tail"); t != "" {
+		var err error
+		if tail, err = strconv.Atoi(t); err != nil || tail < 1 || tail > maxLogTail {
+			L.Printf("Request '%s' failed:  tail invalid\n", rqst.URL)
+			writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", fmt.Sprintf("tail must be 1 to %d", maxLogTail))
+			return
+		}
+	}
+
+	lines, err := tailLines(logFileName, tail)
+	if err != nil {
+		L.Printf("Request '%s' failed:  %v\n", rqst.URL, err)
+		writeJSONError(w, http.StatusInternalServerError, "ERR_INTERNAL", "cannot read the log file")
+		return
+	}
+	writeJSON(w, rqst, struct {
+		Lines []string `json:"lines"`
+	}{lines})
+	return
+} // handleLogs
+
+// tailLines returns the last n lines of the file named fileName, oldest
+// first, without their newlines.  The file is opened separately, and read
+// backwards from the end a block at a time, so a big log isn't read in full,
+// and L can keep writing to it meanwhile.  If the last line has only been
+// partly written, then what there is of it is returned.
+func tailLines(fileName string, n int) ([]string, error) {
+	const blockSize = 8192
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("tailLines failed:  %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("tailLines failed:  %v", err)
+	}
+
+	// Read blocks from the end until there are more than n newlines (the one
+	// ending the line before the first wanted), or the start is reached.
+	end := info.Size()
+	start := end
+	var data []byte
+	for start > 0 && bytes.Count(data, []byte("\n")) <= n {
+		size := int64(blockSize)
+		if size > start {
+			size = start
+		}
+		start -= size
+		block := make([]byte, size)
+		if _, err := f.ReadAt(block, start); err != nil {
+			return nil, fmt.Errorf("tailLines failed:  %v", err)
+		}
+		data = append(block, data...)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+} // tailLines
+
+// handleConfig sends back the size of the theatre (see tickets.Dimensions
+// and tickets.Windows), as JSON, with HTTP 200.  Access the URL with HTTP
+// GET.
+func handleConfig(w http.ResponseWriter, rqst *http.Request) {
+	L.Printf("handleConfig called for %v\n", rqst.URL)
+
+	var responseData struct {
+		Movies   int `json:"movies"`
+		Showings int `json:"showings"`
+		Windows  int `json:"windows"`
+	}
+	responseData.Movies, responseData.Showings = tickets.Dimensions()
+	responseData.Windows = tickets.Windows()
+	writeJSON(w, rqst, responseData)
+	return
+} // handleConfig
+
+// handleShowing sends back all of the tickets sold for one showing of one
+// movie (see tickets.TicketsForShowing), and its sales by channel (see
+// tickets.ChannelSales), as JSON.  The URL format is:
+//     /tickets/showing/<movie#>/<showing#>
+// Access the URL with HTTP GET.
+//
+// Returns HTTP 400 if the movie or showing is invalid, or HTTP 200 and the
+// tickets.
+//
+// A URL ending in /start is an admin URL instead, handled by
+// handleShowingStart.
+func handleShowing(w http.ResponseWriter, rqst *http.Request) {
+	const (
+		PPMovie   = 3 // where's the movie# in the URL.Path?
+		PPShowing = 4 // where's the showing# in the URL.Path?
+	)
+
+	if strings.HasSuffix(rqst.URL.Path, "/start") {
+		adminOnly(handleShowingStart)(w, rqst)
+		return
+	}
+
+	L.Printf("handleShowing called for %v\n", rqst.URL)
+
+	pathParts := strings.Split(rqst.URL.Path, "/")
+	if len(pathParts) <= PPShowing {
+		L.Printf("Request '%s' failed:  expected /tickets/showing/<movie#>/<showing#>\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "expected /tickets/showing/<movie#>/<showing#>")
+		return
+	}
+	movies, showings := tickets.Dimensions()
+	movie, err := strconv.Atoi(pathParts[PPMovie])
+	if err != nil || movie < 0 || movie >= movies {
+		L.Printf("Request '%s' failed:  movie number invalid\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "movie number invalid")
+		return
+	}
+	showing, err := strconv.Atoi(pathParts[PPShowing])
+	if err != nil || showing < 0 || showing >= showings {
+		L.Printf("Request '%s' failed:  showing number invalid\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "showing number invalid")
+		return
+	}
+
+	var responseData struct {
+		// All fields must be exported (capitalized), to be visible to json.
+		Ticks      []tickets.Ticket `json:"tickets"`
+		WindowSold int              `json:"windowSold"`
+		OnlineSold int              `json:"onlineSold"`
+	}
+	responseData.Ticks = tickets.TicketsForShowing(movie, showing)
+	responseData.WindowSold, responseData.OnlineSold = tickets.ChannelSales(movie, showing)
+	writeJSON(w, rqst, responseData)
+	return
+} // handleShowing
+
+// handleShowingStart marks a showing as having started now (see
+// tickets.StartShowing).  The URL format is:
+//     /tickets/showing/<movie#>/<showing#>/start
+// Access the URL with HTTP POST.
+//
+// Returns HTTP 204 on success, HTTP 405 if not POSTed, or HTTP 400 if the
+// movie or showing is invalid.
+func handleShowingStart(w http.ResponseWriter, rqst *http.Request) {
+	const (
+		PPMovie   = 3 // where's the movie# in the URL.Path?
+		PPShowing = 4 // where's the showing# in the URL.Path?
+	)
+
+	L.Printf("handleShowingStart called for %v\n", rqst.URL)
+
+	if rqst.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "ERR_METHOD_NOT_ALLOWED", "use POST")
+		return
+	}
+	pathParts := strings.Split(rqst.URL.Path, "/")
+	if len(pathParts) != PPShowing+2 {
+		L.Printf("Request '%s' failed:  expected /tickets/showing/<movie#>/<showing#>/start\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "expected /tickets/showing/<movie#>/<showing#>/start")
+		return
+	}
+	movie, err := strconv.Atoi(pathParts[PPMovie])
+	if err != nil {
+		L.Printf("Request '%s' failed:  movie number invalid\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "movie number invalid")
+		return
+	}
+	showing, err := strconv.Atoi(pathParts[PPShowing])
+	if err != nil {
+		L.Printf("Request '%s' failed:  showing number invalid\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "showing number invalid")
+		return
+	}
+
+	if err := tickets.StartShowing(movie, showing); err != nil {
+		L.Printf("Request '%s' failed:  error from tickets.StartShowing:  %v\n", rqst.URL.Path, err)
+		writeTicketsError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+} // handleShowingStart
+
+// handleNext sends back the next available showing of one movie (see
+// tickets.NextAvailableShowing), as JSON.  The URL format is:
+//     /tickets/next/<movie#>
+// Access the URL with HTTP GET.
+//
+// Returns HTTP 400 if the movie is invalid, or HTTP 200 and the showing
+// (found false if no showing of the movie is available).
+func handleNext(w http.ResponseWriter, rqst *http.Request) {
+	const (
+		PPMovie = 3 // where's the movie# in the URL.Path?
+	)
+
+	L.Printf("handleNext called for %v\n", rqst.URL)
+
+	pathParts := strings.Split(rqst.URL.Path, "/")
+	if len(pathParts) <= PPMovie {
+		L.Printf("Request '%s' failed:  expected /tickets/next/<movie#>\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "expected /tickets/next/<movie#>")
+		return
+	}
+	movies, _ := tickets.Dimensions()
+	movie, err := strconv.Atoi(pathParts[PPMovie])
+	if err != nil || movie < 0 || movie >= movies {
+		L.Printf("Request '%s' failed:  movie number invalid\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "movie number invalid")
+		return
+	}
+
+	var responseData struct {
+		Showing int  `json:"showing"`
+		Found   bool `json:"found"`
+	}
+	responseData.Showing, responseData.Found = tickets.NextAvailableShowing(movie)
+	writeJSON(w, rqst, responseData)
+	return
+} // handleNext
+
+// handleReceiptByNum sends back one receipt (see tickets.ReceiptByNum), as
+// JSON.  The URL format is:
+//     /tickets/receipt-by-num/<receipt#>
+// Access the URL with HTTP GET.
+//
+// Returns HTTP 400 if the receipt number is invalid, HTTP 404 if there is no
+// such receipt, or HTTP 200 and the receipt.
+func handleReceiptByNum(w http.ResponseWriter, rqst *http.Request) {
+	const (
+		PPReceiptNum = 3 // where's the receipt# in the URL.Path?
+	)
+
+	L.Printf("handleReceiptByNum called for %v\n", rqst.URL)
+
+	pathParts := strings.Split(rqst.URL.Path, "/")
+	if len(pathParts) <= PPReceiptNum {
+		L.Printf("Request '%s' failed:  expected /tickets/receipt-by-num/<receipt#>\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "expected /tickets/receipt-by-num/<receipt#>")
+		return
+	}
+	receiptNum, err := strconv.Atoi(pathParts[PPReceiptNum])
+	if err != nil {
+		L.Printf("Request '%s' failed:  receipt number invalid:  %v\n", rqst.URL.Path, err)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "receipt number invalid")
+		return
+	}
+
+	rcpt, err := tickets.ReceiptByNum(receiptNum)
+	if err != nil {
+		L.Printf("Request '%s' failed:  error from tickets.ReceiptByNum:  %v\n", rqst.URL.Path, err)
+		writeTicketsError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, rqst, rcpt)
+	return
+} // handleReceiptByNum
+
+// writeJSON sends responseData back as JSON, with HTTP 200.  If it can't be
+// converted to JSON, then HTTP 500 is sent instead.
+func writeJSON(w http.ResponseWriter, rqst *http.Request, responseData interface{}) {
+	jbuffer, err := json.Marshal(responseData)
+	if err != nil {
+		L.Printf("Request '%s' failed:  error marshalling response data to JSON:  %v\n", rqst.URL.Path, err)
+		writeJSONError(w, http.StatusInternalServerError, "ERR_INTERNAL", "error marshalling response data to JSON")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jbuffer)
+} // writeJSON
+
+// errorCodes maps the tickets package's sentinel errors to the stable codes
+// sent in error responses.  Clients should test the code, not the message.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{tickets.ErrXchNotEntitled, "ERR_XCH_NOT_ENTITLED"},
+	{tickets.ErrXchAlreadyDone, "ERR_XCH_ALREADY_DONE"},
+	{tickets.ErrXchOutOfGoods, "ERR_XCH_OUT_OF_GOODS"},
+	{tickets.ErrXchRationed, "ERR_XCH_RATIONED"},
+	{tickets.ErrXchNotDone, "ERR_XCH_NOT_DONE"},
+	{tickets.ErrXchNotOnMenu, "ERR_XCH_NOT_ON_MENU"},
+	{tickets.ErrTicketVoid, "ERR_TICKET_VOID"},
+	{tickets.ErrNothingToVoid, "ERR_NOTHING_TO_VOID"},
+	{tickets.ErrSalesNotOpenYet, "ERR_SALES_NOT_OPEN_YET"},
+	{tickets.ErrSalesClosed, "ERR_SALES_CLOSED"},
+	{tickets.ErrBlackout, "ERR_BLACKOUT"},
+	{tickets.ErrPaymentLimit, "ERR_PAYMENT_LIMIT"},
+	{tickets.ErrCustomerLimit, "ERR_CUSTOMER_LIMIT"},
+	{tickets.ErrNoSuchReceipt, "ERR_NO_SUCH_RECEIPT"},
+	{tickets.ErrReadOnly, "ERR_READ_ONLY"},
+	{tickets.ErrBusy, "ERR_BUSY"},
+	{tickets.ErrNoRequests, "ERR_NO_REQUESTS"},
+	{tickets.ErrChannelSoldOut, "ERR_CHANNEL_SOLD_OUT"},
+	{tickets.ErrNoMoreTickets, "ERR_NO_MORE_TICKETS"},
+	{tickets.ErrShowingStarted, "ERR_SHOWING_STARTED"},
+	{tickets.ErrPaymentTypeNotAccepted, "ERR_PAYMENT_TYPE_NOT_ACCEPTED"},
+}
+
+// writeJSONError sends an error response with the given HTTP status, as
+//     { "error" : <message>, "code" : <code> }
+// An HTTP 429 or 503 response also gets a Retry-After header (see
+// retryAfterSeconds), so that clients back off instead of retrying at once.
+func writeJSONError(w http.ResponseWriter, status int, code string, message string) {
+	jbuffer, err := json.Marshal(struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{message, code})
+	if err != nil {
+		// Can't happen with two strings, but don't send back nothing.
+		jbuffer = []byte(`{"error":"error marshalling error response to JSON","code":"ERR_INTERNAL"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds()))
+	}
+	w.WriteHeader(status)
+	w.Write(jbuffer)
+	w.Write([]byte("\n"))
+} // writeJSONError
+
+// retryAfterSeconds picks the Retry-After for one reply:  retryAfterBase,
+// plus a random part of up to retryAfterJitter, rounded up to whole seconds
+// (and at least 1, since 0 would mean to retry at once).
+func retryAfterSeconds() int {
+	d := retryAfterBase
+	if retryAfterJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(retryAfterJitter) + 1))
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+} // retryAfterSeconds
+
+// sellStatus returns the HTTP status for a sell refused by tickets.Sell with
+// err:  429 if the ticket DB is full, 503 if the ticketing system is too busy
+// (both worth retrying later, see retryAfterSeconds), or 400.
+func sellStatus(err error) int {
+	switch {
+	case errors.Is(err, tickets.ErrNoMoreTickets):
+		return http.StatusTooManyRequests
+	case errors.Is(err, tickets.ErrBusy):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+} // sellStatus
+
+// writeTicketsError sends err, from the tickets package, as an error
+// response with the given HTTP status.  Its code comes from errorCodes, or
+// is ERR_BAD_REQUEST if it isn't one of the sentinel errors.
+func writeTicketsError(w http.ResponseWriter, status int, err error) {
+	writeJSONError(w, status, errorCode(err), err.Error())
+} // writeTicketsError
+
+// errorCode returns the code for err, from the tickets package, from
+// errorCodes, or ERR_BAD_REQUEST if it isn't one of the sentinel errors.
+func errorCode(err error) string {
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code
+		}
+	}
+	return "ERR_BAD_REQUEST"
+} // errorCode
+
 // handleExchange is an adapter between the http Handler protocol and the
-// ticketing system's Exchange function.  The URL format is:
+// ticketing system's ExchangeWithReceipt function.  The URL format is:
 //     /tickets/exchange/<ticket_number>/<old_goodie>/<new_goodie>
-// and there is no additional response body.  Access the URL with HTTP GET.
+// and there is no additional request body.  Access the URL with HTTP GET.
 //
 // If there are no errors in the request and the specified ticket allows the
 // exchange, then the specified item is reported as exchanged for the specified
 // replacement, in the ticketing system.
 //
-// Returns HTTP 400 on errors and HTTP 204 on success.
-// No payload is ever sent back.
+// Returns HTTP 400 on errors, and HTTP 204 on success.  If the upgrade menu
+// puts a price on the exchange, then HTTP 200 and its receipt are sent back
+// instead of the 204.
 func handleExchange(w http.ResponseWriter, rqst *http.Request) {
 	const (
 		PPTickNum   = 3 // where's the ticket number in the URL.Path?
@@ -129,23 +1151,34 @@ func handleExchange(w http.ResponseWriter, rqst *http.Request) {
 	L.Printf("handleExchange called for %v\n", rqst.URL)
 
 	pathParts := strings.Split(rqst.URL.Path, "/")
+	if len(pathParts) <= PPNewGoodie {
+		L.Printf("Request '%s' failed:  expected /tickets/exchange/<ticket#>/<old_goodie>/<new_goodie>\n", rqst.URL.Path)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "expected /tickets/exchange/<ticket#>/<old_goodie>/<new_goodie>")
+		return
+	}
 	tickNum, err := strconv.Atoi(pathParts[PPTickNum])
 	if err != nil {
 		L.Printf("Request '%s' failed:  ticket number invalid:  %v\n", rqst.URL.Path, err)
-		http.Error(w, "ticket number invalid", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "ticket number invalid")
 		return
 	}
 
-	err = tickets.Exchange(tickNum, pathParts[PPOldGoodie], pathParts[PPNewGoodie])
+	rcpt, err := tickets.ExchangeWithReceipt(tickNum, pathParts[PPOldGoodie], pathParts[PPNewGoodie])
 	if err != nil {
 		L.Printf("Request '%s' failed:  error from tickets.Exchange:  %v\n", rqst.URL.Path, err)
-		http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		writeTicketsError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// I don't think calling "Error" is really the right way to report success,
-	// but I can't find any other way to send back a 204 ...
-	http.Error(w, "exchanged", http.StatusNoContent)
+	if rcpt.Total == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var responseData struct {
+		Rcpt tickets.Receipt `json:"receipt"`
+	}
+	responseData.Rcpt = rcpt
+	writeJSON(w, rqst, responseData)
 	return
 } // handleExchange
 
@@ -153,76 +1186,99 @@ func handleExchange(w http.ResponseWriter, rqst *http.Request) {
 // ticketing system's Sell function.
 //
 // URLs are accessed with HTTP POST.  URL format
-//   /tickets/sell/<window_number>
+//   /tickets/sell/<window_number>[?omit_soldout=true]
 //
 // JSON data format:
 //   {
 //     "TicketRequests" : [ [<movie#>, <showing#>], [<movie#>, <showing#>], ... ],
-//     "PaymentInfo"    : { <any number of fields with any contents> },
-//     "LocalTime"      : <anything>
+//     "PaymentInfo"    : { "paymentType" : ..., "cardFingerprint" : ..., "customerID" : ..., <others ignored> },
+//     "LocalTime"      : <the client's time, in RFC 3339 format;  see Sell>
 //   }
 //
 // One possible GO data format:
 //   var requestData struct {
 //      // Use the same case for the variable names as the JSON map keys.
 //      TicketRequests [][2]int               // { movie #, showing # }
-//      PaymentInfo    map[string]interface{} // not currently implemented
-//      LocalTime      interface{}            // not currently implemented
+//      PaymentInfo    map[string]interface{} // payment type, payer and customer IDs
+//      LocalTime      interface{}            // receipt time, and showing starts
 //   }
 //
 // If there are no errors, then the Sell function's response converted to JSON
-// format and returned, with an HTTP 200 status code.
+// format and returned, with an HTTP 200 status code.  If omit_soldout is true,
+// then the response is reshaped by tickets.SplitSoldOut (see sellResponse).
 //
-// If an error occurs, then HTTP 400 or 500 is returned.
+// If an error occurs, then HTTP 400 or 500 is returned, or 429 or 503 (with a
+// Retry-After header) if the sell may succeed later (see sellStatus).  But if
+// Sell sold some of the tickets despite the error (ErrNoMoreTickets, when the
+// ticket DB fills part way through, or ErrChannelSoldOut, which only refuses
+// the requests its channel can't fill), then they have been charged for, so
+// the reply is HTTP 200 as usual, with the error and its code added (see
+// sellResponse).
 func sellTickets(w http.ResponseWriter, rqst *http.Request) {
 	const (
 		PPWindow = 3 // where's the Window# in the URL.Path?
 	)
 	var requestData struct {
 		// Use the same case for the variable names as the JSON map keys.
-		TicketRequests [][2]int               // { movie #, showing # }
-		PaymentInfo    map[string]interface{} // not currently implemented
-		LocalTime      interface{}            // not currently implemented
+		TicketRequests [][]json.Number        // { movie #, showing # }, checked by ticketRequests
+		PaymentInfo    map[string]interface{} // payment type, payer and customer IDs (see tickets.Sell)
+		LocalTime      interface{}            // receipt time, and showing starts (see tickets.Sell)
 	}
 
 	L.Printf("sellTickets called for %v\n", rqst.URL)
 
+	omitSoldOut := false
+	if o := rqst.URL.Query().Get("omit_soldout"); o != "" {
+		var oerr error
+		if omitSoldOut, oerr = strconv.ParseBool(o); oerr != nil {
+			L.Printf("Request '%s' failed:  omit_soldout invalid:  %v\n", rqst.URL, oerr)
+			writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "omit_soldout invalid")
+			return
+		}
+	}
+
 	jparser := json.NewDecoder(rqst.Body)
 
 	if err := jparser.Decode(&requestData); err != nil {
 		L.Printf("Request '%s' failed:  data not in JSON format:  %v\n", rqst.URL.Path, err)
-		http.Error(w, "data not in JSON format", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "data not in JSON format")
 		return
 	}
 
 	window, winerr := strconv.Atoi(strings.Split(rqst.URL.Path, "/")[PPWindow])
 	if winerr != nil {
 		L.Printf("Request '%s' failed:  window number invalid:  %v\n", rqst.URL.Path, winerr)
-		http.Error(w, "window number invalid", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "window number invalid")
 		return
 	}
 
-	ticks, rcpt, err := tickets.Sell(window, requestData.TicketRequests, requestData.PaymentInfo, requestData.LocalTime)
+	ticketRequests, err := ticketRequests(requestData.TicketRequests)
 	if err != nil {
-		L.Printf("Request '%s' failed:  error from tickets.Sell:  %v\n", rqst.URL.Path, err)
-		http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		L.Printf("Request '%s' failed:  %v\n", rqst.URL.Path, err)
+		writeTicketsError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	var responseData struct {
-		// All fields must be exported (capitalized), to be visible to json.
-		Ticks []tickets.Ticket
-		Rcpt  tickets.Receipt
+	ticks, rcpt, err := sell(window, ticketRequests, requestData.PaymentInfo, requestData.LocalTime)
+	if err != nil && !soldAny(ticks, rcpt) {
+		L.Printf("Request '%s' failed:  error from tickets.Sell:  %v\n", rqst.URL.Path, err)
+		writeTicketsError(w, sellStatus(err), err)
+		return
 	}
-	responseData.Ticks = ticks
-	responseData.Rcpt = rcpt
+	if err != nil {
+		// Some of the tickets were sold (and charged for), so they must be
+		// sent back, even though the rest of the sale failed.
+		L.Printf("Request '%s' partly failed:  error from tickets.Sell:  %v\n", rqst.URL.Path, err)
+	}
+
+	responseData := sellResponse(ticks, rcpt, omitSoldOut, err)
 	L.Printf("sellTickets window %d responseData\n%+v\n", window, responseData)
 	//jcoder := json.NewEncoder(w)
 	//if err := jcoder.Encode(responseData); err != nil {
 	jbuffer, err := json.Marshal(responseData)
 	if err != nil {
 		L.Printf("Request '%s' failed:  error marshalling response data to JSON:  %v\n", rqst.URL.Path, err)
-		http.Error(w, "error marshalling response data to JSON", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "ERR_INTERNAL", "error marshalling response data to JSON")
 		return
 	}
 	L.Printf("Marshalled responseData is %d bytes:\n'%s'\n", len(jbuffer), bytes.NewBuffer(jbuffer).String())
@@ -230,3 +1286,88 @@ func sellTickets(w http.ResponseWriter, rqst *http.Request) {
 	// Note:  http.ResponseWriter doesn't have a Close() method, so can't do that.
 	return
 } // sellTickets
+
+// ticketRequests converts the TicketRequests decoded from a sell request into
+// the form tickets.Sell takes, validating them on the way.  They are decoded
+// as json.Numbers, so that floats and values too big for an int can be caught
+// here with a clear message, instead of as an obscure decoding error.  Each
+// request must be a [movie#, showing#] pair of whole numbers, within the
+// movies and showings the ticket system was initialized with.
+//
+// Returns an error naming the first offending request and value, or nil.
+func ticketRequests(jrqsts [][]json.Number) ([][2]int, error) {
+	movies, showings := tickets.Dimensions()
+	trqsts := make([][2]int, len(jrqsts), len(jrqsts))
+	for i, jrqst := range jrqsts {
+		if len(jrqst) != 2 {
+			return nil, fmt.Errorf("ticket request %d:  expected [movie#, showing#], got %d values", (i + 1), len(jrqst))
+		}
+		for j, what := range []struct {
+			name  string
+			limit int
+		}{{"movie#", movies}, {"showing", showings}} {
+			v, err := strconv.ParseInt(jrqst[j].String(), 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("ticket request %d:  %s %s is not a whole number in range", (i + 1), what.name, jrqst[j])
+			}
+			if v < 0 || v >= int64(what.limit) {
+				return nil, fmt.Errorf("ticket request %d:  %s %d not between 0 and %d", (i + 1), what.name, v, what.limit)
+			}
+			trqsts[i][j] = int(v)
+		}
+	}
+	return trqsts, nil
+} // ticketRequests
+
+// sellResponse builds the data for sellTickets to send back as JSON.  By
+// default, it is the tickets (including sold-out placeholders) and receipt,
+// exactly as returned by tickets.Sell.  If omitSoldOut is set, then the
+// placeholders are split out into a separate list of unavailable requests.
+// If err is not nil (a sale which partly failed, see soldAny), then it is
+// added, with its code, as in an error reply.
+func sellResponse(ticks []tickets.Ticket, rcpt tickets.Receipt, omitSoldOut bool, err error) interface{} {
+	var message, code string
+	if err != nil {
+		message, code = err.Error(), errorCode(err)
+	}
+	if omitSoldOut {
+		var responseData struct {
+			Sold        []tickets.Ticket      `json:"sold"`
+			Unavailable []tickets.Unavailable `json:"unavailable"`
+			Rcpt        tickets.Receipt       `json:"receipt"`
+			Error       string                `json:"error,omitempty"`
+			Code        string                `json:"code,omitempty"`
+		}
+		responseData.Sold, responseData.Unavailable = tickets.SplitSoldOut(ticks)
+		responseData.Rcpt = rcpt
+		responseData.Error, responseData.Code = message, code
+		return responseData
+	}
+
+	var responseData struct {
+		// All fields must be exported (capitalized), to be visible to json.
+		Ticks []tickets.Ticket `json:"tickets"`
+		Rcpt  tickets.Receipt  `json:"receipt"`
+		Error string           `json:"error,omitempty"`
+		Code  string           `json:"code,omitempty"`
+	}
+	responseData.Ticks = ticks
+	responseData.Rcpt = rcpt
+	responseData.Error, responseData.Code = message, code
+	return responseData
+} // sellResponse
+
+// soldAny tells whether a Sell which returned an error still sold some of
+// the tickets, and recorded their receipt.  If so, sellTickets must send them
+// back, instead of only the error.
+func soldAny(ticks []tickets.Ticket, rcpt tickets.Receipt) bool {
+	if rcpt.ReceiptNum == 0 {
+		return false
+	}
+	for _, t := range ticks {
+		if t.TicketNum != 0 && !t.SoldOut {
+			return true
+		}
+	}
+	return false
+} // soldAny
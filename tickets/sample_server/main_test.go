@@ -0,0 +1,940 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/d-m-w/learninggo/tickets"
+)
+
+// Small theatre, so that sold-out showings are easy to reach.
+const (
+	testExchanges = 5
+	testMovies    = 3
+	testShowings  = 4
+	testSeats     = 2
+	testWindows   = 2
+)
+
+func init() {
+	L = log.New(os.Stderr, "ticketServerTest:  ", log.Ldate|log.Ltime|log.Lshortfile)
+	if err := tickets.Init(L, testExchanges, testMovies, testShowings, testSeats, testWindows); err != nil {
+		L.Fatalf("ticket system initialization failed:  %v\n", err)
+	}
+}
+
+// postSell POSTs body to the sellTickets handler at url, and returns the
+// recorded response.
+func postSell(url string, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	sellTickets(rec, httptest.NewRequest("POST", url, strings.NewReader(body)))
+	return rec
+} // postSell
+
+func TestIPLimiter(tst *testing.T) {
+	const perIP = 2
+	block := make(chan bool)
+	entered := make(chan bool, perIP)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		entered <- true
+		<-block
+	})
+	handler := newIPLimiter(perIP, false).limit(slow)
+
+	// Saturate all of 10.0.0.1's slots, and wait until they're all in flight.
+	done := make(chan int, perIP)
+	for i := 0; i < perIP; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			rqst := httptest.NewRequest("GET", "/tickets/exchange/1/water/soda", nil)
+			rqst.RemoteAddr = "10.0.0.1:5000"
+			handler.ServeHTTP(rec, rqst)
+			done <- rec.Code
+		}()
+	}
+	for i := 0; i < perIP; i++ {
+		<-entered
+	}
+
+	rec := httptest.NewRecorder()
+	rqst := httptest.NewRequest("GET", "/tickets/exchange/1/water/soda", nil)
+	rqst.RemoteAddr = "10.0.0.1:5001"
+	handler.ServeHTTP(rec, rqst)
+	if rec.Code != http.StatusTooManyRequests {
+		tst.Errorf("Request %d from a saturated IP got HTTP %d, expected %d", perIP+1, rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		tst.Errorf("Request %d from a saturated IP got no Retry-After header", perIP+1)
+	}
+
+	// Another IP still gets through while the first one is saturated.
+	go func() {
+		rec := httptest.NewRecorder()
+		rqst := httptest.NewRequest("GET", "/tickets/exchange/1/water/soda", nil)
+		rqst.RemoteAddr = "10.0.0.2:5000"
+		handler.ServeHTTP(rec, rqst)
+		done <- rec.Code
+	}()
+	<-entered
+
+	close(block)
+	for i := 0; i < perIP+1; i++ {
+		if code := <-done; code != http.StatusOK {
+			tst.Errorf("Request within the per-IP limit got HTTP %d, expected %d", code, http.StatusOK)
+		}
+	}
+
+	// Once the slots are released, the first IP can get in again.
+	rec = httptest.NewRecorder()
+	rqst = httptest.NewRequest("GET", "/tickets/exchange/1/water/soda", nil)
+	rqst.RemoteAddr = "10.0.0.1:5002"
+	go func() { <-entered }()
+	handler.ServeHTTP(rec, rqst)
+	if rec.Code != http.StatusOK {
+		tst.Errorf("Request after the slots were released got HTTP %d, expected %d", rec.Code, http.StatusOK)
+	}
+} // TestIPLimiter
+
+func TestHandleExchangeBadPath(tst *testing.T) {
+	for _, path := range []string{"/tickets/exchange/", "/tickets/exchange/5", "/tickets/exchange/5/water", "/tickets/exchange/x/water/soda"} {
+		rec := httptest.NewRecorder()
+		handleExchange(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"ERR_BAD_REQUEST"`) {
+			tst.Errorf("GET %s got HTTP %d '%s', expected %d with ERR_BAD_REQUEST", path, rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusBadRequest)
+		}
+	}
+} // TestHandleExchangeBadPath
+
+func TestClientIP(tst *testing.T) {
+	rqst := httptest.NewRequest("GET", "/tickets/sell/1", nil)
+	rqst.RemoteAddr = "192.0.2.7:4242"
+	rqst.Header.Set("X-Forwarded-For", "203.0.113.9, 192.0.2.7")
+	if ip := clientIP(rqst, false); ip != "192.0.2.7" {
+		tst.Errorf("clientIP without XFF trust returned %s, expected 192.0.2.7", ip)
+	}
+	if ip := clientIP(rqst, true); ip != "203.0.113.9" {
+		tst.Errorf("clientIP with XFF trust returned %s, expected 203.0.113.9", ip)
+	}
+} // TestClientIP
+
+func TestSellOmitSoldOut(tst *testing.T) {
+	// Movie 0, showing 0 has testSeats seats, so the last request is sold out.
+	ticks, rcpt, err := tickets.Sell(2, [][2]int{[2]int{0, 0}, [2]int{0, 0}, [2]int{0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("tickets.Sell returned error %v", err)
+	}
+
+	var interleaved struct {
+		Ticks []tickets.Ticket `json:"tickets"`
+		Rcpt  tickets.Receipt  `json:"receipt"`
+	}
+	var split struct {
+		Sold        []tickets.Ticket      `json:"sold"`
+		Unavailable []tickets.Unavailable `json:"unavailable"`
+		Rcpt        tickets.Receipt       `json:"receipt"`
+	}
+	for _, shape := range []struct {
+		omit bool
+		into interface{}
+	}{{false, &interleaved}, {true, &split}} {
+		jbytes, err := json.Marshal(sellResponse(ticks, rcpt, shape.omit, nil))
+		if err != nil {
+			tst.Fatalf("sellResponse(omitSoldOut=%t) could not be marshalled:  %v", shape.omit, err)
+		}
+		if err := json.Unmarshal(jbytes, shape.into); err != nil {
+			tst.Fatalf("sellResponse(omitSoldOut=%t) JSON could not be unmarshalled:  %v\n%s", shape.omit, err, jbytes)
+		}
+	}
+
+	if len(interleaved.Ticks) != 3 || !interleaved.Ticks[2].SoldOut {
+		tst.Errorf("Interleaved shape should have 3 tickets, the last one sold out, but got:  %+v", interleaved.Ticks)
+	}
+	if len(split.Sold) != 2 || split.Sold[0] != interleaved.Ticks[0] || split.Sold[1] != interleaved.Ticks[1] {
+		tst.Errorf("Split shape should have the 2 tickets actually sold, %+v, but got:  %+v", interleaved.Ticks[:2], split.Sold)
+	}
+	want := tickets.Unavailable{Movie: 0, Showing: 0, Reason: "sold out"}
+	if len(split.Unavailable) != 1 || split.Unavailable[0] != want {
+		tst.Errorf("Split shape should have one unavailable request, %+v, but got:  %+v", want, split.Unavailable)
+	}
+	if split.Rcpt.Total != interleaved.Rcpt.Total || len(split.Rcpt.ItemsSold) != 2 {
+		tst.Errorf("Both shapes should carry the same receipt, but got %+v and %+v", interleaved.Rcpt, split.Rcpt)
+	}
+
+	// And through the handler, with the query parameter.
+	rec := postSell("/tickets/sell/2?omit_soldout=true", `{"TicketRequests": [[0,1], [0,1], [0,1]]}`)
+	if rec.Code != http.StatusOK {
+		tst.Fatalf("POST with omit_soldout=true got HTTP %d:  %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"unavailable":[{"movie":0,"showing":1,"reason":"sold out"}]`) {
+		tst.Errorf("POST with omit_soldout=true did not report the sold-out request:  %s", rec.Body.String())
+	}
+	if rec := postSell("/tickets/sell/2?omit_soldout=maybe", `{"TicketRequests": [[0,2]]}`); rec.Code != http.StatusBadRequest {
+		tst.Errorf("POST with omit_soldout=maybe got HTTP %d, expected %d", rec.Code, http.StatusBadRequest)
+	}
+} // TestSellOmitSoldOut
+
+func TestSellRejectsBadNumbers(tst *testing.T) {
+	for _, c := range []struct {
+		body, want string
+	}{
+		{`{"TicketRequests": [[1, 1.5]]}`, "showing 1.5 is not a whole number"},
+		{`{"TicketRequests": [[0, 0], [99999999999999999999999, 0]]}`, "ticket request 2:  movie# 99999999999999999999999 is not a whole number"},
+		{`{"TicketRequests": [[-1, 0]]}`, "movie# -1 not between 0 and 3"},
+		{`{"TicketRequests": [[1, 4]]}`, "showing 4 not between 0 and 4"},
+		{`{"TicketRequests": [[1]]}`, "expected [movie#, showing#], got 1 values"},
+	} {
+		rec := postSell("/tickets/sell/2", c.body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), c.want) {
+			tst.Errorf("POST %s got HTTP %d '%s', expected %d containing '%s'", c.body, rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusBadRequest, c.want)
+		}
+	}
+} // TestSellRejectsBadNumbers
+
+func TestSelfCheckAdminOnly(tst *testing.T) {
+	defer func(saved string) { adminToken = saved }(adminToken)
+	handler := adminOnly(handleSelfCheck)
+
+	for _, c := range []struct {
+		configured, sent string
+		want             int
+	}{
+		{"", "", http.StatusForbidden},
+		{"", "guess", http.StatusForbidden},
+		{"sekrit", "guess", http.StatusForbidden},
+		{"sekrit", "sekrit", http.StatusOK},
+	} {
+		adminToken = c.configured
+		rec := httptest.NewRecorder()
+		rqst := httptest.NewRequest("GET", "/tickets/admin/selfcheck", nil)
+		if c.sent != "" {
+			rqst.Header.Set("X-Admin-Token", c.sent)
+		}
+		handler(rec, rqst)
+		if rec.Code != c.want {
+			tst.Errorf("Self-check with admin token '%s' configured and '%s' sent got HTTP %d, expected %d", c.configured, c.sent, rec.Code, c.want)
+		}
+		if rec.Code == http.StatusOK && !strings.Contains(rec.Body.String(), `"problems":[`) {
+			tst.Errorf("Self-check response has no problems list:  %s", rec.Body.String())
+		}
+	}
+} // TestSelfCheckAdminOnly
+
+func TestHandleConfig(tst *testing.T) {
+	rec := httptest.NewRecorder()
+	handleConfig(rec, httptest.NewRequest("GET", "/tickets/config", nil))
+	if want := fmt.Sprintf(`{"movies":%d,"showings":%d,"windows":%d}`, testMovies, testShowings, testWindows); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != want {
+		tst.Errorf("GET /tickets/config got HTTP %d '%s', expected %d '%s'", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK, want)
+	}
+} // TestHandleConfig
+
+func TestHandleShowing(tst *testing.T) {
+	if _, _, err := tickets.Sell(2, [][2]int{[2]int{1, 3}, [2]int{1, 2}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("tickets.Sell returned error %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleShowing(rec, httptest.NewRequest("GET", "/tickets/showing/1/3", nil))
+	var responseData struct {
+		Ticks      []tickets.Ticket `json:"tickets"`
+		WindowSold int              `json:"windowSold"`
+		OnlineSold int              `json:"onlineSold"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &responseData); rec.Code != http.StatusOK || err != nil {
+		tst.Fatalf("GET /tickets/showing/1/3 got HTTP %d, error %v:  %s", rec.Code, err, rec.Body.String())
+	}
+	if len(responseData.Ticks) != 1 || responseData.Ticks[0].Movie != 1 || responseData.Ticks[0].Showing != 3 {
+		tst.Errorf("GET /tickets/showing/1/3 returned %+v, expected the one ticket sold for it", responseData.Ticks)
+	}
+	if responseData.WindowSold != 1 || responseData.OnlineSold != 0 {
+		tst.Errorf("GET /tickets/showing/1/3 returned %d window and %d online sales, expected 1 and 0", responseData.WindowSold, responseData.OnlineSold)
+	}
+
+	for _, url := range []string{"/tickets/showing/1", "/tickets/showing/x/1", "/tickets/showing/3/0", "/tickets/showing/0/-1"} {
+		rec := httptest.NewRecorder()
+		handleShowing(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusBadRequest {
+			tst.Errorf("GET %s got HTTP %d, expected %d", url, rec.Code, http.StatusBadRequest)
+		}
+	}
+} // TestHandleShowing
+
+func TestSellResponseJSONKeys(tst *testing.T) {
+	ticks, rcpt, err := tickets.Sell(1, [][2]int{[2]int{2, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("tickets.Sell returned error %v", err)
+	}
+	jbytes, err := json.Marshal(sellResponse(ticks, rcpt, false, nil))
+	if err != nil {
+		tst.Fatalf("sellResponse could not be marshalled:  %v", err)
+	}
+
+	var keys map[string]json.RawMessage
+	json.Unmarshal(jbytes, &keys)
+	if _, ok := keys["tickets"]; !ok || len(keys) != 2 {
+		tst.Errorf("Sell response keys should be tickets and receipt, but got:  %s", jbytes)
+	}
+	if _, ok := keys["receipt"]; !ok {
+		tst.Errorf("Sell response keys should be tickets and receipt, but got:  %s", jbytes)
+	}
+	for _, want := range []string{
+		`"ticketNum":`, `"movie":2,`, `"showing":0,`, `"price":1000,`, `"soldOut":false,`, `"goodies":true,`,
+		`"exchanged":false,`, `"xchOld":"",`, `"xchNew":"",`, `"window":1,`, `"void":false,`, `"customerID":""`,
+		fmt.Sprintf(`"receipt":{"receiptNum":%d,"time":"a dummy time","window":1,"itemsSold":[{"desc":"Movie 2, Showing 0","penneys":1000}],"total":1000}`, rcpt.ReceiptNum),
+	} {
+		if !strings.Contains(string(jbytes), want) {
+			tst.Errorf("Sell response JSON does not contain %s:  %s", want, jbytes)
+		}
+	}
+} // TestSellResponseJSONKeys
+
+func TestIdleTimeoutShutdown(tst *testing.T) {
+	const idleTimeout = 100 * time.Millisecond
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tst.Fatalf("net.Listen failed:  %v", err)
+	}
+	url := "http://" + ln.Addr().String() + "/"
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {})}
+	served := make(chan error, 1)
+	start := time.Now()
+	go func() { served <- serve(srv, ln, idleTimeout, nil) }()
+
+	// Keep the server busy for several idle timeouts; it must stay up.
+	for time.Since(start) < 4*idleTimeout {
+		resp, err := http.Get(url)
+		if err != nil {
+			tst.Fatalf("GET while active failed:  %v", err)
+		}
+		resp.Body.Close()
+		select {
+		case err := <-served:
+			tst.Fatalf("serve returned %v after %v while requests were still arriving", err, time.Since(start))
+		case <-time.After(idleTimeout / 4):
+		}
+	}
+
+	// Now leave it alone; it must shut itself down.
+	stopped := time.Now()
+	select {
+	case err := <-served:
+		if err != nil {
+			tst.Errorf("serve returned error %v, expected nil after idle shutdown", err)
+		}
+		if idle := time.Since(stopped); idle < idleTimeout/2 {
+			tst.Errorf("serve shut down after only %v idle, expected about %v", idle, idleTimeout)
+		}
+	case <-time.After(10 * idleTimeout):
+		tst.Fatalf("serve still running %v after the last request, expected shutdown after %v", 10*idleTimeout, idleTimeout)
+	}
+} // TestIdleTimeoutShutdown
+
+func TestSignalShutdown(tst *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tst.Fatalf("net.Listen failed:  %v", err)
+	}
+	url := "http://" + ln.Addr().String() + "/"
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {})}
+
+	// The server is closed by the signal, and then so is the theatre, which
+	// runs its shutdown hooks.  A Theatre of the test's own is closed, so
+	// that the other tests can still use the default one.
+	th, err := tickets.NewTheatre(tickets.Config{Logger: L, MaxMovies: 1, MaxShowings: 1, MaxSeats: 1, MaxWindows: 1})
+	if err != nil {
+		tst.Fatalf("NewTheatre returned error %v", err)
+	}
+	hooked := make(chan struct{})
+	th.RegisterShutdownHook(func() error { close(hooked); return nil })
+	stop := make(chan os.Signal, 1)
+	ran := make(chan error, 1)
+	go func() { ran <- run(srv, ln, 0, stop, th.Close) }()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		tst.Fatalf("GET before the signal failed:  %v", err)
+	}
+	resp.Body.Close()
+	stop <- syscall.SIGTERM
+	select {
+	case err := <-ran:
+		if err != nil {
+			tst.Errorf("run returned error %v, expected nil after a signal", err)
+		}
+	case <-time.After(5 * time.Second):
+		tst.Fatalf("run still going 5s after SIGTERM")
+	}
+	select {
+	case <-hooked:
+	default:
+		tst.Errorf("run returned after SIGTERM without closing the theatre and running its shutdown hook")
+	}
+	if _, err := http.Get(url); err == nil {
+		tst.Errorf("GET after SIGTERM succeeded, expected the server to be closed")
+	}
+} // TestSignalShutdown
+
+func TestSellErrorBody(tst *testing.T) {
+	if err := tickets.SetSalesWindow(time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)); err != nil {
+		tst.Fatalf("SetSalesWindow returned error %v", err)
+	}
+	defer tickets.SetSalesWindow(time.Time{}, time.Time{})
+
+	for _, c := range []struct {
+		url, body, code string
+	}{
+		{"/tickets/sell/1", `{"TicketRequests": [[0, 3]]}`, "ERR_SALES_CLOSED"},
+		{"/tickets/sell/1", `not JSON`, "ERR_BAD_REQUEST"},
+	} {
+		rec := postSell(c.url, c.body)
+		var errorData struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &errorData); err != nil {
+			tst.Errorf("POST %s %s error body is not JSON (%v):  %s", c.url, c.body, err, rec.Body.String())
+			continue
+		}
+		if rec.Code != http.StatusBadRequest || errorData.Code != c.code || errorData.Error == "" {
+			tst.Errorf("POST %s %s got HTTP %d %+v, expected %d with code %s and a message", c.url, c.body, rec.Code, errorData, http.StatusBadRequest, c.code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			tst.Errorf("POST %s %s error Content-Type is '%s', expected application/json", c.url, c.body, ct)
+		}
+	}
+} // TestSellErrorBody
+
+func TestHandleBlackout(tst *testing.T) {
+	defer func(saved string) { adminToken = saved }(adminToken)
+	adminToken = "sekrit"
+	handler := adminOnly(handleBlackout)
+	blackout := func(method string, url string) int {
+		rec := httptest.NewRecorder()
+		rqst := httptest.NewRequest(method, url, nil)
+		rqst.Header.Set("X-Admin-Token", adminToken)
+		handler(rec, rqst)
+		return rec.Code
+	}
+
+	if code := blackout("POST", "/tickets/admin/blackout/2/3?on=true"); code != http.StatusNoContent {
+		tst.Fatalf("Blackout on got HTTP %d, expected %d", code, http.StatusNoContent)
+	}
+	rec := postSell("/tickets/sell/2", `{"TicketRequests": [[2, 2], [2, 3]]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"ERR_BLACKOUT"`) {
+		tst.Errorf("Sell into a blacked-out showing got HTTP %d '%s', expected %d with ERR_BLACKOUT", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusBadRequest)
+	}
+
+	if code := blackout("POST", "/tickets/admin/blackout/2/3?on=false"); code != http.StatusNoContent {
+		tst.Fatalf("Blackout off got HTTP %d, expected %d", code, http.StatusNoContent)
+	}
+	if rec := postSell("/tickets/sell/2", `{"TicketRequests": [[2, 3]]}`); rec.Code != http.StatusOK {
+		tst.Errorf("Sell after the blackout was cleared got HTTP %d '%s', expected %d", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK)
+	}
+
+	for _, c := range []struct {
+		method, url string
+		want        int
+	}{
+		{"GET", "/tickets/admin/blackout/2/3?on=true", http.StatusMethodNotAllowed},
+		{"POST", "/tickets/admin/blackout/2/3?on=maybe", http.StatusBadRequest},
+		{"POST", "/tickets/admin/blackout/2/99?on=true", http.StatusBadRequest},
+		{"POST", "/tickets/admin/blackout/2", http.StatusBadRequest},
+	} {
+		if code := blackout(c.method, c.url); code != c.want {
+			tst.Errorf("%s %s got HTTP %d, expected %d", c.method, c.url, code, c.want)
+		}
+	}
+} // TestHandleBlackout
+
+func TestHandleShowingStart(tst *testing.T) {
+	defer func(saved string) { adminToken = saved }(adminToken)
+	adminToken = "sekrit"
+	defer tickets.SetShowingStart(2, 0, time.Time{})
+	start := func(method string, url string, token string) int {
+		rec := httptest.NewRecorder()
+		rqst := httptest.NewRequest(method, url, nil)
+		rqst.Header.Set("X-Admin-Token", token)
+		handleShowing(rec, rqst)
+		return rec.Code
+	}
+
+	if code := start("POST", "/tickets/showing/2/0/start", "wrong"); code != http.StatusForbidden {
+		tst.Errorf("Start with the wrong admin token got HTTP %d, expected %d", code, http.StatusForbidden)
+	}
+	if code := start("POST", "/tickets/showing/2/0/start", adminToken); code != http.StatusNoContent {
+		tst.Fatalf("Start got HTTP %d, expected %d", code, http.StatusNoContent)
+	}
+	rec := postSell("/tickets/sell/2", `{"TicketRequests": [[1, 1], [2, 0]]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"ERR_SHOWING_STARTED"`) {
+		tst.Errorf("Sell into a started showing got HTTP %d '%s', expected %d with ERR_SHOWING_STARTED", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusBadRequest)
+	}
+	if rec := postSell("/tickets/sell/2", `{"TicketRequests": [[1, 1]]}`); rec.Code != http.StatusOK {
+		tst.Errorf("Sell into a showing not started got HTTP %d '%s', expected %d", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK)
+	}
+
+	for _, c := range []struct {
+		method, url string
+		want        int
+	}{
+		{"GET", "/tickets/showing/2/0/start", http.StatusMethodNotAllowed},
+		{"POST", "/tickets/showing/2/99/start", http.StatusBadRequest},
+		{"POST", "/tickets/showing/x/0/start", http.StatusBadRequest},
+		{"POST", "/tickets/showing/2/start", http.StatusBadRequest},
+	} {
+		if code := start(c.method, c.url, adminToken); code != c.want {
+			tst.Errorf("%s %s got HTTP %d, expected %d", c.method, c.url, code, c.want)
+		}
+	}
+} // TestHandleShowingStart
+
+func TestHandleNext(tst *testing.T) {
+	next := func(url string) (int, int, bool) {
+		rec := httptest.NewRecorder()
+		handleNext(rec, httptest.NewRequest("GET", url, nil))
+		var responseData struct {
+			Showing int  `json:"showing"`
+			Found   bool `json:"found"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &responseData); err != nil {
+				tst.Errorf("GET %s reply '%s' is not JSON:  %v", url, strings.TrimSpace(rec.Body.String()), err)
+			}
+		}
+		return rec.Code, responseData.Showing, responseData.Found
+	}
+
+	wantShowing, wantFound := tickets.NextAvailableShowing(0)
+	if code, showing, found := next("/tickets/next/0"); code != http.StatusOK || showing != wantShowing || found != wantFound {
+		tst.Errorf("GET /tickets/next/0 got HTTP %d, showing %d found %v, expected %d, %d %v", code, showing, found, http.StatusOK, wantShowing, wantFound)
+	}
+
+	// Black out every showing of movie 2, so that none is available.
+	for s := 0; s < testShowings; s++ {
+		tickets.Blackout(2, s, true)
+		defer tickets.Blackout(2, s, false)
+	}
+	if code, _, found := next("/tickets/next/2"); code != http.StatusOK || found {
+		tst.Errorf("GET /tickets/next/2 with every showing blacked out got HTTP %d, found %v, expected %d, false", code, found, http.StatusOK)
+	}
+
+	for _, url := range []string{"/tickets/next", "/tickets/next/x", "/tickets/next/3", "/tickets/next/-1"} {
+		if code, _, _ := next(url); code != http.StatusBadRequest {
+			tst.Errorf("GET %s got HTTP %d, expected %d", url, code, http.StatusBadRequest)
+		}
+	}
+} // TestHandleNext
+
+func TestHandleReceiptByNum(tst *testing.T) {
+	rec := postSell("/tickets/sell/2", `{"TicketRequests": [[1, 0]], "LocalTime": "receipt test"}`)
+	var sold struct {
+		Rcpt tickets.Receipt `json:"receipt"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &sold); rec.Code != http.StatusOK || err != nil {
+		tst.Fatalf("Sell got HTTP %d, error %v:  %s", rec.Code, err, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleReceiptByNum(rec, httptest.NewRequest("GET", fmt.Sprintf("/tickets/receipt-by-num/%d", sold.Rcpt.ReceiptNum), nil))
+	var found tickets.Receipt
+	if err := json.Unmarshal(rec.Body.Bytes(), &found); rec.Code != http.StatusOK || err != nil {
+		tst.Fatalf("GET receipt %d got HTTP %d, error %v:  %s", sold.Rcpt.ReceiptNum, rec.Code, err, rec.Body.String())
+	}
+	if found.ReceiptNum != sold.Rcpt.ReceiptNum || found.Time != "receipt test" || found.Total != sold.Rcpt.Total {
+		tst.Errorf("GET receipt %d returned %+v, expected %+v", sold.Rcpt.ReceiptNum, found, sold.Rcpt)
+	}
+
+	for _, c := range []struct {
+		url  string
+		want int
+	}{
+		{"/tickets/receipt-by-num/999999", http.StatusNotFound},
+		{"/tickets/receipt-by-num/x", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		handleReceiptByNum(rec, httptest.NewRequest("GET", c.url, nil))
+		if rec.Code != c.want {
+			tst.Errorf("GET %s got HTTP %d, expected %d", c.url, rec.Code, c.want)
+		}
+	}
+} // TestHandleReceiptByNum
+
+func TestUnknownPath(tst *testing.T) {
+	var logged bytes.Buffer
+	defer func(saved *log.Logger) { L = saved }(L)
+	L = log.New(&logged, "ticketServerTest:  ", 0)
+
+	mux := http.NewServeMux()
+	registerHandlers(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/tickets/nosuch/1", nil))
+	var errorData struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &errorData); rec.Code != http.StatusNotFound || err != nil || errorData.Code != "ERR_NOT_FOUND" {
+		tst.Errorf("GET /tickets/nosuch/1 got HTTP %d %+v (%v), expected %d with ERR_NOT_FOUND", rec.Code, errorData, err, http.StatusNotFound)
+	}
+	if !strings.Contains(logged.String(), "'/tickets/nosuch/1'") || !strings.Contains(logged.String(), "unknown URL path") {
+		tst.Errorf("GET /tickets/nosuch/1 was not logged as an unknown path:  %s", logged.String())
+	}
+
+	// A registered prefix still gets to its own handler.
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/tickets/showing/0/0", nil))
+	if rec.Code != http.StatusOK {
+		tst.Errorf("GET /tickets/showing/0/0 got HTTP %d, expected %d", rec.Code, http.StatusOK)
+	}
+} // TestUnknownPath
+
+func TestHandleReadOnly(tst *testing.T) {
+	defer func(saved string) { adminToken = saved }(adminToken)
+	adminToken = "sekrit"
+	defer tickets.SetReadOnly(false)
+	readOnly := func(method string, url string) int {
+		rec := httptest.NewRecorder()
+		rqst := httptest.NewRequest(method, url, nil)
+		rqst.Header.Set("X-Admin-Token", adminToken)
+		adminOnly(handleReadOnly)(rec, rqst)
+		return rec.Code
+	}
+
+	if code := readOnly("POST", "/tickets/admin/readonly?on=true"); code != http.StatusNoContent {
+		tst.Fatalf("Read-only on got HTTP %d, expected %d", code, http.StatusNoContent)
+	}
+	rec := postSell("/tickets/sell/2", `{"TicketRequests": [[2, 1]]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"ERR_READ_ONLY"`) {
+		tst.Errorf("Sell in read-only mode got HTTP %d '%s', expected %d with ERR_READ_ONLY", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusBadRequest)
+	}
+	rec = httptest.NewRecorder()
+	handleShowing(rec, httptest.NewRequest("GET", "/tickets/showing/2/1", nil))
+	if rec.Code != http.StatusOK {
+		tst.Errorf("Showing lookup in read-only mode got HTTP %d, expected %d", rec.Code, http.StatusOK)
+	}
+
+	if code := readOnly("POST", "/tickets/admin/readonly?on=false"); code != http.StatusNoContent {
+		tst.Fatalf("Read-only off got HTTP %d, expected %d", code, http.StatusNoContent)
+	}
+	if rec := postSell("/tickets/sell/2", `{"TicketRequests": [[2, 1]]}`); rec.Code != http.StatusOK {
+		tst.Errorf("Sell after read-only mode ended got HTTP %d '%s', expected %d", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK)
+	}
+	if code := readOnly("GET", "/tickets/admin/readonly?on=true"); code != http.StatusMethodNotAllowed {
+		tst.Errorf("GET of the read-only URL got HTTP %d, expected %d", code, http.StatusMethodNotAllowed)
+	}
+	if code := readOnly("POST", "/tickets/admin/readonly"); code != http.StatusBadRequest {
+		tst.Errorf("Read-only with no on flag got HTTP %d, expected %d", code, http.StatusBadRequest)
+	}
+} // TestHandleReadOnly
+
+func TestHandleCompact(tst *testing.T) {
+	defer func(saved string) { adminToken = saved }(adminToken)
+	adminToken = "sekrit"
+	compact := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rqst := httptest.NewRequest(method, "/tickets/admin/compact", nil)
+		rqst.Header.Set("X-Admin-Token", adminToken)
+		adminOnly(handleCompact)(rec, rqst)
+		return rec
+	}
+
+	compact("POST") // whatever earlier tests left
+	if rec := postSell("/tickets/sell/2", `{"TicketRequests": [[1, 2]], "PaymentInfo": {"customerID": "alice"}}`); rec.Code != http.StatusOK {
+		tst.Fatalf("Sell got HTTP %d '%s', expected %d", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK)
+	}
+	if _, err := tickets.VoidLastSale(2); err != nil {
+		tst.Fatalf("tickets.VoidLastSale returned error %v", err)
+	}
+
+	rec := compact("POST")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"compacted":1}` {
+		tst.Errorf("Compact got HTTP %d '%s', expected %d '{\"compacted\":1}'", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK)
+	}
+	if rec := compact("GET"); rec.Code != http.StatusMethodNotAllowed {
+		tst.Errorf("GET compact got HTTP %d, expected %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+} // TestHandleCompact
+
+func TestHandleRevenue(tst *testing.T) {
+	defer func(saved string) { adminToken = saved }(adminToken)
+	adminToken = "sekrit"
+	revenue := func(method string, url string) (int, int) {
+		rec := httptest.NewRecorder()
+		rqst := httptest.NewRequest(method, url, nil)
+		rqst.Header.Set("X-Admin-Token", adminToken)
+		adminOnly(handleRevenue)(rec, rqst)
+		var responseData struct {
+			Revenue int `json:"revenue"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &responseData); err != nil {
+				tst.Errorf("%s %s returned '%s', which isn't JSON:  %v", method, url, rec.Body.String(), err)
+			}
+		}
+		return rec.Code, responseData.Revenue
+	}
+
+	before := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	rec := postSell("/tickets/sell/1", `{"TicketRequests": [[0, 2], [0, 2]]}`)
+	var sold struct {
+		Ticks []tickets.Ticket `json:"tickets"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &sold); rec.Code != http.StatusOK || err != nil || len(sold.Ticks) != 2 {
+		tst.Fatalf("Sell got HTTP %d '%s', error %v, expected %d and two tickets", rec.Code, strings.TrimSpace(rec.Body.String()), err, http.StatusOK)
+	}
+	price := sold.Ticks[0].Price + sold.Ticks[1].Price
+	after := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+
+	for _, c := range []struct {
+		query string
+		want  int
+	}{
+		{"movie=0&showing=2", price},
+		{"movie=0&showing=2&window=1", price},
+		{"movie=0&showing=2&window=2", 0},
+		{"movie=0&showing=2&from=" + before + "&to=" + after, price},
+		{"movie=0&showing=2&from=" + after, 0},
+		{"movie=0&showing=2&to=" + before, 0},
+		{"movie=0&showing=3", 0},
+	} {
+		if code, got := revenue("GET", "/tickets/revenue?"+c.query); code != http.StatusOK || got != c.want {
+			tst.Errorf("GET revenue?%s got HTTP %d and %d, expected %d and %d", c.query, code, got, http.StatusOK, c.want)
+		}
+	}
+	if code, all := revenue("GET", "/tickets/revenue"); code != http.StatusOK || all < price {
+		tst.Errorf("GET revenue got HTTP %d and %d, expected %d and at least %d", code, all, http.StatusOK, price)
+	}
+
+	for _, c := range []struct {
+		method, url string
+		want        int
+	}{
+		{"POST", "/tickets/revenue", http.StatusMethodNotAllowed},
+		{"GET", "/tickets/revenue?movie=x", http.StatusBadRequest},
+		{"GET", "/tickets/revenue?from=yesterday", http.StatusBadRequest},
+	} {
+		if code, _ := revenue(c.method, c.url); code != c.want {
+			tst.Errorf("%s %s got HTTP %d, expected %d", c.method, c.url, code, c.want)
+		}
+	}
+} // TestHandleRevenue
+
+func TestHandleLoadTest(tst *testing.T) {
+	for _, c := range []struct {
+		method, url string
+		want        int
+	}{
+		{"POST", "/tickets/admin/loadtest?concurrency=4&duration=20ms", http.StatusOK},
+		{"GET", "/tickets/admin/loadtest?concurrency=4&duration=20ms", http.StatusMethodNotAllowed},
+		{"POST", "/tickets/admin/loadtest?concurrency=0&duration=20ms", http.StatusBadRequest},
+		{"POST", "/tickets/admin/loadtest?concurrency=4&duration=2h", http.StatusBadRequest},
+		{"POST", "/tickets/admin/loadtest?concurrency=4", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		handleLoadTest(rec, httptest.NewRequest(c.method, c.url, nil))
+		if rec.Code != c.want {
+			tst.Errorf("%s %s got HTTP %d, expected %d:  %s", c.method, c.url, rec.Code, c.want, rec.Body.String())
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var result tickets.LoadResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.Concurrency != 4 || len(result.Violations) != 0 {
+			tst.Errorf("%s %s returned %+v (%v), expected a clean run at concurrency 4", c.method, c.url, result, err)
+		}
+	}
+} // TestHandleLoadTest
+
+func TestHandleLogs(tst *testing.T) {
+	f, err := os.Create(tst.TempDir() + "/tickets.log")
+	if err != nil {
+		tst.Fatalf("Cannot create a test log file:  %v", err)
+	}
+	defer f.Close()
+	defer func(saved string) { logFileName = saved }(logFileName)
+	logFileName = f.Name()
+
+	const written = 2000 // enough to take several blocks to read back
+	testLog := log.New(f, "test:  ", 0)
+	for i := 1; i <= written; i++ {
+		testLog.Printf("log line %d", i)
+	}
+
+	for _, tail := range []int{1, 3, 1500} {
+		rec := httptest.NewRecorder()
+		handleLogs(rec, httptest.NewRequest("GET", fmt.Sprintf("/tickets/logs?tail=%d", tail), nil))
+		var reply struct {
+			Lines []string `json:"lines"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); rec.Code != http.StatusOK || err != nil {
+			tst.Errorf("GET tail=%d got HTTP %d (%v):  %s", tail, rec.Code, err, rec.Body.String())
+			continue
+		}
+		if len(reply.Lines) != tail {
+			tst.Errorf("GET tail=%d returned %d lines, expected %d", tail, len(reply.Lines), tail)
+			continue
+		}
+		for i, line := range reply.Lines {
+			if want := fmt.Sprintf("test:  log line %d", written-tail+1+i); line != want {
+				tst.Errorf("GET tail=%d line %d is '%s', expected '%s'", tail, i, line, want)
+				break
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleLogs(rec, httptest.NewRequest("GET", "/tickets/logs", nil))
+	if !strings.Contains(rec.Body.String(), fmt.Sprintf(`"test:  log line %d"`, written-defaultLogTail+1)) || strings.Contains(rec.Body.String(), fmt.Sprintf(`"test:  log line %d"`, written-defaultLogTail)) {
+		tst.Errorf("GET with no tail did not return the last %d lines:  %s", defaultLogTail, rec.Body.String())
+	}
+
+	for _, c := range []struct {
+		method, url string
+		want        int
+	}{
+		{"POST", "/tickets/logs?tail=3", http.StatusMethodNotAllowed},
+		{"GET", "/tickets/logs?tail=0", http.StatusBadRequest},
+		{"GET", fmt.Sprintf("/tickets/logs?tail=%d", maxLogTail+1), http.StatusBadRequest},
+		{"GET", "/tickets/logs?tail=lots", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		handleLogs(rec, httptest.NewRequest(c.method, c.url, nil))
+		if rec.Code != c.want {
+			tst.Errorf("%s %s got HTTP %d, expected %d:  %s", c.method, c.url, rec.Code, c.want, rec.Body.String())
+		}
+	}
+} // TestHandleLogs
+
+func TestRetryAfter(tst *testing.T) {
+	defer func(base, jitter time.Duration) { retryAfterBase, retryAfterJitter = base, jitter }(retryAfterBase, retryAfterJitter)
+	retryAfterBase, retryAfterJitter = 2*time.Second, 3*time.Second
+
+	full := fmt.Errorf("Sell failed:  ticket request 1:  %w", tickets.ErrNoMoreTickets)
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		writeTicketsError(rec, sellStatus(full), full)
+		if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "ERR_NO_MORE_TICKETS") {
+			tst.Fatalf("Sell refused with %v got HTTP %d '%s', expected %d and ERR_NO_MORE_TICKETS", full, rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusTooManyRequests)
+		}
+		secs, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		if err != nil || secs < 2 || secs > 5 {
+			tst.Fatalf("HTTP 429 got Retry-After '%s', expected 2 to 5 seconds", rec.Header().Get("Retry-After"))
+		}
+		seen[secs] = true
+	}
+	if len(seen) < 2 {
+		tst.Errorf("100 HTTP 429s all got Retry-After %v, expected some jitter", seen)
+	}
+
+	rec := httptest.NewRecorder()
+	writeTicketsError(rec, sellStatus(tickets.ErrBusy), tickets.ErrBusy)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		tst.Errorf("Sell refused with ErrBusy got HTTP %d, Retry-After '%s', expected %d, with a Retry-After", rec.Code, rec.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+	rec = httptest.NewRecorder()
+	writeTicketsError(rec, sellStatus(tickets.ErrSalesClosed), tickets.ErrSalesClosed)
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Retry-After") != "" {
+		tst.Errorf("Sell refused with ErrSalesClosed got HTTP %d, Retry-After '%s', expected %d, with no Retry-After", rec.Code, rec.Header().Get("Retry-After"), http.StatusBadRequest)
+	}
+
+	retryAfterBase, retryAfterJitter = 0, 0
+	if secs := retryAfterSeconds(); secs != 1 {
+		tst.Errorf("retryAfterSeconds with no base or jitter returned %d, expected at least 1", secs)
+	}
+} // TestRetryAfter
+
+func TestSellNoRequests(tst *testing.T) {
+	for _, body := range []string{`{"ticketRequests": []}`, `{}`} {
+		rec := postSell("/tickets/sell/1", body)
+		var errorData struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &errorData); err != nil || rec.Code != http.StatusBadRequest || errorData.Code != "ERR_NO_REQUESTS" {
+			tst.Errorf("POST %s got HTTP %d %s, expected %d with code ERR_NO_REQUESTS", body, rec.Code, rec.Body.String(), http.StatusBadRequest)
+		}
+	}
+} // TestSellNoRequests
+
+func TestSellPartlyFailed(tst *testing.T) {
+	// A theatre of its own, with room in the ticket DB for only 2 tickets.
+	th, err := tickets.NewTheatre(tickets.Config{Logger: L, MaxMovies: 1, MaxShowings: 1, MaxSeats: 2, MaxWindows: 2})
+	if err != nil {
+		tst.Fatalf("NewTheatre returned error %v", err)
+	}
+	defer th.Close()
+	saved := sell
+	defer func() { sell = saved }()
+	sell = th.Sell
+
+	var responseData struct {
+		Ticks []tickets.Ticket `json:"tickets"`
+		Rcpt  tickets.Receipt  `json:"receipt"`
+		Error string           `json:"error"`
+		Code  string           `json:"code"`
+	}
+	rec := postSell("/tickets/sell/2", `{"TicketRequests": [[0, 0], [0, 0], [0, 0]]}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &responseData); err != nil || rec.Code != http.StatusOK {
+		tst.Fatalf("Sell crossing the ticket DB limit got HTTP %d '%s', expected %d", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK)
+	}
+	if len(responseData.Ticks) != 2 || responseData.Rcpt.ReceiptNum == 0 || responseData.Rcpt.Total != 2000 || responseData.Code != "ERR_NO_MORE_TICKETS" || responseData.Error == "" {
+		tst.Errorf("Sell crossing the ticket DB limit returned %+v, expected the 2 tickets sold, their receipt for 2000, and ERR_NO_MORE_TICKETS", responseData)
+	}
+	if rec.Header().Get("Retry-After") != "" {
+		tst.Errorf("Sell which sold some tickets got Retry-After '%s', expected none", rec.Header().Get("Retry-After"))
+	}
+
+	// Once nothing can be sold, it is refused as before.
+	rec = postSell("/tickets/sell/2", `{"TicketRequests": [[0, 0]]}`)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), `"code":"ERR_NO_MORE_TICKETS"`) || strings.Contains(rec.Body.String(), `"receipt"`) {
+		tst.Errorf("Sell with the ticket DB full got HTTP %d '%s', expected %d with only ERR_NO_MORE_TICKETS", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusTooManyRequests)
+	}
+} // TestSellPartlyFailed
+
+func TestSellChannelSoldOut(tst *testing.T) {
+	// Window 2 sells online, and may only sell 1 of the 4 seats.
+	th, err := tickets.NewTheatre(tickets.Config{Logger: L, MaxMovies: 1, MaxShowings: 1, MaxSeats: 4, MaxWindows: 2})
+	if err != nil {
+		tst.Fatalf("NewTheatre returned error %v", err)
+	}
+	defer th.Close()
+	if err := th.SetOnlineWindows([]int{2}); err != nil {
+		tst.Fatalf("SetOnlineWindows returned error %v", err)
+	}
+	if err := th.SetChannelAllocation(0, 0, 25); err != nil {
+		tst.Fatalf("SetChannelAllocation returned error %v", err)
+	}
+	saved := sell
+	defer func() { sell = saved }()
+	sell = th.Sell
+
+	var responseData struct {
+		Ticks []tickets.Ticket `json:"tickets"`
+		Rcpt  tickets.Receipt  `json:"receipt"`
+		Code  string           `json:"code"`
+	}
+	rec := postSell("/tickets/sell/2", `{"TicketRequests": [[0, 0], [0, 0]]}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &responseData); err != nil || rec.Code != http.StatusOK {
+		tst.Fatalf("Sell past the online share got HTTP %d '%s', expected %d", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusOK)
+	}
+	if len(responseData.Ticks) != 2 || responseData.Ticks[0].SoldOut || !responseData.Ticks[1].SoldOut || responseData.Rcpt.Total != 1000 || responseData.Code != "ERR_CHANNEL_SOLD_OUT" {
+		tst.Errorf("Sell past the online share returned %+v, expected 1 ticket sold, 1 placeholder, a receipt for 1000, and ERR_CHANNEL_SOLD_OUT", responseData)
+	}
+
+	// With nothing sold, it is an error reply, as before.
+	rec = postSell("/tickets/sell/2", `{"TicketRequests": [[0, 0]]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"ERR_CHANNEL_SOLD_OUT"`) {
+		tst.Errorf("Sell with the online share sold got HTTP %d '%s', expected %d with ERR_CHANNEL_SOLD_OUT", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusBadRequest)
+	}
+} // TestSellChannelSoldOut
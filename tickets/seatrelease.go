@@ -0,0 +1,100 @@
+/*****************************************************************************
+
+The seat release delay keeps a cancelled seat off sale for a short while
+before it goes back on sale, so that under contention a seat which is
+cancelled (and then grabbed, and cancelled again, ...) doesn't thrash between
+windows.  A cancelled seat "cools" until the delay runs out, and is then
+released as it would have been straight away without the delay.
+
+The seats given back by AbortSale (and so Txn.Cancel) and VoidLastSale are
+delayed.  The seats of a prepared sale which times out, or of a sale which
+fails part way, are released at once, since nobody just gave them up.
+
+*****************************************************************************/
+
+package tickets
+
+import (
+	"time"
+)
+
+// A coolingSeat is one cancelled seat waiting for the release delay to run
+// out (see SetSeatReleaseDelay).
+type coolingSeat struct {
+	movie   int
+	showing int
+	channel int
+	timer   *time.Timer
+} // coolingSeat
+
+// SetSeatReleaseDelay sets how long a cancelled seat stays off sale before
+// it can be sold again.  Until then, it counts as taken, as though the sale
+// had not been cancelled.  ResetShowing (and CancelShowing) releases the
+// showing's cooling seats at once, with the rest of its seats.
+//
+// The default is 0, which releases cancelled seats straight away.  A
+// negative delay is taken as 0.  Seats which are already cooling keep the
+// delay they were given.
+func (th *Theatre) SetSeatReleaseDelay(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.seatReleaseDelay = d
+	th.L.Printf("Seat release delay set to %v.", d)
+} // SetSeatReleaseDelay
+
+// releaseCancelledSeat gives back one seat of movie m, showing s, taken by
+// reserveSeat for channel ch, once the seat release delay has run out.  The
+// caller must hold resetLock shared, or ticketDBmutex, so that a reset
+// doesn't clear the showing in between.
+func (th *Theatre) releaseCancelledSeat(m int, s int, ch int) {
+	th.configMutex.RLock()
+	delay := th.seatReleaseDelay
+	th.configMutex.RUnlock()
+	if delay == 0 {
+		th.releaseReservedSeat(m, s, ch)
+		return
+	}
+
+	th.txMutex.Lock()
+	defer th.txMutex.Unlock()
+	if th.cooling == nil {
+		th.cooling = make(map[int]*coolingSeat)
+	}
+	th.lastCoolID++
+	coolID := th.lastCoolID
+	th.cooling[coolID] = &coolingSeat{movie: m, showing: s, channel: ch, timer: time.AfterFunc(delay, func() { th.endCooling(coolID) })}
+} // releaseCancelledSeat
+
+// endCooling releases a cooling seat whose delay has run out, unless a reset
+// has already dropped it.
+func (th *Theatre) endCooling(coolID int) {
+	th.resetLock.RLock()
+	defer th.resetLock.RUnlock()
+	th.txMutex.Lock()
+	c, found := th.cooling[coolID]
+	delete(th.cooling, coolID)
+	th.txMutex.Unlock()
+	if !found {
+		return
+	}
+	th.releaseReservedSeat(c.movie, c.showing, c.channel)
+	th.L.Printf("Cancelled seat of movie %d, showing %d released after cooling.", c.movie, c.showing)
+} // endCooling
+
+// dropCooling forgets the cooling seats of one showing of one movie, when
+// the showing is reset.  As for dropPrepared, the seats themselves are not
+// released, since the reset has already zeroed the showing's counters.  The
+// caller must hold resetLock exclusively.
+func (th *Theatre) dropCooling(movie int, showing int) {
+	th.txMutex.Lock()
+	defer th.txMutex.Unlock()
+	for coolID, c := range th.cooling {
+		if c.movie == movie && c.showing == showing {
+			c.timer.Stop()
+			delete(th.cooling, coolID)
+		}
+	}
+} // dropCooling
@@ -0,0 +1,79 @@
+package tickets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetSeatReleaseDelay(tst *testing.T) {
+	const delay = 50 * time.Millisecond
+	// One seat per showing, but enough movies that the ticket DB has room
+	// for all of the Sells made while waiting for the delay to run out.
+	th := newTestTheatre(tst, 5, 100, 2, 1, 2)
+	th.SetSeatReleaseDelay(delay)
+
+	// soldOut tells whether a Sell of one seat of showing s is refused.  If
+	// it isn't, the sale is voided again, so that the seat starts cooling.
+	soldOut := func(s int) bool {
+		ticks, _, err := th.Sell(2, [][2]int{{0, s}}, nil, "a dummy time")
+		if err != nil {
+			tst.Fatalf("Sell of showing %d returned error %v", s, err)
+		}
+		if ticks[0].SoldOut {
+			return true
+		}
+		if _, err := th.VoidLastSale(2); err != nil {
+			tst.Fatalf("VoidLastSale returned error %v", err)
+		}
+		return false
+	}
+
+	ps, err := th.PrepareSale(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil || len(ps.Reserved) != 1 {
+		tst.Fatalf("PrepareSale returned %+v, error %v, expected 1 seat reserved", ps, err)
+	}
+	start := time.Now()
+	if err := th.AbortSale(ps.TxID); err != nil {
+		tst.Fatalf("AbortSale returned error %v", err)
+	}
+	if !soldOut(0) {
+		tst.Errorf("The seat given back by AbortSale was sold again straight away, expected it to cool for %v", delay)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v with a seat cooling", problems)
+	}
+	for soldOut(0) {
+		if time.Since(start) > 20*delay {
+			tst.Fatalf("The seat given back by AbortSale was still not on sale after %v", time.Since(start))
+		}
+		time.Sleep(delay / 5)
+	}
+	if cooled := time.Since(start); cooled < delay {
+		tst.Errorf("The seat given back by AbortSale was on sale again after %v, expected at least %v", cooled, delay)
+	}
+
+	// The seat voided by soldOut is cooling now;  a reset releases it at
+	// once, and it isn't released again when the delay runs out.
+	if !soldOut(0) {
+		tst.Errorf("The seat given back by VoidLastSale was sold again straight away, expected it to cool for %v", delay)
+	}
+	if err := th.ResetShowing(0, 0); err != nil {
+		tst.Fatalf("ResetShowing returned error %v", err)
+	}
+	if ticks, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil || ticks[0].SoldOut {
+		tst.Fatalf("Sell after ResetShowing returned %+v, error %v, expected the seat which was cooling", ticks, err)
+	}
+	time.Sleep(3 * delay)
+	if !soldOut(0) {
+		tst.Errorf("The seat dropped by ResetShowing was released again when its delay ran out")
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v after a reset dropped the cooling seats", problems)
+	}
+
+	// Without a delay, cancelled seats go straight back on sale.
+	th.SetSeatReleaseDelay(-time.Second)
+	if soldOut(1) || soldOut(1) {
+		tst.Errorf("A seat voided with no release delay was not on sale again straight away")
+	}
+} // TestSetSeatReleaseDelay
@@ -14,108 +14,504 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"runtime"
+	"math"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// The JSON names of the exported types' fields are given by their tags, and
+// are part of the tickets service's wire format, so must not be changed.
+
 // The itemized receipt for goods actually sold
 type Receipt struct {
-	Time      interface{}
-	Window    int
-	ItemsSold []RItem
-	Total     int // total amount for all items, in penneys
+	ReceiptNum int         `json:"receiptNum"` // unique, increasing, from 1; see ReceiptByNum
+	Time       interface{} `json:"time"`
+	Window     int         `json:"window"`
+	ItemsSold  []RItem     `json:"itemsSold"`
+	Total      int         `json:"total"`            // total amount for all items, in penneys
+	Footer     []string    `json:"footer,omitempty"` // promotional lines, from SetReceiptFooter
 } // Receipt
 
+// String formats the Receipt as text, for printing:  a heading, one line per
+// item, the total, and then the footer lines (if any).
+func (r Receipt) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Receipt %d    Window %d    %v\n", r.ReceiptNum, r.Window, r.Time)
+	for _, item := range r.ItemsSold {
+		fmt.Fprintf(&b, "  %-36s %10s\n", item.Desc, formatPenneys(item.Penneys))
+	}
+	fmt.Fprintf(&b, "  %-36s %10s\n", "Total", formatPenneys(r.Total))
+	for _, line := range r.Footer {
+		fmt.Fprintf(&b, "%s\n", line)
+	}
+	return b.String()
+} // String
+
+// formatPenneys formats an amount in penneys as dollars and cents, e.g.
+// 1050 as "10.50".
+func formatPenneys(penneys int) string {
+	sign := ""
+	if penneys < 0 {
+		sign, penneys = "-", -penneys
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, penneys/100, penneys%100)
+} // formatPenneys
+
 // One line of the ItemsSold slice in a Receipt
 type RItem struct {
-	Desc    string
-	Penneys int // amount, in penneys
+	Desc    string `json:"desc"`
+	Penneys int    `json:"penneys"` // amount, in penneys
 } // RItem
 
 // A ticket record.
 type Ticket struct {
-	TicketNum int
-	Movie     int
-	Showing   int
-	Price     int
-	SoldOut   bool
-	Goodies   bool
-	Exchanged bool
-	XchOld    string
-	XchNew    string
-	Window    int
+	TicketNum  int       `json:"ticketNum"`
+	Movie      int       `json:"movie"`
+	Showing    int       `json:"showing"`
+	Price      int       `json:"price"`
+	SoldOut    bool      `json:"soldOut"`
+	Goodies    bool      `json:"goodies"`
+	Exchanged  bool      `json:"exchanged"`
+	XchOld     string    `json:"xchOld"`
+	XchNew     string    `json:"xchNew"`
+	Window     int       `json:"window"`
+	Void       bool      `json:"void"`       // the sale was voided (e.g. the showing was reset)
+	CustomerID string    `json:"customerID"` // loyalty ID, from the sale's paymentInfo (see CustomerIDField);  "" if none
+	SoldAt     time.Time `json:"soldAt"`     // when the sale was made, by the theatre's clock
 } // Ticket
 
+// RevenueFilter picks the tickets which Revenue adds up.  A nil Movie,
+// Showing or Window, or a zero From or To, doesn't constrain that field, so
+// RevenueFilter{} picks every ticket.
+type RevenueFilter struct {
+	Movie   *int      // only this movie
+	Showing *int      // only this showing (of each movie picked)
+	Window  *int      // only tickets sold at this window
+	From    time.Time // only tickets sold at or after this time
+	To      time.Time // only tickets sold before this time
+} // RevenueFilter
+
+// CustomerCount is how many tickets one customer has bought, as reported by
+// TopCustomers.
+type CustomerCount struct {
+	CustomerID string `json:"customerID"`
+	Tickets    int    `json:"tickets"`
+} // CustomerCount
+
+// One ticket request which Sell could not fill, as reported by SplitSoldOut.
+type Unavailable struct {
+	Movie   int    `json:"movie"`
+	Showing int    `json:"showing"`
+	Reason  string `json:"reason"`
+} // Unavailable
+
+// One choice on the upgrade menu (see SetUpgradeMenu):  a goodie which may be
+// exchanged for, and what it costs on top of the goodie given up.
+type Upgrade struct {
+	Name       string `json:"name"`
+	PriceDelta int    `json:"priceDelta"` // in penneys; may be 0 (free) or negative (refund)
+} // Upgrade
+
+// One change to the price table (see UpdatePrices):  the new price of one
+// showing of one movie.
+type PriceUpdate struct {
+	Movie   int `json:"movie"`
+	Showing int `json:"showing"`
+	Penneys int `json:"penneys"`
+} // PriceUpdate
+
+// AuditRecord is the record of one attempt to change the theatre's state
+// (a sale, exchange, void, reset or reissue), as given to the AuditSink set
+// by SetAuditSink.  Denied attempts are recorded too, with the error as the
+// Outcome.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`      // the method called, e.g. "Sell"
+	Window  int       `json:"window"`  // the window it was done for;  0 if not known
+	Before  string    `json:"before"`  // what was asked for, or what it was done to
+	After   string    `json:"after"`   // what was done;  "" if nothing was
+	Outcome string    `json:"outcome"` // "ok", or why it failed
+} // AuditRecord
+
+// AuditSink receives an AuditRecord for each change made to a Theatre (see
+// SetAuditSink).  Audit is called synchronously, as each change finishes, and
+// may be called by several goroutines at once.
+type AuditSink interface {
+	Audit(rec AuditRecord)
+} // AuditSink
+
+// The sales channels, as indexes into channelSold.
+const (
+	chWindow = 0 // walk-up windows
+	chOnline = 1 // online sales (see SetOnlineWindows)
+)
+
 const (
 	TRMovie   = 0 // where's the Movie# in a ticket request tuple?
 	TRShowing = 1 // where's the Showing# in a ticket request tuple?
 )
 
-// L is the logger to use.
+// L is the logger used by the default Theatre (the one behind the
+// package-level functions).
 var L log.Logger
 
-// totExchanges is the number of exchanges which have been done.
-var totExchanges int
-
-// maxExchanges is the amount of exchangable goods on hand.
-// Must not be negative.
-var maxExchanges int
-
-// maxMovies is the number of movies the theatre handles simultaneously.
-// Requested movie must be  0 <= requested movie < maxMovies
-var maxMovies int
-
-// maxShowings is the number of times per day that each movie is show.
-// Requested showing must be  0 <= requested showing < maxShowings
-var maxShowings int
-
-// maxSeats is the number of seats in each movie room.
-// If the incremented number of sold seats is greater than this, then the sale
-// is denied due to being sold out.
-var maxSeats int
-
-// maxWindows is the number of ticket windows the theatre has.
-// Request must come from 1 <= window number <= maxWindows
-var maxWindows int
-
-// ticketRoll is a virtual roll of tickets (actually, it is just the ticket
-// numbers).  Pulling one off reserves the corresponding ticketRqstDB entry
-// in a thread-safe manner.  Channels are the only queue primitive in Go.
-var ticketRoll chan int
-
-// ticketRqstDB implements the ticket database internally, since I don't yet
-// know how to use a real database with Go.
-// Note that this DB tracks both sold tickets and ticket requests which
-// couldn't be fulfilled because the requested showing was sold out.
-// This allows management to request a lost-opportunity report (not currently
-// implemented).
-var ticketRqstDB []Ticket
-
-// ticketDBmutex enables the ticket DB to be locked during certain updates.
-var ticketDBmutex sync.Mutex
-
-// seatsSold is used to implement a cache of sold-out counters to reduce DB
-// queries to determine the count of seats sold for a showing (which would
-// otherwise be issued for every ticket request).  There is one counter per
-// showing, per movie.
-//
-// WARNING!  These counters MUST ONLY be accessed with functions of the
-//           sync/atomic package, once ticket sales have openned.
-var seatsSold [][]int32 // sync/atomic doesn't support plain ints
-
-// The salesOpen flag indicates ticket sales have openned.
-// Once this flag is set, all multithreaded access to the tickets system may
-// occur at any time.
-// There doesn't seem to be a way to update a bool with guaranteed visibilty
-// ordering of the update, and no way to query the state of a sync.Once gate,
-// which would be a usable substitute for the bool.
-var salesOpen bool // WARNING!  This MAY be exposed to visibility problems
-
-// initGate ensures ticket system initialization isn't done multiple times.
+// Config holds the settings a Theatre is created with.  See Init for what
+// each one means, and the limits on it.
+type Config struct {
+	Logger       *log.Logger // must not be nil
+	MaxExchanges int
+	MaxMovies    int
+	MaxShowings  int
+	MaxSeats     int
+	MaxWindows   int
+
+	// DBWorker has ticket DB updates made by one DB-owner goroutine (see
+	// dbworker.go), instead of by each sale or exchange locking the DB
+	// itself.  false (the default) keeps the mutex.
+	DBWorker bool
+} // Config
+
+// A Theatre is one independent ticketing system:  its own movies, seats,
+// ticket DB, ticket roll and goodies.  Create one with NewTheatre (or
+// Register, to make it available by name).  The package-level functions
+// (Init, Sell, Exchange, ...) work on a default Theatre, for programs which
+// only need one.
+//
+// A zero Theatre is not open for sales.
+type Theatre struct {
+	// L is the logger to use.
+	L *log.Logger
+
+	// totExchanges is the number of exchanges which have been done (and not
+	// undone).  Only change it with takeGoodie and returnGoodie.
+	totExchanges int
+
+	// goodsMutex protects totExchanges, the ration* fields and the goodie
+	// holds, so that checking for goods on hand and taking one is a single
+	// step.
+	goodsMutex sync.Mutex
+
+	// goodieHolds are the outstanding holds made by ReserveGoodies, by
+	// reservation ID, and lastHoldID numbers them.  heldGoods is their total,
+	// and usedGoods the total of the holds consumed by ConsumeGoodies.
+	goodieHolds map[string]goodieHold
+	lastHoldID  int
+	heldGoods   int
+	usedGoods   int
+
+	// rationStock, rationOver and rationStart are the goodie rationing set by
+	// SetGoodieRationing:  rationStock goods spread evenly over rationOver,
+	// starting at rationStart.  A zero rationStock means no rationing.
+	rationStock int
+	rationOver  time.Duration
+	rationStart time.Time
+
+	// maxExchanges is the amount of exchangable goods on hand.
+	// Must not be negative.
+	maxExchanges int
+
+	// maxMovies is the number of movies the theatre handles simultaneously.
+	// Requested movie must be  0 <= requested movie < maxMovies
+	maxMovies int
+
+	// maxShowings is the number of times per day that each movie is show.
+	// Requested showing must be  0 <= requested showing < maxShowings
+	maxShowings int
+
+	// maxSeats is the number of seats in each movie room.
+	// If the incremented number of sold seats is greater than this, then the
+	// sale is denied due to being sold out.
+	maxSeats int
+
+	// maxWindows is the number of ticket windows the theatre has.
+	// Request must come from 1 <= window number <= maxWindows
+	maxWindows int
+
+	// ticketRoll is a virtual roll of tickets (actually, it is just the
+	// ticket numbers).  Pulling one off reserves the corresponding
+	// ticketRqstDB entry in a thread-safe manner.  Channels are the only
+	// queue primitive in Go.
+	ticketRoll chan int
+
+	// stopRoll is closed by Close, to stop the ticketProducer and have it
+	// close the ticketRoll.
+	stopRoll chan struct{}
+
+	// ticketRqstDB implements the ticket database internally, since I don't
+	// yet know how to use a real database with Go.
+	// Note that this DB tracks both sold tickets and ticket requests which
+	// couldn't be fulfilled because the requested showing was sold out.
+	// This allows management to request a lost-opportunity report (not
+	// currently implemented).
+	ticketRqstDB []Ticket
+
+	// ticketDBmutex enables the ticket DB to be locked during certain
+	// updates.
+	ticketDBmutex sync.Mutex
+
+	// dbUpdates is how updateTicketSale and updateTicketExchange hand their
+	// updates to the dbWorker, if Config.DBWorker was set.  nil means they
+	// lock the DB themselves.
+	dbUpdates chan dbUpdate
+
+	// seatsSold is used to implement a cache of sold-out counters to reduce
+	// DB queries to determine the count of seats sold for a showing (which
+	// would otherwise be issued for every ticket request).  There is one
+	// counter per showing, per movie.
+	//
+	// WARNING!  These counters MUST ONLY be accessed with functions of the
+	//           sync/atomic package, once ticket sales have openned.
+	seatsSold [][]int32 // sync/atomic doesn't support plain ints
+
+	// channelSold counts the seats of each showing which have been sold
+	// through each sales channel (indexed by movie, showing, then chWindow or
+	// chOnline), for SetChannelAllocation.  Unlike seatsSold, refused
+	// requests are never counted.
+	//
+	// WARNING!  These counters MUST ONLY be accessed with functions of the
+	//           sync/atomic package, once ticket sales have openned.
+	channelSold [][][2]int32
+
+	// The salesOpen flag indicates ticket sales have openned.
+	// Once this flag is set, all multithreaded access to the theatre may
+	// occur at any time.
+	// There doesn't seem to be a way to update a bool with guaranteed
+	// visibilty ordering of the update, and no way to query the state of a
+	// sync.Once gate, which would be a usable substitute for the bool.
+	salesOpen bool // WARNING!  This MAY be exposed to visibility problems
+
+	// closeLock keeps Close from shutting the theatre while a sale is in
+	// progress.  Sell holds it shared from its salesOpen check until it
+	// returns, and Close holds it exclusively while it clears salesOpen and
+	// stops the ticketRoll.
+	closeLock sync.RWMutex
+
+	// lastSale tracks the ticket numbers of the most recent sale made at
+	// each window, for VoidLastSale.  It is indexed by window number
+	// (lastSale[0] is not used), and an entry is nil if the window has
+	// nothing to void.
+	lastSale [][]int
+
+	// lastSaleChannel is the sales channel (see channel) which each
+	// window's last sale was made through, so that VoidLastSale gives its
+	// seats back to that channel, even if SetOnlineWindows has changed the
+	// window's channel since.  It is indexed as lastSale is.
+	lastSaleChannel []int
+
+	// lastSaleMutex protects lastSale and lastSaleChannel.
+	lastSaleMutex sync.Mutex
+
+	// paymentCounts is the number of tickets each payment identifier has
+	// bought (or is in the middle of buying) for each showing.
+	paymentCounts map[paymentKey]int
+
+	// paymentMutex protects paymentCounts, so that checking a payer's count
+	// against the limit and adding to it is a single step.
+	paymentMutex sync.Mutex
+
+	// customerCounts is the number of tickets each customer (by customer ID,
+	// see CustomerIDField) has bought, or is in the middle of buying, across
+	// all windows, for SetCustomerTicketLimit.
+	// WARNING!  The counts MUST ONLY be accessed with functions of the
+	//           sync/atomic package.
+	customerCounts map[string]*int32
+
+	// customerMutex protects the customerCounts map itself (not the counts
+	// in it, so that sales for different customers don't wait on each
+	// other).
+	customerMutex sync.Mutex
+
+	// selloutTimes is when each showing sold out (by movie, then showing),
+	// for SelloutTimes.  Only showings which have sold out are in it.
+	selloutTimes map[int]map[int]time.Time
+
+	// selloutMutex protects selloutTimes.
+	selloutMutex sync.Mutex
+
+	// lastReceiptNum is the number given to the most recent Receipt.
+	// WARNING!  It MUST ONLY be accessed with functions of the sync/atomic
+	//           package.
+	lastReceiptNum int64
+
+	// receipts holds every Receipt which Sell has returned, by ReceiptNum,
+	// for ReceiptByNum.
+	receipts map[int]Receipt
+
+	// receiptsMutex protects receipts.
+	receiptsMutex sync.Mutex
+
+	// prepared holds the sales which PrepareSale has reserved seats for, and
+	// which have not yet been committed, aborted or timed out, by TxID.
+	prepared map[int]*preparedSale
+
+	// lastTxID is the TxID of the most recently prepared sale.
+	lastTxID int
+
+	// cooling holds the cancelled seats waiting for the seat release delay
+	// (seatReleaseDelay, as set by SetSeatReleaseDelay) to run out, and
+	// lastCoolID numbers them.
+	cooling          map[int]*coolingSeat
+	lastCoolID       int
+	seatReleaseDelay time.Duration
+
+	// txMutex protects prepared, lastTxID, cooling and lastCoolID.
+	txMutex sync.Mutex
+
+	// resetLock keeps showing resets from interleaving with ticket sales.
+	// Sell holds it shared while it consumes seats and records Tickets, and
+	// ResetShowing holds it exclusively, so a reset never sees a seat which
+	// has been counted in seatsSold but not yet recorded in the
+	// ticketRqstDB.
+	resetLock sync.RWMutex
+
+	// clock tells the theatre what time it is.  It is only ever time.Now,
+	// except in tests, which substitute a fake clock.
+	clock func() time.Time
+
+	// salesOpens and salesCloses are the sales window set by
+	// SetSalesWindow.  A zero value means there is no limit on that side.
+	salesOpens, salesCloses time.Time
+
+	// minPrice and maxPrice bound every ticket price (in penneys), as set by
+	// SetPriceBounds.
+	minPrice, maxPrice int
+
+	// showingPrices is the price table:  the price (in penneys) of each
+	// showing (indexed by movie, then showing), before the price bounds, as
+	// set by UpdatePrices.
+	showingPrices [][]int
+
+	// flatPrice is the price (in penneys) which every ticket is sold at while
+	// flatPriceOn is set, as set by SetFlatPrice.
+	flatPrice   int
+	flatPriceOn bool
+
+	// cashWindows marks the windows (indexed by window number) which round
+	// their prices up to whole dollars, as set by SetWindowCashRounding.
+	cashWindows []bool
+
+	// windowPaymentTypes lists the payment types which each window (indexed
+	// by window number) accepts, as set by SetWindowPaymentTypes.  nil means
+	// the window accepts any payment.
+	windowPaymentTypes [][]string
+
+	// goodieShowings marks the showings (indexed by movie, then showing)
+	// which have been made goodie-eligible by SetGoodieShowings.
+	goodieShowings [][]bool
+
+	// spendGoodieThreshold is the sale total, in penneys, from which every
+	// ticket in the sale gets goodies, as set by SetSpendGoodieThreshold.
+	// Zero means the promotion is off.
+	spendGoodieThreshold int
+
+	// paymentLimit is the most tickets which one payment identifier may buy
+	// for any one showing, as set by SetPaymentLimit.  Zero means no limit.
+	paymentLimit int
+
+	// paymentIDField is the paymentInfo key which identifies the payer for
+	// paymentLimit, as set by SetPaymentIDField.
+	paymentIDField string
+
+	// customerLimit is the most tickets which one customer may buy, across
+	// all windows and showings, as set by SetCustomerTicketLimit.  Zero means
+	// no limit.
+	customerLimit int
+
+	// sellTimeout is how long Sell waits to get going, as set by
+	// SetSellTimeout.  0 means it waits as long as it takes.
+	sellTimeout time.Duration
+
+	// compactStop is closed to stop the background compaction started by
+	// SetCompactInterval.  It is nil while there is none.
+	compactStop chan struct{}
+
+	// receiptFooter is the lines put at the bottom of every Receipt, as set
+	// by SetReceiptFooter.
+	receiptFooter []string
+
+	// upgradeMenu lists the Upgrades allowed for each goodie given up, as
+	// set by SetUpgradeMenu.  If it is nil, then any exchange is allowed,
+	// free of charge.
+	upgradeMenu map[string][]Upgrade
+
+	// readOnly is set by SetReadOnly, to refuse changes (sales, exchanges,
+	// voids and resets) while still answering lookups.
+	readOnly bool
+
+	// blackout marks the showings (indexed by movie, then showing) which
+	// have been taken off public sale by Blackout.
+	blackout [][]bool
+
+	// showingStarts holds when each showing (indexed by movie, then showing)
+	// starts, as set by SetShowingStart.  A zero time means it is not known.
+	showingStarts [][]time.Time
+
+	// reissueGrace is how long after a showing starts Reissue may still be
+	// used, as set by SetReissueGrace.
+	reissueGrace time.Duration
+
+	// clockSkewTolerance is how far a client's localTime may be from the
+	// theatre's clock and still be trusted, as set by SetClockSkewTolerance.
+	// 0 means localTime is never used.
+	clockSkewTolerance time.Duration
+
+	// onlineWindows marks the windows (indexed by window number) which sell
+	// online, as set by SetOnlineWindows.  nil means all of them are walk-up
+	// windows.
+	onlineWindows []bool
+
+	// onlineSeats is how many seats of each showing (indexed by movie, then
+	// showing) are kept for online sales, as set by SetChannelAllocation.
+	// The rest are kept for the walk-up windows.  -1 means the showing's
+	// seats are not allocated, so either channel may sell any of them.
+	onlineSeats [][]int
+
+	// prepareTimeout is how long a prepared sale keeps its seats, as set by
+	// SetPrepareTimeout.
+	prepareTimeout time.Duration
+
+	// shutdownHooks are run by Close, as registered by RegisterShutdownHook.
+	// The slice is never changed in place, so Close can run the hooks
+	// without holding configMutex.
+	shutdownHooks []func() error
+
+	// auditSink is given a record of every change, as set by SetAuditSink.
+	// nil means no records are kept.
+	auditSink AuditSink
+
+	// configMutex protects the settings which can be changed after the
+	// theatre opens (via the Set* methods) from being read by a sale while
+	// they are being changed.
+	configMutex sync.RWMutex
+} // Theatre
+
+// paymentKey identifies one payer's purchases for one showing.
+type paymentKey struct {
+	id      string
+	movie   int
+	showing int
+} // paymentKey
+
+// DefaultPaymentIDField is the paymentInfo key which identifies the payer,
+// until SetPaymentIDField is called.
+const DefaultPaymentIDField = "cardFingerprint"
+
+// CustomerIDField is the paymentInfo key which holds the customer's loyalty
+// ID, which Sell copies into each Ticket's CustomerID.
+const CustomerIDField = "customerID"
+
+// PaymentTypeField is the paymentInfo key which holds the payment type (e.g.
+// "cash" or "card"), which Sell checks against SetWindowPaymentTypes.
+const PaymentTypeField = "paymentType"
+
+// std is the default Theatre, which the package-level functions use.
+var std = newTheatre(&L)
+
+// initGate ensures the default Theatre's initialization isn't done multiple
+// times.
 var initGate sync.Once
 
 /*  Public error constants  */
@@ -123,7 +519,7 @@ var initGate sync.Once
 // ErrXchNotEntitled  is returned when a goodie exchange is denied because the
 // ticket does not entitle the customer to any goodies, either because the movie
 // was sold out, or because it was not purchased at a window which offered free
-// goodies.
+// goodies (nor for a showing made goodie-eligible by SetGoodieShowings).
 var ErrXchNotEntitled = errors.New("Exchange denied:  customer is not entitled to goodies by this ticket")
 
 // ErrXchAlreadyDone is returned when someone tries to make more than one goodie
@@ -131,13 +527,99 @@ var ErrXchNotEntitled = errors.New("Exchange denied:  customer is not entitled t
 var ErrXchAlreadyDone = errors.New("Exchange denied:  a goodie exchange was already made with this ticket")
 
 // ErrXchOutOfGoods is returned if the goodie exchange is otherwise valid, but
-// the theatre has run out of goods to exchange things for.
+// the theatre has run out of goods to exchange things for (including those
+// held by ReserveGoodies), and by ReserveGoodies if too few are left.
 var ErrXchOutOfGoods = errors.New("Exchange denied:  the theatre has run out of exchange goods")
 
+// ErrXchRationed is returned if the goodie exchange is otherwise valid, but
+// so many exchanges have already been made that the allowance set by
+// SetGoodieRationing for the time elapsed so far has been used up.  Trying
+// again later may succeed.
+var ErrXchRationed = errors.New("Exchange denied:  the goodie ration for this time of day has been used up")
+
+// ErrXchNotOnMenu is returned when an exchange is denied because the upgrade
+// menu set by SetUpgradeMenu doesn't allow the requested goodie in exchange
+// for the one being given up.
+var ErrXchNotOnMenu = errors.New("Exchange denied:  that exchange is not on the upgrade menu")
+
+// ErrTicketVoid is returned when someone tries to use a ticket whose sale has
+// been voided (for example, by ResetShowing).
+var ErrTicketVoid = errors.New("Ticket denied:  this ticket has been voided")
+
+// ErrXchNotDone is returned when someone tries to undo a goodie exchange on a
+// ticket which has not been used for one.
+var ErrXchNotDone = errors.New("Undo denied:  no goodie exchange has been made with this ticket")
+
+// ErrNothingToVoid is returned by VoidLastSale when the window has not made
+// a sale since it opened (or since its last sale was voided).
+var ErrNothingToVoid = errors.New("Void denied:  this window has no sale to void")
+
+// ErrBlackout is returned by Sell when a ticket request is for a showing
+// which has been taken off public sale by Blackout (e.g. for a private
+// event), however many seats it has left.
+var ErrBlackout = errors.New("Sell denied:  this showing is blacked out")
+
+// ErrPaymentLimit is returned by Sell when the sale would take the payer past
+// the number of tickets for one showing allowed by SetPaymentLimit.
+var ErrPaymentLimit = errors.New("Sell denied:  this payment has reached its ticket limit for the showing")
+
+// ErrCustomerLimit is returned (wrapped) by Sell when the sale would take the
+// customer past the number of tickets allowed by SetCustomerTicketLimit.
+var ErrCustomerLimit = errors.New("Sell denied:  this customer has reached their ticket limit")
+
+// ErrNoMoreTickets is returned (wrapped) by Sell and Reissue if the ticket
+// numbers have run past the end of the ticketRqstDB, which has a fixed
+// capacity of one record per seat (see Init), plus a few placeholders.
+// Nothing more can be sold until the theatre is restarted.
+var ErrNoMoreTickets = errors.New("no more tickets:  the ticket DB is full")
+
+// ErrPaymentTypeNotAccepted is returned (wrapped) by Sell if the window
+// doesn't take the paymentInfo's payment type (see SetWindowPaymentTypes).
+var ErrPaymentTypeNotAccepted = errors.New("Sell denied:  this window does not accept that payment type")
+
+// ErrChannelSoldOut is returned by Sell if the seats which SetChannelAllocation
+// set aside for the window's sales channel (online or walk-up) have all been
+// sold, even if the other channel still has seats.  The request gets a
+// sold-out placeholder Ticket, as it would if the showing were sold out.
+var ErrChannelSoldOut = errors.New("Sell denied:  this sales channel's share of the showing is sold out")
+
+// ErrNoRequests is returned by Sell if it is given no ticket requests, so
+// that an empty request can't be mistaken for a real sale.  Nothing is sold,
+// and no receipt is made.
+var ErrNoRequests = errors.New("Sell denied:  there are no ticket requests")
+
+// ErrShowingStarted is returned by Reissue if the ticket's showing has
+// already started (allowing for the grace set by SetReissueGrace), and
+// (wrapped) by Sell if any request is for a showing which has started (see
+// SetShowingStart).
+var ErrShowingStarted = errors.New("Ticket denied:  the showing has already started")
+
+// ErrBusy is returned by Sell, and nothing is sold, if a sell timeout has
+// been set by SetSellTimeout, and the sale could not get started within it.
+// Trying again later may succeed.
+var ErrBusy = errors.New("Sell denied:  the ticketing system is too busy right now")
+
+// ErrReadOnly is returned by anything which would change tickets or goodies
+// (Sell, Exchange, UndoExchange, VoidLastSale, ResetShowing, CancelShowing,
+// ReserveGoodies) while the theatre has been put in read-only mode by SetReadOnly.
+var ErrReadOnly = errors.New("Request denied:  the ticketing system is in read-only mode")
+
+// ErrNoSuchReceipt is returned by ReceiptByNum when no Receipt has been given
+// the requested number.
+var ErrNoSuchReceipt = errors.New("Receipt lookup failed:  no receipt has this number")
+
+// ErrSalesNotOpenYet is returned by Sell when the system is up, but the
+// sales window set by SetSalesWindow has not opened yet.
+var ErrSalesNotOpenYet = errors.New("Sell denied:  ticket sales have not opened yet")
+
+// ErrSalesClosed is returned by Sell when the system is up, but the sales
+// window set by SetSalesWindow has already closed.
+var ErrSalesClosed = errors.New("Sell denied:  ticket sales have closed")
+
 /*----------------------------------------------------------------------------
 tickets.Init(L, MaxMovies, MaxShowings, MaxSeats, MaxWindows)
 
-Public function to initialize the ticket sales system.
+Public function to initialize the default Theatre's ticket sales system.
 Uses private function initOnce() to do actual initialization, if and only if
 it has not previously been run.  If called while initOnce() is still running,
 then this called to Init() will wait for the in-progress initOnce() to finish.
@@ -162,7 +644,8 @@ MaxWindows
     The number of ticket windows the theatre has.
     Must be at least 1.
 
-Returns an error if a parameter is invalid, or nil.
+Returns an error if a parameter is invalid, or nil.  If several are invalid,
+the error (made by errors.Join) reports all of them, one per line.
 ----------------------------------------------------------------------------*/
 func Init(parmL *log.Logger, parmMaxExchanges int, parmMaxMovies int, parmMaxShowings int, parmMaxSeats int, parmMaxWindows int) error {
 	// Not sure if this is really the right way to do this, but it doesn't
@@ -180,44 +663,1074 @@ func initOnce(parmL *log.Logger, parmMaxExchanges int, parmMaxMovies int, parmMa
 	if parmL == nil {
 		return errors.New("Missing Logger")
 	}
-	L = *parmL
-	maxExchanges = parmMaxExchanges
-	if maxExchanges < 0 {
-		return errors.New("MaxExchanges " + strconv.Itoa(maxExchanges) + " must not be negative")
+	// Copy the Logger's settings rather than the Logger itself, which holds
+	// a mutex.
+	L.SetOutput(parmL.Writer())
+	L.SetPrefix(parmL.Prefix())
+	L.SetFlags(parmL.Flags())
+	return std.open(Config{
+		Logger:       &L,
+		MaxExchanges: parmMaxExchanges,
+		MaxMovies:    parmMaxMovies,
+		MaxShowings:  parmMaxShowings,
+		MaxSeats:     parmMaxSeats,
+		MaxWindows:   parmMaxWindows,
+	})
+} // initOnce
+
+// NewTheatre creates a Theatre and opens it for sales and exchanges.  It is
+// independent of the default Theatre, and of any other Theatre.
+//
+// Parameters:
+//
+// cfg
+//   The settings for the new Theatre.  See Init for the limits on them.
+//
+// Returns the new Theatre, or nil and an error if a setting is invalid.  As
+// with Init, the error reports every invalid setting.
+func NewTheatre(cfg Config) (*Theatre, error) {
+	if cfg.Logger == nil {
+		return nil, errors.New("Missing Logger")
+	}
+	th := newTheatre(cfg.Logger)
+	if err := th.open(cfg); err != nil {
+		return nil, err
 	}
-	maxMovies = parmMaxMovies
-	if maxMovies < 1 {
-		return errors.New("MaxMovies " + strconv.Itoa(maxMovies) + " must be greater than zero")
+	return th, nil
+} // NewTheatre
+
+// newTheatre returns a Theatre which has its defaults set, but is not yet
+// open.
+func newTheatre(l *log.Logger) *Theatre {
+	return &Theatre{L: l, clock: time.Now, minPrice: 0, maxPrice: math.MaxInt32, paymentIDField: DefaultPaymentIDField, prepareTimeout: DefaultPrepareTimeout}
+} // newTheatre
+
+// open checks cfg, sets up the Theatre's ticket DB and counters, starts its
+// ticket roll, and opens it for sales.  It must only be called once per
+// Theatre.
+func (th *Theatre) open(cfg Config) error {
+	// Check every setting before giving up, so that all of the problems
+	// can be fixed at once.
+	var problems []error
+	if cfg.MaxExchanges < 0 {
+		problems = append(problems, errors.New("MaxExchanges "+strconv.Itoa(cfg.MaxExchanges)+" must not be negative"))
 	}
-	maxShowings = parmMaxShowings
-	if maxShowings < 1 {
-		return errors.New("MaxShowings " + strconv.Itoa(maxMovies) + " must be greater than zero")
+	if cfg.MaxMovies < 1 {
+		problems = append(problems, errors.New("MaxMovies "+strconv.Itoa(cfg.MaxMovies)+" must be greater than zero"))
 	}
-	maxSeats = parmMaxSeats
-	if maxSeats < 1 {
-		return errors.New("MaxSeats " + strconv.Itoa(maxMovies) + " must be greater than zero")
+	if cfg.MaxShowings < 1 {
+		problems = append(problems, errors.New("MaxShowings "+strconv.Itoa(cfg.MaxShowings)+" must be greater than zero"))
 	}
-	maxWindows = parmMaxWindows
-	if maxWindows < 1 {
-		return errors.New("MaxWindows " + strconv.Itoa(maxMovies) + " must be greater than zero")
+	if cfg.MaxSeats < 1 {
+		problems = append(problems, errors.New("MaxSeats "+strconv.Itoa(cfg.MaxSeats)+" must be greater than zero"))
+	}
+	if cfg.MaxWindows < 1 {
+		problems = append(problems, errors.New("MaxWindows "+strconv.Itoa(cfg.MaxWindows)+" must be greater than zero"))
+	}
+	if err := errors.Join(problems...); err != nil {
+		return err
 	}
 
-	seatsSold = make([][]int32, maxMovies, maxMovies)
-	for i, _ := range seatsSold {
-		seatsSold[i] = make([]int32, maxShowings, maxShowings)
+	th.L = cfg.Logger
+	th.maxExchanges = cfg.MaxExchanges
+	th.maxMovies = cfg.MaxMovies
+	th.maxShowings = cfg.MaxShowings
+	th.maxSeats = cfg.MaxSeats
+	th.maxWindows = cfg.MaxWindows
+
+	th.seatsSold = make([][]int32, th.maxMovies, th.maxMovies)
+	th.channelSold = make([][][2]int32, th.maxMovies, th.maxMovies)
+	th.onlineSeats = make([][]int, th.maxMovies, th.maxMovies)
+	for i, _ := range th.seatsSold {
+		th.seatsSold[i] = make([]int32, th.maxShowings, th.maxShowings)
+		th.channelSold[i] = make([][2]int32, th.maxShowings, th.maxShowings)
+		th.onlineSeats[i] = make([]int, th.maxShowings, th.maxShowings)
+		for j, _ := range th.onlineSeats[i] {
+			th.onlineSeats[i][j] = -1
+		}
 	}
 
-	ticketRqstDB = make([]Ticket, maxMovies*maxShowings*maxSeats+1) // ticketRqstDB[0] is not used
+	th.goodieShowings = make([][]bool, th.maxMovies, th.maxMovies)
+	th.blackout = make([][]bool, th.maxMovies, th.maxMovies)
+	th.showingStarts = make([][]time.Time, th.maxMovies, th.maxMovies)
+	th.showingPrices = make([][]int, th.maxMovies, th.maxMovies)
+	for i, _ := range th.goodieShowings {
+		th.goodieShowings[i] = make([]bool, th.maxShowings, th.maxShowings)
+		th.blackout[i] = make([]bool, th.maxShowings, th.maxShowings)
+		th.showingStarts[i] = make([]time.Time, th.maxShowings, th.maxShowings)
+		th.showingPrices[i] = make([]int, th.maxShowings, th.maxShowings)
+		for j, _ := range th.showingPrices[i] {
+			th.showingPrices[i][j] = 1000 // Initially, all tickets cost $10.00
+		}
+	}
 
-	ticketRoll = make(chan int, 5) // small buffer to minimize read response time
-	go ticketProducer(ticketRoll)
+	th.ticketRqstDB = make([]Ticket, th.maxMovies*th.maxShowings*th.maxSeats+1) // ticketRqstDB[0] is not used
+
+	th.lastSale = make([][]int, th.maxWindows+1, th.maxWindows+1)
+	th.lastSaleChannel = make([]int, th.maxWindows+1, th.maxWindows+1)
+	th.cashWindows = make([]bool, th.maxWindows+1, th.maxWindows+1)
+	th.windowPaymentTypes = make([][]string, th.maxWindows+1, th.maxWindows+1)
+
+	th.receipts = make(map[int]Receipt)
+	th.prepared = make(map[int]*preparedSale)
+	th.paymentCounts = make(map[paymentKey]int)
+	th.customerCounts = make(map[string]*int32)
+	th.selloutTimes = make(map[int]map[int]time.Time)
+
+	th.ticketRoll = make(chan int, 5) // small buffer to minimize read response time
+	th.stopRoll = make(chan struct{})
+	go ticketProducer(th.ticketRoll, th.stopRoll)
+	if cfg.DBWorker {
+		th.dbUpdates = make(chan dbUpdate)
+		go th.dbWorker(th.dbUpdates, th.stopRoll)
+	}
 
-	salesOpen = true
-	L.Printf("Ticketing system open for sales and exchanges at %s.", time.Now().Format("2006-01-02t15-04-05z-0700"))
+	th.salesOpen = true
+	th.L.Printf("Ticketing system open for sales and exchanges at %s.", time.Now().Format("2006-01-02t15-04-05z-0700"))
 	return nil
-} // initOnce
+} // open
+
+// Dimensions returns the number of movies, and of showings per movie, which
+// the ticketing system was initialized with.  Valid movie and showing numbers
+// start at 0 and are less than these.
+func (th *Theatre) Dimensions() (movies int, showings int) {
+	return th.maxMovies, th.maxShowings
+} // Dimensions
+
+// Windows returns the number of ticket windows which the ticketing system was
+// initialized with.  Valid window numbers start at 1 and are at most this.
+func (th *Theatre) Windows() int {
+	return th.maxWindows
+} // Windows
+
+// SetSalesWindow sets the times between which Sell will sell tickets (e.g.
+// so that no tickets are sold before the house opens, even though the system
+// is up).  Sales are allowed from open, up to but not including close.  A
+// zero open or close time leaves that side of the window unlimited, so
+// SetSalesWindow(time.Time{}, time.Time{}) removes the restriction.
+//
+// This is separate from the salesOpen (system up) flag, which still applies.
+//
+// Returns an error if close is not after open, or nil.
+func (th *Theatre) SetSalesWindow(open time.Time, close time.Time) error {
+	if !open.IsZero() && !close.IsZero() && !close.After(open) {
+		return fmt.Errorf("SetSalesWindow failed:  close %v is not after open %v", close, open)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.salesOpens, th.salesCloses = open, close
+	th.L.Printf("Sales window set to open %v, close %v.", open, close)
+	return nil
+} // SetSalesWindow
+
+// SetPriceBounds sets the lowest and highest price (in penneys) which any
+// ticket may be sold for.  Whatever the pricing rules come up with is clamped
+// into this range before it is written to the ticket and receipt.  The
+// defaults are 0 and math.MaxInt32.
+//
+// Returns an error if minPenneys is negative or greater than maxPenneys, or
+// nil.
+func (th *Theatre) SetPriceBounds(minPenneys int, maxPenneys int) error {
+	if minPenneys < 0 || minPenneys > maxPenneys {
+		return fmt.Errorf("SetPriceBounds failed:  need 0 <= min (%d) <= max (%d)", minPenneys, maxPenneys)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.minPrice, th.maxPrice = minPenneys, maxPenneys
+	th.L.Printf("Price bounds set to %d to %d penneys.", minPenneys, maxPenneys)
+	return nil
+} // SetPriceBounds
+
+// UpdatePrices changes the prices of some showings (e.g. to reprice the next
+// showing between showings), while sales go on.  The whole batch is checked
+// first, and then applied at once, so each ticket is priced either before
+// any of the batch or after all of it.  The new prices are still clamped by
+// SetPriceBounds, and overridden by SetFlatPrice.  Every showing starts at
+// 1000 penneys ($10.00).
+//
+// Returns an error if any update's movie or showing is out of range, or its
+// price is negative, in which case nothing is changed.  Otherwise nil.
+func (th *Theatre) UpdatePrices(updates []PriceUpdate) error {
+	for i, u := range updates {
+		if u.Movie < 0 || u.Movie >= th.maxMovies || u.Showing < 0 || u.Showing >= th.maxShowings {
+			return fmt.Errorf("UpdatePrices failed:  update %d:  movie %d, showing %d out of range", (i + 1), u.Movie, u.Showing)
+		}
+		if u.Penneys < 0 {
+			return fmt.Errorf("UpdatePrices failed:  update %d:  price %d must not be negative", (i + 1), u.Penneys)
+		}
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	for _, u := range updates {
+		old := th.showingPrices[u.Movie][u.Showing]
+		th.showingPrices[u.Movie][u.Showing] = u.Penneys
+		th.L.Printf("Price of movie %d, showing %d changed from %d to %d penneys.", u.Movie, u.Showing, old, u.Penneys)
+	}
+	return nil
+} // UpdatePrices
+
+// SetFlatPrice turns a flat-price promotion (e.g. "$5 Tuesday") on (enabled
+// is true) or off.  While it is on, every ticket is sold at penneys, which
+// takes precedence over all other pricing, the price bounds set by
+// SetPriceBounds included.
+//
+// Returns an error if penneys is negative, in which case nothing is changed,
+// or nil.
+func (th *Theatre) SetFlatPrice(penneys int, enabled bool) error {
+	if penneys < 0 {
+		return fmt.Errorf("SetFlatPrice failed:  price %d must not be negative", penneys)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.flatPrice, th.flatPriceOn = penneys, enabled
+	if enabled {
+		th.L.Printf("Flat price of %d penneys turned on.", penneys)
+	} else {
+		th.L.Printf("Flat price turned off.")
+	}
+	return nil
+} // SetFlatPrice
+
+// SetWindowCashRounding turns whole-dollar rounding on or off for a cash-only
+// window, so it doesn't have to handle coins.  Each ticket sold at the window
+// is rounded up to the next 100 penneys, after all of the other pricing
+// (including SetPriceBounds and SetFlatPrice), and its receipt item says what
+// it was rounded up from.  Rounding is off for every window by default.
+//
+// Returns an error if window is out of range.  Otherwise nil.
+func (th *Theatre) SetWindowCashRounding(window int, on bool) error {
+	if window < 1 || window > th.maxWindows {
+		return fmt.Errorf("SetWindowCashRounding failed:  window %d out of range.  Must be between 1 and %d, inclusive.", window, th.maxWindows)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.cashWindows[window] = on
+	th.L.Printf("Cash rounding for window %d set to %t.", window, on)
+	return nil
+} // SetWindowCashRounding
+
+// SetWindowPaymentTypes sets the payment types which a window accepts (e.g.
+// []string{"cash"} for a cash-only window).  Sell then refuses any sale at
+// the window whose paymentInfo doesn't have one of them under
+// PaymentTypeField.  An empty types (the default) means the window accepts
+// any payment, and doesn't look at the payment type at all.
+//
+// Returns an error if window is out of range.  Otherwise nil.
+func (th *Theatre) SetWindowPaymentTypes(window int, types []string) error {
+	if window < 1 || window > th.maxWindows {
+		return fmt.Errorf("SetWindowPaymentTypes failed:  window %d out of range.  Must be between 1 and %d, inclusive.", window, th.maxWindows)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	if len(types) == 0 {
+		th.windowPaymentTypes[window] = nil
+		th.L.Printf("Window %d set to accept any payment type.", window)
+		return nil
+	}
+	th.windowPaymentTypes[window] = append([]string(nil), types...)
+	th.L.Printf("Window %d set to accept payment types %v.", window, types)
+	return nil
+} // SetWindowPaymentTypes
+
+// checkPaymentType checks that window accepts paymentInfo's payment type (see
+// SetWindowPaymentTypes), for op (e.g. "Sell").
+//
+// Returns an error wrapping ErrPaymentTypeNotAccepted if it doesn't, or nil.
+func (th *Theatre) checkPaymentType(op string, window int, paymentInfo map[string]interface{}) error {
+	th.configMutex.RLock()
+	accepted := th.windowPaymentTypes[window]
+	th.configMutex.RUnlock()
+	if accepted == nil {
+		return nil
+	}
+
+	declared := ""
+	if v, found := paymentInfo[PaymentTypeField]; found && v != nil {
+		declared = fmt.Sprint(v)
+	}
+	for _, t := range accepted {
+		if t == declared {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s failed:  window %d accepts payment types %v, not %q:  %w", op, window, accepted, declared, ErrPaymentTypeNotAccepted)
+} // checkPaymentType
+
+// cashRound rounds priceInPenneys up to a whole dollar, if window is a cash
+// window (see SetWindowCashRounding).  Otherwise it is returned as it is.
+func (th *Theatre) cashRound(window int, priceInPenneys int) int {
+	th.configMutex.RLock()
+	on := th.cashWindows[window]
+	th.configMutex.RUnlock()
+	if !on {
+		return priceInPenneys
+	}
+	if cents := priceInPenneys % 100; cents > 0 {
+		priceInPenneys += 100 - cents
+	} else if cents < 0 {
+		priceInPenneys -= cents // toward zero is up, for a negative price
+	}
+	return priceInPenneys
+} // cashRound
+
+// saleItem is the receipt item for a Ticket sold, whose price was exact
+// before any cash rounding (see SetWindowCashRounding).
+func saleItem(t Ticket, exact int) RItem {
+	desc := fmt.Sprintf("Movie %d, Showing %d", t.Movie, t.Showing)
+	if t.Price != exact {
+		desc += fmt.Sprintf(" (rounded up from %s for cash)", formatPenneys(exact))
+	}
+	return RItem{Desc: desc, Penneys: t.Price}
+} // saleItem
+
+// SetGoodieShowings sets which showings of a movie come with goodies, for
+// promotions such as opening night.  A ticket gets goodies if it is sold at
+// window 1 OR it is for one of these showings; either condition is enough.
+// Each call replaces the movie's previous set, so an empty showings list
+// turns the promotion off for that movie.
+//
+// Parameters:
+//
+// movie
+//   The movie the promotion is for.
+// showings
+//   The showing numbers which come with goodies.
+//
+// Returns an error if the movie or any showing is out of range (in which case
+// nothing is changed), or nil.
+func (th *Theatre) SetGoodieShowings(movie int, showings []int) error {
+	if movie < 0 || movie >= th.maxMovies {
+		return fmt.Errorf("SetGoodieShowings failed:  movie# %d not between 0 and %d", movie, th.maxMovies)
+	}
+	eligible := make([]bool, th.maxShowings, th.maxShowings)
+	for _, showing := range showings {
+		if showing < 0 || showing >= th.maxShowings {
+			return fmt.Errorf("SetGoodieShowings failed:  showing %d not between 0 and %d", showing, th.maxShowings)
+		}
+		eligible[showing] = true
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.goodieShowings[movie] = eligible
+	th.L.Printf("Goodie showings for movie %d set to %v.", movie, showings)
+	return nil
+} // SetGoodieShowings
+
+// SetSpendGoodieThreshold sets up a promotion which gives goodies with every
+// ticket in a sale whose total (the receipt's Total) is penneys or more.  It
+// adds to the other goodie rules (window 1, and SetGoodieShowings):  a ticket
+// gets goodies if any of them says so.  Sold-out placeholders never get
+// goodies.  A threshold of 0 (the default) turns the promotion off.
+//
+// Returns an error if penneys is negative, or nil.
+func (th *Theatre) SetSpendGoodieThreshold(penneys int) error {
+	if penneys < 0 {
+		return fmt.Errorf("SetSpendGoodieThreshold failed:  threshold %d must not be negative", penneys)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.spendGoodieThreshold = penneys
+	th.L.Printf("Spend goodie threshold set to %s.", formatPenneys(penneys))
+	return nil
+} // SetSpendGoodieThreshold
+
+// grantSpendGoodies gives goodies to the tickets of a sale totalling total
+// penneys, if that meets the SetSpendGoodieThreshold threshold.  It is a
+// second pass over tickets, once the sale has been totalled, and records the
+// goodies in the ticketRqstDB as well as in tickets.
+func (th *Theatre) grantSpendGoodies(tickets []Ticket, total int) {
+	th.configMutex.RLock()
+	threshold := th.spendGoodieThreshold
+	th.configMutex.RUnlock()
+	if threshold <= 0 || total < threshold {
+		return
+	}
+	for i := range tickets {
+		if tickets[i].SoldOut || tickets[i].Goodies {
+			continue
+		}
+		tickets[i].Goodies = true
+		if err := th.updateTicketSale(tickets[i]); err != nil {
+			th.L.Printf("grantSpendGoodies cannot record goodies for ticket %d:  %v", tickets[i].TicketNum, err)
+		}
+	}
+} // grantSpendGoodies
+
+// SetPaymentLimit sets the most tickets which any one payer may buy for any
+// one showing, across all of their sales, to discourage scalping.  The payer
+// is identified by the paymentInfo field set by SetPaymentIDField.  A sale
+// whose paymentInfo has no identifier is not limited.  Sold-out requests
+// don't count towards the limit.  A limit of 0 (the default) turns the check
+// off.
+//
+// Returns an error if perShowing is negative, or nil.
+func (th *Theatre) SetPaymentLimit(perShowing int) error {
+	if perShowing < 0 {
+		return fmt.Errorf("SetPaymentLimit failed:  limit %d must not be negative", perShowing)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.paymentLimit = perShowing
+	th.L.Printf("Payment limit set to %d tickets per showing.", perShowing)
+	return nil
+} // SetPaymentLimit
+
+// SetPaymentIDField sets which paymentInfo field identifies the payer, for
+// SetPaymentLimit.  The default is DefaultPaymentIDField.  The field's value
+// may be of any type, and is compared as printed by fmt.
+//
+// Returns an error if field is empty, or nil.
+func (th *Theatre) SetPaymentIDField(field string) error {
+	if field == "" {
+		return errors.New("SetPaymentIDField failed:  missing field name")
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.paymentIDField = field
+	th.L.Printf("Payment identifier field set to '%s'.", field)
+	return nil
+} // SetPaymentIDField
+
+// paymentID extracts the payer's identifier from paymentInfo, and returns it
+// with the current payment limit.  The identifier is "" if paymentInfo
+// doesn't have one.
+func (th *Theatre) paymentID(paymentInfo map[string]interface{}) (id string, limit int) {
+	th.configMutex.RLock()
+	defer th.configMutex.RUnlock()
+	if v, found := paymentInfo[th.paymentIDField]; found && v != nil {
+		id = fmt.Sprint(v)
+	}
+	return id, th.paymentLimit
+} // paymentID
+
+// reservePayment checks that payer id may buy all of ticketRequests without
+// passing limit for any showing, and if so counts them against the payer
+// straight away, so that concurrent sales can't both squeeze in under the
+// limit.  Requests which don't end up being sold must be handed back with
+// releasePayment.
+//
+// Returns an error wrapping ErrPaymentLimit, or nil.
+func (th *Theatre) reservePayment(id string, limit int, ticketRequests [][2]int) error {
+	wanted := make(map[paymentKey]int)
+	for _, trqst := range ticketRequests {
+		wanted[paymentKey{id, trqst[TRMovie], trqst[TRShowing]}]++
+	}
+	th.paymentMutex.Lock()
+	defer th.paymentMutex.Unlock()
+	for k, n := range wanted {
+		if th.paymentCounts[k]+n > limit {
+			return fmt.Errorf("Sell failed:  movie %d, showing %d:  %d already bought, %d more requested, limit %d:  %w", k.movie, k.showing, th.paymentCounts[k], n, limit, ErrPaymentLimit)
+		}
+	}
+	for k, n := range wanted {
+		th.paymentCounts[k] += n
+	}
+	return nil
+} // reservePayment
+
+// releasePayment hands back one ticket reserved by reservePayment, which
+// was not sold after all.
+func (th *Theatre) releasePayment(id string, m int, s int) {
+	th.paymentMutex.Lock()
+	defer th.paymentMutex.Unlock()
+	k := paymentKey{id, m, s}
+	if th.paymentCounts[k]--; th.paymentCounts[k] <= 0 {
+		delete(th.paymentCounts, k)
+	}
+} // releasePayment
+
+// SetCustomerTicketLimit sets the most tickets which any one customer may
+// buy, across all of their sales at all windows, to stop scalpers from
+// getting round SetPaymentLimit by spreading their buying out.  The customer
+// is identified by the paymentInfo's CustomerIDField, and a sale without one
+// is not limited.  Every customer's tickets are counted, limit or no limit,
+// so a new limit takes account of what was bought before it was set.  As for
+// SetPaymentLimit, sold-out requests don't count, but tickets voided later
+// still do.  A limit of 0 (the default) turns the check off.
+//
+// Returns an error if n is negative, or nil.
+func (th *Theatre) SetCustomerTicketLimit(n int) error {
+	if n < 0 {
+		return fmt.Errorf("SetCustomerTicketLimit failed:  limit %d must not be negative", n)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.customerLimit = n
+	th.L.Printf("Customer ticket limit set to %d tickets.", n)
+	return nil
+} // SetCustomerTicketLimit
+
+// customerCounter returns customer id's count of tickets, for
+// reserveCustomer and releaseCustomer, adding one if it's a new customer.
+func (th *Theatre) customerCounter(id string) *int32 {
+	th.customerMutex.Lock()
+	defer th.customerMutex.Unlock()
+	counter, found := th.customerCounts[id]
+	if !found {
+		counter = new(int32)
+		th.customerCounts[id] = counter
+	}
+	return counter
+} // customerCounter
+
+// reserveCustomer counts n more tickets against customer id straight away,
+// so that concurrent sales at different windows can't both squeeze in under
+// the SetCustomerTicketLimit limit.  Tickets which don't end up being sold
+// must be handed back with releaseCustomer.  op names the caller, for the
+// error message.
+//
+// Returns an error wrapping ErrCustomerLimit, and counts nothing, if the
+// tickets would take the customer past the limit.  Otherwise nil.
+func (th *Theatre) reserveCustomer(op string, id string, n int) error {
+	th.configMutex.RLock()
+	limit := th.customerLimit
+	th.configMutex.RUnlock()
+	counter := th.customerCounter(id)
+	for {
+		bought := atomic.LoadInt32(counter)
+		if limit > 0 && int(bought)+n > limit {
+			return fmt.Errorf("%s failed:  customer %q:  %d already bought, %d more requested, limit %d:  %w", op, id, bought, n, limit, ErrCustomerLimit)
+		}
+		if atomic.CompareAndSwapInt32(counter, bought, bought+int32(n)) {
+			return nil
+		}
+	}
+} // reserveCustomer
+
+// releaseCustomer hands back n tickets reserved by reserveCustomer, which
+// were not sold after all.
+func (th *Theatre) releaseCustomer(id string, n int) {
+	if n > 0 {
+		atomic.AddInt32(th.customerCounter(id), -int32(n))
+	}
+} // releaseCustomer
+
+// SetGoodieRationing spreads exchanges out, so that the goodies last the
+// whole day rather than being used up by the first customers.  From now on,
+// Exchange only succeeds if the exchanges made so far (including any made
+// before rationing was set) don't exceed totalStock pro-rated over the time
+// since SetGoodieRationing was called;  i.e. after half of over, half of
+// totalStock may have been exchanged.  Once over has passed, the allowance is
+// all of totalStock.  Exchanges beyond the allowance are denied with
+// ErrXchRationed.
+//
+// The stock given to Init still limits the total, whatever totalStock is.
+// If totalStock or over is not positive, rationing is turned off.
+func (th *Theatre) SetGoodieRationing(totalStock int, over time.Duration) {
+	if totalStock <= 0 || over <= 0 {
+		totalStock, over = 0, 0
+	}
+	now := th.clock()
+	th.goodsMutex.Lock()
+	defer th.goodsMutex.Unlock()
+	th.rationStock = totalStock
+	th.rationOver = over
+	th.rationStart = now
+	if totalStock == 0 {
+		th.L.Printf("Goodie rationing turned off.")
+	} else {
+		th.L.Printf("Goodie rationing set to %d goodies over %v.", totalStock, over)
+	}
+} // SetGoodieRationing
+
+// rationAllowance returns how many exchanges the goodie rationing allows by
+// now.  The caller must hold goodsMutex, and rationing must be on.
+func (th *Theatre) rationAllowance(now time.Time) int {
+	elapsed := now.Sub(th.rationStart)
+	if elapsed >= th.rationOver {
+		return th.rationStock
+	}
+	if elapsed <= 0 {
+		return 0
+	}
+	return int(float64(th.rationStock) * float64(elapsed) / float64(th.rationOver))
+} // rationAllowance
+
+// SetReceiptFooter sets lines (e.g. promotional messages) to put at the
+// bottom of every Receipt from now on, both in its Footer and in its text
+// from String.  An empty or nil lines removes the footer.
+func (th *Theatre) SetReceiptFooter(lines []string) {
+	var copied []string
+	if len(lines) > 0 {
+		copied = append(copied, lines...)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.receiptFooter = copied
+	th.L.Printf("Receipt footer set to %q.", copied)
+} // SetReceiptFooter
+
+// SetUpgradeMenu sets which goodies may be exchanged for which, and at what
+// price.  menu maps each goodie which may be given up to the Upgrades which
+// may be had for it.  Once a menu is set, Exchange denies anything not on it
+// with ErrXchNotOnMenu, and charges (or refunds) the Upgrade's PriceDelta.
+// A nil menu (the default) allows any exchange, free.
+//
+// Returns an error if an Upgrade has no name, or is listed twice for the same
+// goodie, in which case the menu is not changed.  Otherwise nil.
+func (th *Theatre) SetUpgradeMenu(menu map[string][]Upgrade) error {
+	var copied map[string][]Upgrade
+	if menu != nil {
+		copied = make(map[string][]Upgrade, len(menu))
+		for old, ups := range menu {
+			seen := make(map[string]bool)
+			for _, up := range ups {
+				if up.Name == "" {
+					return fmt.Errorf("SetUpgradeMenu failed:  an upgrade for '%s' has no name", old)
+				}
+				if seen[up.Name] {
+					return fmt.Errorf("SetUpgradeMenu failed:  '%s' is listed more than once for '%s'", up.Name, old)
+				}
+				seen[up.Name] = true
+			}
+			copied[old] = append([]Upgrade(nil), ups...)
+		}
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.upgradeMenu = copied
+	th.L.Printf("Upgrade menu set to %v.", copied)
+	return nil
+} // SetUpgradeMenu
+
+// upgradePrice looks up the price of exchanging oldGoodie for newGoodie on
+// the upgrade menu.  Returns the PriceDelta, or ErrXchNotOnMenu.
+func (th *Theatre) upgradePrice(oldGoodie string, newGoodie string) (int, error) {
+	th.configMutex.RLock()
+	defer th.configMutex.RUnlock()
+	if th.upgradeMenu == nil {
+		return 0, nil
+	}
+	for _, up := range th.upgradeMenu[oldGoodie] {
+		if up.Name == newGoodie {
+			return up.PriceDelta, nil
+		}
+	}
+	return 0, ErrXchNotOnMenu
+} // upgradePrice
+
+// SetSellTimeout sets how long Sell may wait to get going, when it is held up
+// by other work on the ticketing system (such as a ResetShowing), before it
+// gives up with ErrBusy.  This keeps a busy theatre's sales from hanging.
+// The wait is in real time, whatever clock the theatre uses.  A timeout of 0
+// or less (the default) means Sell waits for as long as it takes.
+func (th *Theatre) SetSellTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.sellTimeout = timeout
+	th.L.Printf("Sell timeout set to %v.", timeout)
+} // SetSellTimeout
+
+// rLockReset takes resetLock shared, for Sell.  If a sell timeout has been
+// set by SetSellTimeout, then it only waits that long for the lock.
+//
+// Returns true if the lock was taken, or false if the timeout ran out first.
+func (th *Theatre) rLockReset() bool {
+	th.configMutex.RLock()
+	timeout := th.sellTimeout
+	th.configMutex.RUnlock()
+	if timeout == 0 {
+		th.resetLock.RLock()
+		return true
+	}
+	deadline := time.Now().Add(timeout)
+	for !th.resetLock.TryRLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+} // rLockReset
+
+// SetReadOnly puts the theatre in read-only mode (on is true) or takes it out
+// again (on is false), e.g. for maintenance.  In read-only mode, everything
+// which would change tickets or goodies returns ErrReadOnly, while lookups
+// (TicketsForShowing, ReceiptByNum, SelfCheck, ...) keep working.  This is
+// separate from Close, which shuts the theatre for good.
+func (th *Theatre) SetReadOnly(on bool) {
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.readOnly = on
+	th.L.Printf("Read-only mode set to %v.", on)
+} // SetReadOnly
+
+// isReadOnly tells whether the theatre is in read-only mode (see
+// SetReadOnly).
+func (th *Theatre) isReadOnly() bool {
+	th.configMutex.RLock()
+	defer th.configMutex.RUnlock()
+	return th.readOnly
+} // isReadOnly
+
+// Blackout takes a showing off public sale (on is true), or puts it back on
+// sale (on is false), for private events and the like.  While a showing is
+// blacked out, Sell rejects any sale which includes it with ErrBlackout,
+// whether or not it has seats left.  Tickets already sold are not affected.
+//
+// Returns an error if the movie or showing is out of range, or nil.
+func (th *Theatre) Blackout(movie int, showing int, on bool) error {
+	if movie < 0 || movie >= th.maxMovies {
+		return fmt.Errorf("Blackout failed:  movie# %d not between 0 and %d", movie, th.maxMovies)
+	}
+	if showing < 0 || showing >= th.maxShowings {
+		return fmt.Errorf("Blackout failed:  showing %d not between 0 and %d", showing, th.maxShowings)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.blackout[movie][showing] = on
+	th.L.Printf("Blackout of movie %d, showing %d set to %v.", movie, showing, on)
+	return nil
+} // Blackout
+
+// SetShowingStart records when a showing starts, for Sell and Reissue.  Once
+// it has started, Sell refuses requests for it.  A zero start means the start
+// time is not known, so neither of them is limited by it.
+//
+// Returns an error if the movie or showing is out of range, or nil.
+func (th *Theatre) SetShowingStart(movie int, showing int, start time.Time) error {
+	if movie < 0 || movie >= th.maxMovies {
+		return fmt.Errorf("SetShowingStart failed:  movie# %d not between 0 and %d", movie, th.maxMovies)
+	}
+	if showing < 0 || showing >= th.maxShowings {
+		return fmt.Errorf("SetShowingStart failed:  showing %d not between 0 and %d", showing, th.maxShowings)
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.showingStarts[movie][showing] = start
+	th.L.Printf("Start of movie %d, showing %d set to %v.", movie, showing, start)
+	return nil
+} // SetShowingStart
+
+// StartShowing marks a showing as having started now, as SetShowingStart
+// with the theatre's clock.  It is for testing the start-dependent checks
+// without waiting for a real start time.
+//
+// Returns an error if the movie or showing is out of range, or nil.
+func (th *Theatre) StartShowing(movie int, showing int) error {
+	return th.SetShowingStart(movie, showing, th.clock())
+} // StartShowing
+
+// hasStarted tells whether movie m, showing s, has started (see
+// SetShowingStart), by the theatre's clock.  If clientNow is not zero (see
+// clientTime), and the showing hasn't started by it, then it is taken to have
+// not started yet, so that a client whose clock is a little behind isn't
+// refused.
+func (th *Theatre) hasStarted(m int, s int, clientNow time.Time) bool {
+	th.configMutex.RLock()
+	start := th.showingStarts[m][s]
+	th.configMutex.RUnlock()
+	if start.IsZero() || th.clock().Before(start) {
+		return false
+	}
+	return clientNow.IsZero() || !clientNow.Before(start)
+} // hasStarted
+
+// SetClockSkewTolerance sets how far a client's localTime (see Sell) may be
+// from the theatre's clock and still be trusted for the showing start checks
+// (see SetShowingStart).  A showing which has started by the theatre's clock,
+// but not by a trusted localTime, may still be sold;  so a sale from a client
+// whose clock is up to tolerance behind isn't refused for a showing which
+// started that little while ago.  A localTime further off than tolerance is
+// logged, and not trusted, so only the theatre's clock counts for it.
+//
+// The default is 0, under which localTime is opaque, and never used.  A
+// negative tolerance is taken as 0.
+func (th *Theatre) SetClockSkewTolerance(tolerance time.Duration) {
+	if tolerance < 0 {
+		tolerance = 0
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.clockSkewTolerance = tolerance
+	th.L.Printf("Clock skew tolerance set to %v.", tolerance)
+} // SetClockSkewTolerance
+
+// clientTime returns a client's localTime, as a time, if it can be trusted
+// (see SetClockSkewTolerance):  a time.Time, or a string in RFC 3339 format
+// (as sent by JSON clients), within the tolerance of the theatre's clock.
+// Otherwise it returns the zero time, and logs the skew if localTime is a
+// time, but too far off.  op names the caller, for the log.
+func (th *Theatre) clientTime(op string, localTime interface{}) time.Time {
+	th.configMutex.RLock()
+	tolerance := th.clockSkewTolerance
+	th.configMutex.RUnlock()
+	if tolerance == 0 {
+		return time.Time{}
+	}
+
+	var claimed time.Time
+	switch lt := localTime.(type) {
+	case time.Time:
+		claimed = lt
+	case string:
+		var err error
+		if claimed, err = time.Parse(time.RFC3339Nano, lt); err != nil {
+			return time.Time{}
+		}
+	default:
+		return time.Time{}
+	}
+	skew := claimed.Sub(th.clock())
+	if skew > tolerance || skew < -tolerance {
+		th.L.Printf("%s:  client's local time %s is %v off the theatre's clock, beyond the %v tolerance;  not using it.", op, claimed.Format(time.RFC3339), skew, tolerance)
+		return time.Time{}
+	}
+	return claimed
+} // clientTime
+
+// SetReissueGrace sets how long after a showing starts (see SetShowingStart)
+// a lost ticket for it may still be reissued, e.g. for latecomers.  The
+// default is 0:  no reissues once the showing has started.  A negative grace
+// stops reissues that long before the showing starts.
+func (th *Theatre) SetReissueGrace(grace time.Duration) {
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.reissueGrace = grace
+	th.L.Printf("Reissue grace set to %v.", grace)
+} // SetReissueGrace
+
+// SetOnlineWindows says which windows are the online sales channel, for
+// SetChannelAllocation.  All of the other windows are walk-up windows.  An
+// empty windows allows no online sales, which is the default.
+//
+// Returns an error, and changes nothing, if any window is out of range.
+func (th *Theatre) SetOnlineWindows(windows []int) error {
+	online := make([]bool, th.maxWindows+1, th.maxWindows+1)
+	for _, w := range windows {
+		if w < 1 || w > th.maxWindows {
+			return fmt.Errorf("SetOnlineWindows failed:  window %d out of range.  Must be between 1 and %d, inclusive.", w, th.maxWindows)
+		}
+		online[w] = true
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.onlineWindows = online
+	th.L.Printf("Online windows set to %v.", windows)
+	return nil
+} // SetOnlineWindows
+
+// SetChannelAllocation keeps onlinePct percent of a showing's seats (rounded
+// down) for online sales (see SetOnlineWindows), and the rest for the walk-up
+// windows.  Once a channel has sold its share, Sell refuses its requests for
+// the showing with ErrChannelSoldOut, even if the other channel has seats
+// left.  A negative onlinePct removes the allocation, so that either channel
+// may sell any seat, which is the default.
+//
+// Seats already sold count against their channel's share, so a share which is
+// cut below what has been sold is just sold out.
+//
+// Returns an error if movie, showing or onlinePct (above 100) is out of
+// range.  Otherwise nil.
+func (th *Theatre) SetChannelAllocation(movie int, showing int, onlinePct int) error {
+	if movie < 0 || movie >= th.maxMovies {
+		return fmt.Errorf("SetChannelAllocation failed:  movie# %d not between 0 and %d", movie, th.maxMovies)
+	}
+	if showing < 0 || showing >= th.maxShowings {
+		return fmt.Errorf("SetChannelAllocation failed:  showing %d not between 0 and %d", showing, th.maxShowings)
+	}
+	if onlinePct > 100 {
+		return fmt.Errorf("SetChannelAllocation failed:  online percentage %d is over 100", onlinePct)
+	}
+	seats := -1
+	if onlinePct >= 0 {
+		seats = th.maxSeats * onlinePct / 100
+	}
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.onlineSeats[movie][showing] = seats
+	th.L.Printf("Movie %d, showing %d:  %d seats allocated for online sales (-1 means not allocated).", movie, showing, seats)
+	return nil
+} // SetChannelAllocation
+
+// channel tells which sales channel window sells through.
+func (th *Theatre) channel(window int) int {
+	th.configMutex.RLock()
+	defer th.configMutex.RUnlock()
+	if window < len(th.onlineWindows) && th.onlineWindows[window] {
+		return chOnline
+	}
+	return chWindow
+} // channel
+
+// takeChannelSeat counts one more seat of movie m, showing s, as sold through
+// channel ch.  Returns false, and counts nothing, if the channel has already
+// sold its share of the showing (see SetChannelAllocation).
+func (th *Theatre) takeChannelSeat(m int, s int, ch int) bool {
+	th.configMutex.RLock()
+	share := th.onlineSeats[m][s]
+	th.configMutex.RUnlock()
+	if share >= 0 && ch == chWindow {
+		share = th.maxSeats - share
+	}
+	for {
+		taken := atomic.LoadInt32(&th.channelSold[m][s][ch])
+		if share >= 0 && int(taken) >= share {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&th.channelSold[m][s][ch], taken, taken+1) {
+			return true
+		}
+	}
+} // takeChannelSeat
+
+// releaseChannelSeat gives back one seat which takeChannelSeat counted.
+func (th *Theatre) releaseChannelSeat(m int, s int, ch int) {
+	atomic.AddInt32(&th.channelSold[m][s][ch], -1)
+} // releaseChannelSeat
+
+// reserveSeat takes one seat of movie m, showing s, for sales channel ch,
+// pricing it as checkAvailabilityAndPrice does.  If soldOut is true, then no
+// seat was taken, because the showing is sold out, or (with channelFull)
+// because the channel has sold its share of it.
+func (th *Theatre) reserveSeat(m int, s int, ch int) (priceInPenneys int, soldOut bool, channelFull bool) {
+	if !th.takeChannelSeat(m, s, ch) {
+		return 0, true, true
+	}
+	priceInPenneys, soldOut = th.checkAvailabilityAndPrice(m, s)
+	if soldOut {
+		th.releaseChannelSeat(m, s, ch)
+	}
+	return priceInPenneys, soldOut, false
+} // reserveSeat
+
+// releaseReservedSeat gives back a seat taken by reserveSeat.
+func (th *Theatre) releaseReservedSeat(m int, s int, ch int) {
+	th.releaseSeat(m, s)
+	th.releaseChannelSeat(m, s, ch)
+} // releaseReservedSeat
+
+// ChannelSales reports how many seats of one showing of one movie have been
+// sold at the walk-up windows, and online (see SetOnlineWindows).  Returns 0,
+// 0 if movie or showing is out of range.
+func (th *Theatre) ChannelSales(movie int, showing int) (window int, online int) {
+	if movie < 0 || movie >= th.maxMovies || showing < 0 || showing >= th.maxShowings {
+		return 0, 0
+	}
+	return int(atomic.LoadInt32(&th.channelSold[movie][showing][chWindow])), int(atomic.LoadInt32(&th.channelSold[movie][showing][chOnline]))
+} // ChannelSales
+
+// SetAuditSink sets where the records of changes to the theatre (sales,
+// exchanges, undone exchanges, voids, resets, reissues and compactions) are
+// sent, for compliance.  Each attempt gets one AuditRecord, whether or not it
+// succeeds (except that a Compact which releases nothing isn't recorded, so
+// that SetCompactInterval doesn't fill the records up).  The sink must keep
+// the records itself (e.g. in a file), and should be quick about it, since
+// the change waits for it.  nil, the default, turns the records off.
+func (th *Theatre) SetAuditSink(sink AuditSink) {
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.auditSink = sink
+	th.L.Printf("Audit sink set to %T.", sink)
+} // SetAuditSink
+
+// audit sends the record of one change to the audit sink, if there is one.
+// err is the change's outcome.
+func (th *Theatre) audit(op string, window int, before string, after string, err error) {
+	th.configMutex.RLock()
+	sink := th.auditSink
+	th.configMutex.RUnlock()
+	if sink == nil {
+		return
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = err.Error()
+	}
+	sink.Audit(AuditRecord{Time: th.clock(), Op: op, Window: window, Before: before, After: after, Outcome: outcome})
+} // audit
+
+// ticketNums lists the numbers of the Tickets in ticks which were actually
+// sold, for the audit records.
+func ticketNums(ticks []Ticket) []int {
+	nums := make([]int, 0, len(ticks))
+	for _, t := range ticks {
+		if t.TicketNum != 0 && !t.SoldOut {
+			nums = append(nums, t.TicketNum)
+		}
+	}
+	return nums
+} // ticketNums
+
+// isBlackedOut tells whether movie m, showing s, is blacked out (see
+// Blackout).
+func (th *Theatre) isBlackedOut(m int, s int) bool {
+	th.configMutex.RLock()
+	defer th.configMutex.RUnlock()
+	return th.blackout[m][s]
+} // isBlackedOut
+
+// getsGoodies tells whether a ticket sold at window for movie m, showing s,
+// comes with goodies (see SetGoodieShowings).
+func (th *Theatre) getsGoodies(window int, m int, s int) bool {
+	if window == 1 {
+		return true
+	}
+	th.configMutex.RLock()
+	defer th.configMutex.RUnlock()
+	return th.goodieShowings[m][s]
+} // getsGoodies
+
+// checkSalesWindow returns ErrSalesNotOpenYet or ErrSalesClosed if the clock
+// is outside the sales window, or nil if it is inside it.
+func (th *Theatre) checkSalesWindow() error {
+	th.configMutex.RLock()
+	defer th.configMutex.RUnlock()
+	t := th.clock()
+	if !th.salesOpens.IsZero() && t.Before(th.salesOpens) {
+		return ErrSalesNotOpenYet
+	}
+	if !th.salesCloses.IsZero() && !t.Before(th.salesCloses) {
+		return ErrSalesClosed
+	}
+	return nil
+} // checkSalesWindow
 
-//  TODO :  Orderly shutdown.
+// Close shuts the theatre for sales and exchanges in an orderly way.  It
+// waits for any sales in progress to finish, then marks the system down (so
+// later calls to Sell fail), and stops the ticketRoll.  Then it runs the
+// shutdown hooks (see RegisterShutdownHook), so they see the final state.
+// Calling Close on a theatre which is not open does nothing.
+//
+// Returns the errors from any hooks which failed, joined together, or nil.
+func (th *Theatre) Close() error {
+	th.closeLock.Lock()
+	if !th.salesOpen {
+		th.closeLock.Unlock()
+		return nil
+	}
+	th.salesOpen = false
+	close(th.stopRoll)
+	th.closeLock.Unlock()
+	th.L.Printf("Ticketing system closed at %s.", time.Now().Format("2006-01-02t15-04-05z-0700"))
+
+	th.configMutex.RLock()
+	hooks := th.shutdownHooks
+	th.configMutex.RUnlock()
+	var problems []error
+	for i, hook := range hooks {
+		if err := hook(); err != nil {
+			th.L.Printf("Shutdown hook %d failed:  %v", i+1, err)
+			problems = append(problems, fmt.Errorf("shutdown hook %d failed:  %w", i+1, err))
+		}
+	}
+	return errors.Join(problems...)
+} // Close
+
+// RegisterShutdownHook adds hook to the functions which Close runs once the
+// theatre is closed, e.g. to flush metrics, audit records or other state to
+// disk, so that a clean stop leaves a final snapshot.  The hooks are run in
+// the order they were registered, and all of them are run, even if some
+// fail.  A hook may look at the theatre (e.g. with TicketsForShowing), but
+// can no longer change it.
+func (th *Theatre) RegisterShutdownHook(hook func() error) {
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.shutdownHooks = append(th.shutdownHooks[:len(th.shutdownHooks):len(th.shutdownHooks)], hook)
+} // RegisterShutdownHook
 
 //  TODO :  Panic shutdown.
 
@@ -231,51 +1744,55 @@ func initOnce(parmL *log.Logger, parmMaxExchanges int, parmMaxMovies int, parmMa
 //
 // tRoll
 //   The ticketRoll queue (an output channel of int).
-func ticketProducer(tRoll chan<- int) {
+// stop
+//   Closed when the theatre closes.  The ticketProducer then closes tRoll
+//   and returns.
+func ticketProducer(tRoll chan<- int, stop <-chan struct{}) {
 	defer close(tRoll)
 	for i := 1; ; i++ {
-		tRoll <- i
+		select {
+		case tRoll <- i:
+		case <-stop:
+			return
+		}
 	}
 } //ticketProducer
 
-// nextTicket pulls the next available ticket number off the ticketRoll.  If
-// the ticket number is beyond the end of ticketRqstDB, then it logs a fatal
-// error and terminates the program.  Otherwise, it marks that Ticket allocated
-// in the ticketRqstDB (by setting the TicketNum field in the Ticket), and
-// returns the Ticket to the caller.  If no ticket number is available, then it
-// waits for one.  If an error occurs on the ticketRoll, it panics, because
-// ticket number acquisition is a critical step for all ticket sales.
+// nextTicket pulls the next available ticket number off the ticketRoll,
+// waiting for one if none is ready yet.  It marks that Ticket allocated in
+// the ticketRqstDB (by setting the TicketNum field in the Ticket), and
+// returns a copy of it to the caller.
 //
-// Because the ticketRoll access is threadsafe, and the ticket number pulled
-// off of it is guaranteed unique, it is not necessary to lock the ticketRqstDB
-// while marking the Ticket as allocated (Note:  allocation marking is really
-// only useful for debugging, or if restart functionality is ever added to the
-// ticketing system).
+// Returns an error, instead of a Ticket, if the ticketRoll has been closed
+// (the theatre is closing), or ErrNoMoreTickets (after logging it) if the
+// ticket number is beyond the end of ticketRqstDB, so that the sale fails
+// instead of the whole program, and the DB never grows without bound.
 //
-// T.B.D.  verify that this is returning a copy of the Ticket not a pointer to
-// the ticketRqstDB entry, and fix it if it is returning a pointer to the DB entry.
+// The ticket number pulled off the ticketRoll is guaranteed unique, but the
+// ticketRqstDB is still locked while marking the Ticket as allocated, because
+// the scans of the whole DB (SelfCheck, TicketsForShowing, Compact, ...) read
+// every Ticket's TicketNum meanwhile.
 //
-// After updating a copy of the Ticket, the caller will need to call
-// UpdateTicket to commit the Ticket changes into the DB.
-func nextTicket() (Ticket, error) {
-	t, stillOpen := <-ticketRoll
+// After filling in its copy of the Ticket, the caller will need to call
+// updateTicketSale to commit the Ticket changes into the DB.
+func (th *Theatre) nextTicket() (Ticket, error) {
+	t, stillOpen := <-th.ticketRoll
 	if !stillOpen {
 		return *new(Ticket), errors.New("Cannot get another ticket:  ticketRoll has been closed and drained.")
 	}
 
-	if t >= len(ticketRqstDB) {
-		L.Printf("nextTicket cannot continue:  new number %d exceeds capacity of ticketRqstDB (last element is [%d]).  Aborting ...", t, (len(ticketRqstDB) - 1))
-		b := make([]byte, 16*1024)
-		u := runtime.Stack(b, true)
-		L.Printf("\n%s\n", bytes.NewBuffer(b[:u]).String())
-		os.Exit(1) // panic doesn't work in a web server - it just kills the current transaction
+	if t >= len(th.ticketRqstDB) {
+		th.L.Printf("nextTicket cannot continue:  new number %d exceeds capacity of ticketRqstDB (last element is [%d]).", t, (len(th.ticketRqstDB) - 1))
+		return *new(Ticket), ErrNoMoreTickets
 	}
 
 	// Mark the Ticket as in-use, in case of restart/recovery (not implemented in the initial release).
-	// Nobody else knows this ticket number, yet, so we don't need a lock.
-	ticketRqstDB[t].TicketNum = t
+	// Nobody else knows this ticket number, yet, but the DB scans read it.
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+	th.ticketRqstDB[t].TicketNum = t
 
-	return ticketRqstDB[t], nil
+	return th.ticketRqstDB[t], nil
 } // nextTicket
 
 // readTicket reads the specified ticket from the ticketRqstDB and returns a
@@ -297,32 +1814,35 @@ func nextTicket() (Ticket, error) {
 //   C. No additional sorts of ticket DB updates (especially asynchronous ones)
 //      are implemented.
 // To avoid accidents if the application is changed, locking is already implemented.
-func readTicket(tickNum int) (Ticket, error) {
+func (th *Theatre) readTicket(tickNum int) (Ticket, error) {
 	var t Ticket
 
-	if tickNum < 1 || tickNum >= len(ticketRqstDB) /* Don't need the lock for this, bec. ticketRqstDB cannot shrink. */ {
+	if tickNum < 1 || tickNum >= len(th.ticketRqstDB) /* Don't need the lock for this, bec. ticketRqstDB cannot shrink. */ {
 		return t, fmt.Errorf("readTicket failed:  tickNum %d outside the DB", tickNum)
 	}
 
-	ticketDBmutex.Lock()
-	defer ticketDBmutex.Unlock()
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
 	switch tickNum {
 	case 0:
 		return t, fmt.Errorf("readTicket failed:  Ticket %d is not allocated.", tickNum)
-	case ticketRqstDB[tickNum].TicketNum:
+	case th.ticketRqstDB[tickNum].TicketNum:
 		// Good  --  it's an active Ticket
-		t.TicketNum = ticketRqstDB[tickNum].TicketNum
-		t.Movie = ticketRqstDB[tickNum].Movie
-		t.Showing = ticketRqstDB[tickNum].Showing
-		t.Price = ticketRqstDB[tickNum].Price
-		t.SoldOut = ticketRqstDB[tickNum].SoldOut
-		t.Goodies = ticketRqstDB[tickNum].Goodies
-		t.Exchanged = ticketRqstDB[tickNum].Exchanged
-		t.XchOld = ticketRqstDB[tickNum].XchOld
-		t.XchNew = ticketRqstDB[tickNum].XchNew
-		t.Window = ticketRqstDB[tickNum].Window
+		t.TicketNum = th.ticketRqstDB[tickNum].TicketNum
+		t.Movie = th.ticketRqstDB[tickNum].Movie
+		t.Showing = th.ticketRqstDB[tickNum].Showing
+		t.Price = th.ticketRqstDB[tickNum].Price
+		t.SoldOut = th.ticketRqstDB[tickNum].SoldOut
+		t.Goodies = th.ticketRqstDB[tickNum].Goodies
+		t.Exchanged = th.ticketRqstDB[tickNum].Exchanged
+		t.XchOld = th.ticketRqstDB[tickNum].XchOld
+		t.XchNew = th.ticketRqstDB[tickNum].XchNew
+		t.Window = th.ticketRqstDB[tickNum].Window
+		t.Void = th.ticketRqstDB[tickNum].Void
+		t.SoldAt = th.ticketRqstDB[tickNum].SoldAt
+		t.CustomerID = th.ticketRqstDB[tickNum].CustomerID
 	default:
-		panic(fmt.Sprintf("readTicket failed:  tickNum %d requested, but Ticket marked with TicketNum %d  --  either the database is corrupted or there is an internal logic error  --  NOTIFY SUPPORT!  System shutting down.", tickNum, ticketRqstDB[tickNum].TicketNum))
+		panic(fmt.Sprintf("readTicket failed:  tickNum %d requested, but Ticket marked with TicketNum %d  --  either the database is corrupted or there is an internal logic error  --  NOTIFY SUPPORT!  System shutting down.", tickNum, th.ticketRqstDB[tickNum].TicketNum))
 	}
 	return t, nil
 } // readTicket
@@ -355,14 +1875,134 @@ func readTicket(tickNum int) (Ticket, error) {
 //
 // Note:  if the processing of this ticket request fails after
 // checkAvailabilityAndPrice(), then the seat in that showing may go unsold.
-func checkAvailabilityAndPrice(m int, s int) (priceInPenneys int, soldOut bool) {
-	priceInPenneys = 1000 // Initially, all tickets cost $10.00
+func (th *Theatre) checkAvailabilityAndPrice(m int, s int) (priceInPenneys int, soldOut bool) {
+	th.configMutex.RLock()
+	flat, flatOn := th.flatPrice, th.flatPriceOn
+	listed := th.showingPrices[m][s]
+	th.configMutex.RUnlock()
+
+	if flatOn {
+		priceInPenneys = flat
+		th.L.Printf("Flat price override active:  movie %d, showing %d priced at %d.", m, s, flat)
+	} else {
+		priceInPenneys = listed // from the price table (see UpdatePrices)
+
+		priceInPenneys = th.clampPrice(priceInPenneys, m, s)
+	}
 
-	consumedSeatsIncludingThisOne := atomic.AddInt32(&seatsSold[m][s], 1)
+	consumedSeatsIncludingThisOne := atomic.AddInt32(&th.seatsSold[m][s], 1)
+	if int(consumedSeatsIncludingThisOne) == th.maxSeats {
+		th.recordSellout(m, s)
+	}
 
-	return priceInPenneys, (int(consumedSeatsIncludingThisOne) > maxSeats)
+	return priceInPenneys, (int(consumedSeatsIncludingThisOne) > th.maxSeats)
 } // checkAvailabilityAndPrice
 
+// recordSellout records the theatre's clock time as when movie m, showing s
+// sold out, unless it has already sold out before (see SelloutTimes).
+func (th *Theatre) recordSellout(m int, s int) {
+	now := th.clock()
+	th.selloutMutex.Lock()
+	defer th.selloutMutex.Unlock()
+	if _, ok := th.selloutTimes[m][s]; ok {
+		return
+	}
+	if th.selloutTimes[m] == nil {
+		th.selloutTimes[m] = make(map[int]time.Time)
+	}
+	th.selloutTimes[m][s] = now
+	th.L.Printf("Movie %d, showing %d sold out at %s.", m, s, now.Format(time.RFC3339))
+} // recordSellout
+
+// SelloutTimes reports when each showing sold out, by the theatre's clock,
+// for demand analytics (e.g. how fast opening night sold out).  The map is
+// indexed by movie, then showing, and only has the showings which have sold
+// out.  A showing's time is when its last seat was first taken:  seats given
+// back later (by a void, say) don't clear it, and selling out again doesn't
+// change it.  Only ResetShowing clears it.  The map is a copy, which the
+// caller may keep.
+func (th *Theatre) SelloutTimes() map[int]map[int]time.Time {
+	th.selloutMutex.Lock()
+	defer th.selloutMutex.Unlock()
+	times := make(map[int]map[int]time.Time, len(th.selloutTimes))
+	for m, showings := range th.selloutTimes {
+		times[m] = make(map[int]time.Time, len(showings))
+		for s, t := range showings {
+			times[m][s] = t
+		}
+	}
+	return times
+} // SelloutTimes
+
+// NextAvailableShowing finds the earliest showing of movie which can still
+// be sold:  one which has not started (see SetShowingStart), is not blacked
+// out, and has a seat left.  Showings are taken in the order of their start
+// times;  those with no start time set come after those with one, in
+// showing number order (which is the order they are shown in, each day).
+//
+// Returns the showing, and found true;  or found false if no showing of
+// movie is available, or movie is out of range.
+func (th *Theatre) NextAvailableShowing(movie int) (showing int, found bool) {
+	if movie < 0 || movie >= th.maxMovies {
+		return 0, false
+	}
+	var bestStart time.Time
+	for s := 0; s < th.maxShowings; s++ {
+		if th.hasStarted(movie, s, time.Time{}) || th.isBlackedOut(movie, s) || int(atomic.LoadInt32(&th.seatsSold[movie][s])) >= th.maxSeats {
+			continue
+		}
+		th.configMutex.RLock()
+		start := th.showingStarts[movie][s]
+		th.configMutex.RUnlock()
+		if !found || (!start.IsZero() && (bestStart.IsZero() || start.Before(bestStart))) {
+			showing, bestStart, found = s, start, true
+		}
+	}
+	return showing, found
+} // NextAvailableShowing
+
+// releaseSeat gives back one seat which was consumed by a Ticket which is no
+// longer sold, so that it can be sold again.  Once a showing is sold out, its
+// seatsSold counter also counts the requests which were refused, so those
+// are dropped when the counter is brought back below maxSeats.
+func (th *Theatre) releaseSeat(m int, s int) {
+	for {
+		consumed := atomic.LoadInt32(&th.seatsSold[m][s])
+		released := consumed
+		if int(released) > th.maxSeats {
+			released = int32(th.maxSeats)
+		}
+		if released > 0 {
+			released--
+		}
+		if atomic.CompareAndSwapInt32(&th.seatsSold[m][s], consumed, released) {
+			return
+		}
+	}
+} // releaseSeat
+
+// clampPrice keeps a computed ticket price within the bounds set by
+// SetPriceBounds, as a safety net against pricing rules combining to give a
+// negative or absurd price.  Logs a message if the price had to be clamped.
+// m and s identify the showing, for the log.
+func (th *Theatre) clampPrice(priceInPenneys int, m int, s int) int {
+	th.configMutex.RLock()
+	lo, hi := th.minPrice, th.maxPrice
+	th.configMutex.RUnlock()
+
+	clamped := priceInPenneys
+	if clamped < lo {
+		clamped = lo
+	}
+	if clamped > hi {
+		clamped = hi
+	}
+	if clamped != priceInPenneys {
+		th.L.Printf("Price %d for movie %d, showing %d clamped to %d (bounds are %d to %d).", priceInPenneys, m, s, clamped, lo, hi)
+	}
+	return clamped
+} // clampPrice
+
 // updateTicketExchange uses the supplied Ticket struct to update the product
 // exchange fields in the ticket in ticketRqstDB with the same ticket number.
 // updateTicketExchange and updateTicketSale are kept as separate functions,
@@ -374,20 +2014,41 @@ func checkAvailabilityAndPrice(m int, s int) (priceInPenneys int, soldOut bool)
 //
 // See doc. for readTicket(), and TODO comments in Exchange(), for locking
 // considerations.
-func updateTicketExchange(t Ticket) error {
-	if t.TicketNum < 1 || t.TicketNum >= len(ticketRqstDB) /* Don't need the lock for this, bec. ticketRqstDB cannot shrink. */ {
+func (th *Theatre) updateTicketExchange(t Ticket) error {
+	if t.TicketNum < 1 || t.TicketNum >= len(th.ticketRqstDB) /* Don't need the lock for this, bec. ticketRqstDB cannot shrink. */ {
 		return fmt.Errorf("updateTicketExchange failed:  TicketNum %d outside the DB", t.TicketNum)
 	}
 
-	ticketDBmutex.Lock()
-	defer ticketDBmutex.Unlock()
-	ticketRqstDB[t.TicketNum].Exchanged = t.Exchanged
-	ticketRqstDB[t.TicketNum].XchOld = t.XchOld
-	ticketRqstDB[t.TicketNum].XchNew = t.XchNew
-
+	th.updateTicket(dbUpdate{t: t, exchange: true})
 	return nil
 } // updateTicketExchange
 
+// applyExchange copies the product exchange fields of t into ticketRqstDB.
+// The caller must hold ticketDBmutex.
+func (th *Theatre) applyExchange(t Ticket) {
+	th.ticketRqstDB[t.TicketNum].Exchanged = t.Exchanged
+	th.ticketRqstDB[t.TicketNum].XchOld = t.XchOld
+	th.ticketRqstDB[t.TicketNum].XchNew = t.XchNew
+} // applyExchange
+
+// clearExchange clears the product exchange fields of ticket tickNum (which
+// must be in the DB), under ticketDBmutex, if an exchange was made with it and
+// it hasn't been voided.  Returns the goodie which was exchanged, or
+// ErrXchNotDone or ErrTicketVoid if there was no exchange to clear.
+func (th *Theatre) clearExchange(tickNum int) (xchOld string, err error) {
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+	switch {
+	case !th.ticketRqstDB[tickNum].Exchanged:
+		return "", ErrXchNotDone
+	case th.ticketRqstDB[tickNum].Void:
+		return "", ErrTicketVoid
+	}
+	xchOld = th.ticketRqstDB[tickNum].XchOld
+	th.applyExchange(Ticket{TicketNum: tickNum})
+	return xchOld, nil
+} // clearExchange
+
 // updateTicketSale uses the supplied Ticket struct to update the sales-related
 // fields of the Ticket in the ticketRqstDB with the same ticket number.  The
 // product exchange fields are IGNORED (c/f updateTicketExchange).  This
@@ -396,24 +2057,38 @@ func updateTicketExchange(t Ticket) error {
 //
 // See doc. for readTicket(), and TODO comments in Exchange(), for locking
 // considerations.
-func updateTicketSale(t Ticket) error {
-	if t.TicketNum < 1 || t.TicketNum >= len(ticketRqstDB) /* Don't need the lock for this, bec. ticketRqstDB cannot shrink. */ {
+func (th *Theatre) updateTicketSale(t Ticket) error {
+	if t.TicketNum < 1 || t.TicketNum >= len(th.ticketRqstDB) /* Don't need the lock for this, bec. ticketRqstDB cannot shrink. */ {
 		return fmt.Errorf("updateTicketSale failed:  TicketNum %d outside the DB", t.TicketNum)
 	}
 
-	ticketDBmutex.Lock()
-	defer ticketDBmutex.Unlock()
-	ticketRqstDB[t.TicketNum].Movie = t.Movie
-	ticketRqstDB[t.TicketNum].Showing = t.Showing
-	ticketRqstDB[t.TicketNum].Price = t.Price
-	ticketRqstDB[t.TicketNum].SoldOut = t.SoldOut
-	ticketRqstDB[t.TicketNum].Goodies = t.Goodies
-	ticketRqstDB[t.TicketNum].Window = t.Window
-
+	th.updateTicket(dbUpdate{t: t})
 	return nil
 } // updateTicketSale
 
-// Exchange is used to exchange goodies which the customer has received.
+// applySale copies the sales-related fields of t into ticketRqstDB.  The
+// caller must hold ticketDBmutex.
+func (th *Theatre) applySale(t Ticket) {
+	th.ticketRqstDB[t.TicketNum].Movie = t.Movie
+	th.ticketRqstDB[t.TicketNum].Showing = t.Showing
+	th.ticketRqstDB[t.TicketNum].Price = t.Price
+	th.ticketRqstDB[t.TicketNum].SoldOut = t.SoldOut
+	th.ticketRqstDB[t.TicketNum].Goodies = t.Goodies
+	th.ticketRqstDB[t.TicketNum].Window = t.Window
+	th.ticketRqstDB[t.TicketNum].CustomerID = t.CustomerID
+	th.ticketRqstDB[t.TicketNum].SoldAt = t.SoldAt
+} // applySale
+
+// Exchange is used to exchange goodies which the customer has received.  It
+// is ExchangeWithReceipt, for callers which don't need the receipt.
+func (th *Theatre) Exchange(tickNum int, oldGoodie string, newGoodie string) error {
+	_, err := th.ExchangeWithReceipt(tickNum, oldGoodie, newGoodie)
+	return err
+} // Exchange
+
+// ExchangeWithReceipt is used to exchange goodies which the customer has
+// received.  If an upgrade menu has been set (see SetUpgradeMenu), then the
+// exchange must be on it, and its price delta is charged on the receipt.
 //
 // Parameters:
 //
@@ -427,40 +2102,70 @@ func updateTicketSale(t Ticket) error {
 //
 // Returns:
 //
+// receipt
+//    A receipt for the exchange, at the window the ticket was sold at, with
+//    the upgrade's price delta (which is 0 for a free exchange).  Receipts
+//    for exchanges which charge (or refund) something are numbered along
+//    with those for sales.  A free exchange's receipt is not recorded, and
+//    has ReceiptNum 0, so that it doesn't leave a gap in the numbering.
 // err
 //    if the exchange was denied, the ticket number was invalid, or some system
 //    error occurred while recording the exchange. See the doc. for the ErrXch*
 //    variables for possible data-driven reasons for denial of the exchange.
 //
 //    An error is also returned if the salesOpen (system up) flag is not set.
-func Exchange(tickNum int, oldGoodie string, newGoodie string) error {
+func (th *Theatre) ExchangeWithReceipt(tickNum int, oldGoodie string, newGoodie string) (receipt Receipt, err error) {
+
+	var t Ticket
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("ticket %d:  %s", tickNum, newGoodie)
+			if receipt.ReceiptNum != 0 {
+				after += fmt.Sprintf(", receipt %d", receipt.ReceiptNum)
+			}
+		}
+		th.audit("Exchange", t.Window, fmt.Sprintf("ticket %d:  %s", tickNum, oldGoodie), after, err)
+	}()
 
-	if !salesOpen {
-		return errors.New("Exchange failed:  ticketing system is down.")
+	if !th.salesOpen {
+		return receipt, errors.New("Exchange failed:  ticketing system is down.")
 	}
 
-	t, err := readTicket(tickNum)
+	if th.isReadOnly() {
+		return receipt, ErrReadOnly
+	}
+
+	t, err = th.readTicket(tickNum)
 	if err != nil {
-		return fmt.Errorf("Exchange failed:  %v", err)
+		return receipt, fmt.Errorf("Exchange failed:  %v", err)
+	}
+
+	if t.Void {
+		return receipt, ErrTicketVoid
 	}
 
 	if t.SoldOut {
-		return ErrXchNotEntitled
+		return receipt, ErrXchNotEntitled
 	}
 
 	if !t.Goodies {
-		return ErrXchNotEntitled
+		return receipt, ErrXchNotEntitled
 	}
 
 	if t.Exchanged {
-		return ErrXchAlreadyDone
+		return receipt, ErrXchAlreadyDone
 	}
 
-	if totExchanges >= maxExchanges {
-		return ErrXchOutOfGoods
+	delta, err := th.upgradePrice(oldGoodie, newGoodie)
+	if err != nil {
+		return receipt, err
+	}
+
+	if err := th.takeGoodie(); err != nil {
+		return receipt, err
 	}
 
-	totExchanges++
 	t.Exchanged = true
 	t.XchOld = oldGoodie
 	t.XchNew = newGoodie
@@ -476,13 +2181,98 @@ func Exchange(tickNum int, oldGoodie string, newGoodie string) error {
 	//  TODO :  into the simulation, but should be fixed before anyone tries to
 	//  TODO :  make a "real" ticketing system.
 	//  TODO :  See doc. for readTicket() for further discussion of DB locking.
-	err = updateTicketExchange(t)
+	err = th.updateTicketExchange(t)
 	if err != nil {
-		return fmt.Errorf("Exchange failed:  %v", err)
+		th.returnGoodie()
+		return receipt, fmt.Errorf("Exchange failed:  %v", err)
+	}
+
+	receipt = Receipt{Time: th.clock(), Window: t.Window, Total: delta}
+	receipt.ItemsSold = []RItem{{Desc: fmt.Sprintf("Ticket %d:  exchange %s for %s", t.TicketNum, oldGoodie, newGoodie), Penneys: delta}}
+	if delta != 0 {
+		th.recordReceipt(&receipt)
 	}
+	return receipt, nil
+} // ExchangeWithReceipt
+
+// UndoExchange reverses the goodie exchange made with a ticket (e.g. the
+// customer changed their mind), and puts the exchanged goods back in stock,
+// so they are available for another exchange.  The ticket's goodie
+// entitlement is left as it was, so it can be used for a new exchange.
+//
+// There is no low-stock notification in the initial implementation, so
+// nothing else needs to be told that the stock has gone back up.
+//
+// Parameters:
+//
+// tickNum
+//    The ticket number of the exchange to be undone.
+//
+// Returns ErrXchNotDone if no exchange was made with the ticket,
+// ErrTicketVoid if the ticket has been voided (its goodie is not taken back),
+// an error if the ticket number is invalid or the salesOpen (system up) flag
+// is not set, or nil.
+func (th *Theatre) UndoExchange(tickNum int) (err error) {
+
+	var t Ticket
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("ticket %d:  %s", tickNum, t.XchOld)
+		}
+		th.audit("UndoExchange", t.Window, fmt.Sprintf("ticket %d", tickNum), after, err)
+	}()
+
+	if !th.salesOpen {
+		return errors.New("UndoExchange failed:  ticketing system is down.")
+	}
+
+	if th.isReadOnly() {
+		return ErrReadOnly
+	}
+
+	t, err = th.readTicket(tickNum)
+	if err != nil {
+		return fmt.Errorf("UndoExchange failed:  %v", err)
+	}
+
+	// Check and clear the exchange in one step, so that of two undos of the
+	// same exchange, only one puts the goods back in stock.
+	xchOld, err := th.clearExchange(tickNum)
+	if err != nil {
+		return err
+	}
+	t.XchOld = xchOld
+	th.returnGoodie()
 
 	return nil
-} // Exchange
+} // UndoExchange
+
+// takeGoodie takes one item of exchange goods out of stock.  Returns
+// ErrXchOutOfGoods if the stock has run out, or ErrXchRationed if the goodie
+// rationing doesn't allow another exchange yet, in which case nothing is
+// taken.  Otherwise nil.
+func (th *Theatre) takeGoodie() error {
+	now := th.clock()
+	th.goodsMutex.Lock()
+	defer th.goodsMutex.Unlock()
+	if th.goodsOnHand() < 1 {
+		return ErrXchOutOfGoods
+	}
+	if th.rationStock > 0 && th.totExchanges >= th.rationAllowance(now) {
+		return ErrXchRationed
+	}
+	th.totExchanges++
+	return nil
+} // takeGoodie
+
+// returnGoodie puts one item of exchange goods back into stock, after an
+// exchange is undone or could not be recorded.
+func (th *Theatre) returnGoodie() {
+	th.goodsMutex.Lock()
+	defer th.goodsMutex.Unlock()
+	th.totExchanges--
+} // returnGoodie
 
 // Sell is used when a customer requests to buy one or more tickets.
 // This may result in any combination of compleated sales and sales denied
@@ -493,87 +2283,903 @@ func Exchange(tickNum int, oldGoodie string, newGoodie string) error {
 // window
 //    Which ticket window is conducting this sale.
 // ticketRequests
-//    One or more ticket requests.  Each request consists of a [2]int, which
-//    gives the movie and showing numbers.
+//    One or more ticket requests (none is an error).  Each request consists
+//    of a [2]int, which gives the movie and showing numbers.
 // paymentInfo
-//    Reserved for future use.  The exact composition of this data is not
+//    The payer's details.  The only fields used are the one which identifies
+//    the payer (see SetPaymentIDField), for SetPaymentLimit, the
+//    PaymentTypeField, for SetWindowPaymentTypes, and the CustomerIDField,
+//    which is copied into the Tickets and counted for
+//    SetCustomerTicketLimit.  The rest of the composition of this data is not
 //    currently defined.
 // localTime
 //    Copied as-is as the receipt's timestamp.
-//    In the initial implementation, this field is opaque, and no other
-//    use or validation is made of it.  In the future, its format should
-//    be defined and it should be validated against a clock sync limit.
+//    Unless SetClockSkewTolerance has been set, this field is opaque, and
+//    no other use or validation is made of it.  If it has, then a
+//    time.Time, or a string in RFC 3339 format, within the tolerance is
+//    also used for the showing start checks.
 //
 // Returns:
 //
 // tickets
 //    An array of Tickets, which can be a mix of valid tickets and sold-out
-//    placeholders.  This is in the same order as the incoming ticketRequests.
+//    placeholders.  This is in the same order as the incoming ticketRequests:
+//    tickets[i] is always the answer to ticketRequests[i], however other
+//    sales are interleaved with this one, so callers may match them up by
+//    position.  If the sale stops part way (see err), then tickets is cut
+//    short, but still lines up with the requests it has.
+//    A valid ticket comes with goodies if it was sold at window 1, or is for
+//    a showing set by SetGoodieShowings, or the sale's total meets the
+//    SetSpendGoodieThreshold threshold.
 // receipt
-//    A receipt for whatever tickets were actually sold, if any.
+//    A receipt for whatever tickets were actually sold, if any.  A sale which
+//    succeeds gets the next ReceiptNum, and its receipt can be fetched again
+//    with ReceiptByNum.
 // err
 //    Any error which occurred.
 //      * The window and movie information is validated, but the initial imple-
-//        mentation ignores the paymentInfo and localTime fields.
-//      * Any internal error which occurs is passed through.
+//        mentation ignores the localTime field (except as above), and all of
+//        the paymentInfo field except for the payer's identifier, the
+//        customer ID, and the payment type.
+//      * An error wrapping ErrPaymentTypeNotAccepted is returned, and
+//        nothing is sold, if the window doesn't accept the payment type
+//        (see SetWindowPaymentTypes).
+//      * An error wrapping ErrPaymentLimit is returned, and nothing is sold,
+//        if the sale would take the payer past the SetPaymentLimit limit.
+//      * An error wrapping ErrCustomerLimit is returned, and nothing is sold,
+//        if the sale would take the customer past the SetCustomerTicketLimit
+//        limit.
+//      * Any internal error which occurs is passed through.  If it happens
+//        part way through the requests (e.g. because the theatre is being
+//        closed and the ticketRoll has run out), then the tickets before the
+//        failed request are still sold, and are returned with their receipt.
 //      * An error is returned if the salesOpen (system up) flag is not set.
-func Sell(window int, ticketRequests [][2]int, paymentInfo map[string]interface{}, localTime interface{}) (tickets []Ticket, receipt Receipt, err error) {
-
-	if !salesOpen {
+//      * ErrSalesNotOpenYet or ErrSalesClosed is returned if the clock is
+//        outside the window set by SetSalesWindow.
+//      * An error wrapping ErrBlackout is returned, and nothing is sold, if
+//        any request is for a showing which is blacked out (see Blackout).
+//        This is distinct from a sold-out showing, which only gets a
+//        placeholder Ticket.
+//      * An error wrapping ErrShowingStarted is returned, and nothing is
+//        sold, if any request is for a showing which has started (see
+//        SetShowingStart, and SetClockSkewTolerance).
+//      * ErrNoRequests is returned if ticketRequests is empty.
+//      * ErrChannelSoldOut is returned (wrapped) if any request is refused
+//        because the window's sales channel has sold its share of the
+//        showing (see SetChannelAllocation).  Unlike the errors above, the
+//        other requests are still filled, and everything is returned as
+//        usual, with the refused request as a sold-out placeholder.
+//      * ErrBusy is returned, and nothing is sold, if the sale could not get
+//        going within the timeout set by SetSellTimeout.
+func (th *Theatre) Sell(window int, ticketRequests [][2]int, paymentInfo map[string]interface{}, localTime interface{}) (tickets []Ticket, receipt Receipt, err error) {
+
+	th.closeLock.RLock()
+	defer th.closeLock.RUnlock()
+	defer func() {
+		after := ""
+		if receipt.ReceiptNum != 0 {
+			after = fmt.Sprintf("receipt %d:  tickets %v, total %s", receipt.ReceiptNum, ticketNums(tickets), formatPenneys(receipt.Total))
+		}
+		th.audit("Sell", window, fmt.Sprintf("requests %v", ticketRequests), after, err)
+	}()
+	if !th.salesOpen {
 		return tickets, receipt, errors.New("Sell failed:  ticketing system is down.")
 	}
 
+	if th.isReadOnly() {
+		return tickets, receipt, ErrReadOnly
+	}
+
+	if err := th.checkSalesWindow(); err != nil {
+		return tickets, receipt, err
+	}
+
 	var totalprice = 0 // in penneys
 	tickets = make([]Ticket, len(ticketRequests), len(ticketRequests))
 	receipt = Receipt{Time: localTime, Window: window}
 
-	if window < 1 || window > maxWindows {
-		return tickets, receipt, fmt.Errorf("Sell failed:  window %d out of range.  Must be between 1 and %d, inclusive.", window, maxWindows)
+	// Edit as much as possible before consuming tickets in the DB
+	if err := th.checkRequests("Sell", window, ticketRequests, localTime); err != nil {
+		return tickets, receipt, err
+	}
+	if err := th.checkPaymentType("Sell", window, paymentInfo); err != nil {
+		return tickets, receipt, err
 	}
-	// Validation and use of localTime not currently implemented.
-	// Validation and use of paymentInfo not currently implemented.
+	// localTime is only used for the showing start checks (see
+	// SetClockSkewTolerance).  paymentInfo is only used to identify the payer, for SetPaymentLimit,
+	// the customer, for TicketsByCustomer, and the payment type, for
+	// SetWindowPaymentTypes.
+	customerID := paymentCustomerID(paymentInfo)
+
+	if !th.rLockReset() {
+		return nil, receipt, ErrBusy
+	}
+	defer th.resetLock.RUnlock()
 
-	// Edit as much as possible before consuming tickets in the DB
-	for i, trqst := range ticketRequests {
-		movie := trqst[TRMovie]
-		if movie < 0 || movie >= maxMovies {
-			return tickets, receipt, fmt.Errorf("Sell failed:  ticket request %d:  movie# %d not between 0 and %d", (i + 1), movie, maxMovies)
+	if customerID != "" {
+		if err := th.reserveCustomer("Sell", customerID, len(ticketRequests)); err != nil {
+			return tickets, receipt, err
 		}
-		showing := trqst[TRShowing]
-		if showing < 0 || showing >= maxShowings {
-			return tickets, receipt, fmt.Errorf("Sell failed:  ticket request %d:  showing %d not between 0 and %d", (i + 1), showing, maxShowings)
+	}
+	payer, limit := th.paymentID(paymentInfo)
+	if payer != "" && limit > 0 {
+		if err := th.reservePayment(payer, limit, ticketRequests); err != nil {
+			if customerID != "" {
+				th.releaseCustomer(customerID, len(ticketRequests))
+			}
+			return tickets, receipt, err
 		}
+		defer func() {
+			for i, trqst := range ticketRequests {
+				if i >= len(tickets) || tickets[i].SoldOut {
+					th.releasePayment(payer, trqst[TRMovie], trqst[TRShowing])
+				}
+			}
+		}()
+	}
+	if customerID != "" {
+		defer func() {
+			unsold := 0
+			for i := range ticketRequests {
+				if i >= len(tickets) || tickets[i].SoldOut {
+					unsold++
+				}
+			}
+			th.releaseCustomer(customerID, unsold)
+		}()
 	}
 
+	sold := make([]int, 0, len(ticketRequests))
+	soldAt := th.clock()
+	ch := th.channel(window)
+	// If a request fails part way through, then the requests before it have
+	// already been committed to the DB, so they are kept and returned, along
+	// with a receipt for them, and the error.
+	var loopErr error
 	for i, trqst := range ticketRequests {
-		t, err := nextTicket()
+		t, err := th.nextTicket()
 		if err != nil {
-			return tickets, receipt, fmt.Errorf("Sell failed:  ticket request %d:  %v", (i + 1), err)
+			loopErr = fmt.Errorf("Sell failed:  ticket request %d:  %w", (i + 1), err)
+			tickets = tickets[:i]
+			break
 		}
 		t.Movie = trqst[TRMovie]
 		t.Showing = trqst[TRShowing]
 		t.Window = window
-		t.Price, t.SoldOut = checkAvailabilityAndPrice(t.Movie, t.Showing)
+		t.CustomerID = customerID
+		t.SoldAt = soldAt
+		var channelFull bool
+		t.Price, t.SoldOut, channelFull = th.reserveSeat(t.Movie, t.Showing, ch)
+		exact := t.Price
 		if !t.SoldOut {
-			totalprice += t.Price
-			if window == 1 {
-				t.Goodies = true
+			t.Price = th.cashRound(window, t.Price)
+		}
+		if channelFull {
+			if loopErr == nil {
+				loopErr = fmt.Errorf("Sell failed:  ticket request %d:  movie %d, showing %d:  %w", (i + 1), t.Movie, t.Showing, ErrChannelSoldOut)
 			}
-			item := RItem{Desc: fmt.Sprintf("Movie %d, Showing %d", t.Movie, t.Showing), Penneys: t.Price}
-			receipt.ItemsSold = append(receipt.ItemsSold, item)
 		}
-		tickets[i] = t
-		err = updateTicketSale(t)
+		if !t.SoldOut {
+			t.Goodies = th.getsGoodies(window, t.Movie, t.Showing)
+		}
+		err = th.updateTicketSale(t)
 		if err != nil {
-			return tickets, receipt, fmt.Errorf("Sell failed:  ticket request %d:  %v", (i + 1), err)
+			// This request's seat isn't recorded anywhere, so give it back.
+			if !t.SoldOut {
+				th.releaseReservedSeat(t.Movie, t.Showing, ch)
+			}
+			loopErr = fmt.Errorf("Sell failed:  ticket request %d:  %v", (i + 1), err)
+			tickets = tickets[:i]
+			break
+		}
+		// Clients match the tickets to their requests by position (see the doc.
+		// above), so a ticket must go in its own request's slot, whatever order
+		// the seats are taken in.
+		tickets[i] = t
+		if !t.SoldOut {
+			totalprice += t.Price
+			receipt.ItemsSold = append(receipt.ItemsSold, saleItem(t, exact))
+			sold = append(sold, t.TicketNum)
 		}
 
 	}
 
 	receipt.Total = totalprice
+	th.grantSpendGoodies(tickets, receipt.Total)
+	th.recordReceipt(&receipt)
+
+	if len(sold) > 0 {
+		th.lastSaleMutex.Lock()
+		th.lastSale[window] = sold
+		th.lastSaleChannel[window] = ch
+		th.lastSaleMutex.Unlock()
+	}
 
-	L.Printf("Sell for window %d returning:\n\ttickets:\n%+v\n\treceipt:\n%+v\n", window, tickets, receipt)
+	th.L.Printf("Sell for window %d returning:\n\ttickets:\n%+v\n\treceipt:\n%+v\n", window, tickets, receipt)
 
-	return tickets, receipt, nil
+	return tickets, receipt, loopErr
 
 } // Sell
+
+// checkRequests makes the checks on a window and its ticket requests which
+// can be made before any seats are taken.  op names the caller, for the error
+// messages.  localTime is the client's, for the showing start checks (see
+// SetClockSkewTolerance);  nil if there isn't one.
+//
+// Returns an error if the window or any movie or showing is out of range, or
+// wrapping ErrBlackout if any showing is blacked out, or ErrShowingStarted
+// if any showing has started, or ErrNoRequests if there are no requests.
+// Otherwise nil.
+func (th *Theatre) checkRequests(op string, window int, ticketRequests [][2]int, localTime interface{}) error {
+	if window < 1 || window > th.maxWindows {
+		return fmt.Errorf("%s failed:  window %d out of range.  Must be between 1 and %d, inclusive.", op, window, th.maxWindows)
+	}
+	if len(ticketRequests) == 0 {
+		return ErrNoRequests
+	}
+	clientNow := th.clientTime(op, localTime)
+	for i, trqst := range ticketRequests {
+		movie := trqst[TRMovie]
+		if movie < 0 || movie >= th.maxMovies {
+			return fmt.Errorf("%s failed:  ticket request %d:  movie# %d not between 0 and %d", op, (i + 1), movie, th.maxMovies)
+		}
+		showing := trqst[TRShowing]
+		if showing < 0 || showing >= th.maxShowings {
+			return fmt.Errorf("%s failed:  ticket request %d:  showing %d not between 0 and %d", op, (i + 1), showing, th.maxShowings)
+		}
+		if th.isBlackedOut(movie, showing) {
+			return fmt.Errorf("%s failed:  ticket request %d:  movie %d, showing %d:  %w", op, (i + 1), movie, showing, ErrBlackout)
+		}
+		if th.hasStarted(movie, showing, clientNow) {
+			return fmt.Errorf("%s failed:  ticket request %d:  movie %d, showing %d:  %w", op, (i + 1), movie, showing, ErrShowingStarted)
+		}
+	}
+	return nil
+} // checkRequests
+
+// paymentCustomerID gets the customer's loyalty ID (see CustomerIDField)
+// from a sale's paymentInfo.  Returns "" if there isn't one.
+func paymentCustomerID(paymentInfo map[string]interface{}) string {
+	if v, found := paymentInfo[CustomerIDField]; found && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+} // paymentCustomerID
+
+// recordReceipt gives receipt the next ReceiptNum and the current footer,
+// and keeps a copy of it for ReceiptByNum.
+func (th *Theatre) recordReceipt(receipt *Receipt) {
+	th.configMutex.RLock()
+	receipt.Footer = th.receiptFooter // never changed in place, so can be shared
+	th.configMutex.RUnlock()
+	receipt.ReceiptNum = int(atomic.AddInt64(&th.lastReceiptNum, 1))
+	th.receiptsMutex.Lock()
+	defer th.receiptsMutex.Unlock()
+	th.receipts[receipt.ReceiptNum] = *receipt
+} // recordReceipt
+
+// ReceiptByNum looks up a Receipt which Sell (or ExchangeWithReceipt) has
+// returned, by its ReceiptNum, for reconciling with payment systems.  The
+// Receipt is as it was when the sale was made, even if its tickets have since
+// been voided.
+//
+// Returns the Receipt, or an empty Receipt and ErrNoSuchReceipt.
+func (th *Theatre) ReceiptByNum(receiptNum int) (Receipt, error) {
+	th.receiptsMutex.Lock()
+	defer th.receiptsMutex.Unlock()
+	r, found := th.receipts[receiptNum]
+	if !found {
+		return Receipt{}, ErrNoSuchReceipt
+	}
+	return r, nil
+} // ReceiptByNum
+
+// ResetShowing clears one showing of one movie (e.g. because it has been
+// rescheduled), without affecting any other showing.  The showing's seatsSold
+// counter is zeroed, its sellout time (see SelloutTimes) is cleared, and
+// every Ticket sold for it is marked Void, so that it can no longer be used
+// for exchanges.  Sold-out placeholders are left alone.
+//
+// The reset holds resetLock exclusively, so it waits for any Sell which is
+// in progress to finish, and holds off new ones until it is done.
+//
+// Seats reserved for the showing by prepared sales (see PrepareSale) are
+// dropped from them too, so committing one of those sales gets no Ticket for
+// the showing.
+//
+// Parameters:
+//
+// movie
+//    The movie number of the showing to be reset.
+// showing
+//    The showing to be reset.
+//
+// Returns an error if the indices are out of range, or if the salesOpen
+// (system up) flag is not set.  Otherwise nil.
+func (th *Theatre) ResetShowing(movie int, showing int) (err error) {
+	voided := 0
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("%d tickets voided", voided)
+		}
+		th.audit("ResetShowing", 0, fmt.Sprintf("movie %d, showing %d", movie, showing), after, err)
+	}()
+	if !th.salesOpen {
+		return errors.New("ResetShowing failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return ErrReadOnly
+	}
+	if movie < 0 || movie >= th.maxMovies {
+		return fmt.Errorf("ResetShowing failed:  movie# %d not between 0 and %d", movie, th.maxMovies)
+	}
+	if showing < 0 || showing >= th.maxShowings {
+		return fmt.Errorf("ResetShowing failed:  showing %d not between 0 and %d", showing, th.maxShowings)
+	}
+
+	th.resetLock.Lock()
+	defer th.resetLock.Unlock()
+	voided = len(th.clearShowing(movie, showing))
+
+	th.L.Printf("ResetShowing voided %d tickets for movie %d, showing %d.", voided, movie, showing)
+	return nil
+} // ResetShowing
+
+// clearShowing does the work of ResetShowing, for it and CancelShowing:  it
+// marks every Ticket sold for movie, showing Void, zeroes its seat counters,
+// drops its seats from prepared sales, and clears its sellout time.  The
+// caller must hold resetLock exclusively.
+//
+// Returns copies of the Tickets voided, in ticket number order.
+func (th *Theatre) clearShowing(movie int, showing int) []Ticket {
+	var voided []Ticket
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+	for i := 1; i < len(th.ticketRqstDB); i++ {
+		t := &th.ticketRqstDB[i]
+		if t.TicketNum == i && t.Movie == movie && t.Showing == showing && !t.SoldOut && !t.Void {
+			t.Void = true
+			voided = append(voided, *t)
+		}
+	}
+	atomic.StoreInt32(&th.seatsSold[movie][showing], 0)
+	atomic.StoreInt32(&th.channelSold[movie][showing][chWindow], 0)
+	atomic.StoreInt32(&th.channelSold[movie][showing][chOnline], 0)
+	th.dropPrepared(movie, showing)
+	th.dropCooling(movie, showing)
+	th.selloutMutex.Lock()
+	delete(th.selloutTimes[movie], showing)
+	th.selloutMutex.Unlock()
+	return voided
+} // clearShowing
+
+// CancelShowing cancels one showing of one movie entirely (e.g. the
+// projector broke), and refunds everybody who bought a ticket for it.  The
+// showing is blacked out (see Blackout), so that no more tickets are sold
+// for it, and then cleared as ResetShowing clears it:  every Ticket sold for
+// it is marked Void, and its seats are released.
+//
+// Each window which sold tickets for the showing gets one refund Receipt,
+// with a line for each of its Tickets, at minus the price paid, so the
+// Receipt's Total is the (negative) amount to pay back.  The refunds are
+// recorded, and can be fetched with ReceiptByNum, like any other Receipt.
+// Since the Tickets are Void, they no longer count in Revenue.
+//
+// Goodie exchanges already made with the Tickets are not undone, as for
+// VoidLastSale:  the customers keep the goods they were given, and no
+// upgrade charge is refunded.
+//
+// Like ResetShowing, the cancel waits for any Sell in progress to finish.
+// The showing stays blacked out until Blackout is called to lift it.
+//
+// Returns the refund Receipts, in window order (none if no tickets had been
+// sold), or an error if the indices are out of range, or if the salesOpen
+// (system up) flag is not set, or ErrReadOnly.
+func (th *Theatre) CancelShowing(movie int, showing int) (refunds []Receipt, err error) {
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("%d refunds", len(refunds))
+		}
+		th.audit("CancelShowing", 0, fmt.Sprintf("movie %d, showing %d", movie, showing), after, err)
+	}()
+	if !th.salesOpen {
+		return nil, errors.New("CancelShowing failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if err := th.Blackout(movie, showing, true); err != nil {
+		return nil, fmt.Errorf("CancelShowing failed:  %v", err)
+	}
+
+	th.resetLock.Lock()
+	voided := th.clearShowing(movie, showing)
+	th.resetLock.Unlock()
+
+	byWindow := make([]*Receipt, th.maxWindows+1, th.maxWindows+1)
+	now := th.clock()
+	for _, t := range voided {
+		r := byWindow[t.Window]
+		if r == nil {
+			r = &Receipt{Time: now, Window: t.Window}
+			byWindow[t.Window] = r
+		}
+		r.ItemsSold = append(r.ItemsSold, RItem{Desc: fmt.Sprintf("Refund ticket %d:  Movie %d, Showing %d", t.TicketNum, t.Movie, t.Showing), Penneys: -t.Price})
+		r.Total -= t.Price
+	}
+	refunds = make([]Receipt, 0)
+	for _, r := range byWindow {
+		if r != nil {
+			th.recordReceipt(r)
+			refunds = append(refunds, *r)
+		}
+	}
+
+	th.L.Printf("CancelShowing refunded %d tickets for movie %d, showing %d.", len(voided), movie, showing)
+	return refunds, nil
+} // CancelShowing
+
+// SplitSoldOut reshapes the tickets returned by Sell for clients which would
+// rather not have the sold-out placeholders interleaved with the real tickets.
+// The Tickets actually sold are returned in sold, and each placeholder is
+// reported in unavailable, with the reason it could not be sold.  Both keep
+// the relative order of the original tickets slice.
+func SplitSoldOut(tickets []Ticket) (sold []Ticket, unavailable []Unavailable) {
+	sold = make([]Ticket, 0, len(tickets))
+	unavailable = make([]Unavailable, 0)
+	for _, t := range tickets {
+		if t.SoldOut {
+			unavailable = append(unavailable, Unavailable{Movie: t.Movie, Showing: t.Showing, Reason: "sold out"})
+		} else {
+			sold = append(sold, t)
+		}
+	}
+	return sold, unavailable
+} // SplitSoldOut
+
+// SelfCheck verifies the internal consistency of the ticketing system, as a
+// diagnostic for database corruption and for bugs in new features.  The
+// invariants checked are:
+//   * every allocated Ticket in the ticketRqstDB is at the index given by its
+//     TicketNum,
+//   * each showing's seatsSold counter matches the number of Tickets actually
+//     sold (i.e. not sold-out placeholders, and not void) for that showing
+//     (the counter also counts refused requests once the showing is sold
+//     out, so it is capped at maxSeats for the comparison),
+//   * totExchanges equals the number of Tickets marked Exchanged, and
+//   * no Ticket is both a sold-out placeholder and entitled to goodies.
+//
+// Sales are held off (by resetLock) while the check runs, so that a sale
+// which is part way through recording its Tickets does not show up as a
+// false alarm.
+//
+// Returns one error per violation found, or an empty slice if all is well.
+func (th *Theatre) SelfCheck() []error {
+	problems := make([]error, 0)
+
+	th.resetLock.Lock()
+	defer th.resetLock.Unlock()
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+
+	sold := make([][]int, th.maxMovies, th.maxMovies)
+	for i, _ := range sold {
+		sold[i] = make([]int, th.maxShowings, th.maxShowings)
+	}
+	exchanged := 0
+	for i := 1; i < len(th.ticketRqstDB); i++ {
+		t := th.ticketRqstDB[i]
+		if t.TicketNum == 0 {
+			continue // not allocated
+		}
+		if t.TicketNum != i {
+			problems = append(problems, fmt.Errorf("ticketRqstDB[%d] is marked with TicketNum %d", i, t.TicketNum))
+			continue
+		}
+		if t.Movie < 0 || t.Movie >= th.maxMovies || t.Showing < 0 || t.Showing >= th.maxShowings {
+			problems = append(problems, fmt.Errorf("Ticket %d is for movie %d, showing %d, which does not exist", i, t.Movie, t.Showing))
+			continue
+		}
+		if t.SoldOut && t.Goodies {
+			problems = append(problems, fmt.Errorf("Ticket %d is a sold-out placeholder, but is entitled to goodies", i))
+		}
+		if !t.SoldOut && !t.Void {
+			sold[t.Movie][t.Showing]++
+		}
+		if t.Exchanged {
+			exchanged++
+		}
+	}
+	// Seats reserved by prepared sales, or cooling after a cancel (see
+	// SetSeatReleaseDelay), are counted as sold, too.
+	th.txMutex.Lock()
+	for _, ps := range th.prepared {
+		for _, trqst := range ps.Reserved {
+			sold[trqst[TRMovie]][trqst[TRShowing]]++
+		}
+	}
+	for _, c := range th.cooling {
+		sold[c.movie][c.showing]++
+	}
+	th.txMutex.Unlock()
+
+	for m := 0; m < th.maxMovies; m++ {
+		for s := 0; s < th.maxShowings; s++ {
+			counted := int(atomic.LoadInt32(&th.seatsSold[m][s]))
+			if counted > th.maxSeats {
+				counted = th.maxSeats
+			}
+			if counted != sold[m][s] {
+				problems = append(problems, fmt.Errorf("Movie %d, showing %d:  seatsSold counts %d seats, but %d Tickets are sold", m, s, counted, sold[m][s]))
+			}
+			walkUp, online := th.ChannelSales(m, s)
+			if walkUp+online != sold[m][s] {
+				problems = append(problems, fmt.Errorf("Movie %d, showing %d:  channelSold counts %d window and %d online seats, but %d Tickets are sold", m, s, walkUp, online, sold[m][s]))
+			}
+		}
+	}
+
+	th.goodsMutex.Lock()
+	if exchanged != th.totExchanges {
+		problems = append(problems, fmt.Errorf("totExchanges is %d, but %d Tickets are marked Exchanged", th.totExchanges, exchanged))
+	}
+	th.goodsMutex.Unlock()
+
+	return problems
+} // SelfCheck
+
+// TicketsForShowing returns copies of all of the Tickets sold for one showing
+// of one movie (e.g. for a will-call desk).  Sold-out placeholders and void
+// tickets are left out, since they don't get anyone into the showing.  The
+// Tickets are taken from a snapshot of the ticketRqstDB, in ticket number
+// order.
+//
+// Returns nil if movie or showing is out of range.
+func (th *Theatre) TicketsForShowing(movie int, showing int) []Ticket {
+	if movie < 0 || movie >= th.maxMovies || showing < 0 || showing >= th.maxShowings {
+		return nil
+	}
+
+	ticks := make([]Ticket, 0)
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+	for i := 1; i < len(th.ticketRqstDB); i++ {
+		t := th.ticketRqstDB[i]
+		if t.TicketNum == i && t.Movie == movie && t.Showing == showing && !t.SoldOut && !t.Void {
+			ticks = append(ticks, t)
+		}
+	}
+	return ticks
+} // TicketsForShowing
+
+// Reissue replaces a ticket which the customer has lost, before its showing
+// starts.  The original is voided, so that it can't be used if it turns up,
+// and a replacement with a new ticket number is issued for the same movie,
+// showing, price, window, customer and goodie entitlement.  The seat stays
+// sold, to the replacement.  A goodie exchange made with the original moves
+// to the replacement, so that it can't be made again.  If the original was
+// in its window's last sale, then VoidLastSale voids the replacement instead.
+//
+// Parameters:
+//
+// tickNum
+//    The number of the lost ticket.
+//
+// Returns:
+//
+// replacement
+//    The replacement Ticket.
+// err
+//    ErrShowingStarted if the showing has started (allowing for the grace
+//    set by SetReissueGrace), ErrTicketVoid if the ticket has been voided
+//    (e.g. already reissued), ErrReadOnly in read-only mode, or an error if
+//    the ticket number is invalid or is a sold-out placeholder, the system is
+//    down, or the replacement couldn't be issued.  Otherwise nil.
+func (th *Theatre) Reissue(tickNum int) (replacement Ticket, err error) {
+	th.closeLock.RLock()
+	defer th.closeLock.RUnlock()
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("ticket %d", replacement.TicketNum)
+		}
+		th.audit("Reissue", replacement.Window, fmt.Sprintf("ticket %d", tickNum), after, err)
+	}()
+	if !th.salesOpen {
+		return replacement, errors.New("Reissue failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return replacement, ErrReadOnly
+	}
+
+	t, err := th.readTicket(tickNum)
+	if err != nil {
+		return replacement, fmt.Errorf("Reissue failed:  %v", err)
+	}
+	if t.Void {
+		return replacement, ErrTicketVoid
+	}
+	if t.SoldOut {
+		return replacement, fmt.Errorf("Reissue failed:  ticket %d is a sold-out placeholder", tickNum)
+	}
+
+	th.configMutex.RLock()
+	start, grace := th.showingStarts[t.Movie][t.Showing], th.reissueGrace
+	th.configMutex.RUnlock()
+	if !start.IsZero() && !th.clock().Before(start.Add(grace)) {
+		return replacement, ErrShowingStarted
+	}
+
+	// Hold off ResetShowing, as Sell does, so the original can't be voided
+	// by a reset while the replacement is being issued.
+	th.resetLock.RLock()
+	defer th.resetLock.RUnlock()
+
+	replacement, err = th.nextTicket()
+	if err != nil {
+		return replacement, fmt.Errorf("Reissue failed:  %w", err)
+	}
+
+	// Check the original again, and swap it for the replacement, as one step.
+	// If another Reissue (or a void) got there first, the replacement's
+	// number is used up, so it is voided, as it would never be sold.
+	th.ticketDBmutex.Lock()
+	original := th.ticketRqstDB[tickNum]
+	newNum := replacement.TicketNum
+	if original.Void {
+		th.ticketRqstDB[newNum].Void = true
+		th.ticketDBmutex.Unlock()
+		return Ticket{}, ErrTicketVoid
+	}
+	replacement = original
+	replacement.TicketNum = newNum
+	th.ticketRqstDB[newNum] = replacement
+	th.ticketRqstDB[tickNum].Void = true
+	th.ticketRqstDB[tickNum].Exchanged = false
+	th.ticketRqstDB[tickNum].XchOld = ""
+	th.ticketRqstDB[tickNum].XchNew = ""
+	th.ticketDBmutex.Unlock()
+
+	th.lastSaleMutex.Lock()
+	for i, n := range th.lastSale[replacement.Window] {
+		if n == tickNum {
+			th.lastSale[replacement.Window][i] = newNum
+		}
+	}
+	th.lastSaleMutex.Unlock()
+
+	th.L.Printf("Reissue voided ticket %d, and replaced it with:\n%+v\n", tickNum, replacement)
+	return replacement, nil
+} // Reissue
+
+// TicketsByCustomer returns copies of all of the Tickets sold to the customer
+// with loyalty ID id (see CustomerIDField), for loyalty analytics.  As with
+// TicketsForShowing, sold-out placeholders and void tickets are left out, and
+// the Tickets are taken from a snapshot of the ticketRqstDB, in ticket number
+// order.
+//
+// Returns nil if id is "", since tickets sold without an ID belong to no
+// customer.
+func (th *Theatre) TicketsByCustomer(id string) []Ticket {
+	if id == "" {
+		return nil
+	}
+
+	ticks := make([]Ticket, 0)
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+	for i := 1; i < len(th.ticketRqstDB); i++ {
+		t := th.ticketRqstDB[i]
+		if t.TicketNum == i && t.CustomerID == id && !t.SoldOut && !t.Void {
+			ticks = append(ticks, t)
+		}
+	}
+	return ticks
+} // TicketsByCustomer
+
+// TopCustomers reports the n customers who have bought the most tickets
+// (counted as for TicketsByCustomer), most first, and by CustomerID among
+// those with the same count.  Tickets sold without a customer ID are not
+// counted.
+//
+// Returns fewer than n CustomerCounts if fewer customers have bought
+// tickets, or all of them if n is 0 or less.
+func (th *Theatre) TopCustomers(n int) []CustomerCount {
+	counts := make(map[string]int)
+	th.ticketDBmutex.Lock()
+	for i := 1; i < len(th.ticketRqstDB); i++ {
+		t := th.ticketRqstDB[i]
+		if t.TicketNum == i && t.CustomerID != "" && !t.SoldOut && !t.Void {
+			counts[t.CustomerID]++
+		}
+	}
+	th.ticketDBmutex.Unlock()
+
+	top := make([]CustomerCount, 0, len(counts))
+	for id, tickets := range counts {
+		top = append(top, CustomerCount{CustomerID: id, Tickets: tickets})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Tickets != top[j].Tickets {
+			return top[i].Tickets > top[j].Tickets
+		}
+		return top[i].CustomerID < top[j].CustomerID
+	})
+	if n > 0 && n < len(top) {
+		top = top[:n]
+	}
+	return top
+} // TopCustomers
+
+// Revenue adds up the prices of the tickets sold which filter picks (e.g.
+// one movie's tickets sold today, or everything sold at window 1), from a
+// snapshot of the ticketRqstDB.  Sold-out placeholders and void tickets are
+// left out, since nothing was paid for them (or it was given back).  So are
+// upgrade charges for goodie exchanges, which are on their own receipts.
+//
+// Returns the revenue, in penneys.
+func (th *Theatre) Revenue(filter RevenueFilter) int {
+	revenue := 0
+	th.ticketDBmutex.Lock()
+	defer th.ticketDBmutex.Unlock()
+	for i := 1; i < len(th.ticketRqstDB); i++ {
+		t := th.ticketRqstDB[i]
+		if t.TicketNum != i || t.SoldOut || t.Void {
+			continue
+		}
+		if (filter.Movie != nil && t.Movie != *filter.Movie) ||
+			(filter.Showing != nil && t.Showing != *filter.Showing) ||
+			(filter.Window != nil && t.Window != *filter.Window) ||
+			(!filter.From.IsZero() && t.SoldAt.Before(filter.From)) ||
+			(!filter.To.IsZero() && !t.SoldAt.Before(filter.To)) {
+			continue
+		}
+		revenue += t.Price
+	}
+	return revenue
+} // Revenue
+
+// Compact releases what it can of the Tickets which are done with for good:
+// the void tickets, and the sold-out placeholders.  They are not removed, so
+// ticketRqstDB[n] is still Ticket n, and every ticket number can still be
+// looked up.  Their customer IDs are cleared, since nothing reads those once
+// a ticket is void or was never sold (TicketsByCustomer and TopCustomers
+// skip them), and so are the goodies named by their exchanges, since an
+// exchange can't be undone once its ticket is void (see UndoExchange).  Their
+// Exchanged flags are kept, as the goodies are still out of stock.  Only void
+// tickets and sold-out placeholders are compacted:  the live tickets of every
+// showing, whether or not it has started, are left as they are.  See also
+// SetCompactInterval.
+//
+// Compactions which release anything are sent to the audit sink (see
+// SetAuditSink).
+//
+// Returns the number of Tickets which had anything released.
+func (th *Theatre) Compact() int {
+	compacted := 0
+	th.ticketDBmutex.Lock()
+	for i := 1; i < len(th.ticketRqstDB); i++ {
+		t := &th.ticketRqstDB[i]
+		if t.TicketNum != i || !(t.SoldOut || t.Void) {
+			continue
+		}
+		if t.CustomerID != "" || t.XchOld != "" || t.XchNew != "" {
+			t.CustomerID, t.XchOld, t.XchNew = "", "", ""
+			compacted++
+		}
+	}
+	th.ticketDBmutex.Unlock()
+
+	if compacted > 0 {
+		th.L.Printf("Compact released %d void and sold-out Tickets.", compacted)
+		th.audit("Compact", 0, "void and sold-out Tickets", fmt.Sprintf("%d Tickets released", compacted), nil)
+	}
+	return compacted
+} // Compact
+
+// SetCompactInterval runs Compact in the background every interval, from
+// now until the theatre is closed, so that a long run doesn't keep the data
+// of its void tickets around.  It replaces any interval set before.  An
+// interval of 0 or less (the default) stops the background compaction.
+func (th *Theatre) SetCompactInterval(interval time.Duration) {
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	if th.compactStop != nil {
+		close(th.compactStop)
+		th.compactStop = nil
+	}
+	if interval <= 0 {
+		th.L.Printf("Background compaction turned off.")
+		return
+	}
+	th.compactStop = make(chan struct{})
+	go th.compactor(interval, th.compactStop, th.stopRoll)
+	th.L.Printf("Background compaction set to every %v.", interval)
+} // SetCompactInterval
+
+// compactor runs Compact every interval, until SetCompactInterval closes
+// stop, or Close closes the theatre's stopRoll (closed).
+func (th *Theatre) compactor(interval time.Duration, stop <-chan struct{}, closed <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			th.Compact()
+		case <-stop:
+			return
+		case <-closed:
+			return
+		}
+	}
+} // compactor
+
+// VoidLastSale voids the most recent sale made at a window (e.g. a cashier's
+// mistake, with a manager override).  Every Ticket sold in it is marked Void,
+// and its seat is released, so it can be sold again (after the seat release
+// delay, see SetSeatReleaseDelay).  A sale made up only of sold-out requests
+// doesn't count, since there is nothing in it to void.  Only the one most
+// recent sale can be voided:  once it has been, the window has nothing more
+// to void until it makes another sale.
+//
+// Goodie exchanges already made with the voided Tickets are not undone (use
+// UndoExchange first, if the goods were returned).
+//
+// Parameters:
+//
+// window
+//    The window whose last sale should be voided.
+//
+// Returns:
+//
+// voidedTickets
+//    Copies of the Tickets which were voided, as they now are in the DB.
+// err
+//    ErrNothingToVoid if the window has no sale to void, or an error if the
+//    window is out of range or the salesOpen (system up) flag is not set.
+func (th *Theatre) VoidLastSale(window int) (voidedTickets []Ticket, err error) {
+	var sold []int
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("tickets %v voided", ticketNums(voidedTickets))
+		}
+		th.audit("VoidLastSale", window, fmt.Sprintf("last sale:  tickets %v", sold), after, err)
+	}()
+	if !th.salesOpen {
+		return nil, errors.New("VoidLastSale failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if window < 1 || window > th.maxWindows {
+		return nil, fmt.Errorf("VoidLastSale failed:  window %d out of range.  Must be between 1 and %d, inclusive.", window, th.maxWindows)
+	}
+
+	th.lastSaleMutex.Lock()
+	sold = th.lastSale[window]
+	ch := th.lastSaleChannel[window]
+	th.lastSale[window] = nil
+	th.lastSaleMutex.Unlock()
+	if sold == nil {
+		return nil, ErrNothingToVoid
+	}
+
+	voidedTickets = make([]Ticket, 0, len(sold))
+	th.ticketDBmutex.Lock()
+	for _, tickNum := range sold {
+		t := &th.ticketRqstDB[tickNum]
+		if t.Void {
+			continue // e.g. ResetShowing got there first
+		}
+		t.Void = true
+		th.releaseCancelledSeat(t.Movie, t.Showing, ch)
+		voidedTickets = append(voidedTickets, *t)
+	}
+	th.ticketDBmutex.Unlock()
+
+	th.L.Printf("VoidLastSale for window %d voided tickets:\n%+v\n", window, voidedTickets)
+	return voidedTickets, nil
+} // VoidLastSale
@@ -1,8 +1,17 @@
 package tickets
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -18,20 +27,20 @@ func TestInitAndTicketProducer(tst *testing.T) {
 	if ierr != nil {
 		tst.Errorf("Init(Ltest,5,6,7,8,9) returned error %v", ierr)
 	}
-	if maxExchanges != 5 {
-		tst.Errorf("Init(Ltest,5,6,7,8,9) maxExchanges is %d not 5", maxExchanges)
+	if std.maxExchanges != 5 {
+		tst.Errorf("Init(Ltest,5,6,7,8,9) maxExchanges is %d not 5", std.maxExchanges)
 	}
-	if maxMovies != 6 {
-		tst.Errorf("Init(Ltest,5,6,7,8,9) maxMovies is %d not 6", maxMovies)
+	if std.maxMovies != 6 {
+		tst.Errorf("Init(Ltest,5,6,7,8,9) maxMovies is %d not 6", std.maxMovies)
 	}
-	if maxShowings != 7 {
-		tst.Errorf("Init(Ltest,5,6,7,8,9) maxShowings is %d not 7", maxShowings)
+	if std.maxShowings != 7 {
+		tst.Errorf("Init(Ltest,5,6,7,8,9) maxShowings is %d not 7", std.maxShowings)
 	}
-	if maxSeats != 8 {
-		tst.Errorf("Init(Ltest,5,6,7,8,9) maxSeats is %d not 8", maxSeats)
+	if std.maxSeats != 8 {
+		tst.Errorf("Init(Ltest,5,6,7,8,9) maxSeats is %d not 8", std.maxSeats)
 	}
-	if maxWindows != 9 {
-		tst.Errorf("Init(Ltest,5,6,7,8,9) maxWindows is %d not 9", maxWindows)
+	if std.maxWindows != 9 {
+		tst.Errorf("Init(Ltest,5,6,7,8,9) maxWindows is %d not 9", std.maxWindows)
 	}
 	/*********
 	     * This test always fails, even if the assignment works.
@@ -48,35 +57,35 @@ func TestInitAndTicketProducer(tst *testing.T) {
 	  }
 	     * Therefore, have to remove the test, for now.
 	 *********/
-	if len(seatsSold) != maxMovies {
-		tst.Errorf("Init(Ltest,5,6,7,8,9) seatsSold is %d long, expecting %d", len(seatsSold), maxMovies)
+	if len(std.seatsSold) != std.maxMovies {
+		tst.Errorf("Init(Ltest,5,6,7,8,9) seatsSold is %d long, expecting %d", len(std.seatsSold), std.maxMovies)
 	}
-	for i, s := range seatsSold {
-		if len(s) != maxShowings {
-			tst.Errorf("Init(Ltest,5,6,7,8,9) seatsSold[%d] is %d long, expecting %d", i, len(s), maxShowings)
+	for i, s := range std.seatsSold {
+		if len(s) != std.maxShowings {
+			tst.Errorf("Init(Ltest,5,6,7,8,9) seatsSold[%d] is %d long, expecting %d", i, len(s), std.maxShowings)
 		}
 	}
-	trl := maxMovies*maxShowings*maxSeats + 1
-	if len(ticketRqstDB) != trl {
-		tst.Errorf("Init(Ltest,5,6,7,8,9) ticketRqstDB is %d tickets long, expecting %d", len(ticketRqstDB), trl)
+	trl := std.maxMovies*std.maxShowings*std.maxSeats + 1
+	if len(std.ticketRqstDB) != trl {
+		tst.Errorf("Init(Ltest,5,6,7,8,9) ticketRqstDB is %d tickets long, expecting %d", len(std.ticketRqstDB), trl)
 	}
-	if ticketRoll == nil {
+	if std.ticketRoll == nil {
 		tst.Error("Init(Ltest,5,6,7,8,9) did not create ticketRoll")
 	} else {
-		t, ok := <-ticketRoll
+		t, ok := <-std.ticketRoll
 		lastTickNum++
 		if t != lastTickNum || !ok { // !ok == EOF on a closed channel
 			tst.Errorf("Init(Ltest,5,6,7,8,9) ticketRoll returned %d, %t when %d, true were expected", t, ok, lastTickNum)
 		}
 		lastTickNum = t
 	}
-	if !salesOpen {
+	if !std.salesOpen {
 		tst.Error("Init(Ltest,5,6,7,8,9) did not set salesOpen flag")
 	}
 } // TestInitAndTicketProducer
 
 func TestNextTicket(tst *testing.T) {
-	t, err := nextTicket()
+	t, err := std.nextTicket()
 	lastTickNum++
 	if err != nil {
 		tst.Errorf("nextTicket() returned error %v", err)
@@ -85,45 +94,45 @@ func TestNextTicket(tst *testing.T) {
 		tst.Errorf("nextTicket() returned a ticket identifying itself as # %d.  Expected %d", t.TicketNum, lastTickNum)
 		lastTickNum = t.TicketNum
 	}
-	if ticketRqstDB[lastTickNum].TicketNum != lastTickNum {
-		tst.Errorf("nextTicket() didn't set the TicketNum field correctly in ticketRqstDB[%d] - found %d", lastTickNum, ticketRqstDB[lastTickNum].TicketNum)
+	if std.ticketRqstDB[lastTickNum].TicketNum != lastTickNum {
+		tst.Errorf("nextTicket() didn't set the TicketNum field correctly in ticketRqstDB[%d] - found %d", lastTickNum, std.ticketRqstDB[lastTickNum].TicketNum)
 	}
 } // TestNextTicket
 
 func TestSellAndCheckAvailabilityAndPrice(tst *testing.T) {
-	atomic.StoreInt32(&seatsSold[1][2], 0)
-	penneys, soldOut := checkAvailabilityAndPrice(1, 2)
-	ss12 := atomic.LoadInt32(&seatsSold[1][2])
+	atomic.StoreInt32(&std.seatsSold[1][2], 0)
+	penneys, soldOut := std.checkAvailabilityAndPrice(1, 2)
+	ss12 := atomic.LoadInt32(&std.seatsSold[1][2])
 	if penneys != 1000 || soldOut || ss12 != 1 {
 		tst.Errorf("checkAvailabilityAndPrice(1,2) with seatsSold[1][2] = 0, returned price=%d,soldOut=%t,seatsSold[1][2]=%d, expected 1000,false,1", penneys, soldOut, ss12)
 	}
 
-	atomic.StoreInt32(&seatsSold[1][2], int32(maxSeats-1))
-	penneys, soldOut = checkAvailabilityAndPrice(1, 2)
-	ss12 = atomic.LoadInt32(&seatsSold[1][2])
-	if penneys != 1000 || soldOut || ss12 != int32(maxSeats) {
-		tst.Errorf("checkAvailabilityAndPrice(1,2) with seatsSold[1][2] = maxSeats - 1 = %d, returned price=%d,soldOut=%t,seatsSold[1][2]=%d, expected 1000,false,(maxSeats=%d)", maxSeats-1, penneys, soldOut, ss12, maxSeats)
+	atomic.StoreInt32(&std.seatsSold[1][2], int32(std.maxSeats-1))
+	penneys, soldOut = std.checkAvailabilityAndPrice(1, 2)
+	ss12 = atomic.LoadInt32(&std.seatsSold[1][2])
+	if penneys != 1000 || soldOut || ss12 != int32(std.maxSeats) {
+		tst.Errorf("checkAvailabilityAndPrice(1,2) with seatsSold[1][2] = maxSeats - 1 = %d, returned price=%d,soldOut=%t,seatsSold[1][2]=%d, expected 1000,false,(maxSeats=%d)", std.maxSeats-1, penneys, soldOut, ss12, std.maxSeats)
 	}
 
-	atomic.StoreInt32(&seatsSold[1][2], int32(maxSeats))
-	penneys, soldOut = checkAvailabilityAndPrice(1, 2)
-	ss12 = atomic.LoadInt32(&seatsSold[1][2])
-	if !soldOut || ss12 != int32(maxSeats+1) {
-		tst.Errorf("checkAvailabilityAndPrice(1,2) with seatsSold[1][2] = 0, returned price=%d,soldOut=%t,seatsSold[1][2]=%d, expected <unreliable_value>,true,(maxSeats+1=%d)", penneys, soldOut, ss12, maxSeats+1)
+	atomic.StoreInt32(&std.seatsSold[1][2], int32(std.maxSeats))
+	penneys, soldOut = std.checkAvailabilityAndPrice(1, 2)
+	ss12 = atomic.LoadInt32(&std.seatsSold[1][2])
+	if !soldOut || ss12 != int32(std.maxSeats+1) {
+		tst.Errorf("checkAvailabilityAndPrice(1,2) with seatsSold[1][2] = 0, returned price=%d,soldOut=%t,seatsSold[1][2]=%d, expected <unreliable_value>,true,(maxSeats+1=%d)", penneys, soldOut, ss12, std.maxSeats+1)
 	}
 
-	sstemp := int32(maxSeats - 3)
+	sstemp := int32(std.maxSeats - 3)
 	if sstemp < 0 {
 		sstemp = 0
 	}
-	atomic.StoreInt32(&seatsSold[1][2], sstemp) // set precondition so that tickets will be sold
+	atomic.StoreInt32(&std.seatsSold[1][2], sstemp) // set precondition so that tickets will be sold
 	tickets1, receipt1, err1 := Sell(1, [][2]int{[2]int{1, 2}, [2]int{1, 2}}, make(map[string]interface{}), time.Now())
-	tickets2, receipt2, err2 := Sell(maxWindows, [][2]int{[2]int{1, 2}, [2]int{1, 2}}, make(map[string]interface{}), "a dummy time")
+	tickets2, receipt2, err2 := Sell(std.maxWindows, [][2]int{[2]int{1, 2}, [2]int{1, 2}}, make(map[string]interface{}), "a dummy time")
 	if err1 != nil {
 		tst.Errorf("Window 1 Sell call returned error %v", err1)
 	}
 	if err2 != nil {
-		tst.Errorf("Window %d Sell call returned error %v", maxWindows, err2)
+		tst.Errorf("Window %d Sell call returned error %v", std.maxWindows, err2)
 	}
 	if tickets1[0].TicketNum == tickets1[1].TicketNum ||
 		tickets2[0].TicketNum == tickets2[1].TicketNum ||
@@ -132,12 +141,12 @@ func TestSellAndCheckAvailabilityAndPrice(tst *testing.T) {
 		tickets1[0].TicketNum == tickets2[1].TicketNum ||
 		tickets1[1].TicketNum == tickets2[0].TicketNum {
 		tst.Errorf(`Sell(1,  [][2]int{ [2]int{1,2}, [2]int{1,2}, },  make(map[string]interface{}),  time.Now())
- Sell(maxWindows,  [][2]int{ [2]int{1,2}, [2]int{1,2}, },  make(map[string]interface{}),  "a dummy time")
+ Sell(std.maxWindows,  [][2]int{ [2]int{1,2}, [2]int{1,2}, },  make(map[string]interface{}),  "a dummy time")
  should have four unique ticket numbers, but they don't:
  %d, %d, %d, %d`, tickets1[0].TicketNum, tickets1[1].TicketNum, tickets2[0].TicketNum, tickets2[1].TicketNum)
 	}
-	if tickets1[0].Window != 1 || tickets1[1].Window != 1 || tickets2[0].Window != maxWindows || tickets2[1].Window != maxWindows {
-		tst.Errorf("Ticket window #s should be 1, 1, %d, %d, and receipts 1 and %d, but got %d, %d, %d, %d, %d, %d", maxWindows, maxWindows, maxWindows, tickets1[0].Window, tickets1[1].Window, tickets2[0].Window, tickets2[1].Window, receipt1.Window, receipt2.Window)
+	if tickets1[0].Window != 1 || tickets1[1].Window != 1 || tickets2[0].Window != std.maxWindows || tickets2[1].Window != std.maxWindows {
+		tst.Errorf("Ticket window #s should be 1, 1, %d, %d, and receipts 1 and %d, but got %d, %d, %d, %d, %d, %d", std.maxWindows, std.maxWindows, std.maxWindows, tickets1[0].Window, tickets1[1].Window, tickets2[0].Window, tickets2[1].Window, receipt1.Window, receipt2.Window)
 	}
 	if tickets1[0].SoldOut || tickets1[1].SoldOut || tickets2[0].SoldOut || !tickets2[1].SoldOut {
 		tst.Errorf("Expected 3 tickets to sell and the last one to be sold out (flags false, false, false, true).\nGot SoldOut flags %t, %t, %t, %t", tickets1[0].SoldOut, tickets1[1].SoldOut, tickets2[0].SoldOut, tickets2[1].SoldOut)
@@ -146,7 +155,7 @@ func TestSellAndCheckAvailabilityAndPrice(tst *testing.T) {
 		tst.Errorf("Not all tickets from Window 1 have goodies:  %t, %t, expected true, true", tickets1[0].Goodies, tickets1[1].Goodies)
 	}
 	if tickets2[0].Goodies || tickets2[1].Goodies {
-		tst.Errorf("Ticket(s) from Window %d should not have goodies:  %t, %t, expected false, false", maxWindows, tickets2[0].Goodies, tickets2[1].Goodies)
+		tst.Errorf("Ticket(s) from Window %d should not have goodies:  %t, %t, expected false, false", std.maxWindows, tickets2[0].Goodies, tickets2[1].Goodies)
 	}
 	if len(receipt1.ItemsSold) != 2 || len(receipt2.ItemsSold) != 1 {
 		tst.Errorf("First call sold %d, expected 2;  second call sold %d, expected 1.", len(receipt1.ItemsSold), len(receipt2.ItemsSold))
@@ -173,34 +182,2060 @@ func TestExchange(tst *testing.T) {
 		return
 	}
 
-	origExchanges := totExchanges
+	origExchanges := std.totExchanges
 	t := ticketForExchange.TicketNum
 	err := Exchange(t, "water", "soda")
-	tst.Logf("\nAfter Exchange(%d, water, soda), ticketRqstDB[%d] record is:\n%+v\n", t, t, ticketRqstDB[t])
+	tst.Logf("\nAfter Exchange(%d, water, soda), ticketRqstDB[%d] record is:\n%+v\n", t, t, std.ticketRqstDB[t])
 	if err != nil {
 		tst.Errorf("Attempt to exchange water for soda on ticket # %d failed:  %v", t, err)
 	}
-	if !ticketRqstDB[t].Exchanged {
+	if !std.ticketRqstDB[t].Exchanged {
 		tst.Errorf("Exchanged flag not set on ticketRqstDB[%d]", t)
 	}
-	if ticketRqstDB[t].XchOld == "" || ticketRqstDB[t].XchNew == "" {
-		tst.Errorf("Exchanged goods field(s) not set on ticketRqstDB[%d]:  '%s' exchanged for '%s', expected 'water' exchanged for 'soda'", t, ticketRqstDB[t].XchOld, ticketRqstDB[t].XchNew)
+	if std.ticketRqstDB[t].XchOld == "" || std.ticketRqstDB[t].XchNew == "" {
+		tst.Errorf("Exchanged goods field(s) not set on ticketRqstDB[%d]:  '%s' exchanged for '%s', expected 'water' exchanged for 'soda'", t, std.ticketRqstDB[t].XchOld, std.ticketRqstDB[t].XchNew)
 	}
-	if totExchanges != origExchanges+1 {
-		tst.Errorf("totExchanges not properly incremented from %d to %d.  It is now %d.", origExchanges, origExchanges+1, totExchanges)
+	if std.totExchanges != origExchanges+1 {
+		tst.Errorf("totExchanges not properly incremented from %d to %d.  It is now %d.", origExchanges, origExchanges+1, std.totExchanges)
 	}
 
-	origExchanges = totExchanges
+	origExchanges = std.totExchanges
 	t = ticketNotExchange.TicketNum
 	err = Exchange(t, "water", "soda")
-	tst.Logf("\nAfter Exchange(%d, water, soda), ticketRqstDB[%d] record is:\n%+v\n", t, t, ticketRqstDB[t])
+	tst.Logf("\nAfter Exchange(%d, water, soda), ticketRqstDB[%d] record is:\n%+v\n", t, t, std.ticketRqstDB[t])
 	if err != ErrXchNotEntitled {
 		tst.Errorf("Attempt to exchange water for soda on ticket # %d should have failed for %v, but got:  %v", t, ErrXchNotEntitled, err)
 	}
-	if ticketRqstDB[t].Exchanged {
+	if std.ticketRqstDB[t].Exchanged {
 		tst.Errorf("Exchanged flag should not be set on ticketRqstDB[%d]", t)
 	}
-	if totExchanges != origExchanges {
-		tst.Errorf("totExchanges should not have changed when an exchange is not allowed.  Was %d, now %d.", origExchanges, totExchanges)
+	if std.totExchanges != origExchanges {
+		tst.Errorf("totExchanges should not have changed when an exchange is not allowed.  Was %d, now %d.", origExchanges, std.totExchanges)
 	}
 } // TestExchange
+
+func TestResetShowing(tst *testing.T) {
+	atomic.StoreInt32(&std.seatsSold[2][0], 0)
+	atomic.StoreInt32(&std.seatsSold[2][1], 0)
+	ticks, _, err := Sell(std.maxWindows, [][2]int{[2]int{2, 0}, [2]int{2, 1}, [2]int{2, 0}}, make(map[string]interface{}), "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell for movie 2 showings 0 and 1 returned error %v", err)
+	}
+
+	if err := ResetShowing(2, 0); err != nil {
+		tst.Errorf("ResetShowing(2,0) returned error %v", err)
+	}
+	if ss := atomic.LoadInt32(&std.seatsSold[2][0]); ss != 0 {
+		tst.Errorf("ResetShowing(2,0) left seatsSold[2][0] = %d, expected 0", ss)
+	}
+	if ss := atomic.LoadInt32(&std.seatsSold[2][1]); ss != 1 {
+		tst.Errorf("ResetShowing(2,0) changed seatsSold[2][1] to %d, expected 1", ss)
+	}
+	for _, t := range ticks {
+		wantVoid := t.Showing == 0
+		if std.ticketRqstDB[t.TicketNum].Void != wantVoid {
+			tst.Errorf("After ResetShowing(2,0), ticket # %d for showing %d has Void=%t, expected %t", t.TicketNum, t.Showing, std.ticketRqstDB[t.TicketNum].Void, wantVoid)
+		}
+	}
+	if err := Exchange(ticks[0].TicketNum, "water", "soda"); err != ErrTicketVoid {
+		tst.Errorf("Exchange on voided ticket # %d returned %v, expected %v", ticks[0].TicketNum, err, ErrTicketVoid)
+	}
+
+	if err := ResetShowing(std.maxMovies, 0); err == nil {
+		tst.Errorf("ResetShowing(%d,0) should have failed for an out of range movie", std.maxMovies)
+	}
+	if err := ResetShowing(0, -1); err == nil {
+		tst.Error("ResetShowing(0,-1) should have failed for an out of range showing")
+	}
+} // TestResetShowing
+
+func TestCancelShowing(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 4, 2)
+	ticks1, _, err := th.Sell(1, [][2]int{{0, 0}, {1, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell at window 1 returned error %v", err)
+	}
+	ticks2, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell at window 2 returned error %v", err)
+	}
+	if err := th.Exchange(ticks1[0].TicketNum, "water", "soda"); err != nil {
+		tst.Fatalf("Exchange returned error %v", err)
+	}
+
+	refunds, err := th.CancelShowing(0, 0)
+	if err != nil {
+		tst.Fatalf("CancelShowing returned error %v", err)
+	}
+	if len(refunds) != 2 || refunds[0].Window != 1 || refunds[0].Total != -2000 || len(refunds[0].ItemsSold) != 2 ||
+		refunds[1].Window != 2 || refunds[1].Total != -1000 || len(refunds[1].ItemsSold) != 1 {
+		tst.Fatalf("CancelShowing returned refunds %+v, expected -20.00 at window 1 and -10.00 at window 2", refunds)
+	}
+	for _, r := range refunds {
+		if got, err := th.ReceiptByNum(r.ReceiptNum); err != nil || got.Total != r.Total {
+			tst.Errorf("ReceiptByNum(%d) returned %+v, %v, expected the refund", r.ReceiptNum, got, err)
+		}
+	}
+	for _, t := range []Ticket{ticks1[0], ticks1[2], ticks2[0]} {
+		if got, _ := th.readTicket(t.TicketNum); !got.Void {
+			tst.Errorf("Ticket %d for the cancelled showing is not Void", t.TicketNum)
+		}
+	}
+	if got, _ := th.readTicket(ticks1[1].TicketNum); got.Void {
+		tst.Errorf("Ticket %d for movie 1 was voided by cancelling movie 0", got.TicketNum)
+	}
+	if sold := atomic.LoadInt32(&th.seatsSold[0][0]); sold != 0 {
+		tst.Errorf("Cancelled showing still has %d seats sold, expected 0", sold)
+	}
+	if total := th.Revenue(RevenueFilter{}); total != 1000 {
+		tst.Errorf("Revenue after CancelShowing is %d, expected 1000 (movie 1 only)", total)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); !errors.Is(err, ErrBlackout) {
+		tst.Errorf("Sell for the cancelled showing returned error %v, expected %v", err, ErrBlackout)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v after CancelShowing", problems)
+	}
+
+	if refunds, err := th.CancelShowing(0, 0); err != nil || len(refunds) != 0 {
+		tst.Errorf("Cancelling the cancelled showing again returned %+v, %v, expected no refunds", refunds, err)
+	}
+	if _, err := th.CancelShowing(2, 0); err == nil {
+		tst.Errorf("CancelShowing of movie 2 of 2 should have failed")
+	}
+} // TestCancelShowing
+
+func TestSetSalesWindow(tst *testing.T) {
+	opens := time.Date(2017, 3, 7, 12, 0, 0, 0, time.UTC)
+	closes := opens.Add(10 * time.Hour)
+	var fakeNow time.Time
+	std.clock = func() time.Time { return fakeNow }
+	defer func() {
+		std.clock = time.Now
+		SetSalesWindow(time.Time{}, time.Time{})
+	}()
+
+	if err := SetSalesWindow(closes, opens); err == nil {
+		tst.Error("SetSalesWindow with close before open should have failed")
+	}
+	if err := SetSalesWindow(opens, closes); err != nil {
+		tst.Fatalf("SetSalesWindow(%v, %v) returned error %v", opens, closes, err)
+	}
+
+	for _, c := range []struct {
+		at   time.Time
+		want error
+	}{
+		{opens.Add(-time.Minute), ErrSalesNotOpenYet},
+		{opens, nil},
+		{closes.Add(-time.Minute), nil},
+		{closes, ErrSalesClosed},
+		{closes.Add(time.Hour), ErrSalesClosed},
+	} {
+		fakeNow = c.at
+		_, _, err := Sell(std.maxWindows, [][2]int{[2]int{3, 0}}, nil, "a dummy time")
+		if err != c.want {
+			tst.Errorf("Sell at %v with sales window %v to %v returned %v, expected %v", c.at, opens, closes, err, c.want)
+		}
+	}
+} // TestSetSalesWindow
+
+func TestSelfCheck(tst *testing.T) {
+	// Earlier tests poke at seatsSold directly, so only look for new problems.
+	before := len(SelfCheck())
+
+	ticks, _, err := Sell(std.maxWindows, [][2]int{[2]int{4, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell for movie 4, showing 0 returned error %v", err)
+	}
+	if after := len(SelfCheck()); after != before {
+		tst.Errorf("SelfCheck found %d problems after a clean sale, expected %d", after, before)
+	}
+
+	t := ticks[0].TicketNum
+	std.ticketRqstDB[t].TicketNum = t + 1000
+	problems := SelfCheck()
+	std.ticketRqstDB[t].TicketNum = t
+	found := false
+	want := fmt.Sprintf("ticketRqstDB[%d] is marked with TicketNum %d", t, t+1000)
+	for _, p := range problems {
+		if p.Error() == want {
+			found = true
+		}
+	}
+	if !found {
+		tst.Errorf("SelfCheck did not report corrupted ticket # %d.  Expected '%s', got:  %v", t, want, problems)
+	}
+	// The corrupted ticket no longer counts as sold, so its showing's
+	// seatsSold and channelSold counts are off, too.
+	if len(problems) != before+3 {
+		tst.Errorf("SelfCheck reported %d problems with one corrupted ticket, expected %d:  %v", len(problems), before+3, problems)
+	}
+} // TestSelfCheck
+
+func TestUndoExchange(tst *testing.T) {
+	// Window 1 tickets come with goodies.  Get one more than there is stock.
+	onHand := std.maxExchanges - std.totExchanges
+	rqsts := make([][2]int, onHand+1)
+	for i := range rqsts {
+		rqsts[i] = [2]int{5, i % std.maxShowings}
+	}
+	ticks, _, err := Sell(1, rqsts, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell of %d tickets at window 1 returned error %v", len(rqsts), err)
+	}
+
+	for _, t := range ticks[:onHand] {
+		if err := Exchange(t.TicketNum, "water", "soda"); err != nil {
+			tst.Fatalf("Exchange on ticket # %d returned %v with stock remaining", t.TicketNum, err)
+		}
+	}
+	last := ticks[onHand].TicketNum
+	if err := Exchange(last, "water", "soda"); err != ErrXchOutOfGoods {
+		tst.Fatalf("Exchange on ticket # %d returned %v once stock was exhausted, expected %v", last, err, ErrXchOutOfGoods)
+	}
+
+	if err := UndoExchange(last); err != ErrXchNotDone {
+		tst.Errorf("UndoExchange on unexchanged ticket # %d returned %v, expected %v", last, err, ErrXchNotDone)
+	}
+	undone := ticks[0].TicketNum
+	if err := UndoExchange(undone); err != nil {
+		tst.Errorf("UndoExchange on ticket # %d returned error %v", undone, err)
+	}
+	if std.ticketRqstDB[undone].Exchanged || std.ticketRqstDB[undone].XchOld != "" || std.ticketRqstDB[undone].XchNew != "" {
+		tst.Errorf("UndoExchange left ticketRqstDB[%d] exchange fields set:  %+v", undone, std.ticketRqstDB[undone])
+	}
+	if err := Exchange(last, "water", "soda"); err != nil {
+		tst.Errorf("Exchange on ticket # %d after an undo returned %v, expected one unit back in stock", last, err)
+	}
+
+	// Put the stock back the way we found it, for any later tests.
+	for _, t := range ticks[1:] {
+		UndoExchange(t.TicketNum)
+	}
+	if std.maxExchanges-std.totExchanges != onHand {
+		tst.Errorf("After undoing all of this test's exchanges, %d goods are on hand, expected %d", std.maxExchanges-std.totExchanges, onHand)
+	}
+} // TestUndoExchange
+
+func TestUndoExchangeConcurrent(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 4, 1)
+	ticks, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	tickNum := ticks[0].TicketNum
+
+	// Of several undos of the one exchange at once, only one may succeed, and
+	// put the goods back in stock.  With more than one P, the undos overlap
+	// even on a machine with one CPU.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	const undos = 8
+	for round := 0; round < 100; round++ {
+		if err := th.Exchange(tickNum, "water", "soda"); err != nil {
+			tst.Fatalf("Exchange on ticket # %d returned error %v", tickNum, err)
+		}
+		var wg sync.WaitGroup
+		var succeeded int32
+		start := make(chan struct{})
+		for i := 0; i < undos; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if err := th.UndoExchange(tickNum); err == nil {
+					atomic.AddInt32(&succeeded, 1)
+				} else if err != ErrXchNotDone {
+					tst.Errorf("UndoExchange on ticket # %d returned error %v, expected nil or %v", tickNum, err, ErrXchNotDone)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if succeeded != 1 || th.totExchanges != 0 {
+			tst.Fatalf("%d concurrent UndoExchanges of one exchange:  %d succeeded, and %d goods are out, expected 1 and 0", undos, succeeded, th.totExchanges)
+		}
+	}
+} // TestUndoExchangeConcurrent
+
+func TestSetPriceBounds(tst *testing.T) {
+	defer SetPriceBounds(0, math.MaxInt32)
+
+	if err := SetPriceBounds(-1, 100); err == nil {
+		tst.Error("SetPriceBounds(-1,100) should have failed for a negative minimum")
+	}
+	if err := SetPriceBounds(500, 100); err == nil {
+		tst.Error("SetPriceBounds(500,100) should have failed for min > max")
+	}
+
+	if err := SetPriceBounds(100, 5000); err != nil {
+		tst.Fatalf("SetPriceBounds(100,5000) returned error %v", err)
+	}
+	for _, c := range []struct{ computed, want int }{
+		{-300, 100},  // e.g. a heavy discount went negative
+		{99, 100},    // just under
+		{1000, 1000}, // in range is left alone
+		{5000, 5000}, // the limits themselves are in range
+		{7500, 5000}, // e.g. stacked surges
+	} {
+		if got := std.clampPrice(c.computed, 0, 0); got != c.want {
+			tst.Errorf("clampPrice(%d) with bounds 100 to 5000 returned %d, expected %d", c.computed, got, c.want)
+		}
+	}
+
+	// The clamp is applied to real sales, too.
+	for _, c := range []struct{ lo, hi, want int }{{1500, 5000, 1500}, {0, 600, 600}} {
+		SetPriceBounds(c.lo, c.hi)
+		ticks, rcpt, err := Sell(std.maxWindows, [][2]int{[2]int{3, 1}}, nil, "a dummy time")
+		if err != nil {
+			tst.Fatalf("Sell with price bounds %d to %d returned error %v", c.lo, c.hi, err)
+		}
+		if ticks[0].Price != c.want || rcpt.Total != c.want || rcpt.ItemsSold[0].Penneys != c.want {
+			tst.Errorf("Sell with price bounds %d to %d priced the ticket at %d, receipt %+v.  Expected %d", c.lo, c.hi, ticks[0].Price, rcpt, c.want)
+		}
+	}
+} // TestSetPriceBounds
+
+func TestTicketsForShowing(tst *testing.T) {
+	// Movie 3, showing 2 has maxSeats seats, so the last request is sold out.
+	rqsts := [][2]int{[2]int{3, 3}}
+	for i := 0; i <= std.maxSeats; i++ {
+		rqsts = append(rqsts, [2]int{3, 2})
+	}
+	ticks, _, err := Sell(std.maxWindows, rqsts, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell for movie 3 showings 2 and 3 returned error %v", err)
+	}
+
+	want := map[int][]Ticket{2: ticks[1:std.maxSeats+1], 3: ticks[:1]}
+	for showing, wantTicks := range want {
+		got := TicketsForShowing(3, showing)
+		if len(got) != len(wantTicks) {
+			tst.Errorf("TicketsForShowing(3,%d) returned %d tickets, expected %d:  %+v", showing, len(got), len(wantTicks), got)
+			continue
+		}
+		for i, t := range got {
+			if t != wantTicks[i] {
+				tst.Errorf("TicketsForShowing(3,%d)[%d] is %+v, expected %+v", showing, i, t, wantTicks[i])
+			}
+		}
+	}
+	for _, t := range TicketsForShowing(3, 2) {
+		if t.SoldOut {
+			tst.Errorf("TicketsForShowing(3,2) included sold-out placeholder %+v", t)
+		}
+	}
+
+	if got := TicketsForShowing(std.maxMovies, 0); got != nil {
+		tst.Errorf("TicketsForShowing(%d,0) returned %+v for an out of range movie, expected nil", std.maxMovies, got)
+	}
+	if got := TicketsForShowing(0, std.maxShowings); got != nil {
+		tst.Errorf("TicketsForShowing(0,%d) returned %+v for an out of range showing, expected nil", std.maxShowings, got)
+	}
+} // TestTicketsForShowing
+
+func TestVoidLastSale(tst *testing.T) {
+	const window = 3
+	if _, err := VoidLastSale(window); err != ErrNothingToVoid {
+		tst.Errorf("VoidLastSale(%d) before any sales returned %v, expected %v", window, err, ErrNothingToVoid)
+	}
+
+	atomic.StoreInt32(&std.seatsSold[4][1], 0)
+	atomic.StoreInt32(&std.seatsSold[4][2], 0)
+	first, _, err := Sell(window, [][2]int{[2]int{4, 1}, [2]int{4, 2}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("First Sell at window %d returned error %v", window, err)
+	}
+	second, _, err := Sell(window, [][2]int{[2]int{4, 2}, [2]int{4, 2}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Second Sell at window %d returned error %v", window, err)
+	}
+
+	voided, err := VoidLastSale(window)
+	if err != nil {
+		tst.Fatalf("VoidLastSale(%d) returned error %v", window, err)
+	}
+	if len(voided) != 2 || voided[0].TicketNum != second[0].TicketNum || voided[1].TicketNum != second[1].TicketNum || !voided[0].Void {
+		tst.Errorf("VoidLastSale(%d) voided %+v, expected the second sale's tickets %+v", window, voided, second)
+	}
+	for _, t := range first {
+		if std.ticketRqstDB[t.TicketNum].Void {
+			tst.Errorf("VoidLastSale(%d) voided ticket # %d from the first sale", window, t.TicketNum)
+		}
+	}
+	if ss := atomic.LoadInt32(&std.seatsSold[4][1]); ss != 1 {
+		tst.Errorf("After VoidLastSale, seatsSold[4][1] = %d, expected the first sale's 1", ss)
+	}
+	if ss := atomic.LoadInt32(&std.seatsSold[4][2]); ss != 1 {
+		tst.Errorf("After VoidLastSale, seatsSold[4][2] = %d, expected the first sale's 1", ss)
+	}
+
+	if _, err := VoidLastSale(window); err != ErrNothingToVoid {
+		tst.Errorf("Second VoidLastSale(%d) returned %v, expected %v", window, err, ErrNothingToVoid)
+	}
+	if _, err := VoidLastSale(std.maxWindows + 1); err == nil {
+		tst.Errorf("VoidLastSale(%d) should have failed for an out of range window", std.maxWindows+1)
+	}
+} // TestVoidLastSale
+
+func TestReleaseSeat(tst *testing.T) {
+	for _, c := range []struct{ consumed, want int32 }{
+		{0, 0},
+		{1, 0},
+		{int32(std.maxSeats), int32(std.maxSeats - 1)},
+		{int32(std.maxSeats + 5), int32(std.maxSeats - 1)}, // sold out, with refused requests counted
+	} {
+		atomic.StoreInt32(&std.seatsSold[4][3], c.consumed)
+		std.releaseSeat(4, 3)
+		if got := atomic.LoadInt32(&std.seatsSold[4][3]); got != c.want {
+			tst.Errorf("releaseSeat with seatsSold at %d left it at %d, expected %d", c.consumed, got, c.want)
+		}
+	}
+	atomic.StoreInt32(&std.seatsSold[4][3], 0)
+} // TestReleaseSeat
+
+// newTestTheatre creates a Theatre of its own for a test, so that the test
+// doesn't have to share seats and goodies with the default Theatre's tests.
+func newTestTheatre(tst *testing.T, exchanges int, movies int, showings int, seats int, windows int) *Theatre {
+	Ltest := log.New(os.Stderr, tst.Name()+":  ", log.Ldate|log.Ltime|log.Llongfile)
+	th, err := NewTheatre(Config{Logger: Ltest, MaxExchanges: exchanges, MaxMovies: movies, MaxShowings: showings, MaxSeats: seats, MaxWindows: windows})
+	if err != nil {
+		tst.Fatalf("NewTheatre returned error %v", err)
+	}
+	return th
+} // newTestTheatre
+
+func TestSetGoodieShowings(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 3, 4, 2)
+	if err := th.SetGoodieShowings(0, []int{1}); err != nil {
+		tst.Fatalf("SetGoodieShowings(0, [1]) returned error %v", err)
+	}
+	ticks, _, err := th.Sell(2, [][2]int{{0, 0}, {0, 1}, {1, 1}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell at window 2 returned error %v", err)
+	}
+	for i, want := range []bool{false, true, false} {
+		if ticks[i].Goodies != want {
+			tst.Errorf("Window 2 ticket for movie %d showing %d has Goodies %v, expected %v", ticks[i].Movie, ticks[i].Showing, ticks[i].Goodies, want)
+		}
+	}
+	ticks, _, err = th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil || !ticks[0].Goodies {
+		tst.Errorf("Window 1 ticket for a non-promoted showing has Goodies %v (err %v), expected true", ticks[0].Goodies, err)
+	}
+
+	// An empty list ends the promotion.
+	if err := th.SetGoodieShowings(0, nil); err != nil {
+		tst.Fatalf("SetGoodieShowings(0, nil) returned error %v", err)
+	}
+	ticks, _, err = th.Sell(2, [][2]int{{0, 1}}, nil, "a dummy time")
+	if err != nil || ticks[0].Goodies {
+		tst.Errorf("Window 2 ticket after the promotion ended has Goodies %v (err %v), expected false", ticks[0].Goodies, err)
+	}
+
+	for _, c := range []struct {
+		movie    int
+		showings []int
+	}{{-1, nil}, {2, nil}, {0, []int{3}}, {0, []int{0, -1}}} {
+		if err := th.SetGoodieShowings(c.movie, c.showings); err == nil {
+			tst.Errorf("SetGoodieShowings(%d, %v) should have failed", c.movie, c.showings)
+		}
+	}
+	if th.goodieShowings[0][0] {
+		tst.Errorf("A failed SetGoodieShowings(0, [0 -1]) still marked showing 0")
+	}
+} // TestSetGoodieShowings
+
+func TestSetSpendGoodieThreshold(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 4, 2)
+	if err := th.SetFlatPrice(1250, true); err != nil {
+		tst.Fatalf("SetFlatPrice returned error %v", err)
+	}
+	if err := th.SetSpendGoodieThreshold(-1); err == nil {
+		tst.Errorf("SetSpendGoodieThreshold(-1) should have failed")
+	}
+	if err := th.SetSpendGoodieThreshold(2500); err != nil {
+		tst.Fatalf("SetSpendGoodieThreshold returned error %v", err)
+	}
+
+	// Below the threshold, at a window without goodies.
+	ticks, rcpt, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil || rcpt.Total != 1250 || ticks[0].Goodies {
+		tst.Errorf("Sell of 12.50 at window 2 returned %+v, total %d, %v, expected no goodies", ticks, rcpt.Total, err)
+	}
+
+	// At the threshold (3 seats, and one sold out), every ticket sold gets
+	// goodies, in the DB as well, so they can be exchanged.
+	ticks, rcpt, err = th.Sell(2, [][2]int{{0, 0}, {0, 0}, {0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil || rcpt.Total != 3750 || !ticks[3].SoldOut {
+		tst.Fatalf("Sell of the last 3 seats and one more returned %+v, total %d, %v", ticks, rcpt.Total, err)
+	}
+	for i, t := range ticks[:3] {
+		if !t.Goodies {
+			tst.Errorf("Ticket %d of a 37.50 sale is %+v, expected goodies", i, t)
+		}
+		if db, err := th.readTicket(t.TicketNum); err != nil || !db.Goodies {
+			tst.Errorf("DB has %+v, %v for ticket %d of a 37.50 sale, expected goodies", db, err, i)
+		}
+	}
+	if ticks[3].Goodies {
+		tst.Errorf("Sold-out placeholder %+v got goodies", ticks[3])
+	}
+	if err := th.Exchange(ticks[0].TicketNum, "water", "soda"); err != nil {
+		tst.Errorf("Exchange for a ticket with spend goodies returned error %v", err)
+	}
+
+	// The other rules still give goodies below the threshold.
+	if ticks, _, err := th.Sell(1, [][2]int{{1, 0}}, nil, "a dummy time"); err != nil || !ticks[0].Goodies {
+		tst.Errorf("Sell of 12.50 at window 1 returned %+v, %v, expected goodies", ticks, err)
+	}
+
+	th.SetSpendGoodieThreshold(0)
+	if ticks, _, err := th.Sell(2, [][2]int{{1, 0}, {1, 0}}, nil, "a dummy time"); err != nil || ticks[0].Goodies || ticks[1].Goodies {
+		tst.Errorf("Sell of 25.00 with the promotion off returned %+v, %v, expected no goodies", ticks, err)
+	}
+} // TestSetSpendGoodieThreshold
+
+func TestBlackout(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 2, 4, 2)
+	if err := th.Blackout(1, 0, true); err != nil {
+		tst.Fatalf("Blackout(1, 0, true) returned error %v", err)
+	}
+	ticks, _, err := th.Sell(2, [][2]int{{0, 0}, {1, 0}}, nil, "a dummy time")
+	if !errors.Is(err, ErrBlackout) {
+		tst.Errorf("Sell including a blacked-out showing returned %v, expected %v", err, ErrBlackout)
+	}
+	for _, t := range ticks {
+		if t.TicketNum != 0 {
+			tst.Errorf("Sell including a blacked-out showing still issued ticket %+v", t)
+		}
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[0][0]); ss != 0 {
+		tst.Errorf("Rejected sale left seatsSold[0][0] at %d, expected 0", ss)
+	}
+	if ticks, _, err := th.Sell(2, [][2]int{{1, 1}}, nil, "a dummy time"); err != nil || ticks[0].SoldOut {
+		tst.Errorf("Sell for another showing of a blacked-out movie returned %+v, %v, expected a ticket", ticks, err)
+	}
+
+	if err := th.Blackout(1, 0, false); err != nil {
+		tst.Fatalf("Blackout(1, 0, false) returned error %v", err)
+	}
+	if ticks, _, err := th.Sell(2, [][2]int{{1, 0}}, nil, "a dummy time"); err != nil || ticks[0].SoldOut {
+		tst.Errorf("Sell after the blackout was cleared returned %+v, %v, expected a ticket", ticks, err)
+	}
+
+	if err := th.Blackout(2, 0, true); err == nil {
+		tst.Errorf("Blackout(2, 0, true) should have failed for an out of range movie")
+	}
+	if err := th.Blackout(0, -1, true); err == nil {
+		tst.Errorf("Blackout(0, -1, true) should have failed for an out of range showing")
+	}
+} // TestBlackout
+
+func TestReceiptNumbers(tst *testing.T) {
+	const sales = 50
+	th := newTestTheatre(tst, 5, 1, 1, sales+3, 2)
+	nums := make(chan int, sales)
+	var wg sync.WaitGroup
+	for i := 0; i < sales; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, rcpt, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time")
+			if err != nil {
+				tst.Errorf("Sell returned error %v", err)
+			}
+			nums <- rcpt.ReceiptNum
+		}()
+	}
+	wg.Wait()
+	close(nums)
+
+	seen := make(map[int]bool)
+	for n := range nums {
+		if seen[n] {
+			tst.Errorf("Receipt number %d was given out more than once", n)
+		}
+		seen[n] = true
+	}
+	for n := 1; n <= sales; n++ {
+		if !seen[n] {
+			tst.Errorf("Receipt number %d was skipped, expected 1 to %d", n, sales)
+		}
+	}
+
+	// Later sales always get higher numbers.
+	prev := 0
+	for i := 0; i < 3; i++ {
+		_, rcpt, _ := th.Sell(1, [][2]int{{0, 0}}, nil, i)
+		if rcpt.ReceiptNum <= prev {
+			tst.Errorf("Receipt number %d follows %d, expected it to be higher", rcpt.ReceiptNum, prev)
+		}
+		prev = rcpt.ReceiptNum
+		if got, err := th.ReceiptByNum(rcpt.ReceiptNum); err != nil || got.Time != i {
+			tst.Errorf("ReceiptByNum(%d) returned %+v, %v, expected the receipt with time %d", rcpt.ReceiptNum, got, err, i)
+		}
+	}
+	if _, err := th.ReceiptByNum(0); err != ErrNoSuchReceipt {
+		tst.Errorf("ReceiptByNum(0) returned %v, expected %v", err, ErrNoSuchReceipt)
+	}
+} // TestReceiptNumbers
+
+func TestSellOrder(tst *testing.T) {
+	// Enough sellers, crowding onto showing 0/0, that which requests sell
+	// out depends on how the sales interleave.  Each seller's requests and
+	// pauses are random, so each run shuffles the timing differently.
+	const sellers, perSale = 6, 4
+	th := newTestTheatre(tst, 5, 4, 2, 3, 2)
+	seed := time.Now().UnixNano()
+	tst.Logf("Seed %d", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	rqsts := make([][][2]int, sellers)
+	pauses := make([]time.Duration, sellers)
+	for g := range rqsts {
+		for i := 0; i < perSale; i++ {
+			trqst := [2]int{0, 0}
+			if rnd.Intn(2) == 0 {
+				trqst = [2]int{rnd.Intn(4), rnd.Intn(2)}
+			}
+			rqsts[g] = append(rqsts[g], trqst)
+		}
+		pauses[g] = time.Duration(rnd.Intn(500)) * time.Microsecond
+	}
+
+	ticks := make([][]Ticket, sellers)
+	var wg sync.WaitGroup
+	for g := 0; g < sellers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			time.Sleep(pauses[g])
+			var err error
+			if ticks[g], _, err = th.Sell(1+g%2, rqsts[g], nil, "a dummy time"); err != nil {
+				tst.Errorf("Sell %d returned error %v", g, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	sold := 0
+	for g := range ticks {
+		if len(ticks[g]) != len(rqsts[g]) {
+			tst.Errorf("Sell %d of %v returned %d tickets, expected %d", g, rqsts[g], len(ticks[g]), len(rqsts[g]))
+			continue
+		}
+		for i, t := range ticks[g] {
+			if t.Movie != rqsts[g][i][TRMovie] || t.Showing != rqsts[g][i][TRShowing] || t.Window != 1+g%2 {
+				tst.Errorf("Sell %d ticket %d is %+v, expected it to answer request %v from window %d", g, i, t, rqsts[g][i], 1+g%2)
+			}
+			if t.SoldOut {
+				continue
+			}
+			sold++
+			if db, err := th.readTicket(t.TicketNum); err != nil || db.Movie != t.Movie || db.Showing != t.Showing {
+				tst.Errorf("Sell %d ticket %d is %+v, but the DB has %+v, %v for it", g, i, t, db, err)
+			}
+		}
+	}
+	if sold == 0 {
+		tst.Errorf("No tickets were sold, expected some")
+	}
+} // TestSellOrder
+
+func TestSellWhenRollRunsOut(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 8, 2)
+	// Swap in a roll with only two tickets left on it, as if the theatre
+	// were closing part way through the sale.
+	roll := make(chan int, 2)
+	roll <- 1
+	roll <- 2
+	close(roll)
+	th.ticketRoll = roll
+
+	ticks, rcpt, err := th.Sell(2, [][2]int{{0, 0}, {0, 0}, {0, 0}, {0, 0}}, nil, "a dummy time")
+	if err == nil {
+		tst.Errorf("Sell with the ticketRoll running out returned no error")
+	}
+	if len(ticks) != 2 || ticks[0].TicketNum != 1 || ticks[1].TicketNum != 2 {
+		tst.Errorf("Sell with the ticketRoll running out returned %+v, expected tickets 1 and 2", ticks)
+	}
+	if len(rcpt.ItemsSold) != 2 || rcpt.Total != ticks[0].Price+ticks[1].Price || rcpt.ReceiptNum == 0 {
+		tst.Errorf("Sell with the ticketRoll running out returned receipt %+v, expected one for tickets 1 and 2", rcpt)
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[0][0]); ss != 2 {
+		tst.Errorf("After the ticketRoll ran out, seatsSold[0][0] = %d, expected 2", ss)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("After the ticketRoll ran out, SelfCheck found %v", problems)
+	}
+	if voided, err := th.VoidLastSale(2); err != nil || len(voided) != 2 {
+		tst.Errorf("VoidLastSale after the partial sale returned %+v, %v, expected its 2 tickets", voided, err)
+	}
+} // TestSellWhenRollRunsOut
+
+func TestClose(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 8, 2)
+	if _, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell before Close returned error %v", err)
+	}
+	th.Close()
+	if _, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); err == nil {
+		tst.Errorf("Sell after Close returned no error")
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[0][0]); ss != 1 {
+		tst.Errorf("After Close, seatsSold[0][0] = %d, expected 1", ss)
+	}
+	// The roll is closed once the tickets already on it are used up.
+	for range th.ticketRoll {
+	}
+	th.Close() // closing again does nothing
+} // TestClose
+
+func TestSetPaymentLimit(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 8, 2)
+	if err := th.SetPaymentLimit(-1); err == nil {
+		tst.Errorf("SetPaymentLimit(-1) should have failed")
+	}
+	if err := th.SetPaymentLimit(3); err != nil {
+		tst.Fatalf("SetPaymentLimit(3) returned error %v", err)
+	}
+	alice := map[string]interface{}{DefaultPaymentIDField: "alice"}
+	bob := map[string]interface{}{DefaultPaymentIDField: "bob"}
+
+	// Alice builds up to her limit over two sales ...
+	for _, n := range []int{2, 1} {
+		rqsts := make([][2]int, n)
+		if _, _, err := th.Sell(2, rqsts, alice, "a dummy time"); err != nil {
+			tst.Fatalf("Sell of %d tickets for alice returned error %v", n, err)
+		}
+	}
+	// ... and can't go past it, without anything being sold.
+	ticks, _, err := th.Sell(2, [][2]int{{0, 0}}, alice, "a dummy time")
+	if !errors.Is(err, ErrPaymentLimit) {
+		tst.Errorf("Sell past alice's limit returned %v, expected %v", err, ErrPaymentLimit)
+	}
+	if len(ticks) != 1 || ticks[0].TicketNum != 0 {
+		tst.Errorf("Sell past alice's limit still issued %+v", ticks)
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[0][0]); ss != 3 {
+		tst.Errorf("After the refused sale, seatsSold[0][0] = %d, expected 3", ss)
+	}
+
+	// The limit is per showing, per payer, and a sale without a payer
+	// identifier isn't limited.
+	if _, _, err := th.Sell(2, [][2]int{{1, 0}}, alice, "a dummy time"); err != nil {
+		tst.Errorf("Sell for another showing for alice returned error %v", err)
+	}
+	if _, _, err := th.Sell(2, [][2]int{{0, 0}, {0, 0}, {0, 0}}, bob, "a dummy time"); err != nil {
+		tst.Errorf("Sell of 3 tickets for bob returned error %v", err)
+	}
+	if _, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Errorf("Sell without a payer identifier returned error %v", err)
+	}
+
+	// Sold-out requests don't count:  the room only has 1 seat left.
+	carol := map[string]interface{}{"card": 1234}
+	if err := th.SetPaymentIDField("card"); err != nil {
+		tst.Fatalf("SetPaymentIDField(card) returned error %v", err)
+	}
+	ticks, _, err = th.Sell(2, [][2]int{{0, 0}, {0, 0}}, carol, "a dummy time")
+	if err != nil || ticks[0].SoldOut || !ticks[1].SoldOut {
+		tst.Fatalf("Sell of the last seat and one more for carol returned %+v, %v", ticks, err)
+	}
+	if n := th.paymentCounts[paymentKey{"1234", 0, 0}]; n != 1 {
+		tst.Errorf("carol's count for movie 0, showing 0 is %d, expected only the 1 sold", n)
+	}
+	if err := th.SetPaymentIDField(""); err == nil {
+		tst.Errorf("SetPaymentIDField(\"\") should have failed")
+	}
+} // TestSetPaymentLimit
+
+func TestSetCustomerTicketLimit(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 3, 2, 3, 2)
+	customer := func(id string) map[string]interface{} {
+		return map[string]interface{}{CustomerIDField: id}
+	}
+	sell := func(window int, id string, rqsts [][2]int) ([]Ticket, Receipt, error) {
+		return th.Sell(window, rqsts, customer(id), "a dummy time")
+	}
+
+	// What alice bought before there was a limit counts towards it.
+	if _, _, err := sell(1, "alice", [][2]int{{0, 0}}); err != nil {
+		tst.Fatalf("Sell for alice returned error %v", err)
+	}
+	if err := th.SetCustomerTicketLimit(-1); err == nil {
+		tst.Errorf("SetCustomerTicketLimit(-1) should have failed")
+	}
+	if err := th.SetCustomerTicketLimit(4); err != nil {
+		tst.Fatalf("SetCustomerTicketLimit(4) returned error %v", err)
+	}
+
+	// Alice builds up to her limit across both windows ...
+	if _, _, err := sell(2, "alice", [][2]int{{0, 1}, {1, 0}}); err != nil {
+		tst.Fatalf("Sell of 2 more for alice at window 2 returned error %v", err)
+	}
+	if _, rcpt, err := sell(1, "alice", [][2]int{{0, 0}, {0, 0}}); !errors.Is(err, ErrCustomerLimit) || rcpt.Total != 0 {
+		tst.Errorf("Sell of 2 past alice's limit returned receipt %+v, error %v, expected nothing sold and %v", rcpt, err, ErrCustomerLimit)
+	}
+	if _, _, err := sell(2, "alice", [][2]int{{2, 0}}); err != nil {
+		tst.Errorf("Sell of alice's last ticket returned error %v", err)
+	}
+	// ... and can't go past it at either window.
+	for window := 1; window <= 2; window++ {
+		if _, _, err := sell(window, "alice", [][2]int{{2, 0}}); !errors.Is(err, ErrCustomerLimit) {
+			tst.Errorf("Sell past alice's limit at window %d returned %v, expected %v", window, err, ErrCustomerLimit)
+		}
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[2][0]); ss != 1 {
+		tst.Errorf("After the refused sales, seatsSold[2][0] = %d, expected 1", ss)
+	}
+
+	// The limit is per customer, and a sale without a customer ID isn't
+	// limited.
+	if _, _, err := sell(1, "bob", [][2]int{{2, 1}, {2, 1}}); err != nil {
+		tst.Errorf("Sell for bob returned error %v", err)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Errorf("Sell without a customer ID returned error %v", err)
+	}
+
+	// Sold-out requests don't count:  showing 1/1 has 3 seats.
+	ticks, _, err := sell(1, "carol", [][2]int{{1, 1}, {1, 1}, {1, 1}, {1, 1}})
+	if err != nil || !ticks[3].SoldOut {
+		tst.Fatalf("Sell of 4 for carol, with 3 seats left, returned %+v, %v", ticks, err)
+	}
+	if _, _, err := sell(2, "carol", [][2]int{{0, 1}}); err != nil {
+		tst.Errorf("Sell of carol's 4th ticket, after one sold out, returned error %v", err)
+	}
+	if _, _, err := sell(2, "carol", [][2]int{{0, 1}}); !errors.Is(err, ErrCustomerLimit) {
+		tst.Errorf("Sell of carol's 5th ticket returned %v, expected %v", err, ErrCustomerLimit)
+	}
+
+	// Prepared sales count while they hold their seats, and not once they
+	// are aborted.
+	if _, err := th.PrepareSale(2, [][2]int{{1, 0}}, customer("dave"), "a dummy time"); err != nil {
+		tst.Fatalf("PrepareSale for dave returned error %v", err)
+	}
+	prepared, err := th.PrepareSale(1, [][2]int{{1, 0}}, customer("dave"), "a dummy time")
+	if err != nil {
+		tst.Fatalf("PrepareSale of 1 more for dave returned error %v", err)
+	}
+	if _, err := th.PrepareSale(1, [][2]int{{0, 1}, {0, 1}, {0, 1}}, customer("dave"), "a dummy time"); !errors.Is(err, ErrCustomerLimit) {
+		tst.Errorf("PrepareSale past dave's limit returned %v, expected %v", err, ErrCustomerLimit)
+	}
+	last3 := [][2]int{{1, 0}, {0, 1}, {2, 1}} // the last seat of each
+	if _, _, err := sell(1, "dave", last3); !errors.Is(err, ErrCustomerLimit) {
+		tst.Errorf("Sell of 3 more for dave returned %v, expected %v", err, ErrCustomerLimit)
+	}
+	if err := th.AbortSale(prepared.TxID); err != nil {
+		tst.Fatalf("AbortSale returned error %v", err)
+	}
+	if ticks, _, err := sell(1, "dave", last3); err != nil || ticks[0].SoldOut || ticks[1].SoldOut || ticks[2].SoldOut {
+		tst.Errorf("Sell of 3 more for dave, after an aborted sale, returned %+v, %v, expected 3 tickets", ticks, err)
+	}
+} // TestSetCustomerTicketLimit
+
+func TestCustomerTicketLimitRace(tst *testing.T) {
+	const limit, sellers = 5, 20
+	th := newTestTheatre(tst, 5, 1, 1, sellers, 2)
+	th.SetCustomerTicketLimit(limit)
+	var sold int32
+	var wg sync.WaitGroup
+	for i := 0; i < sellers; i++ {
+		wg.Add(1)
+		go func(window int) {
+			defer wg.Done()
+			_, _, err := th.Sell(window, [][2]int{{0, 0}}, map[string]interface{}{CustomerIDField: "eve"}, "a dummy time")
+			switch {
+			case err == nil:
+				atomic.AddInt32(&sold, 1)
+			case !errors.Is(err, ErrCustomerLimit):
+				tst.Errorf("Sell for eve returned error %v, expected nil or %v", err, ErrCustomerLimit)
+			}
+		}(1 + i%2)
+	}
+	wg.Wait()
+	if sold != limit {
+		tst.Errorf("%d concurrent sales for eve sold %d tickets, expected the limit of %d", sellers, sold, limit)
+	}
+} // TestCustomerTicketLimitRace
+
+func TestSetUpgradeMenu(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 8, 2)
+	ticks, saleRcpt, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell at window 1 returned error %v", err)
+	}
+	if err := th.SetUpgradeMenu(map[string][]Upgrade{"water": {{"soda", 0}, {"large soda", 150}}}); err != nil {
+		tst.Fatalf("SetUpgradeMenu returned error %v", err)
+	}
+
+	rcpt, err := th.ExchangeWithReceipt(ticks[0].TicketNum, "water", "soda")
+	if err != nil || rcpt.Total != 0 || len(rcpt.ItemsSold) != 1 || rcpt.ReceiptNum != 0 {
+		tst.Errorf("Free upgrade returned receipt %+v, error %v, expected an unnumbered receipt for 0", rcpt, err)
+	}
+	rcpt, err = th.ExchangeWithReceipt(ticks[1].TicketNum, "water", "large soda")
+	if err != nil || rcpt.Total != 150 || rcpt.ItemsSold[0].Penneys != 150 || rcpt.Window != 1 {
+		tst.Errorf("Surcharge upgrade returned receipt %+v, error %v, expected 150 at window 1", rcpt, err)
+	}
+	if rcpt.ReceiptNum != saleRcpt.ReceiptNum+1 {
+		tst.Errorf("Surcharge upgrade got receipt %d, expected %d, next after the sale's (the free upgrade's isn't numbered)", rcpt.ReceiptNum, saleRcpt.ReceiptNum+1)
+	}
+	if got, err := th.ReceiptByNum(rcpt.ReceiptNum); err != nil || got.Total != 150 {
+		tst.Errorf("ReceiptByNum(%d) returned %+v, %v, expected the surcharge receipt", rcpt.ReceiptNum, got, err)
+	}
+
+	for _, c := range []struct{ old, new string }{{"water", "beer"}, {"popcorn", "soda"}} {
+		if err := th.Exchange(ticks[2].TicketNum, c.old, c.new); err != ErrXchNotOnMenu {
+			tst.Errorf("Exchange of %s for %s returned %v, expected %v", c.old, c.new, err, ErrXchNotOnMenu)
+		}
+	}
+	if th.ticketRqstDB[ticks[2].TicketNum].Exchanged || th.totExchanges != 2 {
+		tst.Errorf("A disallowed exchange was recorded, or took a goodie (%d taken, expected 2)", th.totExchanges)
+	}
+
+	// Bad menus are refused, and a nil menu allows anything again.
+	if err := th.SetUpgradeMenu(map[string][]Upgrade{"water": {{"soda", 0}, {"soda", 5}}}); err == nil {
+		tst.Errorf("SetUpgradeMenu with a duplicate upgrade should have failed")
+	}
+	if err := th.SetUpgradeMenu(map[string][]Upgrade{"water": {{"", 5}}}); err == nil {
+		tst.Errorf("SetUpgradeMenu with an unnamed upgrade should have failed")
+	}
+	if err := th.SetUpgradeMenu(nil); err != nil {
+		tst.Fatalf("SetUpgradeMenu(nil) returned error %v", err)
+	}
+	if err := th.Exchange(ticks[2].TicketNum, "water", "beer"); err != nil {
+		tst.Errorf("Exchange with no menu returned error %v", err)
+	}
+} // TestSetUpgradeMenu
+
+func TestSetReadOnly(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 2, 8, 2)
+	ticks, rcpt, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell before read-only mode returned error %v", err)
+	}
+	if err := th.Exchange(ticks[1].TicketNum, "water", "soda"); err != nil {
+		tst.Fatalf("Exchange before read-only mode returned error %v", err)
+	}
+
+	th.SetReadOnly(true)
+	if _, _, err := th.Sell(1, [][2]int{{0, 1}}, nil, "a dummy time"); err != ErrReadOnly {
+		tst.Errorf("Sell in read-only mode returned %v, expected %v", err, ErrReadOnly)
+	}
+	if err := th.Exchange(ticks[0].TicketNum, "water", "soda"); err != ErrReadOnly {
+		tst.Errorf("Exchange in read-only mode returned %v, expected %v", err, ErrReadOnly)
+	}
+	if err := th.UndoExchange(ticks[1].TicketNum); err != ErrReadOnly {
+		tst.Errorf("UndoExchange in read-only mode returned %v, expected %v", err, ErrReadOnly)
+	}
+	if _, err := th.VoidLastSale(1); err != ErrReadOnly {
+		tst.Errorf("VoidLastSale in read-only mode returned %v, expected %v", err, ErrReadOnly)
+	}
+	if err := th.ResetShowing(0, 0); err != ErrReadOnly {
+		tst.Errorf("ResetShowing in read-only mode returned %v, expected %v", err, ErrReadOnly)
+	}
+	if got := th.TicketsForShowing(0, 0); len(got) != 2 {
+		tst.Errorf("TicketsForShowing in read-only mode returned %d tickets, expected 2", len(got))
+	}
+	if _, err := th.ReceiptByNum(rcpt.ReceiptNum); err != nil {
+		tst.Errorf("ReceiptByNum in read-only mode returned error %v", err)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck in read-only mode found %v", problems)
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[0][1]); ss != 0 {
+		tst.Errorf("Refused sale left seatsSold[0][1] at %d, expected 0", ss)
+	}
+
+	th.SetReadOnly(false)
+	if _, _, err := th.Sell(1, [][2]int{{0, 1}}, nil, "a dummy time"); err != nil {
+		tst.Errorf("Sell after read-only mode returned error %v", err)
+	}
+} // TestSetReadOnly
+
+func TestSetReceiptFooter(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 4, 2)
+	_, rcpt, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	if rcpt.Footer != nil {
+		tst.Errorf("Receipt without a footer set has Footer %q, expected none", rcpt.Footer)
+	}
+	if js, _ := json.Marshal(rcpt); strings.Contains(string(js), `"footer"`) {
+		tst.Errorf("Receipt without a footer set marshals as %s, expected no footer key", js)
+	}
+
+	footer := []string{"Thank you for coming!", "Half-price popcorn on Tuesdays."}
+	th.SetReceiptFooter(footer)
+	footer[0] = "changed after SetReceiptFooter"
+	_, rcpt, err = th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	text := rcpt.String()
+	for _, line := range []string{"Thank you for coming!", "Half-price popcorn on Tuesdays."} {
+		if !strings.Contains(text, line+"\n") {
+			tst.Errorf("Receipt text %q does not include footer line %q", text, line)
+		}
+	}
+	if !strings.HasSuffix(text, "Half-price popcorn on Tuesdays.\n") {
+		tst.Errorf("Receipt text %q does not end with the footer", text)
+	}
+	js, _ := json.Marshal(rcpt)
+	if want := `"footer":["Thank you for coming!","Half-price popcorn on Tuesdays."]`; !strings.Contains(string(js), want) {
+		tst.Errorf("Receipt marshals as %s, expected it to include %s", js, want)
+	}
+
+	th.SetReceiptFooter(nil)
+	_, rcpt, _ = th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if text := rcpt.String(); strings.Contains(text, "Thank you") {
+		tst.Errorf("Receipt text after clearing the footer is %q, expected no footer", text)
+	}
+} // TestSetReceiptFooter
+
+func TestSetGoodieRationing(tst *testing.T) {
+	th := newTestTheatre(tst, 10, 1, 1, 10, 2)
+	start := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+	fakeNow := start
+	th.clock = func() time.Time { return fakeNow }
+	ticks, _, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+
+	// 4 goodies over 8 hours:  one more may be had every 2 hours.
+	th.SetGoodieRationing(4, 8*time.Hour)
+	if err := th.Exchange(ticks[0].TicketNum, "water", "soda"); err != ErrXchRationed {
+		tst.Errorf("Exchange as rationing starts returned %v, expected %v", err, ErrXchRationed)
+	}
+	fakeNow = start.Add(2 * time.Hour)
+	if err := th.Exchange(ticks[0].TicketNum, "water", "soda"); err != nil {
+		tst.Errorf("Exchange after 2 hours returned error %v", err)
+	}
+	if err := th.Exchange(ticks[1].TicketNum, "water", "soda"); err != ErrXchRationed {
+		tst.Errorf("Second exchange after 2 hours returned %v, expected %v", err, ErrXchRationed)
+	}
+	fakeNow = start.Add(5 * time.Hour)
+	if err := th.Exchange(ticks[1].TicketNum, "water", "soda"); err != nil {
+		tst.Errorf("Exchange after 5 hours returned error %v", err)
+	}
+	if err := th.Exchange(ticks[2].TicketNum, "water", "soda"); err != ErrXchRationed {
+		tst.Errorf("Third exchange after 5 hours returned %v, expected %v", err, ErrXchRationed)
+	}
+
+	// Undoing an exchange gives its share of the allowance back.
+	if err := th.UndoExchange(ticks[1].TicketNum); err != nil {
+		tst.Fatalf("UndoExchange returned error %v", err)
+	}
+	if err := th.Exchange(ticks[2].TicketNum, "water", "soda"); err != nil {
+		tst.Errorf("Exchange after an undo returned error %v", err)
+	}
+
+	// Once the whole period has passed, all of the ration may be used.
+	fakeNow = start.Add(10 * time.Hour)
+	for i := 3; i < 5; i++ {
+		if err := th.Exchange(ticks[i].TicketNum, "water", "soda"); err != nil {
+			tst.Errorf("Exchange on ticket %d after the rationing period returned error %v", i, err)
+		}
+	}
+	if err := th.Exchange(ticks[5].TicketNum, "water", "soda"); err != ErrXchRationed {
+		tst.Errorf("Exchange beyond the ration returned %v, expected %v", err, ErrXchRationed)
+	}
+
+	th.SetGoodieRationing(0, 0)
+	if err := th.Exchange(ticks[5].TicketNum, "water", "soda"); err != nil {
+		tst.Errorf("Exchange with rationing turned off returned error %v", err)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck after rationed exchanges found %v", problems)
+	}
+} // TestSetGoodieRationing
+
+func TestSelloutTimes(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 3, 1)
+	start := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+	fakeNow := start
+	th.clock = func() time.Time { return fakeNow }
+
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}, {1, 0}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	if times := th.SelloutTimes(); len(times) != 0 {
+		tst.Errorf("SelloutTimes with nothing sold out returned %v, expected nothing", times)
+	}
+	fakeNow = start.Add(time.Hour)
+	ticks, _, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil || !ticks[1].SoldOut {
+		tst.Fatalf("Sell of the last seat and one more returned %+v, error %v", ticks, err)
+	}
+	want := map[int]map[int]time.Time{0: {0: start.Add(time.Hour)}}
+	if times := th.SelloutTimes(); !reflect.DeepEqual(times, want) {
+		tst.Errorf("SelloutTimes after movie 0 sold out returned %v, expected %v", times, want)
+	}
+
+	// Selling out again, after a void gives a seat back, keeps the first time.
+	fakeNow = start.Add(2 * time.Hour)
+	if _, err := th.VoidLastSale(1); err != nil {
+		tst.Fatalf("VoidLastSale returned error %v", err)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell after the void returned error %v", err)
+	}
+	times := th.SelloutTimes()
+	if !reflect.DeepEqual(times, want) {
+		tst.Errorf("SelloutTimes after selling out again returned %v, expected %v", times, want)
+	}
+	times[0][0] = fakeNow
+	if again := th.SelloutTimes(); !reflect.DeepEqual(again, want) {
+		tst.Errorf("SelloutTimes returned %v after its last reply was changed, expected a copy", again)
+	}
+
+	if err := th.ResetShowing(0, 0); err != nil {
+		tst.Fatalf("ResetShowing returned error %v", err)
+	}
+	if times := th.SelloutTimes(); len(times[0]) != 0 {
+		tst.Errorf("SelloutTimes after ResetShowing returned %v, expected nothing", times)
+	}
+} // TestSelloutTimes
+
+func TestNextAvailableShowing(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 4, 2, 1)
+	evening := time.Date(2020, 6, 1, 19, 0, 0, 0, time.UTC)
+	fakeNow := evening.Add(30 * time.Minute)
+	th.clock = func() time.Time { return fakeNow }
+
+	// Showing 0 has started, and 1 is sold out;  3 starts before 2.
+	for s, start := range []time.Time{evening, evening.Add(time.Hour), evening.Add(3 * time.Hour), evening.Add(2 * time.Hour)} {
+		if err := th.SetShowingStart(0, s, start); err != nil {
+			tst.Fatalf("SetShowingStart(0, %d) returned error %v", s, err)
+		}
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 1}, {0, 1}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	if showing, found := th.NextAvailableShowing(0); !found || showing != 3 {
+		tst.Errorf("NextAvailableShowing(0) returned %d, %v, expected 3, true", showing, found)
+	}
+	if err := th.Blackout(0, 3, true); err != nil {
+		tst.Fatalf("Blackout returned error %v", err)
+	}
+	if showing, found := th.NextAvailableShowing(0); !found || showing != 2 {
+		tst.Errorf("NextAvailableShowing(0) with showing 3 blacked out returned %d, %v, expected 2, true", showing, found)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 2}, {0, 2}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	if showing, found := th.NextAvailableShowing(0); found {
+		tst.Errorf("NextAvailableShowing(0) with every showing started, sold out or blacked out returned %d, expected none", showing)
+	}
+
+	// Without start times, the showings are taken in number order.
+	if showing, found := th.NextAvailableShowing(1); !found || showing != 0 {
+		tst.Errorf("NextAvailableShowing(1) returned %d, %v, expected 0, true", showing, found)
+	}
+	for _, movie := range []int{-1, 2} {
+		if _, found := th.NextAvailableShowing(movie); found {
+			tst.Errorf("NextAvailableShowing(%d) found a showing of a movie out of range", movie)
+		}
+	}
+} // TestNextAvailableShowing
+
+func TestNewTheatreReportsAllProblems(tst *testing.T) {
+	th, err := NewTheatre(Config{Logger: log.New(os.Stderr, tst.Name()+":  ", log.Ldate|log.Ltime|log.Llongfile), MaxExchanges: -1, MaxMovies: 0, MaxShowings: 2, MaxSeats: 0, MaxWindows: 0})
+	if err == nil {
+		tst.Fatalf("NewTheatre with four invalid settings returned nil error, and Theatre %v", th)
+	}
+	if th != nil {
+		tst.Errorf("NewTheatre with invalid settings returned Theatre %v, expected nil", th)
+	}
+	for _, field := range []string{"MaxExchanges -1", "MaxMovies 0", "MaxSeats 0", "MaxWindows 0"} {
+		if !strings.Contains(err.Error(), field) {
+			tst.Errorf("NewTheatre error %q does not report %s", err, field)
+		}
+	}
+	if strings.Contains(err.Error(), "MaxShowings") {
+		tst.Errorf("NewTheatre error %q reports the valid MaxShowings", err)
+	}
+	if lines := strings.Count(err.Error(), "\n") + 1; lines != 4 {
+		tst.Errorf("NewTheatre error %q has %d lines, expected one per problem (4)", err, lines)
+	}
+} // TestNewTheatreReportsAllProblems
+
+func TestTicketsByCustomer(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 2, 3, 2)
+	alice := map[string]interface{}{CustomerIDField: "alice"}
+	bob := map[string]interface{}{CustomerIDField: "bob"}
+	aliceTicks, _, err := th.Sell(1, [][2]int{{0, 0}, {1, 1}}, alice, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell to alice returned error %v", err)
+	}
+	bobTicks, _, err := th.Sell(2, [][2]int{{0, 0}, {0, 1}, {1, 0}}, bob, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell to bob returned error %v", err)
+	}
+	more, _, err := th.Sell(2, [][2]int{{1, 1}}, alice, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Second sell to alice returned error %v", err)
+	}
+	aliceTicks = append(aliceTicks, more...)
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell without a customer ID returned error %v", err)
+	}
+
+	for _, c := range []struct {
+		id   string
+		want []Ticket
+	}{{"alice", aliceTicks}, {"bob", bobTicks}} {
+		got := th.TicketsByCustomer(c.id)
+		if len(got) != len(c.want) {
+			tst.Errorf("TicketsByCustomer(%s) returned %d tickets, expected %d:  %+v", c.id, len(got), len(c.want), got)
+			continue
+		}
+		for i, t := range got {
+			if t != c.want[i] || t.CustomerID != c.id {
+				tst.Errorf("TicketsByCustomer(%s) ticket %d is %+v, expected %+v", c.id, i, t, c.want[i])
+			}
+		}
+	}
+	if t, err := th.readTicket(bobTicks[0].TicketNum); err != nil || t.CustomerID != "bob" {
+		tst.Errorf("readTicket(%d) returned %+v, error %v, expected bob's CustomerID", bobTicks[0].TicketNum, t, err)
+	}
+	if got := th.TicketsByCustomer("carol"); len(got) != 0 {
+		tst.Errorf("TicketsByCustomer(carol) returned %+v, expected none", got)
+	}
+	if got := th.TicketsByCustomer(""); got != nil {
+		tst.Errorf("TicketsByCustomer(\"\") returned %+v, expected nil", got)
+	}
+
+	want := []CustomerCount{{"alice", 3}, {"bob", 3}} // a tie, so by CustomerID
+	if got := th.TopCustomers(0); fmt.Sprint(got) != fmt.Sprint(want) {
+		tst.Errorf("TopCustomers(0) returned %+v, expected %+v", got, want)
+	}
+
+	// Voided tickets no longer count.
+	if _, err := th.VoidLastSale(2); err != nil {
+		tst.Fatalf("VoidLastSale returned error %v", err)
+	}
+	want = []CustomerCount{{"bob", 3}}
+	if got := th.TopCustomers(1); fmt.Sprint(got) != fmt.Sprint(want) {
+		tst.Errorf("TopCustomers(1) after voiding one of alice's tickets returned %+v, expected %+v", got, want)
+	}
+	if got := th.TicketsByCustomer("alice"); len(got) != 2 {
+		tst.Errorf("TicketsByCustomer(alice) after a void returned %d tickets, expected 2", len(got))
+	}
+} // TestTicketsByCustomer
+
+func TestRevenue(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 3, 2, 3, 2)
+	day1 := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	fakeNow := day1.Add(10 * time.Hour)
+	th.clock = func() time.Time { return fakeNow }
+	if err := th.UpdatePrices([]PriceUpdate{{0, 0, 1000}, {0, 1, 1250}, {1, 0, 800}, {2, 1, 1500}}); err != nil {
+		tst.Fatalf("UpdatePrices returned error %v", err)
+	}
+
+	sell := func(window int, rqsts [][2]int) {
+		if _, _, err := th.Sell(window, rqsts, nil, "a dummy time"); err != nil {
+			tst.Fatalf("Sell at window %d returned error %v", window, err)
+		}
+	}
+	sell(1, [][2]int{{0, 0}, {0, 1}, {1, 0}})
+	sell(2, [][2]int{{1, 0}, {2, 1}})
+	fakeNow = day2.Add(10 * time.Hour)
+	sell(1, [][2]int{{0, 0}, {2, 1}})
+	// The first is the last seat for 0/0, and the second is sold out.  Then
+	// the sale is voided, so neither counts.
+	sell(2, [][2]int{{0, 0}, {0, 0}})
+	if _, err := th.VoidLastSale(2); err != nil {
+		tst.Fatalf("VoidLastSale returned error %v", err)
+	}
+
+	intp := func(n int) *int { return &n }
+	for _, c := range []struct {
+		filter RevenueFilter
+		want   int
+	}{
+		{RevenueFilter{}, 1000 + 1250 + 800 + 800 + 1500 + 1000 + 1500},
+		{RevenueFilter{Movie: intp(0)}, 1000 + 1250 + 1000},
+		{RevenueFilter{Movie: intp(0), Showing: intp(0)}, 1000 + 1000},
+		{RevenueFilter{Showing: intp(1)}, 1250 + 1500 + 1500},
+		{RevenueFilter{Window: intp(2)}, 800 + 1500},
+		{RevenueFilter{Movie: intp(1), Window: intp(1)}, 800},
+		{RevenueFilter{Window: intp(1), From: day2}, 1000 + 1500},
+		{RevenueFilter{Movie: intp(2), Showing: intp(1), To: day2}, 1500},
+		{RevenueFilter{From: day1, To: day2}, 1000 + 1250 + 800 + 800 + 1500},
+		{RevenueFilter{From: day2.Add(10 * time.Hour), To: day2.Add(10 * time.Hour)}, 0},
+		{RevenueFilter{Movie: intp(1), Showing: intp(1)}, 0},
+	} {
+		if got := th.Revenue(c.filter); got != c.want {
+			tst.Errorf("Revenue(%+v) returned %d, expected %d", c.filter, got, c.want)
+		}
+	}
+
+	// The sale time is kept with the ticket.
+	ticks := th.TicketsForShowing(2, 1)
+	if len(ticks) != 2 || !ticks[0].SoldAt.Equal(day1.Add(10*time.Hour)) || !ticks[1].SoldAt.Equal(day2.Add(10*time.Hour)) {
+		tst.Errorf("TicketsForShowing(2, 1) returned %+v, expected tickets sold at 10:00 on each day", ticks)
+	}
+} // TestRevenue
+
+func TestCompact(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 2, 2, 2)
+	retained := func() int {
+		th.ticketDBmutex.Lock()
+		defer th.ticketDBmutex.Unlock()
+		bytes := 0
+		for _, t := range th.ticketRqstDB {
+			bytes += len(t.CustomerID) + len(t.XchOld) + len(t.XchNew)
+		}
+		return bytes
+	}
+	sell := func(window int, rqsts [][2]int, id string) []Ticket {
+		ticks, _, err := th.Sell(window, rqsts, map[string]interface{}{CustomerIDField: id}, "a dummy time")
+		if err != nil {
+			tst.Fatalf("Sell to %s returned error %v", id, err)
+		}
+		return ticks
+	}
+
+	alice := sell(2, [][2]int{{0, 0}, {0, 0}, {0, 0}}, "alice") // the third is a sold-out placeholder
+	sell(2, [][2]int{{0, 1}}, "bob")
+	if _, err := th.VoidLastSale(2); err != nil {
+		tst.Fatalf("VoidLastSale returned error %v", err)
+	}
+	carol := sell(1, [][2]int{{1, 1}}, "carol")
+	if err := th.Exchange(carol[0].TicketNum, "water", "soda"); err != nil {
+		tst.Fatalf("Exchange returned error %v", err)
+	}
+	if err := th.ResetShowing(1, 1); err != nil {
+		tst.Fatalf("ResetShowing returned error %v", err)
+	}
+	dave := sell(1, [][2]int{{1, 0}}, "dave")
+
+	before := retained()
+	sink := &auditCapture{}
+	th.SetAuditSink(sink)
+	if n := th.Compact(); n != 3 {
+		tst.Errorf("Compact released %d Tickets, expected 3 (alice's placeholder, bob's and carol's void tickets)", n)
+	}
+	if after := retained(); after >= before {
+		tst.Errorf("Compact left %d bytes of Ticket data, expected less than the %d before", after, before)
+	}
+	if n := th.Compact(); n != 0 {
+		tst.Errorf("Second Compact released %d Tickets, expected 0", n)
+	}
+	th.SetAuditSink(nil)
+	if len(sink.records) != 1 || sink.records[0].Op != "Compact" || sink.records[0].After != "3 Tickets released" || sink.records[0].Outcome != "ok" {
+		tst.Errorf("Audit sink got %+v from two Compacts, expected one record of 3 Tickets released", sink.records)
+	}
+
+	for _, want := range []Ticket{alice[0], dave[0]} {
+		if got, err := th.readTicket(want.TicketNum); err != nil || got.Movie != want.Movie || got.Showing != want.Showing || got.Void {
+			tst.Errorf("Ticket %d after Compact is %+v (error %v), expected %+v", want.TicketNum, got, err, want)
+		}
+	}
+	if got := th.TicketsByCustomer("dave"); len(got) != 1 || got[0] != dave[0] {
+		tst.Errorf("TicketsByCustomer(dave) after Compact returned %+v, expected %+v", got, dave)
+	}
+	if got := th.TicketsByCustomer("alice"); len(got) != 2 || got[0] != alice[0] || got[1] != alice[1] {
+		tst.Errorf("TicketsByCustomer(alice) after Compact returned %+v, expected %+v", got, alice[:2])
+	}
+	if got, err := th.readTicket(alice[2].TicketNum); err != nil || !got.SoldOut {
+		tst.Errorf("Sold-out placeholder %d after Compact is %+v (error %v), expected it to still be there", alice[2].TicketNum, got, err)
+	}
+	// carol's goodie is still out, but her exchange can't be undone.
+	if got, err := th.readTicket(carol[0].TicketNum); err != nil || !got.Exchanged || got.XchOld != "" || got.XchNew != "" {
+		tst.Errorf("Exchanged void ticket %d after Compact is %+v (error %v), expected Exchanged with its goodies released", carol[0].TicketNum, got, err)
+	}
+	if err := th.UndoExchange(carol[0].TicketNum); err != ErrTicketVoid {
+		tst.Errorf("UndoExchange of a compacted void ticket returned %v, expected %v", err, ErrTicketVoid)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck after Compact found %v", problems)
+	}
+
+	// In the background, too.
+	sell(2, [][2]int{{1, 0}, {1, 0}}, "erin") // the second is a sold-out placeholder
+	want := retained() - len("erin")
+	th.SetCompactInterval(time.Millisecond)
+	defer th.SetCompactInterval(0)
+	deadline := time.Now().Add(5 * time.Second)
+	for retained() > want {
+		if time.Now().After(deadline) {
+			tst.Fatalf("Background compaction left %d bytes of Ticket data, expected %d", retained(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+} // TestCompact
+
+func TestCompactDuringSales(tst *testing.T) {
+	// Run with -race:  the background compaction scans the DB while the
+	// sales allocate ticket numbers.
+	th := newTestTheatre(tst, 5, 1, 1, 200, 1)
+	th.SetCompactInterval(time.Millisecond)
+	defer th.SetCompactInterval(0)
+	for i := 0; i < 100; i++ {
+		if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+			tst.Fatalf("Sell %d returned error %v", i, err)
+		}
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck after sales during compaction found %v", problems)
+	}
+} // TestCompactDuringSales
+
+func TestSetSellTimeout(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 4, 2)
+	th.SetSellTimeout(50 * time.Millisecond)
+	th.SetPaymentLimit(1)
+	payer := map[string]interface{}{DefaultPaymentIDField: "card 1"}
+
+	// Hold the theatre up, as a long ResetShowing would.
+	th.resetLock.Lock()
+	start := time.Now()
+	ticks, _, err := th.Sell(1, [][2]int{{0, 0}}, payer, "a dummy time")
+	elapsed := time.Since(start)
+	th.resetLock.Unlock()
+	if err != ErrBusy {
+		tst.Errorf("Sell while held up returned %v, expected %v", err, ErrBusy)
+	}
+	if len(ticks) != 0 {
+		tst.Errorf("Sell while held up returned tickets %+v, expected none", ticks)
+	}
+	if elapsed < 50*time.Millisecond || elapsed > 2*time.Second {
+		tst.Errorf("Sell while held up gave up after %v, expected about 50ms", elapsed)
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[0][0]); ss != 0 {
+		tst.Errorf("Busy sale left seatsSold[0][0] at %d, expected 0", ss)
+	}
+
+	// Nothing was counted against the payer, and a short hold-up is waited out.
+	th.resetLock.Lock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		th.resetLock.Unlock()
+	}()
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, payer, "a dummy time"); err != nil {
+		tst.Errorf("Sell held up for less than the timeout returned error %v", err)
+	}
+
+	// With no timeout, Sell waits as long as it takes.
+	th.SetSellTimeout(0)
+	th.resetLock.Lock()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		th.resetLock.Unlock()
+	}()
+	if _, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Errorf("Sell with no timeout returned error %v", err)
+	}
+} // TestSetSellTimeout
+
+func TestSetFlatPrice(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 6, 2)
+	if err := th.SetFlatPrice(-1, true); err == nil {
+		tst.Error("SetFlatPrice(-1, true) should have failed for a negative price")
+	}
+
+	price := func(what string) int {
+		ticks, rcpt, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time")
+		if err != nil {
+			tst.Fatalf("Sell %s returned error %v", what, err)
+		}
+		if rcpt.Total != ticks[0].Price {
+			tst.Errorf("Sell %s charged %d for a ticket priced at %d", what, rcpt.Total, ticks[0].Price)
+		}
+		return ticks[0].Price
+	}
+
+	if got := price("before the promotion"); got != 1000 {
+		tst.Errorf("Sell before the promotion priced the ticket at %d, expected 1000", got)
+	}
+	if err := th.SetFlatPrice(500, true); err != nil {
+		tst.Fatalf("SetFlatPrice(500, true) returned error %v", err)
+	}
+	if got := price("during the promotion"); got != 500 {
+		tst.Errorf("Sell during the promotion priced the ticket at %d, expected 500", got)
+	}
+
+	// The flat price even beats the price bounds.
+	th.SetPriceBounds(800, 2000)
+	if got := price("during the promotion, with bounds 800 to 2000"); got != 500 {
+		tst.Errorf("Sell during the promotion, with bounds 800 to 2000, priced the ticket at %d, expected 500", got)
+	}
+
+	if err := th.SetFlatPrice(500, false); err != nil {
+		tst.Fatalf("SetFlatPrice(500, false) returned error %v", err)
+	}
+	if got := price("after the promotion"); got != 1000 {
+		tst.Errorf("Sell after the promotion priced the ticket at %d, expected 1000", got)
+	}
+	th.SetPriceBounds(1200, 2000)
+	if got := price("after the promotion, with bounds 1200 to 2000"); got != 1200 {
+		tst.Errorf("Sell after the promotion, with bounds 1200 to 2000, priced the ticket at %d, expected 1200", got)
+	}
+} // TestSetFlatPrice
+
+func TestUpdatePrices(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 2, 100, 2)
+	price := func(m int, s int) int {
+		ticks, _, err := th.Sell(2, [][2]int{{m, s}}, nil, "a dummy time")
+		if err != nil {
+			tst.Fatalf("Sell for movie %d, showing %d returned error %v", m, s, err)
+		}
+		return ticks[0].Price
+	}
+
+	if err := th.UpdatePrices([]PriceUpdate{{0, 0, 1500}, {1, 1, 800}}); err != nil {
+		tst.Fatalf("UpdatePrices returned error %v", err)
+	}
+	for _, c := range []struct{ m, s, want int }{{0, 0, 1500}, {1, 1, 800}, {0, 1, 1000}} {
+		if got := price(c.m, c.s); got != c.want {
+			tst.Errorf("Sell for movie %d, showing %d priced the ticket at %d, expected %d", c.m, c.s, got, c.want)
+		}
+	}
+
+	for _, bad := range [][]PriceUpdate{{{0, 0, 900}, {0, 2, 100}}, {{0, 0, 900}, {2, 0, 100}}, {{0, 0, 900}, {1, 0, -1}}} {
+		if err := th.UpdatePrices(bad); err == nil {
+			tst.Errorf("UpdatePrices(%v) should have failed", bad)
+		}
+	}
+	if got := price(0, 0); got != 1500 {
+		tst.Errorf("Sell after a refused UpdatePrices priced the ticket at %d, expected 1500 (unchanged)", got)
+	}
+
+	// Sales made while a batch is applied see all of it or none of it.
+	low := []PriceUpdate{{0, 1, 600}, {1, 0, 600}}
+	high := []PriceUpdate{{0, 1, 1400}, {1, 0, 1400}}
+	th.UpdatePrices(low)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				th.UpdatePrices(high)
+			} else {
+				th.UpdatePrices(low)
+			}
+		}
+	}()
+	var sellers sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		sellers.Add(1)
+		go func() {
+			defer sellers.Done()
+			for i := 0; i < 20; i++ {
+				ticks, _, err := th.Sell(1, [][2]int{{0, 1}, {1, 0}}, nil, "a dummy time")
+				if err != nil {
+					tst.Errorf("Sell during UpdatePrices returned error %v", err)
+					return
+				}
+				for _, t := range ticks {
+					if t.Price != 600 && t.Price != 1400 {
+						tst.Errorf("Sell during UpdatePrices priced %+v, expected 600 or 1400", t)
+					}
+				}
+			}
+		}()
+	}
+	sellers.Wait()
+	close(done)
+	wg.Wait()
+
+	th.UpdatePrices(high)
+	if got := price(1, 0); got != 1400 {
+		tst.Errorf("Sell after the last UpdatePrices priced the ticket at %d, expected 1400", got)
+	}
+} // TestUpdatePrices
+
+func TestReissue(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 2, 4, 2)
+	start := time.Date(2020, 6, 1, 19, 0, 0, 0, time.UTC)
+	fakeNow := start.Add(-time.Hour)
+	th.clock = func() time.Time { return fakeNow }
+	if err := th.SetShowingStart(0, 0, start); err != nil {
+		tst.Fatalf("SetShowingStart returned error %v", err)
+	}
+	if err := th.SetShowingStart(0, 2, start); err == nil {
+		tst.Error("SetShowingStart for showing 2 of 2 should have failed")
+	}
+
+	ticks, _, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}}, map[string]interface{}{CustomerIDField: "alice"}, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	if err := th.Exchange(ticks[1].TicketNum, "water", "soda"); err != nil {
+		tst.Fatalf("Exchange returned error %v", err)
+	}
+
+	for _, lost := range ticks {
+		got, err := th.Reissue(lost.TicketNum)
+		if err != nil {
+			tst.Fatalf("Reissue of ticket %d returned error %v", lost.TicketNum, err)
+		}
+		want := lost
+		want.TicketNum = got.TicketNum
+		if lost.TicketNum == ticks[1].TicketNum {
+			want.Exchanged, want.XchOld, want.XchNew = true, "water", "soda"
+		}
+		if got.TicketNum == lost.TicketNum || got != want {
+			tst.Errorf("Reissue of ticket %+v returned %+v, expected %+v with a new number", lost, got, want)
+		}
+		if old, _ := th.readTicket(lost.TicketNum); !old.Void || old.Exchanged {
+			tst.Errorf("Reissued ticket is now %+v, expected it to be void, with no exchange", old)
+		}
+		if _, err := th.Reissue(lost.TicketNum); err != ErrTicketVoid {
+			tst.Errorf("Second Reissue of ticket %d returned %v, expected %v", lost.TicketNum, err, ErrTicketVoid)
+		}
+		if err := th.Exchange(lost.TicketNum, "water", "soda"); err != ErrTicketVoid {
+			tst.Errorf("Exchange on reissued ticket %d returned %v, expected %v", lost.TicketNum, err, ErrTicketVoid)
+		}
+	}
+	reissued := th.TicketsForShowing(0, 0)
+	if len(reissued) != 2 {
+		tst.Fatalf("TicketsForShowing after reissues returned %+v, expected the 2 replacements", reissued)
+	}
+	if err := th.Exchange(reissued[1].TicketNum, "water", "soda"); err != ErrXchAlreadyDone {
+		tst.Errorf("Exchange on the replacement for an exchanged ticket returned %v, expected %v", err, ErrXchAlreadyDone)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck after reissues found %v", problems)
+	}
+
+	// VoidLastSale voids the replacements, and gives their seats back.
+	voided, err := th.VoidLastSale(1)
+	if err != nil || len(voided) != 2 || voided[0].TicketNum != reissued[0].TicketNum {
+		tst.Errorf("VoidLastSale after reissues returned %+v, %v, expected the 2 replacements", voided, err)
+	}
+	if ss := atomic.LoadInt32(&th.seatsSold[0][0]); ss != 0 {
+		tst.Errorf("VoidLastSale after reissues left seatsSold[0][0] at %d, expected 0", ss)
+	}
+
+	// Once the showing starts, it is too late, unless there is a grace.
+	ticks, _, err = th.Sell(2, [][2]int{{0, 0}, {0, 1}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	fakeNow = start
+	if _, err := th.Reissue(ticks[0].TicketNum); err != ErrShowingStarted {
+		tst.Errorf("Reissue once the showing started returned %v, expected %v", err, ErrShowingStarted)
+	}
+	if _, err := th.Reissue(ticks[1].TicketNum); err != nil {
+		tst.Errorf("Reissue for a showing with no start time returned error %v", err)
+	}
+	th.SetReissueGrace(10 * time.Minute)
+	fakeNow = start.Add(5 * time.Minute)
+	if _, err := th.Reissue(ticks[0].TicketNum); err != nil {
+		tst.Errorf("Reissue within the grace returned error %v", err)
+	}
+} // TestReissue
+
+func TestReissueConcurrent(tst *testing.T) {
+	// Each round uses up to 3 ticket numbers.
+	const rounds = 100
+	th := newTestTheatre(tst, 5, 1, 1, 3*rounds, 1)
+
+	// Of two Reissues of the one ticket at once, only one may succeed, and
+	// the other's replacement number mustn't look like a sold ticket.  With
+	// more than one P, they overlap even on a machine with one CPU.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for round := 0; round < rounds; round++ {
+		ticks, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+		if err != nil {
+			tst.Fatalf("Sell returned error %v", err)
+		}
+		var wg sync.WaitGroup
+		var succeeded int32
+		start := make(chan struct{})
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if _, err := th.Reissue(ticks[0].TicketNum); err == nil {
+					atomic.AddInt32(&succeeded, 1)
+				} else if err != ErrTicketVoid {
+					tst.Errorf("Reissue of ticket %d returned error %v, expected nil or %v", ticks[0].TicketNum, err, ErrTicketVoid)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if succeeded != 1 {
+			tst.Fatalf("%d of 2 concurrent Reissues of ticket %d succeeded, expected 1", succeeded, ticks[0].TicketNum)
+		}
+	}
+	if got := th.TicketsForShowing(0, 0); len(got) != rounds {
+		tst.Errorf("TicketsForShowing after concurrent Reissues returned %d tickets, expected %d", len(got), rounds)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck after concurrent Reissues found %v", problems)
+	}
+} // TestReissueConcurrent
+
+func TestStartShowing(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 2, 4, 2)
+	start := time.Date(2020, 6, 1, 19, 0, 0, 0, time.UTC)
+	fakeNow := start.Add(-time.Minute)
+	th.clock = func() time.Time { return fakeNow }
+	if err := th.SetShowingStart(0, 0, start); err != nil {
+		tst.Fatalf("SetShowingStart returned error %v", err)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell before the showing started returned error %v", err)
+	}
+
+	fakeNow = start
+	if _, _, err := th.Sell(1, [][2]int{{0, 1}, {0, 0}}, nil, "a dummy time"); !errors.Is(err, ErrShowingStarted) {
+		tst.Errorf("Sell once the showing started returned error %v, expected %v", err, ErrShowingStarted)
+	}
+	if sold := len(th.TicketsForShowing(0, 1)); sold != 0 {
+		tst.Errorf("Refused Sell sold %d tickets for the other showing, expected 0", sold)
+	}
+
+	fakeNow = start.Add(-time.Hour)
+	if err := th.StartShowing(0, 1); err != nil {
+		tst.Fatalf("StartShowing returned error %v", err)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 1}}, nil, "a dummy time"); !errors.Is(err, ErrShowingStarted) {
+		tst.Errorf("Sell after StartShowing returned error %v, expected %v", err, ErrShowingStarted)
+	}
+	if err := th.StartShowing(1, 0); err == nil {
+		tst.Error("StartShowing for movie 1 of 1 should have failed")
+	}
+} // TestStartShowing
+
+func TestSetClockSkewTolerance(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 4, 2)
+	var logged strings.Builder
+	th.L = log.New(&logged, "", 0)
+	start := time.Date(2020, 6, 1, 19, 0, 0, 0, time.UTC)
+	fakeNow := start.Add(time.Minute)
+	th.clock = func() time.Time { return fakeNow }
+	if err := th.SetShowingStart(0, 0, start); err != nil {
+		tst.Fatalf("SetShowingStart returned error %v", err)
+	}
+	slightlyOff := start.Add(-30 * time.Second) // 90s behind the theatre's clock
+	wildlyOff := start.Add(-3 * time.Hour)
+
+	// With no tolerance, localTime isn't used.
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, slightlyOff); !errors.Is(err, ErrShowingStarted) {
+		tst.Errorf("Sell with no tolerance returned error %v, expected %v", err, ErrShowingStarted)
+	}
+
+	th.SetClockSkewTolerance(2 * time.Minute)
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, slightlyOff); err != nil {
+		tst.Errorf("Sell from a client 90s behind, with a 2m tolerance, returned error %v", err)
+	}
+	if _, err := th.PrepareSale(1, [][2]int{{0, 0}}, nil, slightlyOff.Format(time.RFC3339)); err != nil {
+		tst.Errorf("PrepareSale from a client 90s behind, in RFC 3339, returned error %v", err)
+	}
+	if strings.Contains(logged.String(), "beyond the") {
+		tst.Errorf("A client within the tolerance was logged as off:\n%s", logged.String())
+	}
+
+	for _, localTime := range []interface{}{wildlyOff, wildlyOff.Format(time.RFC3339), "a dummy time", nil} {
+		if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, localTime); !errors.Is(err, ErrShowingStarted) {
+			tst.Errorf("Sell with localTime %v returned error %v, expected %v", localTime, err, ErrShowingStarted)
+		}
+	}
+	if n := strings.Count(logged.String(), "beyond the 2m0s tolerance"); n != 2 {
+		tst.Errorf("The client 3h off was logged %d times, expected 2:\n%s", n, logged.String())
+	}
+
+	// A client clock which is ahead is no help.
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, fakeNow.Add(time.Minute)); !errors.Is(err, ErrShowingStarted) {
+		tst.Errorf("Sell from a client 1m ahead returned error %v, expected %v", err, ErrShowingStarted)
+	}
+	// Nor is any tolerance, once the showing has started by the client's clock.
+	fakeNow = start.Add(time.Hour)
+	th.SetClockSkewTolerance(2 * time.Hour)
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, start.Add(time.Second)); !errors.Is(err, ErrShowingStarted) {
+		tst.Errorf("Sell from a client which saw the start returned error %v, expected %v", err, ErrShowingStarted)
+	}
+} // TestSetClockSkewTolerance
+
+func TestSellNoRequests(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 2, 2)
+	for _, rqsts := range [][][2]int{nil, {}} {
+		ticks, rcpt, err := th.Sell(1, rqsts, nil, "a dummy time")
+		if err != ErrNoRequests {
+			tst.Errorf("Sell of %v returned error %v, expected %v", rqsts, err, ErrNoRequests)
+		}
+		if len(ticks) != 0 || rcpt.ReceiptNum != 0 || len(rcpt.ItemsSold) != 0 {
+			tst.Errorf("Sell of %v returned tickets %+v and receipt %+v, expected none", rqsts, ticks, rcpt)
+		}
+	}
+	if _, rcpt, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil || rcpt.ReceiptNum != 1 {
+		tst.Errorf("Sell after empty sells returned receipt %d, %v, expected receipt 1 (none used up)", rcpt.ReceiptNum, err)
+	}
+} // TestSellNoRequests
+
+// auditCapture is an AuditSink which keeps its records, for the tests.
+type auditCapture struct {
+	mutex   sync.Mutex
+	records []AuditRecord
+} // auditCapture
+
+func (ac *auditCapture) Audit(rec AuditRecord) {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	ac.records = append(ac.records, rec)
+} // Audit
+
+func TestSetAuditSink(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 4, 2)
+	sink := &auditCapture{}
+	th.SetAuditSink(sink)
+
+	ticks, rcpt, err := th.Sell(2, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	th.Sell(2, nil, nil, "a dummy time")
+	if _, err := th.VoidLastSale(2); err != nil {
+		tst.Fatalf("VoidLastSale returned error %v", err)
+	}
+
+	soldNums := fmt.Sprint([]int{ticks[0].TicketNum, ticks[1].TicketNum})
+	expected := []AuditRecord{
+		{Op: "Sell", Window: 2, Before: "requests [[0 0] [0 0]]", After: fmt.Sprintf("receipt %d:  tickets %s, total %s", rcpt.ReceiptNum, soldNums, formatPenneys(rcpt.Total)), Outcome: "ok"},
+		{Op: "Sell", Window: 2, Before: "requests []", After: "", Outcome: ErrNoRequests.Error()},
+		{Op: "VoidLastSale", Window: 2, Before: "last sale:  tickets " + soldNums, After: "tickets " + soldNums + " voided", Outcome: "ok"},
+	}
+	if len(sink.records) != len(expected) {
+		tst.Fatalf("Audit sink got %d records %+v, expected %d", len(sink.records), sink.records, len(expected))
+	}
+	for i, rec := range sink.records {
+		if rec.Time.IsZero() {
+			tst.Errorf("Audit record %d has no time", i)
+		}
+		rec.Time = time.Time{}
+		if rec != expected[i] {
+			tst.Errorf("Audit record %d is %+v, expected %+v", i, rec, expected[i])
+		}
+	}
+
+	// Turned off, no more records are made.
+	th.SetAuditSink(nil)
+	th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if len(sink.records) != len(expected) {
+		tst.Errorf("Audit sink got %d records after being turned off, expected %d", len(sink.records), len(expected))
+	}
+} // TestSetAuditSink
+
+func TestSetChannelAllocation(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 10, 3)
+	if err := th.SetOnlineWindows([]int{3}); err != nil {
+		tst.Fatalf("SetOnlineWindows returned error %v", err)
+	}
+	if err := th.SetChannelAllocation(0, 0, 30); err != nil {
+		tst.Fatalf("SetChannelAllocation returned error %v", err)
+	}
+
+	// The online share (3 seats) sells out, while the windows still have
+	// theirs.
+	for i := 0; i < 3; i++ {
+		if _, _, err := th.Sell(3, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+			tst.Fatalf("Online Sell %d returned error %v", i+1, err)
+		}
+	}
+	ticks, _, err := th.Sell(3, [][2]int{{0, 0}, {1, 0}}, nil, "a dummy time")
+	if !errors.Is(err, ErrChannelSoldOut) {
+		tst.Errorf("Online Sell past its share returned error %v, expected %v", err, ErrChannelSoldOut)
+	}
+	if len(ticks) != 2 || !ticks[0].SoldOut || ticks[1].SoldOut {
+		tst.Errorf("Online Sell past its share returned %+v, expected a placeholder, then a ticket for the unallocated showing", ticks)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Errorf("Window Sell after the online share sold out returned error %v", err)
+	}
+
+	// Then the windows' share (7 seats) sells out independently.
+	for i := 1; i < 7; i++ {
+		th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time")
+	}
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); !errors.Is(err, ErrChannelSoldOut) {
+		tst.Errorf("Window Sell past its share returned error %v, expected %v", err, ErrChannelSoldOut)
+	}
+	if window, online := th.ChannelSales(0, 0); window != 7 || online != 3 {
+		tst.Errorf("ChannelSales(0, 0) returned %d, %d, expected 7, 3", window, online)
+	}
+	if window, online := th.ChannelSales(1, 0); window != 0 || online != 1 {
+		tst.Errorf("ChannelSales(1, 0) returned %d, %d, expected 0, 1", window, online)
+	}
+
+	// Voiding gives the seat back to its channel.
+	if _, err := th.VoidLastSale(3); err != nil {
+		tst.Fatalf("VoidLastSale returned error %v", err)
+	}
+	if window, online := th.ChannelSales(1, 0); window != 0 || online != 0 {
+		tst.Errorf("ChannelSales(1, 0) after a void returned %d, %d, expected 0, 0", window, online)
+	}
+
+	// ... even if the window has changed channel since the sale.
+	if _, _, err := th.Sell(2, [][2]int{{1, 0}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Window Sell of movie 1 returned error %v", err)
+	}
+	if err := th.SetOnlineWindows([]int{2, 3}); err != nil {
+		tst.Fatalf("SetOnlineWindows returned error %v", err)
+	}
+	if _, err := th.VoidLastSale(2); err != nil {
+		tst.Fatalf("VoidLastSale after a channel change returned error %v", err)
+	}
+	if window, online := th.ChannelSales(1, 0); window != 0 || online != 0 {
+		tst.Errorf("ChannelSales(1, 0) after a void from a window which went online returned %d, %d, expected 0, 0", window, online)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v", problems)
+	}
+
+	if err := th.SetChannelAllocation(0, 0, 101); err == nil {
+		tst.Errorf("SetChannelAllocation with 101%% returned nil, expected an error")
+	}
+	if err := th.SetOnlineWindows([]int{4}); err == nil {
+		tst.Errorf("SetOnlineWindows with window 4 of 3 returned nil, expected an error")
+	}
+} // TestSetChannelAllocation
+
+func TestNoMoreTickets(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 2, 2)
+	ticks, _, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell of the whole DB returned error %v", err)
+	}
+
+	// The DB is full, so both of these fail, instead of the program.
+	if _, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); !errors.Is(err, ErrNoMoreTickets) {
+		tst.Errorf("Sell past the end of the DB returned error %v, expected %v", err, ErrNoMoreTickets)
+	}
+	if _, err := th.Reissue(ticks[0].TicketNum); !errors.Is(err, ErrNoMoreTickets) {
+		tst.Errorf("Reissue past the end of the DB returned error %v, expected %v", err, ErrNoMoreTickets)
+	}
+	if len(th.ticketRqstDB) != 3 {
+		tst.Errorf("ticketRqstDB has grown to %d records, expected 3", len(th.ticketRqstDB))
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v after the DB filled up", problems)
+	}
+} // TestNoMoreTickets
+
+func TestRegisterShutdownHook(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 2, 2)
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+
+	var ran []string
+	th.RegisterShutdownHook(func() error {
+		// The theatre is closed, but its final state can still be read.
+		_, _, err := th.Sell(1, [][2]int{{0, 0}}, nil, "a dummy time")
+		ran = append(ran, fmt.Sprintf("first:  %d sold, Sell error %t", len(th.TicketsForShowing(0, 0)), err != nil))
+		return errors.New("disk full")
+	})
+	th.RegisterShutdownHook(func() error {
+		ran = append(ran, "second")
+		return nil
+	})
+
+	err := th.Close()
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		tst.Errorf("Close returned error %v, expected the first hook's error", err)
+	}
+	expected := []string{"first:  1 sold, Sell error true", "second"}
+	if fmt.Sprint(ran) != fmt.Sprint(expected) {
+		tst.Errorf("Close ran hooks %q, expected %q", ran, expected)
+	}
+
+	// Closing again doesn't run them again.
+	if err := th.Close(); err != nil || len(ran) != 2 {
+		tst.Errorf("Second Close returned error %v and ran %d hooks, expected nil and still 2", err, len(ran))
+	}
+} // TestRegisterShutdownHook
+
+func TestSetWindowCashRounding(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 4, 2)
+	if err := th.SetFlatPrice(850, true); err != nil {
+		tst.Fatalf("SetFlatPrice returned error %v", err)
+	}
+	if err := th.SetWindowCashRounding(2, true); err != nil {
+		tst.Fatalf("SetWindowCashRounding returned error %v", err)
+	}
+	rqsts := [][2]int{{0, 0}, {1, 0}}
+	_, exact, err := th.Sell(1, rqsts, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell at window 1 returned error %v", err)
+	}
+	ticks, cash, err := th.Sell(2, rqsts, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell at cash window 2 returned error %v", err)
+	}
+	if exact.Total != 1700 || cash.Total != 1800 {
+		tst.Errorf("Sells for the same tickets totalled %d at window 1 and %d at cash window 2, expected 1700 and 1800", exact.Total, cash.Total)
+	}
+	if ticks[0].Price != 900 || !strings.Contains(cash.ItemsSold[0].Desc, "rounded up from 8.50") {
+		tst.Errorf("Cash window ticket is %+v, receipt item %+v, expected 900, noting the rounding from 8.50", ticks[0], cash.ItemsSold[0])
+	}
+	if strings.Contains(exact.ItemsSold[0].Desc, "rounded") {
+		tst.Errorf("Window 1 receipt item %+v notes rounding, expected none", exact.ItemsSold[0])
+	}
+
+	// Whole dollars are left alone.
+	th.SetFlatPrice(700, true)
+	if _, rcpt, _ := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); rcpt.Total != 700 || strings.Contains(rcpt.ItemsSold[0].Desc, "rounded") {
+		tst.Errorf("Cash window sale at a whole-dollar price got receipt %+v, expected 700, not rounded", rcpt)
+	}
+	if err := th.SetWindowCashRounding(3, true); err == nil {
+		tst.Errorf("SetWindowCashRounding for window 3 of 2 returned nil, expected an error")
+	}
+} // TestSetWindowCashRounding
+
+func TestSetWindowPaymentTypes(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 3, 1, 1, 2)
+	if err := th.SetWindowPaymentTypes(2, []string{"cash"}); err != nil {
+		tst.Fatalf("SetWindowPaymentTypes returned error %v", err)
+	}
+	cash := map[string]interface{}{PaymentTypeField: "cash"}
+	card := map[string]interface{}{PaymentTypeField: "card"}
+
+	if _, _, err := th.Sell(2, [][2]int{{0, 0}}, cash, "a dummy time"); err != nil {
+		tst.Errorf("cash Sell at cash-only window 2 returned error %v", err)
+	}
+	for _, pi := range []map[string]interface{}{card, nil} {
+		_, rcpt, err := th.Sell(2, [][2]int{{1, 0}}, pi, "a dummy time")
+		if !errors.Is(err, ErrPaymentTypeNotAccepted) || rcpt.Total != 0 {
+			tst.Errorf("Sell with paymentInfo %v at cash-only window 2 returned receipt %+v, error %v, expected nothing sold and %v", pi, rcpt, err, ErrPaymentTypeNotAccepted)
+		}
+	}
+	if _, err := th.PrepareSale(2, [][2]int{{1, 0}}, card, "a dummy time"); !errors.Is(err, ErrPaymentTypeNotAccepted) {
+		tst.Errorf("card PrepareSale at cash-only window 2 returned error %v, expected %v", err, ErrPaymentTypeNotAccepted)
+	}
+	// The refused sales left showing 1/0's one seat for window 1, which takes
+	// anything.
+	if _, rcpt, err := th.Sell(1, [][2]int{{1, 0}}, card, "a dummy time"); err != nil || rcpt.Total == 0 {
+		tst.Errorf("card Sell at window 1 returned receipt %+v, error %v, expected the seat the refused sales left", rcpt, err)
+	}
+
+	// Back to taking anything.
+	th.SetWindowPaymentTypes(2, nil)
+	if _, _, err := th.Sell(2, [][2]int{{2, 0}}, card, "a dummy time"); err != nil {
+		tst.Errorf("card Sell at window 2, after its payment types were cleared, returned error %v", err)
+	}
+	if err := th.SetWindowPaymentTypes(3, []string{"cash"}); err == nil {
+		tst.Errorf("SetWindowPaymentTypes for window 3 of 2 returned nil, expected an error")
+	}
+} // TestSetWindowPaymentTypes
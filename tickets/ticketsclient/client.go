@@ -0,0 +1,292 @@
+/*****************************************************************************
+
+'ticketsclient' is a Go client for the JSON interface of tickets/sample_server,
+so that programs using 'tickets' as a Web service (such as the theatre model)
+don't each have to build the URLs, encode the requests, and decode the
+replies and errors themselves.
+
+It covers the config, sell, exchange and receipt lookup URLs.  The server
+has no URLs to initialize or stop the ticketing system (it does those
+itself, as it starts up and shuts down), so neither does the client.
+
+See the doc. in tickets/sample_server/main.go for the URLs and their JSON.
+
+*****************************************************************************/
+
+package ticketsclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/d-m-w/learninggo/tickets"
+)
+
+// A Client calls one tickets server.  Its fields may be changed between
+// calls, but not during one.  A Client may be used by several goroutines at
+// once.
+type Client struct {
+	// BaseURL is where the server's URLs start, up to and including
+	// "/tickets", e.g. "http://localhost:1811/tickets".
+	BaseURL string
+
+	// HTTPClient makes the calls.  nil means http.DefaultClient, which has
+	// no timeout, so set one with a Timeout to keep a stalled server from
+	// hanging the caller.  Each request also gets a deadline of Timeout
+	// from when the call starts.
+	HTTPClient *http.Client
+} // Client
+
+// Error is a failure reported by the tickets server, from the
+//     { "error" : <message>, "code" : <stable error code> }
+// body which it sends with every 4xx or 5xx reply (or adds to a sale which
+// only partly failed, with StatusCode 200;  see Client.Sell).  If the code is
+// one of the tickets package's errors (see errorCodes), then Error wraps it,
+// so that e.g. errors.Is(err, tickets.ErrSalesClosed) works as it does for a
+// local tickets.Sell.
+type Error struct {
+	StatusCode int    // the HTTP status, e.g. 400
+	Code       string // e.g. "ERR_SALES_CLOSED";  "" if the body wasn't JSON
+	Message    string // the server's message, or the body if it wasn't JSON
+} // Error
+
+// Error returns the HTTP status, code and message, as one line.
+func (e *Error) Error() string {
+	return fmt.Sprintf("tickets server replied %d %s:  %s", e.StatusCode, e.Code, e.Message)
+} // Error
+
+// Unwrap returns the tickets package error for e's Code, or nil if there
+// isn't one.
+func (e *Error) Unwrap() error {
+	for _, ec := range errorCodes {
+		if ec.code == e.Code {
+			return ec.err
+		}
+	}
+	return nil
+} // Unwrap
+
+// errorCodes maps the server's error codes back to the tickets package
+// errors.
+//
+// ATTENTION!  This must be kept in sync with errorCodes in
+// tickets/sample_server, which maps the other way.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{tickets.ErrXchNotEntitled, "ERR_XCH_NOT_ENTITLED"},
+	{tickets.ErrXchAlreadyDone, "ERR_XCH_ALREADY_DONE"},
+	{tickets.ErrXchOutOfGoods, "ERR_XCH_OUT_OF_GOODS"},
+	{tickets.ErrXchRationed, "ERR_XCH_RATIONED"},
+	{tickets.ErrXchNotDone, "ERR_XCH_NOT_DONE"},
+	{tickets.ErrXchNotOnMenu, "ERR_XCH_NOT_ON_MENU"},
+	{tickets.ErrTicketVoid, "ERR_TICKET_VOID"},
+	{tickets.ErrNothingToVoid, "ERR_NOTHING_TO_VOID"},
+	{tickets.ErrSalesNotOpenYet, "ERR_SALES_NOT_OPEN_YET"},
+	{tickets.ErrSalesClosed, "ERR_SALES_CLOSED"},
+	{tickets.ErrBlackout, "ERR_BLACKOUT"},
+	{tickets.ErrPaymentLimit, "ERR_PAYMENT_LIMIT"},
+	{tickets.ErrCustomerLimit, "ERR_CUSTOMER_LIMIT"},
+	{tickets.ErrNoSuchReceipt, "ERR_NO_SUCH_RECEIPT"},
+	{tickets.ErrReadOnly, "ERR_READ_ONLY"},
+	{tickets.ErrBusy, "ERR_BUSY"},
+	{tickets.ErrNoRequests, "ERR_NO_REQUESTS"},
+	{tickets.ErrChannelSoldOut, "ERR_CHANNEL_SOLD_OUT"},
+	{tickets.ErrNoMoreTickets, "ERR_NO_MORE_TICKETS"},
+	{tickets.ErrShowingStarted, "ERR_SHOWING_STARTED"},
+	{tickets.ErrPaymentTypeNotAccepted, "ERR_PAYMENT_TYPE_NOT_ACCEPTED"},
+}
+
+// Config is the size of the theatre, as the server reports it (see
+// Client.Config).
+type Config struct {
+	Movies   int `json:"movies"`   // numbered from 0
+	Showings int `json:"showings"` // of each movie, numbered from 0
+	Windows  int `json:"windows"`  // numbered from 1
+} // Config
+
+// New creates a Client for the server at baseURL (see Client.BaseURL), which
+// makes its calls with httpClient (nil for http.DefaultClient).
+func New(baseURL string, httpClient *http.Client) *Client {
+	return &Client{BaseURL: baseURL, HTTPClient: httpClient}
+} // New
+
+// Config asks the server for the size of the theatre it was started with,
+// with
+//     GET <BaseURL>/config
+//
+// Returns the Config, or an error:  an *Error if the server refused, or the
+// error from making the call or decoding the reply.
+func (c *Client) Config() (Config, error) {
+	var cfg Config
+	if _, err := c.call("Config", "GET", c.BaseURL+"/config", nil, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+} // Config
+
+// Sell asks the server to sell tickets, as tickets.Sell does, with
+//     POST <BaseURL>/sell/<window>
+//
+// Parameters:
+//
+// window, ticketRequests, paymentInfo, localTime
+//    As for tickets.Sell.  paymentInfo and localTime must be encodable as
+//    JSON.
+//
+// Returns the Tickets (sold-out placeholders included) and the Receipt, or
+// an error:  an *Error if the server refused the sale, or the error from
+// making the call or decoding the reply.
+//
+// If the sale only partly failed (e.g. with tickets.ErrNoMoreTickets, when
+// the ticket DB filled part way through), then the server still sold, and
+// charged for, some of the tickets, and Sell returns their Tickets and
+// Receipt as well as an *Error with StatusCode 200, as tickets.Sell does.
+func (c *Client) Sell(window int, ticketRequests [][2]int, paymentInfo map[string]interface{}, localTime interface{}) ([]tickets.Ticket, tickets.Receipt, error) {
+	rqst := struct {
+		// Use the same case for the variable names as the JSON map keys.
+		TicketRequests [][2]int
+		PaymentInfo    map[string]interface{}
+		LocalTime      interface{}
+	}{ticketRequests, paymentInfo, localTime}
+	rqstJSON, err := json.Marshal(rqst)
+	if err != nil {
+		return nil, tickets.Receipt{}, fmt.Errorf("Sell failed:  cannot convert the request to JSON:  %v", err)
+	}
+
+	var responseData struct {
+		Ticks []tickets.Ticket `json:"tickets"`
+		Rcpt  tickets.Receipt  `json:"receipt"`
+		Error string           `json:"error"`
+		Code  string           `json:"code"`
+	}
+	url := fmt.Sprintf("%s/sell/%d", c.BaseURL, window)
+	status, err := c.call("Sell", "POST", url, rqstJSON, &responseData)
+	if err != nil {
+		return nil, tickets.Receipt{}, err
+	}
+	if responseData.Code != "" {
+		err = &Error{StatusCode: status, Code: responseData.Code, Message: responseData.Error}
+	}
+	return responseData.Ticks, responseData.Rcpt, err
+} // Sell
+
+// Exchange asks the server to exchange one ticket's goodie, as
+// tickets.ExchangeWithReceipt does, with
+//     GET <BaseURL>/exchange/<ticketNum>/<oldGoodie>/<newGoodie>
+//
+// Returns the Receipt for the price difference, if the upgrade menu charges
+// (or refunds) for the exchange, or nil for a free exchange.  Returns an
+// *Error if the server refused the exchange, or the error from making the
+// call or decoding the reply.
+func (c *Client) Exchange(ticketNum int, oldGoodie string, newGoodie string) (*tickets.Receipt, error) {
+	var responseData struct {
+		Rcpt tickets.Receipt `json:"receipt"`
+	}
+	url := fmt.Sprintf("%s/exchange/%d/%s/%s", c.BaseURL, ticketNum, oldGoodie, newGoodie)
+	status, err := c.call("Exchange", "GET", url, nil, &responseData)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent {
+		return nil, nil
+	}
+	return &responseData.Rcpt, nil
+} // Exchange
+
+// Lookup asks the server for one receipt, as tickets.ReceiptByNum does, with
+//     GET <BaseURL>/receipt-by-num/<receiptNum>
+//
+// Returns the Receipt, or an error:  an *Error wrapping
+// tickets.ErrNoSuchReceipt if there isn't one, or the error from making the
+// call or decoding the reply.
+func (c *Client) Lookup(receiptNum int) (tickets.Receipt, error) {
+	var receipt tickets.Receipt
+	url := fmt.Sprintf("%s/receipt-by-num/%d", c.BaseURL, receiptNum)
+	if _, err := c.call("Lookup", "GET", url, nil, &receipt); err != nil {
+		return tickets.Receipt{}, err
+	}
+	return receipt, nil
+} // Lookup
+
+// call makes one HTTP call to the server, and decodes the reply.
+//
+// Parameters:
+//
+// op
+//    The Client method making the call, for the error messages.
+// method, url
+//    The HTTP method (GET, POST, ...) and the full URL to call.
+// body
+//    The JSON request body, or nil if there isn't one.
+// reply
+//    What to decode a 200 reply's JSON into.  Other 2xx replies (204) have
+//    no body, and leave reply as it is.
+//
+// If the HTTPClient has a Timeout, then the request's context has a deadline
+// of that long, so that the call (reading the reply included) gives up then
+// even if the HTTPClient's Transport doesn't honour the Timeout itself.
+//
+// Returns the HTTP status, and an *Error for a 4xx or 5xx reply, or the
+// error from making the call or decoding the reply.  Otherwise nil.
+func (c *Client) call(op string, method string, url string, body []byte, reply interface{}) (int, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	ctx := context.Background()
+	if httpClient.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, httpClient.Timeout)
+		defer cancel()
+	}
+
+	var rqst *http.Request
+	var err error
+	if body != nil {
+		rqst, err = http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	} else {
+		rqst, err = http.NewRequestWithContext(ctx, method, url, nil)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s failed:  %v", op, err)
+	}
+	if body != nil {
+		rqst.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := httpClient.Do(rqst)
+	if err != nil {
+		return 0, fmt.Errorf("%s failed:  %v", op, err)
+	}
+	defer response.Body.Close()
+	rbytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return response.StatusCode, fmt.Errorf("%s failed:  cannot read the reply:  %v", op, err)
+	}
+
+	switch {
+	case response.StatusCode >= 400:
+		e := &Error{StatusCode: response.StatusCode}
+		var errorData struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if err := json.Unmarshal(rbytes, &errorData); err == nil && errorData.Code != "" {
+			e.Code, e.Message = errorData.Code, errorData.Error
+		} else {
+			e.Message = string(bytes.TrimSpace(rbytes))
+		}
+		return response.StatusCode, e
+	case response.StatusCode == http.StatusOK:
+		if err := json.Unmarshal(rbytes, reply); err != nil {
+			return response.StatusCode, fmt.Errorf("%s failed:  reply status OK but not in JSON format:  %v", op, err)
+		}
+	}
+	return response.StatusCode, nil
+} // call
@@ -0,0 +1,228 @@
+package ticketsclient
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d-m-w/learninggo/tickets"
+)
+
+// lastRequest is what a test server was last sent.
+type lastRequest struct {
+	mutex       sync.Mutex
+	method      string
+	contentType string
+	body        []byte
+} // lastRequest
+
+// get returns what the server was last sent.
+func (lr *lastRequest) get() (method string, contentType string, body []byte) {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	return lr.method, lr.contentType, lr.body
+} // get
+
+// newTestServer starts a server which replies to each path in replies with
+// its status and body, and records the last request made to it.
+func newTestServer(tst *testing.T, replies map[string]struct {
+	status int
+	body   string
+}) (*httptest.Server, *lastRequest) {
+	last := &lastRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		body, _ := ioutil.ReadAll(rqst.Body)
+		last.mutex.Lock()
+		last.method, last.contentType, last.body = rqst.Method, rqst.Header.Get("Content-Type"), body
+		last.mutex.Unlock()
+		reply, found := replies[rqst.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"no such URL","code":"ERR_NOT_FOUND"}`))
+			return
+		}
+		w.WriteHeader(reply.status)
+		w.Write([]byte(reply.body))
+	}))
+	tst.Cleanup(server.Close)
+	return server, last
+} // newTestServer
+
+func TestConfig(tst *testing.T) {
+	server, _ := newTestServer(tst, map[string]struct {
+		status int
+		body   string
+	}{
+		"/tickets/config": {http.StatusOK, `{"movies":5,"showings":4,"windows":2}`},
+	})
+	c := New(server.URL+"/tickets", nil)
+
+	if cfg, err := c.Config(); err != nil || cfg != (Config{Movies: 5, Showings: 4, Windows: 2}) {
+		tst.Errorf("Config returned %+v, %v, expected 5 movies, 4 showings and 2 windows", cfg, err)
+	}
+	c.BaseURL = server.URL + "/nothing"
+	if _, err := c.Config(); err == nil {
+		tst.Error("Config from a URL which isn't there should have failed")
+	}
+} // TestConfig
+
+func TestSell(tst *testing.T) {
+	server, last := newTestServer(tst, map[string]struct {
+		status int
+		body   string
+	}{
+		"/tickets/sell/1": {http.StatusOK, `{"tickets":[{"ticketNum":7,"movie":2,"showing":3,"price":1000,"goodies":true,"window":1}],"receipt":{"receiptNum":4,"total":1000}}`},
+		"/tickets/sell/2": {http.StatusBadRequest, `{"error":"Sell denied:  sales are closed","code":"ERR_SALES_CLOSED"}`},
+		"/tickets/sell/3": {http.StatusOK, `{"tickets":[{"ticketNum":8,"movie":2,"showing":3,"price":1000,"window":3}],"receipt":{"receiptNum":5,"total":1000},"error":"Sell failed:  no more tickets","code":"ERR_NO_MORE_TICKETS"}`},
+	})
+	c := New(server.URL+"/tickets", nil)
+
+	ticks, rcpt, err := c.Sell(1, [][2]int{{2, 3}}, map[string]interface{}{"customerID": "alice"}, "a dummy time")
+	if err != nil {
+		tst.Fatalf("Sell returned error %v", err)
+	}
+	want := []tickets.Ticket{{TicketNum: 7, Movie: 2, Showing: 3, Price: 1000, Goodies: true, Window: 1}}
+	if !reflect.DeepEqual(ticks, want) || rcpt.ReceiptNum != 4 || rcpt.Total != 1000 {
+		tst.Errorf("Sell returned %+v, %+v, expected %+v and receipt 4 for 1000", ticks, rcpt, want)
+	}
+	method, contentType, body := last.get()
+	if method != "POST" || contentType != "application/json" {
+		tst.Errorf("Sell sent %s with Content-Type '%s', expected POST of application/json", method, contentType)
+	}
+	var sent struct {
+		TicketRequests [][2]int
+		PaymentInfo    map[string]interface{}
+		LocalTime      interface{}
+	}
+	if err := json.Unmarshal(body, &sent); err != nil || !reflect.DeepEqual(sent.TicketRequests, [][2]int{{2, 3}}) || sent.PaymentInfo["customerID"] != "alice" || sent.LocalTime != "a dummy time" {
+		tst.Errorf("Sell sent '%s' (error %v), expected the requests, paymentInfo and localTime", body, err)
+	}
+
+	_, _, err = c.Sell(2, [][2]int{{2, 3}}, nil, nil)
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadRequest || e.Code != "ERR_SALES_CLOSED" || e.Message != "Sell denied:  sales are closed" {
+		tst.Errorf("Sell refused by the server returned error %#v, expected an *Error with its status, code and message", err)
+	}
+	if !errors.Is(err, tickets.ErrSalesClosed) {
+		tst.Errorf("Sell refused with ERR_SALES_CLOSED returned error %v, expected it to wrap %v", err, tickets.ErrSalesClosed)
+	}
+
+	ticks, rcpt, err = c.Sell(3, [][2]int{{2, 3}, {2, 3}}, nil, nil)
+	if !errors.As(err, &e) || e.StatusCode != http.StatusOK || !errors.Is(err, tickets.ErrNoMoreTickets) {
+		tst.Errorf("Sell which partly failed returned error %#v, expected an *Error with status 200, wrapping %v", err, tickets.ErrNoMoreTickets)
+	}
+	if len(ticks) != 1 || ticks[0].TicketNum != 8 || rcpt.ReceiptNum != 5 || rcpt.Total != 1000 {
+		tst.Errorf("Sell which partly failed returned %+v, %+v, expected ticket 8 and receipt 5 for 1000", ticks, rcpt)
+	}
+} // TestSell
+
+func TestExchange(tst *testing.T) {
+	server, last := newTestServer(tst, map[string]struct {
+		status int
+		body   string
+	}{
+		"/tickets/exchange/7/water/soda":    {http.StatusNoContent, ""},
+		"/tickets/exchange/8/water/popcorn": {http.StatusOK, `{"receipt":{"receiptNum":5,"total":250}}`},
+		"/tickets/exchange/9/water/soda":    {http.StatusBadRequest, `{"error":"Exchange denied:  out of soda","code":"ERR_XCH_OUT_OF_GOODS"}`},
+	})
+	c := New(server.URL+"/tickets", &http.Client{})
+
+	if rcpt, err := c.Exchange(7, "water", "soda"); err != nil || rcpt != nil {
+		tst.Errorf("free Exchange returned %+v, %v, expected nil, nil", rcpt, err)
+	}
+	if method, _, _ := last.get(); method != "GET" {
+		tst.Errorf("Exchange sent %s, expected GET", method)
+	}
+	if rcpt, err := c.Exchange(8, "water", "popcorn"); err != nil || rcpt == nil || rcpt.ReceiptNum != 5 || rcpt.Total != 250 {
+		tst.Errorf("charged Exchange returned %+v, %v, expected receipt 5 for 250", rcpt, err)
+	}
+	if _, err := c.Exchange(9, "water", "soda"); !errors.Is(err, tickets.ErrXchOutOfGoods) {
+		tst.Errorf("refused Exchange returned error %v, expected it to wrap %v", err, tickets.ErrXchOutOfGoods)
+	}
+} // TestExchange
+
+func TestLookup(tst *testing.T) {
+	server, _ := newTestServer(tst, map[string]struct {
+		status int
+		body   string
+	}{
+		"/tickets/receipt-by-num/4":  {http.StatusOK, `{"receiptNum":4,"window":1,"total":1000,"itemsSold":[{"desc":"Movie 2, Showing 3","penneys":1000}]}`},
+		"/tickets/receipt-by-num/99": {http.StatusNotFound, `{"error":"no such receipt","code":"ERR_NO_SUCH_RECEIPT"}`},
+		"/tickets/receipt-by-num/5":  {http.StatusOK, `not JSON`},
+		"/tickets/receipt-by-num/6":  {http.StatusBadGateway, "upstream is down\n"},
+	})
+	c := New(server.URL+"/tickets", nil)
+
+	rcpt, err := c.Lookup(4)
+	if err != nil || rcpt.ReceiptNum != 4 || rcpt.Window != 1 || len(rcpt.ItemsSold) != 1 || rcpt.ItemsSold[0].Penneys != 1000 {
+		tst.Errorf("Lookup(4) returned %+v, %v, expected receipt 4", rcpt, err)
+	}
+	if _, err := c.Lookup(99); !errors.Is(err, tickets.ErrNoSuchReceipt) {
+		tst.Errorf("Lookup(99) returned error %v, expected it to wrap %v", err, tickets.ErrNoSuchReceipt)
+	}
+	if _, err := c.Lookup(5); err == nil {
+		tst.Error("Lookup of a reply which isn't JSON should have failed")
+	}
+
+	_, err = c.Lookup(6)
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadGateway || e.Code != "" || e.Message != "upstream is down" || errors.Unwrap(err) != nil {
+		tst.Errorf("Lookup(6) returned error %#v, expected an *Error with the status and body, wrapping nothing", err)
+	}
+
+	c.BaseURL = "http://127.0.0.1:0/tickets" // nothing can listen on port 0
+	if _, err := c.Lookup(4); err == nil {
+		tst.Error("Lookup with no server should have failed")
+	} else if errors.As(err, &e) {
+		tst.Errorf("Lookup with no server returned *Error %v, expected a call error", err)
+	}
+} // TestLookup
+
+// roundTripFunc lets a function be an HTTPClient's Transport.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(rqst *http.Request) (*http.Response, error) {
+	return f(rqst)
+} // RoundTrip
+
+func TestCallDeadline(tst *testing.T) {
+	// Each request should carry a deadline of the HTTPClient's Timeout.
+	var deadline time.Time
+	var hasDeadline bool
+	c := New("http://tickets.invalid/tickets", &http.Client{
+		Timeout: time.Minute,
+		Transport: roundTripFunc(func(rqst *http.Request) (*http.Response, error) {
+			deadline, hasDeadline = rqst.Context().Deadline()
+			return nil, errors.New("not sent")
+		}),
+	})
+	start := time.Now()
+	c.Config()
+	if !hasDeadline || deadline.Before(start.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		tst.Errorf("request deadline was %v (set %v), expected a minute after %v", deadline, hasDeadline, start)
+	}
+
+	// ... and a stalled server should fail the call once it passes.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rqst *http.Request) {
+		select {
+		case <-release:
+		case <-rqst.Context().Done():
+		}
+	}))
+	tst.Cleanup(server.Close)
+	defer close(release)
+	c = New(server.URL+"/tickets", &http.Client{Timeout: 50 * time.Millisecond})
+	start = time.Now()
+	if _, err := c.Config(); err == nil {
+		tst.Error("Config from a stalled server should have failed")
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		tst.Errorf("Config from a stalled server took %v to fail, expected about 50ms", elapsed)
+	}
+} // TestCallDeadline
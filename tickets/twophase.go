@@ -0,0 +1,360 @@
+/*****************************************************************************
+
+PrepareSale, CommitSale and AbortSale split a Sell in two, for callers (e.g.
+an external payment gateway) which must not finalize a sale until its payment
+has cleared.
+
+*****************************************************************************/
+
+package tickets
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PreparedSale is a sale whose seats PrepareSale has reserved, ready to be
+// finalized by CommitSale, or released by AbortSale.
+type PreparedSale struct {
+	TxID        int           `json:"txID"`
+	Window      int           `json:"window"`
+	Reserved    [][2]int      `json:"reserved"`    // the requests which got seats, as { movie #, showing # }
+	Unavailable []Unavailable `json:"unavailable"` // the requests which didn't
+	Total       int           `json:"total"`       // what the Reserved seats cost, in penneys
+	Expires     time.Time     `json:"expires"`     // when the seats go back on sale, if not committed by then
+} // PreparedSale
+
+// preparedSale is what the theatre keeps of a PreparedSale, until it is
+// committed, aborted or timed out.
+type preparedSale struct {
+	PreparedSale
+	prices     []int  // the price of each Reserved seat
+	exact      []int  // ... before any cash rounding (see SetWindowCashRounding)
+	channel    int    // the sales channel the seats were taken from
+	payer      string // whose SetPaymentLimit allowance was reserved;  "" if none was
+	customerID string // whose SetCustomerTicketLimit allowance was reserved;  "" if none was
+	localTime  interface{}
+	timer      *time.Timer // aborts the sale when it times out
+} // preparedSale
+
+// DefaultPrepareTimeout is how long a prepared sale keeps its seats, until
+// SetPrepareTimeout is called.
+const DefaultPrepareTimeout = 5 * time.Minute
+
+// ErrNoSuchSale is returned by CommitSale and AbortSale if the TxID is not
+// one of a prepared sale which is still waiting.
+var ErrNoSuchSale = errors.New("Sale denied:  there is no such prepared sale (it may have been committed, aborted or timed out)")
+
+// SetPrepareTimeout sets how long a sale prepared by PrepareSale keeps its
+// seats.  If it has not been committed by then, it is aborted, and the seats
+// go back on sale.  The default is DefaultPrepareTimeout.  Sales which have
+// already been prepared keep the timeout they were given.
+func (th *Theatre) SetPrepareTimeout(timeout time.Duration) {
+	th.configMutex.Lock()
+	defer th.configMutex.Unlock()
+	th.prepareTimeout = timeout
+	th.L.Printf("Prepared sale timeout set to %v.", timeout)
+} // SetPrepareTimeout
+
+// PrepareSale is the first phase of a two-phase sale.  It makes the same
+// checks as Sell, and reserves a seat for each request it can, but issues no
+// Tickets and makes no receipt.  The seats stay reserved until the sale is
+// finalized by CommitSale, released by AbortSale, or times out (see
+// SetPrepareTimeout).  Requests which can't get a seat (because the showing
+// or the window's share of it is sold out) are reported in Unavailable.
+//
+// The parameters are as for Sell.
+//
+// Returns the PreparedSale, whose TxID identifies it to CommitSale and
+// AbortSale, or an error, and reserves nothing, for the reasons Sell would
+// refuse the sale.
+func (th *Theatre) PrepareSale(window int, ticketRequests [][2]int, paymentInfo map[string]interface{}, localTime interface{}) (prepared PreparedSale, err error) {
+	th.closeLock.RLock()
+	defer th.closeLock.RUnlock()
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("sale %d:  reserved %v, total %s", prepared.TxID, prepared.Reserved, formatPenneys(prepared.Total))
+		}
+		th.audit("PrepareSale", window, fmt.Sprintf("requests %v", ticketRequests), after, err)
+	}()
+	if !th.salesOpen {
+		return prepared, errors.New("PrepareSale failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return prepared, ErrReadOnly
+	}
+	if err := th.checkSalesWindow(); err != nil {
+		return prepared, err
+	}
+	if err := th.checkRequests("PrepareSale", window, ticketRequests, localTime); err != nil {
+		return prepared, err
+	}
+	if err := th.checkPaymentType("PrepareSale", window, paymentInfo); err != nil {
+		return prepared, err
+	}
+
+	if !th.rLockReset() {
+		return prepared, ErrBusy
+	}
+	defer th.resetLock.RUnlock()
+
+	ps := &preparedSale{channel: th.channel(window), customerID: paymentCustomerID(paymentInfo), localTime: localTime}
+	ps.Window = window
+	if ps.customerID != "" {
+		if err := th.reserveCustomer("PrepareSale", ps.customerID, len(ticketRequests)); err != nil {
+			return prepared, err
+		}
+	}
+	payer, limit := th.paymentID(paymentInfo)
+	if payer != "" && limit > 0 {
+		if err := th.reservePayment(payer, limit, ticketRequests); err != nil {
+			if ps.customerID != "" {
+				th.releaseCustomer(ps.customerID, len(ticketRequests))
+			}
+			return prepared, err
+		}
+		ps.payer = payer
+	}
+
+	for _, trqst := range ticketRequests {
+		m, s := trqst[TRMovie], trqst[TRShowing]
+		price, soldOut, channelFull := th.reserveSeat(m, s, ps.channel)
+		if soldOut {
+			reason := "sold out"
+			if channelFull {
+				reason = "channel sold out"
+			}
+			ps.Unavailable = append(ps.Unavailable, Unavailable{Movie: m, Showing: s, Reason: reason})
+			if ps.payer != "" {
+				th.releasePayment(ps.payer, m, s)
+			}
+			if ps.customerID != "" {
+				th.releaseCustomer(ps.customerID, 1)
+			}
+			continue
+		}
+		ps.Reserved = append(ps.Reserved, trqst)
+		ps.exact = append(ps.exact, price)
+		price = th.cashRound(window, price)
+		ps.prices = append(ps.prices, price)
+		ps.Total += price
+	}
+
+	th.configMutex.RLock()
+	timeout := th.prepareTimeout
+	th.configMutex.RUnlock()
+
+	th.txMutex.Lock()
+	th.addPrepared(ps, timeout)
+	prepared = ps.copy()
+	th.txMutex.Unlock()
+
+	th.L.Printf("PrepareSale for window %d prepared:\n%+v\n", window, prepared)
+	return prepared, nil
+} // PrepareSale
+
+// addPrepared gives ps the next TxID, and adds it to the waiting sales,
+// until it times out.  The caller must hold txMutex.
+func (th *Theatre) addPrepared(ps *preparedSale, timeout time.Duration) {
+	th.lastTxID++
+	ps.TxID = th.lastTxID
+	ps.Expires = th.clock().Add(timeout)
+	th.prepared[ps.TxID] = ps
+	txID := ps.TxID
+	ps.timer = time.AfterFunc(timeout, func() { th.expireSale(txID) })
+} // addPrepared
+
+// copy returns a copy of the PreparedSale part of ps, which shares nothing
+// with it.  The caller must hold txMutex.
+func (ps *preparedSale) copy() PreparedSale {
+	c := ps.PreparedSale
+	c.Reserved = append([][2]int(nil), ps.Reserved...)
+	c.Unavailable = append([]Unavailable(nil), ps.Unavailable...)
+	return c
+} // copy
+
+// CommitSale is the second phase of a two-phase sale, once its payment has
+// cleared.  It issues a Ticket for each seat PrepareSale reserved, and makes
+// the receipt, as Sell would have.  The sale then counts as the window's
+// last sale, for VoidLastSale.
+//
+// Parameters:
+//
+// txID
+//    The TxID of the PreparedSale.
+//
+// Returns the Tickets and receipt, as for Sell (but without any sold-out
+// placeholders), or ErrNoSuchSale if the sale has already been committed,
+// aborted or timed out, or an error if the ticketing system is down or in
+// read-only mode, in which case the sale is left prepared.  If an internal
+// error occurs part way through, then the Tickets issued before it are still
+// sold, as for Sell, and the rest of the seats are released.
+func (th *Theatre) CommitSale(txID int) (tickets []Ticket, receipt Receipt, err error) {
+	th.closeLock.RLock()
+	defer th.closeLock.RUnlock()
+	defer func() {
+		after := ""
+		if receipt.ReceiptNum != 0 {
+			after = fmt.Sprintf("receipt %d:  tickets %v, total %s", receipt.ReceiptNum, ticketNums(tickets), formatPenneys(receipt.Total))
+		}
+		th.audit("CommitSale", receipt.Window, fmt.Sprintf("sale %d", txID), after, err)
+	}()
+	if !th.salesOpen {
+		return nil, receipt, errors.New("CommitSale failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return nil, receipt, ErrReadOnly
+	}
+
+	th.resetLock.RLock()
+	defer th.resetLock.RUnlock()
+	ps := th.takeSale(txID)
+	if ps == nil {
+		return nil, receipt, ErrNoSuchSale
+	}
+
+	receipt = Receipt{Time: ps.localTime, Window: ps.Window}
+	tickets = make([]Ticket, 0, len(ps.Reserved))
+	sold := make([]int, 0, len(ps.Reserved))
+	soldAt := th.clock()
+	var loopErr error
+	for i, trqst := range ps.Reserved {
+		t, err := th.nextTicket()
+		if err == nil {
+			t.Movie = trqst[TRMovie]
+			t.Showing = trqst[TRShowing]
+			t.Window = ps.Window
+			t.CustomerID = ps.customerID
+			t.SoldAt = soldAt
+			t.Price = ps.prices[i]
+			t.Goodies = th.getsGoodies(ps.Window, t.Movie, t.Showing)
+			err = th.updateTicketSale(t)
+		}
+		if err != nil {
+			loopErr = fmt.Errorf("CommitSale failed:  reserved seat %d:  %w", (i + 1), err)
+			th.releasePrepared(ps, ps.Reserved[i:], false)
+			break
+		}
+		tickets = append(tickets, t)
+		receipt.ItemsSold = append(receipt.ItemsSold, saleItem(t, ps.exact[i]))
+		receipt.Total += t.Price
+		sold = append(sold, t.TicketNum)
+	}
+	th.grantSpendGoodies(tickets, receipt.Total)
+	th.recordReceipt(&receipt)
+
+	if len(sold) > 0 {
+		th.lastSaleMutex.Lock()
+		th.lastSale[ps.Window] = sold
+		th.lastSaleChannel[ps.Window] = ps.channel
+		th.lastSaleMutex.Unlock()
+	}
+
+	th.L.Printf("CommitSale of sale %d for window %d returning:\n\ttickets:\n%+v\n\treceipt:\n%+v\n", txID, ps.Window, tickets, receipt)
+	return tickets, receipt, loopErr
+} // CommitSale
+
+// AbortSale releases the seats reserved by PrepareSale (e.g. because the
+// payment was declined), so they can be sold again, after the seat release
+// delay (see SetSeatReleaseDelay).  It may be used even if the ticketing
+// system is down or in read-only mode, so that seats are never stuck.
+//
+// Returns ErrNoSuchSale if the sale has already been committed, aborted or
+// timed out.  Otherwise nil.
+func (th *Theatre) AbortSale(txID int) (err error) {
+	var ps *preparedSale
+	defer func() {
+		window := 0
+		if ps != nil {
+			window = ps.Window
+		}
+		th.audit("AbortSale", window, fmt.Sprintf("sale %d", txID), "", err)
+	}()
+
+	th.resetLock.RLock()
+	defer th.resetLock.RUnlock()
+	if ps = th.takeSale(txID); ps == nil {
+		return ErrNoSuchSale
+	}
+	th.releasePrepared(ps, ps.Reserved, true)
+	th.L.Printf("AbortSale released sale %d:  %v", txID, ps.Reserved)
+	return nil
+} // AbortSale
+
+// expireSale aborts a prepared sale which has timed out, unless it has
+// already been committed or aborted.
+func (th *Theatre) expireSale(txID int) {
+	th.resetLock.RLock()
+	defer th.resetLock.RUnlock()
+	ps := th.takeSale(txID)
+	if ps == nil {
+		return
+	}
+	th.releasePrepared(ps, ps.Reserved, false)
+	th.L.Printf("Prepared sale %d timed out, and released:  %v", txID, ps.Reserved)
+	th.audit("AbortSale", ps.Window, fmt.Sprintf("sale %d", txID), "timed out", nil)
+} // expireSale
+
+// takeSale removes a prepared sale from the waiting ones, so that only its
+// caller can commit or abort it.  Returns nil if there is no such sale.
+func (th *Theatre) takeSale(txID int) *preparedSale {
+	th.txMutex.Lock()
+	defer th.txMutex.Unlock()
+	ps, found := th.prepared[txID]
+	if !found {
+		return nil
+	}
+	delete(th.prepared, txID)
+	ps.timer.Stop()
+	return ps
+} // takeSale
+
+// releasePrepared gives back the seats (and the payer's and customer's
+// allowances) which PrepareSale reserved for ps's requests in reserved.  If
+// cancelled, then the seats only go back on sale after the seat release
+// delay (see SetSeatReleaseDelay).
+func (th *Theatre) releasePrepared(ps *preparedSale, reserved [][2]int, cancelled bool) {
+	for _, trqst := range reserved {
+		if cancelled {
+			th.releaseCancelledSeat(trqst[TRMovie], trqst[TRShowing], ps.channel)
+		} else {
+			th.releaseReservedSeat(trqst[TRMovie], trqst[TRShowing], ps.channel)
+		}
+		if ps.payer != "" {
+			th.releasePayment(ps.payer, trqst[TRMovie], trqst[TRShowing])
+		}
+	}
+	if ps.customerID != "" {
+		th.releaseCustomer(ps.customerID, len(reserved))
+	}
+} // releasePrepared
+
+// dropPrepared takes the seats of one showing of one movie out of the
+// prepared sales, when the showing is reset.  The seats themselves are not
+// released, since the reset has already zeroed the showing's counters.  The
+// caller must hold resetLock exclusively.
+func (th *Theatre) dropPrepared(movie int, showing int) {
+	th.txMutex.Lock()
+	defer th.txMutex.Unlock()
+	for _, ps := range th.prepared {
+		kept := 0
+		for i, trqst := range ps.Reserved {
+			if trqst[TRMovie] == movie && trqst[TRShowing] == showing {
+				ps.Total -= ps.prices[i]
+				ps.Unavailable = append(ps.Unavailable, Unavailable{Movie: movie, Showing: showing, Reason: "showing reset"})
+				if ps.payer != "" {
+					th.releasePayment(ps.payer, movie, showing)
+				}
+				if ps.customerID != "" {
+					th.releaseCustomer(ps.customerID, 1)
+				}
+				continue
+			}
+			ps.Reserved[kept], ps.prices[kept], ps.exact[kept] = trqst, ps.prices[i], ps.exact[i]
+			kept++
+		}
+		ps.Reserved, ps.prices, ps.exact = ps.Reserved[:kept], ps.prices[:kept], ps.exact[:kept]
+	}
+} // dropPrepared
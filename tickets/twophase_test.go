@@ -0,0 +1,120 @@
+package tickets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommitSale(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 2, 2)
+	ps, err := th.PrepareSale(1, [][2]int{{0, 0}, {0, 0}, {0, 0}, {1, 0}}, map[string]interface{}{CustomerIDField: "C1"}, "a dummy time")
+	if err != nil {
+		tst.Fatalf("PrepareSale returned error %v", err)
+	}
+	if len(ps.Reserved) != 3 || len(ps.Unavailable) != 1 || ps.Total != 3000 {
+		tst.Errorf("PrepareSale returned %+v, expected 3 seats reserved for 3000, and 1 sold out", ps)
+	}
+
+	// The reserved seats are taken, but no Tickets are issued yet.
+	if ticks, _, _ := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); len(ticks) != 1 || !ticks[0].SoldOut {
+		tst.Errorf("Sell of a seat reserved by PrepareSale returned %+v, expected a sold-out placeholder", ticks)
+	}
+	if ticks := th.TicketsForShowing(0, 0); len(ticks) != 0 {
+		tst.Errorf("TicketsForShowing before CommitSale returned %+v, expected none", ticks)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v with a sale prepared", problems)
+	}
+
+	ticks, rcpt, err := th.CommitSale(ps.TxID)
+	if err != nil {
+		tst.Fatalf("CommitSale returned error %v", err)
+	}
+	if len(ticks) != 3 || rcpt.Total != ps.Total || rcpt.ReceiptNum == 0 || ticks[0].CustomerID != "C1" || !ticks[0].Goodies {
+		tst.Errorf("CommitSale returned tickets %+v and receipt %+v, expected 3 goodie tickets for C1 totalling %d", ticks, rcpt, ps.Total)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v after CommitSale", problems)
+	}
+	if _, _, err := th.CommitSale(ps.TxID); err != ErrNoSuchSale {
+		tst.Errorf("Second CommitSale returned error %v, expected %v", err, ErrNoSuchSale)
+	}
+	if voided, err := th.VoidLastSale(1); err != nil || len(voided) != 3 {
+		tst.Errorf("VoidLastSale after CommitSale voided %d tickets, error %v, expected 3", len(voided), err)
+	}
+} // TestCommitSale
+
+func TestAbortSale(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 2, 2)
+	ps, err := th.PrepareSale(1, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("PrepareSale returned error %v", err)
+	}
+	if err := th.AbortSale(ps.TxID); err != nil {
+		tst.Fatalf("AbortSale returned error %v", err)
+	}
+	if err := th.AbortSale(ps.TxID); err != ErrNoSuchSale {
+		tst.Errorf("Second AbortSale returned error %v, expected %v", err, ErrNoSuchSale)
+	}
+	if _, _, err := th.CommitSale(ps.TxID); err != ErrNoSuchSale {
+		tst.Errorf("CommitSale after AbortSale returned error %v, expected %v", err, ErrNoSuchSale)
+	}
+
+	// The seats are back on sale.
+	ticks, _, err := th.Sell(2, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time")
+	if err != nil || len(ticks) != 2 || ticks[0].SoldOut || ticks[1].SoldOut {
+		tst.Errorf("Sell after AbortSale returned %+v, error %v, expected both seats", ticks, err)
+	}
+} // TestAbortSale
+
+func TestPrepareSaleTimeout(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 1, 2)
+	th.SetPrepareTimeout(20 * time.Millisecond)
+	ps, err := th.PrepareSale(1, [][2]int{{0, 0}}, nil, "a dummy time")
+	if err != nil {
+		tst.Fatalf("PrepareSale returned error %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		th.txMutex.Lock()
+		_, waiting := th.prepared[ps.TxID]
+		th.txMutex.Unlock()
+		if !waiting || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, _, err := th.CommitSale(ps.TxID); err != ErrNoSuchSale {
+		tst.Errorf("CommitSale after the timeout returned error %v, expected %v", err, ErrNoSuchSale)
+	}
+	if ticks, _, err := th.Sell(2, [][2]int{{0, 0}}, nil, "a dummy time"); err != nil || len(ticks) != 1 || ticks[0].SoldOut {
+		tst.Errorf("Sell after the timeout returned %+v, error %v, expected the released seat", ticks, err)
+	}
+} // TestPrepareSaleTimeout
+
+func TestCommitSaleSpendGoodies(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 1, 4, 2)
+	th.SetSpendGoodieThreshold(2000)
+	for _, c := range []struct {
+		rqsts   [][2]int
+		goodies bool
+	}{
+		{[][2]int{{0, 0}}, false},
+		{[][2]int{{0, 0}, {0, 0}}, true},
+	} {
+		ps, err := th.PrepareSale(2, c.rqsts, nil, "a dummy time")
+		if err != nil {
+			tst.Fatalf("PrepareSale returned error %v", err)
+		}
+		ticks, rcpt, err := th.CommitSale(ps.TxID)
+		if err != nil {
+			tst.Fatalf("CommitSale returned error %v", err)
+		}
+		for _, t := range ticks {
+			if t.Goodies != c.goodies {
+				tst.Errorf("CommitSale totalling %d at window 2 returned %+v, expected goodies %v", rcpt.Total, t, c.goodies)
+			}
+		}
+	}
+} // TestCommitSaleSpendGoodies
@@ -0,0 +1,215 @@
+/*****************************************************************************
+
+A Txn is a sale which is built up one ticket at a time (e.g. as a point of
+sale scans each item), and then finalized or cancelled.  It is a prepared
+sale (see PrepareSale) which is added to, instead of being prepared all at
+once.  Its paymentInfo is given to BeginTransaction, and each AddTicket is
+counted against the payer's and customer's limits (see SetPaymentLimit and
+SetCustomerTicketLimit) as it is added, as PrepareSale counts its requests.
+
+*****************************************************************************/
+
+package tickets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrShowingSoldOut is returned by Txn.AddTicket if the showing (or the
+// window's share of it, see SetChannelAllocation) is sold out.
+var ErrShowingSoldOut = errors.New("AddTicket denied:  the showing is sold out")
+
+// A Txn is an open transaction at one window, made by BeginTransaction.
+// Each AddTicket holds one more seat, until the Txn is finalized or
+// cancelled.  If nothing is added to a Txn for as long as the SetPrepareTimeout
+// timeout, then it is cancelled, and its seats are released.
+//
+// A Txn is meant to be used by one goroutine (one cashier) at a time.
+type Txn struct {
+	th           *Theatre
+	txID         int
+	window       int
+	paymentLimit int      // the SetPaymentLimit limit when the Txn was opened
+	tickets      []Ticket // the Tickets issued by Finalize
+} // Txn
+
+// BeginTransaction opens a transaction at window, with nothing in it yet.
+// paymentInfo is as for Sell:  its payment type must be one the window
+// accepts, and its payer and customer IDs are used for the limits on the
+// tickets added to the Txn.
+//
+// Returns the Txn, or an error if the window is out of range, the window
+// doesn't accept the payment type, or the ticketing system is down or in
+// read-only mode.
+func (th *Theatre) BeginTransaction(window int, paymentInfo map[string]interface{}) (txn *Txn, err error) {
+	th.closeLock.RLock()
+	defer th.closeLock.RUnlock()
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("sale %d", txn.txID)
+		}
+		th.audit("BeginTransaction", window, "", after, err)
+	}()
+	if !th.salesOpen {
+		return nil, errors.New("BeginTransaction failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if window < 1 || window > th.maxWindows {
+		return nil, fmt.Errorf("BeginTransaction failed:  window %d out of range.  Must be between 1 and %d, inclusive.", window, th.maxWindows)
+	}
+	if err := th.checkPaymentType("BeginTransaction", window, paymentInfo); err != nil {
+		return nil, err
+	}
+
+	ps := &preparedSale{channel: th.channel(window), customerID: paymentCustomerID(paymentInfo)}
+	ps.Window = window
+	payer, limit := th.paymentID(paymentInfo)
+	if payer != "" && limit > 0 {
+		ps.payer = payer
+	}
+	th.configMutex.RLock()
+	timeout := th.prepareTimeout
+	th.configMutex.RUnlock()
+	th.txMutex.Lock()
+	th.addPrepared(ps, timeout)
+	th.txMutex.Unlock()
+
+	th.L.Printf("BeginTransaction opened sale %d for window %d.", ps.TxID, window)
+	return &Txn{th: th, txID: ps.TxID, window: window, paymentLimit: limit}, nil
+} // BeginTransaction
+
+// AddTicket holds a seat for one showing of one movie in the transaction,
+// priced as Sell would price it.  The seat stays held until the Txn is
+// finalized or cancelled, and restarts the Txn's timeout.
+//
+// Returns:
+//
+// ticket
+//    The Ticket the seat will get, except that its TicketNum is 0, since no
+//    ticket is issued until Finalize.
+// err
+//    ErrShowingSoldOut if there is no seat to hold, ErrNoSuchSale if the Txn
+//    has been finalized, cancelled or timed out, or an error for the reasons
+//    Sell would refuse the request (including an error wrapping
+//    ErrPaymentLimit or ErrCustomerLimit, if the ticket would take the
+//    Txn's payer or customer past their limit).
+func (txn *Txn) AddTicket(movie int, showing int) (ticket Ticket, err error) {
+	th := txn.th
+	th.closeLock.RLock()
+	defer th.closeLock.RUnlock()
+	defer func() {
+		after := ""
+		if err == nil {
+			after = fmt.Sprintf("sale %d:  movie %d, showing %d held for %s", txn.txID, movie, showing, formatPenneys(ticket.Price))
+		}
+		th.audit("AddTicket", txn.window, fmt.Sprintf("sale %d", txn.txID), after, err)
+	}()
+	if !th.salesOpen {
+		return ticket, errors.New("AddTicket failed:  ticketing system is down.")
+	}
+	if th.isReadOnly() {
+		return ticket, ErrReadOnly
+	}
+	if err := th.checkSalesWindow(); err != nil {
+		return ticket, err
+	}
+	trqst := [2]int{movie, showing}
+	if err := th.checkRequests("AddTicket", txn.window, [][2]int{trqst}, nil); err != nil {
+		return ticket, err
+	}
+
+	if !th.rLockReset() {
+		return ticket, ErrBusy
+	}
+	defer th.resetLock.RUnlock()
+
+	th.txMutex.Lock()
+	ps, found := th.prepared[txn.txID]
+	th.txMutex.Unlock()
+	if !found {
+		return ticket, ErrNoSuchSale
+	}
+
+	// Count the ticket against the limits first, as PrepareSale does, and
+	// hand it back if the seat isn't held after all.
+	if ps.customerID != "" {
+		if err := th.reserveCustomer("AddTicket", ps.customerID, 1); err != nil {
+			return ticket, err
+		}
+	}
+	if ps.payer != "" {
+		if err := th.reservePayment(ps.payer, txn.paymentLimit, [][2]int{trqst}); err != nil {
+			if ps.customerID != "" {
+				th.releaseCustomer(ps.customerID, 1)
+			}
+			return ticket, err
+		}
+	}
+	unreserve := func() {
+		if ps.payer != "" {
+			th.releasePayment(ps.payer, movie, showing)
+		}
+		if ps.customerID != "" {
+			th.releaseCustomer(ps.customerID, 1)
+		}
+	}
+
+	price, soldOut, _ := th.reserveSeat(movie, showing, ps.channel)
+	if soldOut {
+		unreserve()
+		return ticket, ErrShowingSoldOut
+	}
+	exact := price
+	price = th.cashRound(txn.window, price)
+
+	th.configMutex.RLock()
+	timeout := th.prepareTimeout
+	th.configMutex.RUnlock()
+	th.txMutex.Lock()
+	if th.prepared[txn.txID] != ps || !ps.timer.Stop() {
+		// It was cancelled, or timed out, while the seat was being taken.
+		th.txMutex.Unlock()
+		th.releaseReservedSeat(movie, showing, ps.channel)
+		unreserve()
+		return ticket, ErrNoSuchSale
+	}
+	ps.Reserved = append(ps.Reserved, trqst)
+	ps.exact = append(ps.exact, exact)
+	ps.prices = append(ps.prices, price)
+	ps.Total += price
+	ps.Expires = th.clock().Add(timeout)
+	ps.timer.Reset(timeout)
+	th.txMutex.Unlock()
+
+	ticket = Ticket{Movie: movie, Showing: showing, Price: price, Window: txn.window, Goodies: th.getsGoodies(txn.window, movie, showing)}
+	return ticket, nil
+} // AddTicket
+
+// Finalize issues the Tickets for the seats held by the transaction, and
+// makes its receipt, as CommitSale does.  The Tickets can then be had from
+// Tickets.
+//
+// Returns the receipt, or an error, as for CommitSale.
+func (txn *Txn) Finalize() (Receipt, error) {
+	ticks, receipt, err := txn.th.CommitSale(txn.txID)
+	txn.tickets = ticks
+	return receipt, err
+} // Finalize
+
+// Tickets returns the Tickets which Finalize issued, or nil if the
+// transaction has not been finalized.
+func (txn *Txn) Tickets() []Ticket {
+	return txn.tickets
+} // Tickets
+
+// Cancel releases the seats held by the transaction, as AbortSale does.
+//
+// Returns ErrNoSuchSale if the Txn has already been finalized, cancelled or
+// timed out.  Otherwise nil.
+func (txn *Txn) Cancel() error {
+	return txn.th.AbortSale(txn.txID)
+} // Cancel
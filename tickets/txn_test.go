@@ -0,0 +1,123 @@
+package tickets
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTxnFinalize(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 2, 2)
+	txn, err := th.BeginTransaction(1, nil)
+	if err != nil {
+		tst.Fatalf("BeginTransaction returned error %v", err)
+	}
+	for _, trqst := range [][2]int{{0, 0}, {1, 0}, {0, 0}} {
+		if t, err := txn.AddTicket(trqst[TRMovie], trqst[TRShowing]); err != nil || t.Price != 1000 || t.TicketNum != 0 {
+			tst.Fatalf("AddTicket(%d, %d) returned %+v, error %v, expected an unnumbered $10 ticket", trqst[TRMovie], trqst[TRShowing], t, err)
+		}
+	}
+	// Both seats for movie 0 are held.
+	if _, err := txn.AddTicket(0, 0); err != ErrShowingSoldOut {
+		tst.Errorf("AddTicket for a third seat of two returned error %v, expected %v", err, ErrShowingSoldOut)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v with a transaction open", problems)
+	}
+
+	rcpt, err := txn.Finalize()
+	if err != nil {
+		tst.Fatalf("Finalize returned error %v", err)
+	}
+	if ticks := txn.Tickets(); len(ticks) != 3 || rcpt.Total != 3000 || len(rcpt.ItemsSold) != 3 || ticks[0].TicketNum == 0 {
+		tst.Errorf("Finalize issued %+v, receipt %+v, expected 3 numbered tickets totalling 3000", ticks, rcpt)
+	}
+	if ticks := th.TicketsForShowing(0, 0); len(ticks) != 2 {
+		tst.Errorf("TicketsForShowing(0, 0) after Finalize returned %d tickets, expected 2", len(ticks))
+	}
+	if _, err := txn.AddTicket(1, 0); err != ErrNoSuchSale {
+		tst.Errorf("AddTicket after Finalize returned error %v, expected %v", err, ErrNoSuchSale)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v after Finalize", problems)
+	}
+} // TestTxnFinalize
+
+func TestTxnCancel(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 1, 2, 2, 2) // the second showing is only there to make room in the DB
+	txn, err := th.BeginTransaction(2, nil)
+	if err != nil {
+		tst.Fatalf("BeginTransaction returned error %v", err)
+	}
+	if _, err := txn.AddTicket(0, 0); err != nil {
+		tst.Fatalf("AddTicket returned error %v", err)
+	}
+	if ticks, _, _ := th.Sell(1, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time"); !ticks[1].SoldOut {
+		tst.Errorf("Sell of both seats with one held returned %+v, expected the second to be sold out", ticks)
+	}
+	th.VoidLastSale(1)
+
+	if err := txn.Cancel(); err != nil {
+		tst.Fatalf("Cancel returned error %v", err)
+	}
+	if err := txn.Cancel(); err != ErrNoSuchSale {
+		tst.Errorf("Second Cancel returned error %v, expected %v", err, ErrNoSuchSale)
+	}
+	if _, err := txn.Finalize(); err != ErrNoSuchSale || txn.Tickets() != nil {
+		tst.Errorf("Finalize after Cancel returned error %v and tickets %+v, expected %v and none", err, txn.Tickets(), ErrNoSuchSale)
+	}
+
+	// The held seat was released, so both can be sold.
+	if ticks, _, err := th.Sell(1, [][2]int{{0, 0}, {0, 0}}, nil, "a dummy time"); err != nil || ticks[0].SoldOut || ticks[1].SoldOut {
+		tst.Errorf("Sell after Cancel returned %+v, error %v, expected both seats", ticks, err)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v after Cancel", problems)
+	}
+} // TestTxnCancel
+
+func TestTxnLimits(tst *testing.T) {
+	th := newTestTheatre(tst, 5, 2, 1, 4, 2)
+	if err := th.SetCustomerTicketLimit(2); err != nil {
+		tst.Fatalf("SetCustomerTicketLimit returned error %v", err)
+	}
+	if err := th.SetPaymentLimit(1); err != nil {
+		tst.Fatalf("SetPaymentLimit returned error %v", err)
+	}
+	if err := th.SetWindowPaymentTypes(2, []string{"card"}); err != nil {
+		tst.Fatalf("SetWindowPaymentTypes returned error %v", err)
+	}
+	if _, err := th.BeginTransaction(2, map[string]interface{}{PaymentTypeField: "cash"}); !errors.Is(err, ErrPaymentTypeNotAccepted) {
+		tst.Errorf("BeginTransaction with cash at a card window returned error %v, expected %v", err, ErrPaymentTypeNotAccepted)
+	}
+
+	// alice buys one ticket at window 1, so a Txn at window 2 may only add
+	// one more, however many windows she goes to.
+	alice := map[string]interface{}{CustomerIDField: "alice", DefaultPaymentIDField: "card 1", PaymentTypeField: "card"}
+	if _, _, err := th.Sell(1, [][2]int{{0, 0}}, alice, "a dummy time"); err != nil {
+		tst.Fatalf("Sell to alice returned error %v", err)
+	}
+	txn, err := th.BeginTransaction(2, alice)
+	if err != nil {
+		tst.Fatalf("BeginTransaction returned error %v", err)
+	}
+	if _, err := txn.AddTicket(0, 0); !errors.Is(err, ErrPaymentLimit) {
+		tst.Errorf("AddTicket past the payment limit returned error %v, expected %v", err, ErrPaymentLimit)
+	}
+	if _, err := txn.AddTicket(1, 0); err != nil {
+		tst.Fatalf("AddTicket within the limits returned error %v", err)
+	}
+	if _, err := txn.AddTicket(1, 0); !errors.Is(err, ErrCustomerLimit) {
+		tst.Errorf("AddTicket past the customer limit returned error %v, expected %v", err, ErrCustomerLimit)
+	}
+
+	// Cancelling hands the ticket back, so alice can buy it elsewhere.
+	if err := txn.Cancel(); err != nil {
+		tst.Fatalf("Cancel returned error %v", err)
+	}
+	if _, _, err := th.Sell(1, [][2]int{{1, 0}}, alice, "a dummy time"); err != nil {
+		tst.Errorf("Sell to alice after Cancel returned error %v, expected her second ticket", err)
+	}
+	if problems := th.SelfCheck(); len(problems) != 0 {
+		tst.Errorf("SelfCheck found problems %v after limited transactions", problems)
+	}
+} // TestTxnLimits